@@ -19,7 +19,12 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func adminGetPlayers(w http.ResponseWriter, r *http.Request) {
@@ -29,12 +34,48 @@ func adminGetPlayers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	query := r.URL.Query()
+
+	mapParam := query.Get("map")
+	accountOnly := query.Get("account") == "1"
+	ipParam := query.Get("ip")
+
+	minRank := 0
+	if rankParam := query.Get("rank"); rankParam != "" {
+		var err error
+		minRank, err = strconv.Atoi(rankParam)
+		if err != nil {
+			handleError(w, r, "invalid rank")
+			return
+		}
+	}
+
 	response := make([]PlayerInfo, 0, clients.GetAmount())
 	for _, client := range clients.Get() {
+		if accountOnly && !client.account {
+			continue
+		}
+		if client.rank < minRank {
+			continue
+		}
+		if ipParam != "" && client.ip != ipParam {
+			continue
+		}
+
+		var mapId string
+		if client.roomC != nil {
+			mapId = client.roomC.mapId
+		}
+		if mapParam != "" && mapId != mapParam {
+			continue
+		}
+
 		response = append(response, PlayerInfo{
-			Uuid: client.uuid,
-			Name: client.name,
-			Rank: client.rank,
+			Uuid:    client.uuid,
+			Name:    client.name,
+			Rank:    client.rank,
+			Latency: int(client.latency.Load()),
+			MapId:   mapId,
 		})
 	}
 
@@ -47,6 +88,49 @@ func adminGetPlayers(w http.ResponseWriter, r *http.Request) {
 	w.Write(responseJson)
 }
 
+func adminGetPlayer(w http.ResponseWriter, r *http.Request) {
+	_, _, rank, _, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
+	if rank == 0 {
+		handleError(w, r, "access denied")
+		return
+	}
+
+	uuid := r.URL.Query().Get("uuid")
+	if uuid == "" {
+		user := r.URL.Query().Get("user")
+		if user == "" {
+			handleError(w, r, "uuid or user not specified")
+			return
+		}
+
+		var err error
+		uuid, err = getUuidFromName(user)
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+
+		if uuid == "" {
+			handleError(w, r, "invalid user specified")
+			return
+		}
+	}
+
+	player, err := getAdminPlayerDetail(uuid)
+	if err != nil {
+		handleInternalError(w, r, err)
+		return
+	}
+
+	playerJson, err := json.Marshal(player)
+	if err != nil {
+		handleError(w, r, "error while marshaling")
+		return
+	}
+
+	w.Write(playerJson)
+}
+
 func adminGetBansMutes(w http.ResponseWriter, r *http.Request) {
 	_, _, rank, _, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
 	if rank == 0 {
@@ -108,6 +192,10 @@ func adminBanMute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.URL.Path == "/admin/ban" {
+		dispatchWebhook(webhookPlayerBanned, map[string]string{"uuid": targetUuid, "bannedBy": uuid})
+	}
+
 	w.Write([]byte("ok"))
 }
 
@@ -183,6 +271,130 @@ func adminResetPw(w http.ResponseWriter, r *http.Request) {
 }
 
 func adminManageBadge(w http.ResponseWriter, r *http.Request) {
+	adminUuid, _, rank, _, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
+	if rank == 0 {
+		handleError(w, r, "access denied")
+		return
+	}
+
+	// uuid, user and id accept comma-separated lists for bulk operations
+	var uuids []string
+
+	if uuidParam := r.URL.Query().Get("uuid"); uuidParam != "" {
+		uuids = strings.Split(uuidParam, ",")
+	}
+
+	if userParam := r.URL.Query().Get("user"); userParam != "" {
+		for _, user := range strings.Split(userParam, ",") {
+			uuid, err := getUuidFromName(user)
+			if err != nil {
+				handleInternalError(w, r, err)
+				return
+			}
+			if uuid == "" {
+				handleError(w, r, "invalid user specified: "+user)
+				return
+			}
+			uuids = append(uuids, uuid)
+		}
+	}
+
+	if len(uuids) == 0 {
+		handleError(w, r, "uuid or user not specified")
+		return
+	}
+
+	idParam := r.URL.Query().Get("id")
+	if idParam == "" {
+		handleError(w, r, "badge ID not specified")
+		return
+	}
+
+	badgeIds := strings.Split(idParam, ",")
+
+	for _, badgeId := range badgeIds {
+		var badgeExists bool
+
+		for _, gameBadges := range badges {
+			if _, ok := gameBadges[badgeId]; ok {
+				badgeExists = true
+				break
+			}
+		}
+
+		if !badgeExists {
+			handleError(w, r, "badge not found for the provided badge ID: "+badgeId)
+			return
+		}
+	}
+
+	var err error
+	if r.URL.Path == "/admin/grantbadge" {
+		err = unlockPlayerBadges(uuids, badgeIds, adminUuid)
+	} else {
+		err = removePlayerBadges(uuids, badgeIds)
+	}
+	if err != nil {
+		if errors.Is(err, errPlayerBadgeNotOwned) {
+			handleError(w, r, err.Error())
+			return
+		}
+		handleInternalError(w, r, err)
+		return
+	}
+
+	w.Write([]byte("ok"))
+}
+
+func adminBadgeBatch(w http.ResponseWriter, r *http.Request) {
+	_, _, rank, _, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
+	if rank == 0 {
+		handleError(w, r, "access denied")
+		return
+	}
+
+	gameParam := r.URL.Query().Get("game")
+	if gameParam == "" {
+		gameParam = getConfig().gameName
+	}
+
+	if _, ok := badges[gameParam]; !ok {
+		handleError(w, r, "no badges found for the provided game")
+		return
+	}
+
+	var delta int
+	switch r.URL.Query().Get("command") {
+	case "preview":
+	case "advance":
+		delta = 1
+	case "rollback":
+		delta = -1
+	default:
+		handleError(w, r, "unknown command")
+		return
+	}
+
+	if delta != 0 {
+		err := writeBadgeBatchOffset(gameParam, delta)
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+
+		updateActiveBadgesAndConditions()
+	}
+
+	responseJson, err := json.Marshal(getBadgeBatchInfo(gameParam))
+	if err != nil {
+		handleError(w, r, "error while marshaling")
+		return
+	}
+
+	w.Write(responseJson)
+}
+
+func adminGetBadgeGrants(w http.ResponseWriter, r *http.Request) {
 	_, _, rank, _, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
 	if rank == 0 {
 		handleError(w, r, "access denied")
@@ -191,58 +403,691 @@ func adminManageBadge(w http.ResponseWriter, r *http.Request) {
 
 	uuidParam := r.URL.Query().Get("uuid")
 	if uuidParam == "" {
-		userParam := r.URL.Query().Get("user")
-		if userParam == "" {
-			handleError(w, r, "uuid or user not specified")
-			return
+		if userParam := r.URL.Query().Get("user"); userParam != "" {
+			var err error
+			uuidParam, err = getUuidFromName(userParam)
+			if err != nil {
+				handleInternalError(w, r, err)
+				return
+			}
+			if uuidParam == "" {
+				handleError(w, r, "invalid user specified")
+				return
+			}
 		}
+	}
+
+	idParam := r.URL.Query().Get("id")
+
+	if uuidParam == "" && idParam == "" {
+		handleError(w, r, "uuid, user or badge ID not specified")
+		return
+	}
+
+	grants, err := getPlayerBadgeGrants(uuidParam, idParam)
+	if err != nil {
+		handleInternalError(w, r, err)
+		return
+	}
+
+	responseJson, err := json.Marshal(grants)
+	if err != nil {
+		handleError(w, r, "error while marshaling")
+		return
+	}
+
+	w.Write(responseJson)
+}
+
+func adminTestConditions(w http.ResponseWriter, r *http.Request) {
+	_, _, rank, _, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
+	if rank == 0 {
+		handleError(w, r, "access denied")
+		return
+	}
+
+	query := r.URL.Query()
+
+	var playerTags []string
+	if uuidParam := query.Get("uuid"); uuidParam != "" {
 		var err error
-		uuidParam, err = getUuidFromName(userParam)
+		playerTags, _, err = getPlayerTags(uuidParam)
 		if err != nil {
 			handleInternalError(w, r, err)
 			return
 		}
-		if uuidParam == "" {
-			handleError(w, r, "invalid user specified")
+	}
+
+	mapId, err := strconv.Atoi(query.Get("map"))
+	if err != nil {
+		handleError(w, r, "invalid map")
+		return
+	}
+
+	var x, y int
+	if xParam := query.Get("x"); xParam != "" {
+		x, err = strconv.Atoi(xParam)
+		if err != nil {
+			handleError(w, r, "invalid x")
+			return
+		}
+	}
+	if yParam := query.Get("y"); yParam != "" {
+		y, err = strconv.Atoi(yParam)
+		if err != nil {
+			handleError(w, r, "invalid y")
 			return
 		}
 	}
 
-	idParam := r.URL.Query().Get("id")
-	if idParam == "" {
-		handleError(w, r, "badge ID not specified")
+	// switches and vars are formatted as comma-separated id:value pairs
+	switches := make(map[int]bool)
+	if switchesParam := query.Get("switches"); switchesParam != "" {
+		for _, pair := range strings.Split(switchesParam, ",") {
+			id, value, _ := strings.Cut(pair, ":")
+			switchId, err := strconv.Atoi(id)
+			if err != nil {
+				handleError(w, r, "invalid switch id: "+id)
+				return
+			}
+			switches[switchId] = value == "1" || value == "true"
+		}
+	}
+
+	vars := make(map[int]int)
+	if varsParam := query.Get("vars"); varsParam != "" {
+		for _, pair := range strings.Split(varsParam, ",") {
+			id, value, _ := strings.Cut(pair, ":")
+			varId, err := strconv.Atoi(id)
+			if err != nil {
+				handleError(w, r, "invalid var id: "+id)
+				return
+			}
+			vars[varId], err = strconv.Atoi(value)
+			if err != nil {
+				handleError(w, r, "invalid var value: "+value)
+				return
+			}
+		}
+	}
+
+	results := testConditions(playerTags, mapId, x, y, switches, vars, query.Get("trigger"), query.Get("value"))
+
+	responseJson, err := json.Marshal(results)
+	if err != nil {
+		handleError(w, r, "error while marshaling")
 		return
 	}
 
-	var badgeExists bool
+	w.Write(responseJson)
+}
 
-	for _, gameBadges := range badges {
-		for badgeId := range gameBadges {
-			if badgeId == idParam {
-				badgeExists = true
+func adminEventPeriod(w http.ResponseWriter, r *http.Request) {
+	_, _, rank, _, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
+	if rank == 0 {
+		handleError(w, r, "access denied")
+		return
+	}
+
+	query := r.URL.Query()
+
+	gameParam := query.Get("game")
+	if gameParam == "" {
+		gameParam = getConfig().gameName
+	}
+
+	eventPeriods, err := getGameEventPeriods(gameParam)
+	if err != nil {
+		handleInternalError(w, r, err)
+		return
+	}
+
+	commandParam := query.Get("command")
+	if commandParam == "list" {
+		responseJson, err := json.Marshal(eventPeriods)
+		if err != nil {
+			handleError(w, r, "error while marshaling")
+			return
+		}
+
+		w.Write(responseJson)
+		return
+	}
+
+	eventPeriod := &GameEventPeriod{Game: gameParam}
+	if commandParam != "create" {
+		periodId, err := strconv.Atoi(query.Get("id"))
+		if err != nil {
+			handleError(w, r, "invalid period ID")
+			return
+		}
+
+		eventPeriod = nil
+		for _, period := range eventPeriods {
+			if period.PeriodId == periodId {
+				eventPeriod = period
 				break
 			}
 		}
-		if badgeExists {
-			break
+
+		if eventPeriod == nil {
+			handleError(w, r, "period not found for the provided game")
+			return
+		}
+	}
+
+	if ordinalParam := query.Get("ordinal"); ordinalParam != "" {
+		eventPeriod.PeriodOrdinal, err = strconv.Atoi(ordinalParam)
+		if err != nil {
+			handleError(w, r, "invalid ordinal")
+			return
+		}
+	}
+	if startDateParam := query.Get("startDate"); startDateParam != "" {
+		eventPeriod.StartDate, err = time.Parse(time.DateOnly, startDateParam)
+		if err != nil {
+			handleError(w, r, "invalid start date")
+			return
+		}
+	}
+	if endDateParam := query.Get("endDate"); endDateParam != "" {
+		eventPeriod.EndDate, err = time.Parse(time.DateOnly, endDateParam)
+		if err != nil {
+			handleError(w, r, "invalid end date")
+			return
+		}
+	}
+	// a negative cap removes the weekly exp cap for the period, while "default" uses the configured cap
+	if weeklyExpCapParam := query.Get("weeklyExpCap"); weeklyExpCapParam == "default" {
+		eventPeriod.WeeklyExpCap = nil
+	} else if weeklyExpCapParam != "" {
+		weeklyExpCap, err := strconv.Atoi(weeklyExpCapParam)
+		if err != nil {
+			handleError(w, r, "invalid weekly exp cap")
+			return
+		}
+		eventPeriod.WeeklyExpCap = &weeklyExpCap
+	}
+	if enableVmsParam := query.Get("enableVms"); enableVmsParam != "" {
+		eventPeriod.EnableVms = enableVmsParam == "1" || enableVmsParam == "true"
+	}
+
+	switch commandParam {
+	case "create", "edit":
+		if eventPeriod.PeriodOrdinal <= 0 {
+			handleError(w, r, "ordinal not specified")
+			return
+		}
+		if eventPeriod.StartDate.IsZero() || eventPeriod.EndDate.IsZero() {
+			handleError(w, r, "start date or end date not specified")
+			return
+		}
+		if !eventPeriod.EndDate.After(eventPeriod.StartDate) {
+			handleError(w, r, "end date must be after start date")
+			return
+		}
+
+		if commandParam == "create" {
+			eventPeriod.PeriodId, err = writeGameEventPeriod(gameParam, eventPeriod.PeriodOrdinal, eventPeriod.StartDate, eventPeriod.EndDate, eventPeriod.WeeklyExpCap, eventPeriod.EnableVms)
+		} else {
+			err = updateGameEventPeriod(eventPeriod.PeriodId, gameParam, eventPeriod.PeriodOrdinal, eventPeriod.StartDate, eventPeriod.EndDate, eventPeriod.WeeklyExpCap, eventPeriod.EnableVms)
+		}
+	case "close":
+		eventPeriod.EndDate, err = closeEventPeriod(eventPeriod.PeriodId)
+		if err == nil {
+			dispatchWebhook(webhookEventPeriodEnded, map[string]int{"periodId": eventPeriod.PeriodId})
 		}
+	default:
+		handleError(w, r, "unknown command")
+		return
+	}
+	if err != nil {
+		handleInternalError(w, r, err)
+		return
 	}
 
-	if !badgeExists {
-		handleError(w, r, "badge not found for the provided badge ID")
+	err = refreshCurrentEventPeriod()
+	if err != nil {
+		handleInternalError(w, r, err)
 		return
 	}
 
-	var err error
-	if r.URL.Path == "/admin/grantbadge" {
-		err = unlockPlayerBadge(uuidParam, idParam)
-	} else {
-		err = removePlayerBadge(uuidParam, idParam)
+	responseJson, err := json.Marshal(eventPeriod)
+	if err != nil {
+		handleError(w, r, "error while marshaling")
+		return
+	}
+
+	w.Write(responseJson)
+}
+
+func adminAddEventLocation(w http.ResponseWriter, r *http.Request) {
+	_, _, rank, _, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
+	if rank == 0 {
+		handleError(w, r, "access denied")
+		return
+	}
+
+	query := r.URL.Query()
+
+	gameParam := query.Get("game")
+	if gameParam == "" {
+		gameParam = getConfig().gameName
+	}
+
+	titleParam := query.Get("title")
+	if titleParam == "" {
+		handleError(w, r, "title not specified")
+		return
+	}
+
+	mapIdsParam := query.Get("mapIds")
+	if mapIdsParam == "" {
+		handleError(w, r, "map IDs not specified")
+		return
+	}
+
+	var mapIds []string
+	for _, mapId := range strings.Split(mapIdsParam, ",") {
+		if _, err := strconv.Atoi(mapId); err != nil {
+			handleError(w, r, "invalid map ID: "+mapId)
+			return
+		}
+		mapIds = append(mapIds, mapId)
+	}
+
+	exp, err := strconv.Atoi(query.Get("exp"))
+	if err != nil || exp < 0 {
+		handleError(w, r, "invalid exp")
+		return
+	}
+
+	days := 1
+	if daysParam := query.Get("days"); daysParam != "" {
+		days, err = strconv.Atoi(daysParam)
+		if err != nil || days <= 0 {
+			handleError(w, r, "invalid days")
+			return
+		}
+	}
+
+	var depth, minDepth int
+	if depthParam := query.Get("depth"); depthParam != "" {
+		depth, err = strconv.Atoi(depthParam)
+		if err != nil {
+			handleError(w, r, "invalid depth")
+			return
+		}
+	}
+	if minDepthParam := query.Get("minDepth"); minDepthParam != "" {
+		minDepth, err = strconv.Atoi(minDepthParam)
+		if err != nil {
+			handleError(w, r, "invalid min depth")
+			return
+		}
+	}
+
+	err = addManualEventLocation(gameParam, titleParam, query.Get("titleJP"), depth, minDepth, exp, mapIds, days)
+	if err != nil {
+		handleInternalError(w, r, err)
+		return
+	}
+
+	w.Write([]byte("ok"))
+}
+
+func adminEventExpMultiplier(w http.ResponseWriter, r *http.Request) {
+	_, _, rank, _, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
+	if rank == 0 {
+		handleError(w, r, "access denied")
+		return
+	}
+
+	query := r.URL.Query()
+
+	switch query.Get("command") {
+	case "list":
+	case "add":
+		multiplier, err := strconv.ParseFloat(query.Get("multiplier"), 64)
+		if err != nil || multiplier <= 0 {
+			handleError(w, r, "invalid multiplier")
+			return
+		}
+
+		startTime, err := time.Parse(time.RFC3339, query.Get("startTime"))
+		if err != nil {
+			handleError(w, r, "invalid start time")
+			return
+		}
+
+		endTime, err := time.Parse(time.RFC3339, query.Get("endTime"))
+		if err != nil || !endTime.After(startTime) {
+			handleError(w, r, "invalid end time")
+			return
+		}
+
+		err = writeEventExpMultiplier(query.Get("game"), multiplier, startTime.UTC(), endTime.UTC())
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+	case "remove":
+		id, err := strconv.Atoi(query.Get("id"))
+		if err != nil {
+			handleError(w, r, "invalid ID")
+			return
+		}
+
+		err = deleteEventExpMultiplier(id)
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+	default:
+		handleError(w, r, "unknown command")
+		return
+	}
+
+	expMultipliers, err := getEventExpMultipliers()
+	if err != nil {
+		handleInternalError(w, r, err)
+		return
+	}
+
+	responseJson, err := json.Marshal(expMultipliers)
+	if err != nil {
+		handleError(w, r, "error while marshaling")
+		return
+	}
+
+	w.Write(responseJson)
+}
+
+func adminSaveBackup(w http.ResponseWriter, r *http.Request) {
+	_, _, rank, _, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
+	if rank == 0 {
+		handleError(w, r, "access denied")
+		return
+	}
+
+	if getConfig().backups.s3.bucket == "" {
+		handleError(w, r, "backups are not configured")
+		return
+	}
+
+	query := r.URL.Query()
+
+	switch query.Get("command") {
+	case "list":
+		backups, err := getSaveBackups(r.Context())
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+
+		backupsJson, err := json.Marshal(backups)
+		if err != nil {
+			handleError(w, r, "error while marshaling")
+			return
+		}
+
+		w.Write(backupsJson)
+	case "backup":
+		// backups take a while, the job can be followed with /admin/jobs
+		jobId, err := enqueueJob(jobTypeSaveBackup)
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+
+		w.Write([]byte(strconv.Itoa(jobId)))
+	case "restore":
+		key := query.Get("key")
+		if key == "" {
+			handleError(w, r, "key not specified")
+			return
+		}
+
+		// restores the saves of every player in the backup if no player is specified
+		restoredCount, err := restoreSaveBackup(r.Context(), key, query.Get("uuid"))
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+
+		w.Write([]byte(strconv.Itoa(restoredCount)))
+	default:
+		handleError(w, r, "unknown command")
 	}
+}
+
+func adminLogLevel(w http.ResponseWriter, r *http.Request) {
+	_, _, rank, _, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
+	if rank == 0 {
+		handleError(w, r, "access denied")
+		return
+	}
+
+	if levelParam := r.URL.Query().Get("level"); levelParam != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(levelParam)); err != nil {
+			handleError(w, r, "invalid level")
+			return
+		}
+
+		logLevel.Set(level)
+	}
+
+	w.Write([]byte(logLevel.Level().String()))
+}
+
+func admin2kkiCache(w http.ResponseWriter, r *http.Request) {
+	_, _, rank, _, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
+	if rank == 0 {
+		handleError(w, r, "access denied")
+		return
+	}
+
+	if r.URL.Query().Get("command") != "purge" {
+		handleError(w, r, "unknown command")
+		return
+	}
+
+	count, err := delete2kkiApiQueries(r.URL.Query().Get("action"), r.URL.Query().Get("query"))
 	if err != nil {
 		handleInternalError(w, r, err)
 		return
 	}
 
+	w.Write([]byte(strconv.FormatInt(count, 10)))
+}
+
+func adminReloadConfig(w http.ResponseWriter, r *http.Request) {
+	_, _, rank, _, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
+	if rank == 0 {
+		handleError(w, r, "access denied")
+		return
+	}
+
+	err := reloadConfig()
+	if err != nil {
+		handleError(w, r, "error reloading config: "+err.Error())
+		return
+	}
+
 	w.Write([]byte("ok"))
 }
+
+type AdminMetrics struct {
+	Players int `json:"players"`
+
+	Db        DbPoolMetrics  `json:"db"`
+	DbReplica *DbPoolMetrics `json:"dbReplica,omitempty"`
+
+	// writes retried after transient errors, and those failing after every attempt
+	DbRetries       int64 `json:"dbRetries"`
+	DbRetryFailures int64 `json:"dbRetryFailures"`
+}
+
+type DbPoolMetrics struct {
+	MaxOpenConnections int `json:"maxOpenConnections"`
+
+	OpenConnections int `json:"openConnections"`
+	InUse           int `json:"inUse"`
+	Idle            int `json:"idle"`
+
+	WaitCount         int64 `json:"waitCount"`
+	WaitDurationMs    int64 `json:"waitDurationMs"`
+	MaxIdleClosed     int64 `json:"maxIdleClosed"`
+	MaxIdleTimeClosed int64 `json:"maxIdleTimeClosed"`
+	MaxLifetimeClosed int64 `json:"maxLifetimeClosed"`
+}
+
+func adminMetrics(w http.ResponseWriter, r *http.Request) {
+	_, _, rank, _, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
+	if rank == 0 {
+		handleError(w, r, "access denied")
+		return
+	}
+
+	metrics := AdminMetrics{
+		Players:         clients.GetAmount(),
+		Db:              getDbPoolMetrics(db),
+		DbRetries:       dbRetryCount.Load(),
+		DbRetryFailures: dbRetryFailureCount.Load(),
+	}
+	if db.replica != nil {
+		replicaMetrics := getDbPoolMetrics(db.replica)
+		metrics.DbReplica = &replicaMetrics
+	}
+
+	metricsJson, err := json.Marshal(metrics)
+	if err != nil {
+		handleError(w, r, "error while marshaling")
+		return
+	}
+
+	w.Write(metricsJson)
+}
+
+func getDbPoolMetrics(database *Database) DbPoolMetrics {
+	stats := database.Stats()
+
+	return DbPoolMetrics{
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitDurationMs:     stats.WaitDuration.Milliseconds(),
+		MaxIdleClosed:      stats.MaxIdleClosed,
+		MaxIdleTimeClosed:  stats.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+	}
+}
+
+type AdminPlayerDetail struct {
+	Uuid    string `json:"uuid"`
+	Name    string `json:"name"`
+	Rank    int    `json:"rank"`
+	Banned  bool   `json:"banned"`
+	Muted   bool   `json:"muted"`
+	Online  bool   `json:"online"`
+	PartyId int    `json:"partyId,omitempty"`
+
+	Session  *AdminSessionDetail  `json:"session,omitempty"`
+	Location *AdminLocationDetail `json:"location,omitempty"`
+
+	RecentChat []*ChatMessage        `json:"recentChat"`
+	Reports    []*PlayerReportRecord `json:"reports"`
+}
+
+type AdminSessionDetail struct {
+	Id           int    `json:"id"`
+	Ip           string `json:"ip"`
+	Account      bool   `json:"account"`
+	Status       string `json:"status"`
+	Private      bool   `json:"private"`
+	HideLocation bool   `json:"hideLocation"`
+	Latency      int    `json:"latency"`
+}
+
+type AdminLocationDetail struct {
+	MapId      string   `json:"mapId"`
+	PrevMapId  string   `json:"prevMapId"`
+	Locations  []string `json:"locations"`
+	X          int      `json:"x"`
+	Y          int      `json:"y"`
+	Instance   int      `json:"instance"`
+	Spectating bool     `json:"spectating"`
+}
+
+type PlayerReportRecord struct {
+	ReporterUuid string    `json:"reporterUuid"`
+	MsgId        string    `json:"msgId,omitempty"`
+	Game         string    `json:"game"`
+	Reason       string    `json:"reason"`
+	Timestamp    time.Time `json:"timestamp"`
+	ActionTaken  bool      `json:"actionTaken"`
+}
+
+const (
+	adminPlayerRecentChatLimit = 50
+)
+
+func getAdminPlayerDetail(uuid string) (*AdminPlayerDetail, error) {
+	player := &AdminPlayerDetail{
+		Uuid: uuid,
+		Name: getNameFromUuid(uuid),
+		Rank: getPlayerRank(uuid),
+	}
+
+	player.Banned, player.Muted = getPlayerModerationStatus(uuid)
+
+	var err error
+
+	player.PartyId, err = getPlayerPartyId(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	if client, ok := clients.Load(uuid); ok {
+		player.Online = true
+
+		player.Session = &AdminSessionDetail{
+			Id:           client.id,
+			Ip:           client.ip,
+			Account:      client.account,
+			Status:       client.status,
+			Private:      client.private,
+			HideLocation: client.hideLocation,
+			Latency:      int(client.latency.Load()),
+		}
+
+		if roomC := client.roomC; roomC != nil {
+			player.Location = &AdminLocationDetail{
+				MapId:      roomC.mapId,
+				PrevMapId:  roomC.prevMapId,
+				Locations:  roomC.locations,
+				X:          roomC.x,
+				Y:          roomC.y,
+				Spectating: roomC.spectator,
+			}
+			if roomC.instance != nil {
+				player.Location.Instance = roomC.instance.id
+			}
+		}
+	}
+
+	player.RecentChat, err = getPlayerRecentChatMessages(uuid, adminPlayerRecentChatLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	player.Reports, err = getPlayerReports(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	return player, nil
+}
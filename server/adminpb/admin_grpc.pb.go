@@ -0,0 +1,410 @@
+// Copyright (C) 2021-2024  The YNOproject Developers
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: admin.proto
+
+package adminpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Admin_GetPlayers_FullMethodName       = "/ynoserver.admin.Admin/GetPlayers"
+	Admin_Ban_FullMethodName              = "/ynoserver.admin.Admin/Ban"
+	Admin_Unban_FullMethodName            = "/ynoserver.admin.Admin/Unban"
+	Admin_Mute_FullMethodName             = "/ynoserver.admin.Admin/Mute"
+	Admin_Unmute_FullMethodName           = "/ynoserver.admin.Admin/Unmute"
+	Admin_GrantBadges_FullMethodName      = "/ynoserver.admin.Admin/GrantBadges"
+	Admin_RevokeBadges_FullMethodName     = "/ynoserver.admin.Admin/RevokeBadges"
+	Admin_CloseEventPeriod_FullMethodName = "/ynoserver.admin.Admin/CloseEventPeriod"
+)
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Admin exposes moderation and event administration for scripted bulk operations.
+// Every call except GetPlayers names the moderator it acts on behalf of,
+// whose rank is checked as in the HTTP admin API.
+type AdminClient interface {
+	GetPlayers(ctx context.Context, in *GetPlayersRequest, opts ...grpc.CallOption) (*GetPlayersResponse, error)
+	Ban(ctx context.Context, in *ModerationRequest, opts ...grpc.CallOption) (*ModerationResponse, error)
+	Unban(ctx context.Context, in *ModerationRequest, opts ...grpc.CallOption) (*ModerationResponse, error)
+	Mute(ctx context.Context, in *ModerationRequest, opts ...grpc.CallOption) (*ModerationResponse, error)
+	Unmute(ctx context.Context, in *ModerationRequest, opts ...grpc.CallOption) (*ModerationResponse, error)
+	GrantBadges(ctx context.Context, in *BadgeRequest, opts ...grpc.CallOption) (*BadgeResponse, error)
+	RevokeBadges(ctx context.Context, in *BadgeRequest, opts ...grpc.CallOption) (*BadgeResponse, error)
+	CloseEventPeriod(ctx context.Context, in *CloseEventPeriodRequest, opts ...grpc.CallOption) (*CloseEventPeriodResponse, error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) GetPlayers(ctx context.Context, in *GetPlayersRequest, opts ...grpc.CallOption) (*GetPlayersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetPlayersResponse)
+	err := c.cc.Invoke(ctx, Admin_GetPlayers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Ban(ctx context.Context, in *ModerationRequest, opts ...grpc.CallOption) (*ModerationResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ModerationResponse)
+	err := c.cc.Invoke(ctx, Admin_Ban_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Unban(ctx context.Context, in *ModerationRequest, opts ...grpc.CallOption) (*ModerationResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ModerationResponse)
+	err := c.cc.Invoke(ctx, Admin_Unban_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Mute(ctx context.Context, in *ModerationRequest, opts ...grpc.CallOption) (*ModerationResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ModerationResponse)
+	err := c.cc.Invoke(ctx, Admin_Mute_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Unmute(ctx context.Context, in *ModerationRequest, opts ...grpc.CallOption) (*ModerationResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ModerationResponse)
+	err := c.cc.Invoke(ctx, Admin_Unmute_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GrantBadges(ctx context.Context, in *BadgeRequest, opts ...grpc.CallOption) (*BadgeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BadgeResponse)
+	err := c.cc.Invoke(ctx, Admin_GrantBadges_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) RevokeBadges(ctx context.Context, in *BadgeRequest, opts ...grpc.CallOption) (*BadgeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BadgeResponse)
+	err := c.cc.Invoke(ctx, Admin_RevokeBadges_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) CloseEventPeriod(ctx context.Context, in *CloseEventPeriodRequest, opts ...grpc.CallOption) (*CloseEventPeriodResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CloseEventPeriodResponse)
+	err := c.cc.Invoke(ctx, Admin_CloseEventPeriod_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServer is the server API for Admin service.
+// All implementations must embed UnimplementedAdminServer
+// for forward compatibility.
+//
+// Admin exposes moderation and event administration for scripted bulk operations.
+// Every call except GetPlayers names the moderator it acts on behalf of,
+// whose rank is checked as in the HTTP admin API.
+type AdminServer interface {
+	GetPlayers(context.Context, *GetPlayersRequest) (*GetPlayersResponse, error)
+	Ban(context.Context, *ModerationRequest) (*ModerationResponse, error)
+	Unban(context.Context, *ModerationRequest) (*ModerationResponse, error)
+	Mute(context.Context, *ModerationRequest) (*ModerationResponse, error)
+	Unmute(context.Context, *ModerationRequest) (*ModerationResponse, error)
+	GrantBadges(context.Context, *BadgeRequest) (*BadgeResponse, error)
+	RevokeBadges(context.Context, *BadgeRequest) (*BadgeResponse, error)
+	CloseEventPeriod(context.Context, *CloseEventPeriodRequest) (*CloseEventPeriodResponse, error)
+	mustEmbedUnimplementedAdminServer()
+}
+
+// UnimplementedAdminServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAdminServer struct{}
+
+func (UnimplementedAdminServer) GetPlayers(context.Context, *GetPlayersRequest) (*GetPlayersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPlayers not implemented")
+}
+func (UnimplementedAdminServer) Ban(context.Context, *ModerationRequest) (*ModerationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ban not implemented")
+}
+func (UnimplementedAdminServer) Unban(context.Context, *ModerationRequest) (*ModerationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unban not implemented")
+}
+func (UnimplementedAdminServer) Mute(context.Context, *ModerationRequest) (*ModerationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Mute not implemented")
+}
+func (UnimplementedAdminServer) Unmute(context.Context, *ModerationRequest) (*ModerationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unmute not implemented")
+}
+func (UnimplementedAdminServer) GrantBadges(context.Context, *BadgeRequest) (*BadgeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GrantBadges not implemented")
+}
+func (UnimplementedAdminServer) RevokeBadges(context.Context, *BadgeRequest) (*BadgeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeBadges not implemented")
+}
+func (UnimplementedAdminServer) CloseEventPeriod(context.Context, *CloseEventPeriodRequest) (*CloseEventPeriodResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CloseEventPeriod not implemented")
+}
+func (UnimplementedAdminServer) mustEmbedUnimplementedAdminServer() {}
+func (UnimplementedAdminServer) testEmbeddedByValue()               {}
+
+// UnsafeAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServer will
+// result in compilation errors.
+type UnsafeAdminServer interface {
+	mustEmbedUnimplementedAdminServer()
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	// If the following call pancis, it indicates UnimplementedAdminServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Admin_ServiceDesc, srv)
+}
+
+func _Admin_GetPlayers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPlayersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetPlayers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_GetPlayers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetPlayers(ctx, req.(*GetPlayersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Ban_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ModerationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Ban(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_Ban_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Ban(ctx, req.(*ModerationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Unban_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ModerationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Unban(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_Unban_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Unban(ctx, req.(*ModerationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Mute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ModerationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Mute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_Mute_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Mute(ctx, req.(*ModerationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Unmute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ModerationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Unmute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_Unmute_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Unmute(ctx, req.(*ModerationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GrantBadges_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BadgeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GrantBadges(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_GrantBadges_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GrantBadges(ctx, req.(*BadgeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_RevokeBadges_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BadgeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).RevokeBadges(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_RevokeBadges_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).RevokeBadges(ctx, req.(*BadgeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_CloseEventPeriod_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CloseEventPeriodRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).CloseEventPeriod(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_CloseEventPeriod_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).CloseEventPeriod(ctx, req.(*CloseEventPeriodRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Admin_ServiceDesc is the grpc.ServiceDesc for Admin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Admin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ynoserver.admin.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetPlayers",
+			Handler:    _Admin_GetPlayers_Handler,
+		},
+		{
+			MethodName: "Ban",
+			Handler:    _Admin_Ban_Handler,
+		},
+		{
+			MethodName: "Unban",
+			Handler:    _Admin_Unban_Handler,
+		},
+		{
+			MethodName: "Mute",
+			Handler:    _Admin_Mute_Handler,
+		},
+		{
+			MethodName: "Unmute",
+			Handler:    _Admin_Unmute_Handler,
+		},
+		{
+			MethodName: "GrantBadges",
+			Handler:    _Admin_GrantBadges_Handler,
+		},
+		{
+			MethodName: "RevokeBadges",
+			Handler:    _Admin_RevokeBadges_Handler,
+		},
+		{
+			MethodName: "CloseEventPeriod",
+			Handler:    _Admin_CloseEventPeriod_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "admin.proto",
+}
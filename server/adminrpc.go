@@ -0,0 +1,225 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"net"
+	"os"
+
+	"github.com/ynoproject/ynoserver/server/adminpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// adminRpcServer exposes moderation and event administration over gRPC with mutual TLS,
+// for scripted bulk operations that are awkward over the HTTP admin endpoints.
+// The service is defined in adminpb/admin.proto so clients can be generated for any language.
+type adminRpcServer struct {
+	adminpb.UnimplementedAdminServer
+}
+
+func initAdminRpc() {
+	if getConfig().adminRpc.listen == "" {
+		return
+	}
+
+	logInitTask("admin RPC")
+
+	cert, err := tls.LoadX509KeyPair(getConfig().adminRpc.certFile, getConfig().adminRpc.keyFile)
+	if err != nil {
+		log.Fatal("initAdminRpc(cert):", err)
+	}
+
+	clientCa, err := os.ReadFile(getConfig().adminRpc.clientCaFile)
+	if err != nil {
+		log.Fatal("initAdminRpc(ca):", err)
+	}
+
+	clientCas := x509.NewCertPool()
+	if !clientCas.AppendCertsFromPEM(clientCa) {
+		log.Fatal("initAdminRpc(ca): no certificates found")
+	}
+
+	listener, err := net.Listen("tcp", getConfig().adminRpc.listen)
+	if err != nil {
+		log.Fatal("initAdminRpc(listen):", err)
+	}
+
+	// separate from the IPC server so sibling-process methods aren't reachable over the network
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCas,
+		MinVersion:   tls.VersionTLS12,
+	})))
+	adminpb.RegisterAdminServer(server, &adminRpcServer{})
+
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			writeErrLog("SERVER", "admin RPC", err.Error())
+		}
+	}()
+}
+
+// checkAdminRpcModerator checks the rank of the moderator a call acts on behalf of, as in the HTTP API
+func checkAdminRpcModerator(moderatorUuid string) error {
+	if moderatorUuid == "" || getPlayerRank(moderatorUuid) == 0 {
+		return status.Error(codes.PermissionDenied, "access denied")
+	}
+
+	return nil
+}
+
+func adminRpcInternalError(method string, err error) error {
+	writeErrLog("SERVER", "admin RPC "+method, err.Error())
+
+	return status.Error(codes.Internal, "internal error")
+}
+
+func (_ *adminRpcServer) GetPlayers(_ context.Context, _ *adminpb.GetPlayersRequest) (*adminpb.GetPlayersResponse, error) {
+	var players []*adminpb.Player
+
+	for _, client := range clients.Get() {
+		players = append(players, &adminpb.Player{
+			Uuid:    client.uuid,
+			Name:    client.name,
+			Rank:    int32(client.rank),
+			Latency: int32(client.latency.Load()),
+		})
+	}
+
+	return &adminpb.GetPlayersResponse{Players: players}, nil
+}
+
+func (_ *adminRpcServer) Ban(_ context.Context, req *adminpb.ModerationRequest) (*adminpb.ModerationResponse, error) {
+	return doAdminRpcModeration(req, func(moderatorUuid, uuid string) error {
+		err := tryBanPlayer(moderatorUuid, uuid)
+		if err == nil {
+			dispatchWebhook(webhookPlayerBanned, map[string]string{"uuid": uuid, "bannedBy": moderatorUuid})
+		}
+
+		return err
+	})
+}
+
+func (_ *adminRpcServer) Unban(_ context.Context, req *adminpb.ModerationRequest) (*adminpb.ModerationResponse, error) {
+	return doAdminRpcModeration(req, tryUnbanPlayer)
+}
+
+func (_ *adminRpcServer) Mute(_ context.Context, req *adminpb.ModerationRequest) (*adminpb.ModerationResponse, error) {
+	return doAdminRpcModeration(req, tryMutePlayer)
+}
+
+func (_ *adminRpcServer) Unmute(_ context.Context, req *adminpb.ModerationRequest) (*adminpb.ModerationResponse, error) {
+	return doAdminRpcModeration(req, tryUnmutePlayer)
+}
+
+func doAdminRpcModeration(req *adminpb.ModerationRequest, action func(moderatorUuid, uuid string) error) (*adminpb.ModerationResponse, error) {
+	if err := checkAdminRpcModerator(req.ModeratorUuid); err != nil {
+		return nil, err
+	}
+
+	failed := make(map[string]string)
+
+	for _, uuid := range req.Uuids {
+		if err := action(req.ModeratorUuid, uuid); err != nil {
+			failed[uuid] = err.Error()
+		}
+	}
+
+	return &adminpb.ModerationResponse{Failed: failed}, nil
+}
+
+func (_ *adminRpcServer) GrantBadges(_ context.Context, req *adminpb.BadgeRequest) (*adminpb.BadgeResponse, error) {
+	if err := checkAdminRpcModerator(req.ModeratorUuid); err != nil {
+		return nil, err
+	}
+
+	if err := checkAdminRpcBadgeIds(req.BadgeIds); err != nil {
+		return nil, err
+	}
+
+	if err := unlockPlayerBadges(req.Uuids, req.BadgeIds, req.ModeratorUuid); err != nil {
+		return nil, adminRpcInternalError("GrantBadges", err)
+	}
+
+	return &adminpb.BadgeResponse{}, nil
+}
+
+func (_ *adminRpcServer) RevokeBadges(_ context.Context, req *adminpb.BadgeRequest) (*adminpb.BadgeResponse, error) {
+	if err := checkAdminRpcModerator(req.ModeratorUuid); err != nil {
+		return nil, err
+	}
+
+	if err := checkAdminRpcBadgeIds(req.BadgeIds); err != nil {
+		return nil, err
+	}
+
+	if err := removePlayerBadges(req.Uuids, req.BadgeIds); err != nil {
+		if errors.Is(err, errPlayerBadgeNotOwned) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, adminRpcInternalError("RevokeBadges", err)
+	}
+
+	return &adminpb.BadgeResponse{}, nil
+}
+
+func checkAdminRpcBadgeIds(badgeIds []string) error {
+	for _, badgeId := range badgeIds {
+		var badgeExists bool
+
+		for _, gameBadges := range badges {
+			if _, ok := gameBadges[badgeId]; ok {
+				badgeExists = true
+				break
+			}
+		}
+
+		if !badgeExists {
+			return status.Error(codes.NotFound, "badge not found for the provided badge ID: "+badgeId)
+		}
+	}
+
+	return nil
+}
+
+func (_ *adminRpcServer) CloseEventPeriod(_ context.Context, req *adminpb.CloseEventPeriodRequest) (*adminpb.CloseEventPeriodResponse, error) {
+	if err := checkAdminRpcModerator(req.ModeratorUuid); err != nil {
+		return nil, err
+	}
+
+	if _, err := closeEventPeriod(int(req.PeriodId)); err != nil {
+		return nil, adminRpcInternalError("CloseEventPeriod", err)
+	}
+
+	dispatchWebhook(webhookEventPeriodEnded, map[string]int{"periodId": int(req.PeriodId)})
+
+	if err := refreshCurrentEventPeriod(); err != nil {
+		return nil, adminRpcInternalError("CloseEventPeriod", err)
+	}
+
+	return &adminpb.CloseEventPeriodResponse{}, nil
+}
@@ -0,0 +1,78 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/ynoproject/ynoserver/server/adminpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdminRpc(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := grpc.NewServer()
+	adminpb.RegisterAdminServer(server, &adminRpcServer{})
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client := adminpb.NewAdminClient(conn)
+
+	if _, err := client.GetPlayers(context.Background(), &adminpb.GetPlayersRequest{}); err != nil {
+		t.Errorf("GetPlayers: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"Ban", func() error {
+			_, err := client.Ban(context.Background(), &adminpb.ModerationRequest{Uuids: []string{"a"}})
+			return err
+		}},
+		{"GrantBadges", func() error {
+			_, err := client.GrantBadges(context.Background(), &adminpb.BadgeRequest{Uuids: []string{"a"}, BadgeIds: []string{"b"}})
+			return err
+		}},
+		{"CloseEventPeriod", func() error {
+			_, err := client.CloseEventPeriod(context.Background(), &adminpb.CloseEventPeriodRequest{PeriodId: 1})
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		if code := status.Code(tt.call()); code != codes.PermissionDenied {
+			t.Errorf("%s without moderator: got %v, want %v", tt.name, code, codes.PermissionDenied)
+		}
+	}
+}
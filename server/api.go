@@ -18,10 +18,12 @@
 package server
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -42,7 +44,10 @@ type PlayerInfo struct {
 	BadgeSlotCols   int    `json:"badgeSlotCols"`
 	ScreenshotLimit int    `json:"screenshotLimit"`
 	Medals          [5]int `json:"medals"`
+	Latency         int    `json:"latency,omitempty"`
+	MapId           string `json:"mapId,omitempty"`
 	LocationIds     []int  `json:"locationIds"`
+	MapsExplored    int    `json:"mapsExplored"`
 }
 
 type PlayerListData struct {
@@ -68,9 +73,38 @@ type PlayerListFullData struct {
 	Y             int    `json:"y"`
 
 	Online     bool      `json:"online"`
+	Status     string    `json:"status,omitempty"`
 	LastActive time.Time `json:"lastActive"`
 }
 
+type OnlinePlayerData struct {
+	PlayerListData
+
+	MapId     string `json:"mapId,omitempty"`
+	PrevMapId string `json:"prevMapId,omitempty"`
+	PartyId   int    `json:"partyId,omitempty"`
+	Status    string `json:"status"`
+	Latency   int    `json:"latency,omitempty"`
+}
+
+type PlayerSearchResult struct {
+	Uuid    string `json:"uuid"`
+	Name    string `json:"name"`
+	Rank    int    `json:"rank"`
+	Account bool   `json:"account"`
+	Badge   string `json:"badge"`
+	Online  bool   `json:"online"`
+}
+
+type PlayerSearchResults struct {
+	Players    []*PlayerSearchResult `json:"players"`
+	TotalCount int                   `json:"totalCount"`
+}
+
+const (
+	playerSearchPageSize = 25
+)
+
 type CheckUpdateData struct {
 	BadgeIds []string `json:"badgeIds"`
 	NewTags  bool     `json:"newTags"`
@@ -82,58 +116,94 @@ func initApi() {
 	http.HandleFunc("/session", handleSession)
 	http.HandleFunc("/room", handleRoom)
 
-	http.HandleFunc("/admin/getplayers", adminGetPlayers)
-	http.HandleFunc("/admin/getbans", adminGetBansMutes)
-	http.HandleFunc("/admin/getmutes", adminGetBansMutes)
-	http.HandleFunc("/admin/ban", adminBanMute)
-	http.HandleFunc("/admin/mute", adminBanMute)
-	http.HandleFunc("/admin/unban", adminBanMute)
-	http.HandleFunc("/admin/unmute", adminBanMute)
-	http.HandleFunc("/admin/changeusername", adminChangeUsername)
-	http.HandleFunc("/admin/resetpw", adminResetPw)
-	http.HandleFunc("/admin/grantbadge", adminManageBadge)
-	http.HandleFunc("/admin/revokebadge", adminManageBadge)
+	apiRouter.Register(adminRoutes)
+	apiRouter.Register(apiRoutes)
+}
+
+var adminRoutes = []ApiRoute{
+	{admin: true, path: "/getplayers", handler: adminGetPlayers, summary: "List connected players", params: []string{"map", "account", "rank", "ip"}},
+	{admin: true, path: "/getplayer", handler: adminGetPlayer, summary: "Get session, location, party, chat and moderation details of a player", params: []string{"uuid", "user"}},
+	{admin: true, path: "/getbans", handler: adminGetBansMutes, summary: "List banned players"},
+	{admin: true, path: "/getmutes", handler: adminGetBansMutes, summary: "List muted players"},
+	{admin: true, path: "/ban", handler: adminBanMute, summary: "Ban a player", params: []string{"uuid", "user"}},
+	{admin: true, path: "/mute", handler: adminBanMute, summary: "Mute a player", params: []string{"uuid", "user"}},
+	{admin: true, path: "/unban", handler: adminBanMute, summary: "Unban a player", params: []string{"uuid", "user"}},
+	{admin: true, path: "/unmute", handler: adminBanMute, summary: "Unmute a player", params: []string{"uuid", "user"}},
+	{admin: true, path: "/changeusername", handler: adminChangeUsername, summary: "Change the username of an account", params: []string{"user", "newUser"}},
+	{admin: true, path: "/resetpw", handler: adminResetPw, summary: "Reset the password of an account", params: []string{"user"}},
+	{admin: true, path: "/grantbadge", handler: adminManageBadge, summary: "Grant a badge to a player", params: []string{"uuid", "user", "id"}},
+	{admin: true, path: "/revokebadge", handler: adminManageBadge, summary: "Revoke a badge from a player", params: []string{"uuid", "user", "id"}},
+	{admin: true, path: "/grantmedal", handler: adminManageMedal, summary: "Grant a medal to a player", params: []string{"uuid", "user", "id", "game"}},
+	{admin: true, path: "/revokemedal", handler: adminManageMedal, summary: "Revoke a player's latest award of a medal", params: []string{"uuid", "user", "id", "game"}},
+	{admin: true, path: "/getbadgegrants", handler: adminGetBadgeGrants, summary: "List manually granted badges", params: []string{"uuid", "user", "id"}},
+	{admin: true, path: "/badgebatch", handler: adminBadgeBatch, summary: "Manage batched badge releases", params: []string{"game"}, commands: []string{"preview", "advance", "rollback"}},
+	{admin: true, path: "/testconditions", handler: adminTestConditions, summary: "Test badge conditions against a simulated game state", params: []string{"uuid", "map", "x", "y", "switches", "vars", "trigger", "value"}},
+	{admin: true, path: "/eventperiod", handler: adminEventPeriod, summary: "Manage event periods", params: []string{"game", "id", "ordinal", "startDate", "endDate", "weeklyExpCap", "enableVms"}, commands: []string{"close"}},
+	{admin: true, path: "/addeventlocation", handler: adminAddEventLocation, summary: "Add an event location", params: []string{"game", "title", "titleJP", "mapIds", "exp", "days", "depth", "minDepth"}},
+	{admin: true, path: "/eventexpmultiplier", handler: adminEventExpMultiplier, summary: "Manage event exp multipliers", params: []string{"multiplier", "startTime", "endTime", "game", "id"}, commands: []string{"list", "add", "remove"}},
+	{admin: true, path: "/savebackup", handler: adminSaveBackup, summary: "Manage save data backups", params: []string{"key", "uuid"}, commands: []string{"list", "backup", "restore"}},
+	{admin: true, path: "/metrics", handler: adminMetrics, summary: "Get session and database connection pool statistics"},
+	{admin: true, path: "/jobs", handler: adminJobs, summary: "List, run, retry and cancel background jobs", params: []string{"status", "type", "id"}, commands: []string{"list", "run", "retry", "cancel"}},
+	{admin: true, path: "/scheduler", handler: adminScheduler, summary: "List scheduled tasks with their next run times, run them immediately or pause them", params: []string{"name"}, commands: []string{"list", "run", "pause", "resume"}},
+	{admin: true, path: "/loglevel", handler: adminLogLevel, summary: "Get or set the minimum level of logged messages", params: []string{"level"}},
+	{admin: true, path: "/2kkicache", handler: admin2kkiCache, summary: "Purge cached Yume 2kki Explorer API responses, all of them or those of an action or query", params: []string{"action", "query"}, commands: []string{"purge"}},
+	{admin: true, path: "/discordbridge", handler: adminDiscordBridge, summary: "List, mute or unmute Discord users whose messages the Discord bridge relays into global chat", params: []string{"user"}, commands: []string{"list", "mute", "unmute"}},
+	{admin: true, path: "/reloadconfig", handler: adminReloadConfig, summary: "Reload the settings of the config file that can change while the server runs"},
+}
+
+var apiRoutes = []ApiRoute{
+	{path: "/party", handler: handleParty, summary: "Manage parties", params: []string{"partyId", "name", "description", "public", "pass", "coLocate", "theme", "player"}, commands: []string{"id", "list", "description", "create", "update", "join", "leave", "kick", "transfer", "disband"}, gzipCommands: []string{"list"}},
+	{path: "/savesync", handler: handleSaveSync, summary: "Synchronize save data", params: []string{"slot", "baseTimestamp", "version"}, commands: []string{"timestamp", "get", "push", "clear", "listVersions", "restore"}, methods: []string{http.MethodGet, http.MethodPost}},
+	{path: "/vm", handler: handleVm, summary: "Get a vending machine image", params: []string{"id", "scale"}},
+	{path: "/badge", handler: handleBadge, summary: "Manage badges", params: []string{"id", "row", "col", "game", "simple", "since", "name", "player"}, commands: []string{"list", "new", "set", "slotList", "slotSet", "savePreset", "applyPreset", "listPresets", "playerSlotList"}, gzipCommands: []string{"list"}},
+	{path: "/events", handler: handleEvents, summary: "Get events", params: []string{"page", "id", "type"}, commands: []string{"history", "leaderboard"}},
+	{path: "/minigame", handler: handleMinigame, summary: "Get minigame leaderboards", params: []string{"id"}, commands: []string{"list"}},
+	{path: "/timetrial", handler: handleTimeTrial, summary: "Get time trial leaderboards and ghosts", params: []string{"mapId", "uuid"}, commands: []string{"list", "ghost"}},
+	{path: "/medal", handler: handleMedal, summary: "List medals and the medals awarded to a player", params: []string{"player", "game"}, commands: []string{"list", "player"}},
+
+	{path: "/register", handler: handleRegister, summary: "Register an account", params: []string{"user", "password"}, methods: []string{http.MethodPost}},
+	{path: "/login", handler: handleLogin, summary: "Log in and get a session token", params: []string{"user", "password"}, methods: []string{http.MethodPost}},
+	{path: "/logout", handler: handleLogout, summary: "Log out of the current session"},
+	{path: "/changepw", handler: handleChangePw, summary: "Change the password of the current account", params: []string{"user", "password", "newPassword"}},
 
-	http.HandleFunc("/api/party", handleParty)
-	http.HandleFunc("/api/savesync", handleSaveSync)
-	http.HandleFunc("/api/vm", handleVm)
-	http.HandleFunc("/api/badge", handleBadge)
+	{path: "/addplayerfriend", handler: handleAddPlayerFriend, summary: "Add or accept a friend", params: []string{"uuid", "user"}},
+	{path: "/removeplayerfriend", handler: handleRemovePlayerFriend, summary: "Remove a friend", params: []string{"uuid", "user"}},
 
-	http.HandleFunc("/api/register", handleRegister)
-	http.HandleFunc("/api/login", handleLogin)
-	http.HandleFunc("/api/logout", handleLogout)
-	http.HandleFunc("/api/changepw", handleChangePw)
+	{path: "/blockplayer", handler: handleBlockPlayer, summary: "Block a player", params: []string{"uuid", "user"}},
+	{path: "/unblockplayer", handler: handleUnblockPlayer, summary: "Unblock a player", params: []string{"uuid", "user"}},
+	{path: "/blocklist", handler: handleBlockList, summary: "List blocked players"},
 
-	http.HandleFunc("/api/addplayerfriend", handleAddPlayerFriend)
-	http.HandleFunc("/api/removeplayerfriend", handleRemovePlayerFriend)
+	{path: "/chathistory", handler: handleChatHistory, summary: "Get global and party chat history", params: []string{"lastMsgId", "globalMsgLimit", "partyMsgLimit"}},
+	{path: "/clearchathistory", handler: handleClearChatHistory, summary: "Clear chat history up to a message", params: []string{"lastGlobalMsgId", "lastPartyMsgId"}},
 
-	http.HandleFunc("/api/blockplayer", handleBlockPlayer)
-	http.HandleFunc("/api/unblockplayer", handleUnblockPlayer)
-	http.HandleFunc("/api/blocklist", handleBlockList)
+	{path: "/gamelocations", handler: handleGameLocations, summary: "List game locations"},
 
-	http.HandleFunc("/api/chathistory", handleChatHistory)
-	http.HandleFunc("/api/clearchathistory", handleClearChatHistory)
+	{path: "/screenshot", handler: handleScreenshot, summary: "Manage screenshots", params: []string{"limit", "offset", "offsetId", "game", "sortOrder", "interval", "uuid", "mapId", "mapX", "mapY", "temp", "id", "value"}, commands: []string{"getScreenshotFeed", "getPlayerScreenshots", "getScreenshotGames", "upload", "setPublic", "setSpoiler", "setLike", "delete"}, methods: []string{http.MethodGet, http.MethodPost}},
 
-	http.HandleFunc("/api/gamelocations", handleGameLocations)
+	{path: "/2kki", handler: handle2kki, summary: "Query the Yume 2kki Explorer API", params: []string{"action"}},
 
-	http.HandleFunc("/api/screenshot", handleScreenshot)
+	{path: "/explorer", handler: handleExplorer, summary: "Get the Yume 2kki Explorer URL for the current player", params: []string{"trackedLocations"}},
+	{path: "/explorercompletion", handler: handleExplorerCompletion, summary: "Get the location completion of the current player"},
+	{path: "/explorerlocations", handler: handleExplorerLocations, summary: "List locations visited by the current player"},
 
-	http.HandleFunc("/api/2kki", handle2kki)
+	{path: "/info", handler: handleInfo, summary: "Get info about the current player"},
 
-	http.HandleFunc("/api/explorer", handleExplorer)
-	http.HandleFunc("/api/explorercompletion", handleExplorerCompletion)
-	http.HandleFunc("/api/explorerlocations", handleExplorerLocations)
+	{path: "/players", handler: handlePlayers, summary: "Get the number of connected players"},
+	{path: "/players/online", handler: handleOnlinePlayers, summary: "List online players"},
+	{path: "/players/search", handler: handlePlayerSearch, summary: "Search players by name", params: []string{"q", "page"}},
+	{path: "/profile", handler: handleProfile, summary: "Get the public profile of a player", params: []string{"player"}},
 
-	http.HandleFunc("/api/info", handleInfo)
+	{path: "/rooms", handler: handleRooms, summary: "Get the player counts of occupied rooms"},
+	{path: "/statistics", handler: handleStatistics, summary: "Get player statistics", params: []string{"player", "game"}},
 
-	http.HandleFunc("/api/players", handlePlayers)
+	{path: "/schedule", handler: handleSchedules, summary: "Manage event schedules", params: []string{"id", "scheduleId", "recurring", "official", "interval", "intervalType", "datetime", "partyId", "name", "description", "ownerUuid", "game", "systemName", "discord", "youtube", "twitch", "niconico", "openrec", "bilibili", "value"}, commands: []string{"list", "update", "follow", "cancel"}},
+	{path: "/registernotification", handler: handleRegisterSubscriber, summary: "Register a push notification subscription", methods: []string{http.MethodPost}},
+	{path: "/unregisternotification", handler: handleUnregisterSubscriber, summary: "Unregister a push notification subscription", methods: []string{http.MethodPost}},
+	{path: "/vapidpublickey", handler: handleVapidPublicKeyRequest, summary: "Get the VAPID public key for push notifications"},
 
-	http.HandleFunc("/api/schedule", handleSchedules)
-	http.HandleFunc("/api/registernotification", handleRegisterSubscriber)
-	http.HandleFunc("/api/unregisternotification", handleUnregisterSubscriber)
-	http.HandleFunc("/api/vapidpublickey", handleVapidPublicKeyRequest)
+	{path: "/report", handler: handleReport, summary: "Report a player", methods: []string{http.MethodPost}},
 
-	http.HandleFunc("/api/report", handleReport)
+	{path: "/spec", handler: handleApiSpec, summary: "Get the OpenAPI description of the API"},
 }
 
 func handleParty(w http.ResponseWriter, r *http.Request) {
@@ -173,12 +243,7 @@ func handleParty(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(strconv.Itoa(partyId)))
 		return
 	case "list":
-		partyListData, err := getAllPartyData()
-		if err != nil {
-			handleInternalError(w, r, err)
-			return
-		}
-		partyListDataJson, err := json.Marshal(partyListData)
+		partyListDataJson, err := getPartyListJson()
 		if err != nil {
 			handleInternalError(w, r, err)
 			return
@@ -262,6 +327,7 @@ func handleParty(w http.ResponseWriter, r *http.Request) {
 				pass = passParam
 			}
 		}
+		coLocate := r.URL.Query().Get("coLocate") != ""
 		themeParam := r.URL.Query().Get("theme")
 		if themeParam == "" {
 			handleError(w, r, "theme not specified")
@@ -272,9 +338,9 @@ func handleParty(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if create {
-			partyId, err = createPartyData(nameParam, public, pass, themeParam, description, uuid)
+			partyId, err = createPartyData(nameParam, public, pass, themeParam, description, coLocate, uuid)
 		} else {
-			err = updatePartyData(partyId, nameParam, public, pass, themeParam, description, uuid)
+			err = updatePartyData(partyId, nameParam, public, pass, themeParam, description, coLocate, uuid)
 		}
 		if err != nil {
 			handleInternalError(w, r, err)
@@ -286,6 +352,7 @@ func handleParty(w http.ResponseWriter, r *http.Request) {
 				handleInternalError(w, r, err)
 				return
 			}
+			dispatchWebhook(webhookPartyCreated, map[string]any{"partyId": partyId, "name": nameParam, "ownerUuid": uuid, "public": public})
 			w.Write([]byte(strconv.Itoa(partyId)))
 			return
 		}
@@ -306,6 +373,15 @@ func handleParty(w http.ResponseWriter, r *http.Request) {
 				handleInternalError(w, r, errors.New("party id not in cache"))
 				return
 			}
+			blocked, err := isPlayerBlocked(uuid, party.OwnerUuid)
+			if err != nil {
+				handleInternalError(w, r, err)
+				return
+			}
+			if blocked {
+				handleError(w, r, "player is blocked by party owner")
+				return
+			}
 			if !party.Public {
 				passParam := r.URL.Query().Get("pass")
 				if passParam == "" {
@@ -455,6 +531,7 @@ func handlePartyMemberLeave(partyId int, playerUuid string) error {
 
 func handleSaveSync(w http.ResponseWriter, r *http.Request) {
 	var uuid string
+	var rank int
 	var banned bool
 
 	token := r.Header.Get("Authorization")
@@ -462,7 +539,7 @@ func handleSaveSync(w http.ResponseWriter, r *http.Request) {
 		handleError(w, r, "token not specified")
 		return
 	} else {
-		uuid, _, _, _, banned, _ = getPlayerDataFromToken(token)
+		uuid, _, rank, _, banned, _ = getPlayerDataFromToken(token)
 		if uuid == "" {
 			handleError(w, r, "invalid token")
 			return
@@ -480,9 +557,21 @@ func handleSaveSync(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	saveSizeLimit := getSaveSizeLimit(rank)
+
+	var slot int
+	if slotParam := r.URL.Query().Get("slot"); slotParam != "" {
+		var err error
+		slot, err = strconv.Atoi(slotParam)
+		if err != nil || slot < 0 || slot > maxSaveSlot {
+			handleError(w, r, "invalid slot")
+			return
+		}
+	}
+
 	switch commandParam {
 	case "timestamp":
-		timestamp, err := getSaveDataTimestamp(uuid)
+		timestamp, err := getSaveDataTimestamp(uuid, slot)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				return
@@ -490,10 +579,18 @@ func handleSaveSync(w http.ResponseWriter, r *http.Request) {
 			handleInternalError(w, r, err)
 			return
 		}
+		saveSize, err := getSaveDataSize(uuid, slot)
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+		// lets clients warn before uploading a save that is too large
+		w.Header().Set("X-Save-Size-Limit", strconv.Itoa(saveSizeLimit))
+		w.Header().Set("X-Save-Size-Remaining", strconv.Itoa(max(saveSizeLimit-saveSize, 0)))
 		w.Write([]byte(timestamp.Format(time.RFC3339)))
 		return
 	case "get":
-		saveData, err := getSaveData(uuid)
+		saveData, err := getSaveData(uuid, slot)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				w.Write([]byte("{}"))
@@ -502,27 +599,113 @@ func handleSaveSync(w http.ResponseWriter, r *http.Request) {
 			handleInternalError(w, r, err)
 			return
 		}
+		// the body depends on whether the client accepts gzip, which caches must take into account
+		w.Header().Add("Vary", "Accept-Encoding")
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			gz.Write(saveData)
+			return
+		}
 		w.Write(saveData)
 		return
 	case "push":
-		data, err := io.ReadAll(r.Body)
 		defer r.Body.Close()
-		if err != nil || len(data) > 1024*1024*8 {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				handleError(w, r, "invalid data")
+				return
+			}
+			defer gz.Close()
+			body = gz
+		}
+		// reject the upload if the save was updated from another device since the client last synced
+		baseTimestamp := r.Header.Get("If-Match")
+		if baseTimestamp == "" {
+			baseTimestamp = r.URL.Query().Get("baseTimestamp")
+		}
+		if baseTimestamp != "" {
+			base, err := time.Parse(time.RFC3339, strings.Trim(baseTimestamp, "\""))
+			if err != nil {
+				handleError(w, r, "invalid base timestamp")
+				return
+			}
+			timestamp, err := getSaveDataTimestamp(uuid, slot)
+			if err != nil {
+				handleInternalError(w, r, err)
+				return
+			}
+			if timestamp.Truncate(time.Second).After(base) {
+				http.Error(w, timestamp.Format(time.RFC3339), http.StatusConflict)
+				return
+			}
+		}
+		// limit the decompressed size as well
+		data, err := io.ReadAll(io.LimitReader(body, int64(saveSizeLimit)+1))
+		if err != nil {
 			handleError(w, r, "invalid data")
 			return
 		}
-		err = createGameSaveData(uuid, data)
+		if len(data) > saveSizeLimit {
+			handleError(w, r, "save exceeds size limit")
+			return
+		}
+		if validationErrors := validateSaveData(data); len(validationErrors) > 0 {
+			validationErrorsJson, err := json.Marshal(validationErrors)
+			if err != nil {
+				handleInternalError(w, r, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write(validationErrorsJson)
+			return
+		}
+		err = createGameSaveData(uuid, slot, data)
 		if err != nil {
 			handleInternalError(w, r, err)
 			return
 		}
+		sendSaveDataUpdate(uuid, slot)
 		return
 	case "clear":
-		err := clearGameSaveData(uuid)
+		err := clearGameSaveData(uuid, slot)
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+	case "listVersions":
+		versions, err := getSaveDataVersions(uuid, slot)
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+		versionsJson, err := json.Marshal(versions)
+		if err != nil {
+			handleError(w, r, "error while marshaling")
+			return
+		}
+		w.Write(versionsJson)
+		return
+	case "restore":
+		version, err := strconv.ParseInt(r.URL.Query().Get("version"), 10, 64)
 		if err != nil {
+			handleError(w, r, "invalid version")
+			return
+		}
+		err = restoreGameSaveData(uuid, slot, version)
+		if err != nil {
+			if os.IsNotExist(err) {
+				handleError(w, r, "version not found")
+				return
+			}
 			handleInternalError(w, r, err)
 			return
 		}
+		sendSaveDataUpdate(uuid, slot)
 	default:
 		handleError(w, r, "unknown command")
 		return
@@ -544,20 +727,33 @@ func handleVm(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	scale := 1
+	if scaleParam := r.URL.Query().Get("scale"); scaleParam != "" {
+		scale, err = strconv.Atoi(scaleParam)
+		if err != nil || scale < 1 || scale > maxEventVmImageScale {
+			handleError(w, r, "invalid scale")
+			return
+		}
+	}
+
 	mapId, eventId, err := getEventVmInfo(eventVmId)
 	if err != nil {
 		handleInternalError(w, r, err)
 		return
 	}
 
-	fileBytes, err := os.ReadFile("vms/Map" + fmt.Sprintf("%04d", mapId) + "_EV" + fmt.Sprintf("%04d", eventId) + ".png")
+	vmImage, err := getEventVmImage(mapId, eventId, scale)
 	if err != nil {
 		handleInternalError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Write(fileBytes)
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("ETag", vmImage.etag)
+
+	// handles If-None-Match and If-Modified-Since
+	http.ServeContent(w, r, "", vmImage.modTime, bytes.NewReader(vmImage.data))
 }
 
 func handleBadge(w http.ResponseWriter, r *http.Request) {
@@ -614,12 +810,7 @@ func handleBadge(w http.ResponseWriter, r *http.Request) {
 			case "null":
 				unlocked = true
 			default:
-				tags, _, err := getPlayerTags(uuid)
-				if err != nil {
-					handleInternalError(w, r, err)
-					return
-				}
-				badgeData, err := getPlayerBadgeData(uuid, rank, tags, true, true)
+				badgeData, err := getCachedPlayerBadgeData(uuid, rank, true)
 				if err != nil {
 					handleInternalError(w, r, err)
 					return
@@ -682,44 +873,59 @@ func handleBadge(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	case "list":
-		var tags []string
-		if token != "" {
-			var err error
-			tags, _, err = getPlayerTags(uuid)
-			if err != nil {
-				handleInternalError(w, r, err)
+		gameParam := r.URL.Query().Get("game")
+		if gameParam != "" {
+			if _, ok := badges[gameParam]; !ok {
+				handleError(w, r, "unknown game")
 				return
 			}
 		}
+		var response any
 		if r.URL.Query().Get("simple") == "true" {
-			simpleBadgeData, err := getSimplePlayerBadgeData(uuid, rank, tags, token != "")
+			simpleBadgeData, err := getSimplePlayerBadgeData(uuid, rank, nil, token != "")
 			if err != nil {
 				handleInternalError(w, r, err)
 				return
 			}
-			simpleBadgeDataJson, err := json.Marshal(simpleBadgeData)
-			if err != nil {
-				handleInternalError(w, r, err)
-				return
+			if gameParam != "" {
+				gameBadges := []*SimplePlayerBadge{}
+				for _, badge := range simpleBadgeData {
+					if badge.Game == gameParam {
+						gameBadges = append(gameBadges, badge)
+					}
+				}
+				response = &GameBadgeListData{Game: gameParam, TotalBp: getGameTotalBp(gameParam), Badges: gameBadges}
+			} else {
+				response = simpleBadgeData
 			}
-			w.Write(simpleBadgeDataJson)
 		} else {
 			if token == "" {
 				handleError(w, r, "cannot retrieve player badge data for guest player")
 				return
 			}
-			badgeData, err := getPlayerBadgeData(uuid, rank, tags, true, false)
+			badgeData, err := getCachedPlayerBadgeData(uuid, rank, false)
 			if err != nil {
 				handleInternalError(w, r, err)
 				return
 			}
-			badgeDataJson, err := json.Marshal(badgeData)
-			if err != nil {
-				handleInternalError(w, r, err)
-				return
+			if gameParam != "" {
+				gameBadges := []*PlayerBadge{}
+				for _, badge := range badgeData {
+					if badge.Game == gameParam {
+						gameBadges = append(gameBadges, badge)
+					}
+				}
+				response = &GameBadgeListData{Game: gameParam, TotalBp: getGameTotalBp(gameParam), Badges: gameBadges}
+			} else {
+				response = badgeData
 			}
-			w.Write(badgeDataJson)
 		}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+		w.Write(responseJson)
 		return
 	case "new":
 		since := r.URL.Query().Get("since")
@@ -745,7 +951,7 @@ func handleBadge(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if len(newUnlockedBadgeIds) != 0 {
-			err := updatePlayerBadgeSlotCounts(uuid)
+			err := updatePlayerBadgeSlotCounts(context.Background(), uuid)
 			if err != nil {
 				handleInternalError(w, r, err)
 				return
@@ -772,6 +978,40 @@ func handleBadge(w http.ResponseWriter, r *http.Request) {
 		}
 		w.Write(badgeSlotsJson)
 		return
+	case "savePreset", "applyPreset":
+		nameParam := r.URL.Query().Get("name")
+		if nameParam == "" || len(nameParam) > 32 {
+			handleError(w, r, "invalid preset name")
+			return
+		}
+
+		var err error
+		if commandParam == "savePreset" {
+			err = writeBadgeSlotPreset(uuid, nameParam)
+		} else {
+			err = applyBadgeSlotPreset(uuid, nameParam)
+		}
+		if err != nil {
+			if errors.Is(err, errBadgeSlotPresetLimit) || errors.Is(err, errBadgeSlotPresetNotFound) {
+				handleError(w, r, err.Error())
+			} else {
+				handleInternalError(w, r, err)
+			}
+			return
+		}
+	case "listPresets":
+		presetNames, err := getBadgeSlotPresetNames(uuid)
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+		presetNamesJson, err := json.Marshal(presetNames)
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+		w.Write(presetNamesJson)
+		return
 	case "playerSlotList":
 		playerParam := r.URL.Query().Get("player")
 		if playerParam == "" {
@@ -876,13 +1116,16 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if getUuidFromToken(token) == "" {
+	uuid := getUuidFromToken(token)
+	if uuid == "" {
 		handleError(w, r, "invalid token")
 		return
 	}
 
 	db.Exec("DELETE FROM playerSessions WHERE sessionId = ?", token)
 
+	invalidatePlayerData(uuid)
+
 	w.Write([]byte("ok"))
 }
 
@@ -1016,6 +1259,9 @@ func handleAddRemovePlayerFriend(w http.ResponseWriter, r *http.Request, isAdd b
 		return
 	}
 
+	sendFriendListUpdate(uuid)
+	sendFriendListUpdate(targetUuid)
+
 	w.Write([]byte("ok"))
 }
 
@@ -1058,8 +1304,21 @@ func handleBlockPlayer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// blocked players can't remain friends
+	err = removePlayerFriend(uuid, targetUuid)
+	if err != nil {
+		handleInternalError(w, r, err)
+		return
+	}
+
 	// "disconnect" them NOW!!!
 	if client, ok := clients.Load(uuid); ok {
+		err = client.cacheBlockedUsers()
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+
 		if otherClient, ok := clients.Load(targetUuid); ok {
 			if (client.roomC != nil && otherClient.roomC != nil) && client.roomC.room == otherClient.roomC.room {
 				client.roomC.outbox <- buildMsg("d", otherClient.id)
@@ -1112,6 +1371,12 @@ func handleUnblockPlayer(w http.ResponseWriter, r *http.Request) {
 
 	// "connect" them NOW!!!
 	if client, ok := clients.Load(uuid); ok {
+		err = client.cacheBlockedUsers()
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+
 		if otherClient, ok := clients.Load(targetUuid); ok {
 			if (client.roomC != nil && otherClient.roomC != nil) && client.roomC.room == otherClient.roomC.room {
 				client.roomC.getPlayerData(otherClient.roomC)
@@ -1161,7 +1426,7 @@ func handleBlockList(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleExplorer(w http.ResponseWriter, r *http.Request) {
-	if config.gameName != "2kki" {
+	if getConfig().gameName != "2kki" {
 		handleError(w, r, "explorer is only available for Yume 2kki")
 		return
 	}
@@ -1189,13 +1454,7 @@ func handleExplorer(w http.ResponseWriter, r *http.Request) {
 				retUrl += url.QueryEscape(locationName)
 
 				getConnectionsUrl := "https://2kki.app/getConnectedLocations?locationName=" + url.QueryEscape(locationName)
-				resp, err := http.Get(getConnectionsUrl)
-				if err != nil {
-					writeErrLog(getIp(r), r.URL.Path, err.Error())
-					continue
-				}
-				defer resp.Body.Close()
-				body, err := io.ReadAll(resp.Body)
+				body, err := explorerApiBreaker.get(getConnectionsUrl)
 				if err != nil {
 					writeErrLog(getIp(r), r.URL.Path, err.Error())
 					continue
@@ -1254,7 +1513,7 @@ func handleExplorerCompletion(w http.ResponseWriter, r *http.Request) {
 
 	uuid := getUuidFromToken(token)
 
-	locationCompletion, err := getPlayerGameLocationCompletion(uuid, config.gameName)
+	locationCompletion, err := getPlayerGameLocationCompletion(uuid, getConfig().gameName)
 	if err != nil {
 		handleError(w, r, err.Error())
 		return
@@ -1273,7 +1532,7 @@ func handleExplorerLocations(w http.ResponseWriter, r *http.Request) {
 
 	uuid := getUuidFromToken(token)
 
-	locationCompletion, err := getPlayerGameLocationCompletion(uuid, config.gameName)
+	locationCompletion, err := getPlayerGameLocationCompletion(uuid, getConfig().gameName)
 	if err != nil {
 		handleError(w, r, err.Error())
 		return
@@ -1299,12 +1558,24 @@ func handleExplorerLocations(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleError(w http.ResponseWriter, r *http.Request, payload string) {
-	writeErrLog(getIp(r), r.URL.Path, payload)
+	writeErrLog(getIp(r), r.URL.Path, payload, "requestId", getRequestId(r))
+
+	if getApiVersion(r) >= apiV2 {
+		writeApiError(w, r, payload, http.StatusBadRequest)
+		return
+	}
+
 	http.Error(w, payload, http.StatusBadRequest)
 }
 
 func handleInternalError(w http.ResponseWriter, r *http.Request, err error) {
-	writeErrLog(getIp(r), r.URL.Path, err.Error())
+	writeErrLog(getIp(r), r.URL.Path, err.Error(), "requestId", getRequestId(r))
+
+	if getApiVersion(r) >= apiV2 {
+		writeApiError(w, r, "internal error", http.StatusInternalServerError)
+		return
+	}
+
 	http.Error(w, "400 - Bad Request", http.StatusBadRequest)
 }
 
@@ -1328,12 +1599,12 @@ func handleChatHistory(w http.ResponseWriter, r *http.Request) {
 
 	globalMsgLimitParam := r.URL.Query().Get("globalMsgLimit")
 	if globalMsgLimitParam == "" {
-		globalMsgLimitParam = "100"
+		globalMsgLimitParam = strconv.Itoa(chatHistoryGlobalMsgLimit)
 	}
 
 	partyMsgLimitParam := r.URL.Query().Get("partyMsgLimit")
 	if partyMsgLimitParam == "" {
-		partyMsgLimitParam = "250"
+		partyMsgLimitParam = strconv.Itoa(chatHistoryPartyMsgLimit)
 	}
 
 	globalMsgLimit, err := strconv.Atoi(globalMsgLimitParam)
@@ -1348,15 +1619,15 @@ func handleChatHistory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if globalMsgLimit <= 0 || globalMsgLimit > 100 {
-		globalMsgLimit = 100
+	if globalMsgLimit <= 0 || globalMsgLimit > chatHistoryGlobalMsgLimit {
+		globalMsgLimit = chatHistoryGlobalMsgLimit
 	}
 
-	if partyMsgLimit <= 0 || partyMsgLimit > 250 {
-		partyMsgLimit = 250
+	if partyMsgLimit <= 0 || partyMsgLimit > chatHistoryPartyMsgLimit {
+		partyMsgLimit = chatHistoryPartyMsgLimit
 	}
 
-	chatHistory, err := getChatMessageHistory(uuid, globalMsgLimit, partyMsgLimit, lastMsgId)
+	chatHistory, err := getChatMessageHistory(r.Context(), uuid, globalMsgLimit, partyMsgLimit, lastMsgId)
 	if err != nil {
 		handleInternalError(w, r, err)
 		return
@@ -1406,7 +1677,7 @@ func handleClearChatHistory(w http.ResponseWriter, r *http.Request) {
 }
 
 func handle2kki(w http.ResponseWriter, r *http.Request) {
-	if config.gameName != "2kki" {
+	if getConfig().gameName != "2kki" {
 		handleError(w, r, "endpoint not supported")
 		return
 	}
@@ -1444,6 +1715,7 @@ func handleInfo(w http.ResponseWriter, r *http.Request) {
 	var screenshotLimit int
 	var medals [5]int
 	var locationIds []int
+	var mapsExplored int
 
 	var err error
 
@@ -1453,7 +1725,8 @@ func handleInfo(w http.ResponseWriter, r *http.Request) {
 	} else {
 		uuid, name, rank, badge, badgeSlotRows, badgeSlotCols, screenshotLimit = getPlayerInfoFromToken(token)
 		medals = getPlayerMedals(uuid)
-		locationIds, _ = getPlayerGameLocationIds(uuid, config.gameName)
+		locationIds, _ = getPlayerGameLocationIds(uuid, getConfig().gameName)
+		mapsExplored, _ = getPlayerVisitedMapCount(uuid, getConfig().gameName)
 	}
 
 	// guest accounts with no playerGameData records will return nothing
@@ -1472,6 +1745,7 @@ func handleInfo(w http.ResponseWriter, r *http.Request) {
 		ScreenshotLimit: screenshotLimit,
 		Medals:          medals,
 		LocationIds:     locationIds,
+		MapsExplored:    mapsExplored,
 	}
 	playerInfoJson, err := json.Marshal(playerInfo)
 	if err != nil {
@@ -1481,6 +1755,112 @@ func handleInfo(w http.ResponseWriter, r *http.Request) {
 	w.Write(playerInfoJson)
 }
 
+func handleOnlinePlayers(w http.ResponseWriter, r *http.Request) {
+	var uuid string
+
+	token := r.Header.Get("Authorization")
+	if token == "" {
+		uuid, _, _ = getPlayerInfo(getIp(r))
+	} else {
+		uuid = getUuidFromToken(token)
+	}
+
+	var partyId int
+	var onlineFriends map[string]bool
+	if viewer, ok := clients.Load(uuid); ok {
+		partyId = viewer.partyId
+		onlineFriends = viewer.onlineFriends
+	}
+
+	onlinePlayers := []*OnlinePlayerData{}
+
+	for _, client := range clients.Get() {
+		if client.name == "" || client.blockedUsers[uuid] {
+			continue
+		}
+
+		onlinePlayer := &OnlinePlayerData{
+			PlayerListData: PlayerListData{
+				Uuid:        client.uuid,
+				Name:        client.name,
+				SystemName:  client.system,
+				Rank:        client.rank,
+				Account:     client.account,
+				Badge:       client.badge,
+				Medals:      client.medals,
+				SpriteName:  client.sprite,
+				SpriteIndex: client.spriteIndex,
+			},
+			Status:  client.status,
+			Latency: int(client.latency.Load()),
+		}
+
+		// same rules as map chat for players in private mode
+		visible := !client.private || (client.partyId != 0 && client.partyId == partyId) || onlineFriends[client.uuid]
+
+		if visible {
+			onlinePlayer.PartyId = client.partyId
+
+			if client.roomC != nil && !client.hideLocation {
+				onlinePlayer.MapId = client.roomC.mapId
+				onlinePlayer.PrevMapId = client.roomC.prevMapId
+			}
+		}
+
+		onlinePlayers = append(onlinePlayers, onlinePlayer)
+	}
+
+	onlinePlayersJson, err := json.Marshal(onlinePlayers)
+	if err != nil {
+		handleInternalError(w, r, err)
+		return
+	}
+
+	w.Write(onlinePlayersJson)
+}
+
+func handlePlayerSearch(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if len([]rune(query)) < 2 {
+		handleError(w, r, "query too short")
+		return
+	}
+
+	page := 1
+	if pageParam := r.URL.Query().Get("page"); pageParam != "" {
+		var err error
+		page, err = strconv.Atoi(pageParam)
+		if err != nil || page < 1 {
+			handleError(w, r, "invalid page")
+			return
+		}
+	}
+
+	searchResults, err := getPlayerSearchResults(r.Context(), query, playerSearchPageSize, (page-1)*playerSearchPageSize)
+	if err != nil {
+		handleInternalError(w, r, err)
+		return
+	}
+
+	var response any = searchResults
+	if getApiVersion(r) >= apiV2 {
+		response = ApiPage{
+			Items:      searchResults.Players,
+			Page:       page,
+			PageSize:   playerSearchPageSize,
+			TotalCount: searchResults.TotalCount,
+		}
+	}
+
+	searchResultsJson, err := json.Marshal(response)
+	if err != nil {
+		handleInternalError(w, r, err)
+		return
+	}
+
+	w.Write(searchResultsJson)
+}
+
 func handlePlayers(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(strconv.Itoa(clients.GetAmount())))
 }
@@ -1497,41 +1877,57 @@ func query2kki(action string, queryString string) (response string, err error) {
 			url += "?" + queryString
 		}
 
-		resp, err := http.Get(url)
+		body, err := explorerApiBreaker.get(url)
 		if err != nil {
-			return "", err
-		}
+			// expired responses are served while 2kki.app is unavailable
+			if db.QueryRow("SELECT response FROM 2kkiApiQueries WHERE action = ? AND query = ?", action, queryString).Scan(&response) != nil {
+				return "", err
+			}
+		} else {
+			response = string(body)
 
-		defer resp.Body.Close()
+			// error responses are cached for a shorter time, so repeated failing queries don't reach the upstream
+			ttl := get2kkiCacheTtl(action)
+			if is2kkiErrorResponse(response) {
+				ttl = getConfig().explorerCache.errorTtl
+			}
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return "", err
-		}
+			ttlMinutes := int(ttl.Minutes())
 
-		if strings.HasPrefix(string(body), "{\"error\"") || strings.HasPrefix(string(body), "<!DOCTYPE html>") {
-			return string(body), errors.New("received error response from Yume 2kki Explorer API: " + string(body))
-		} else {
-			_, err = db.Exec("INSERT INTO 2kkiApiQueries (action, query, response, timestampExpired) VALUES (?, ?, ?, DATE_ADD(NOW(), INTERVAL 1 HOUR)) ON DUPLICATE KEY UPDATE response = ?, timestampExpired = DATE_ADD(NOW(), INTERVAL 1 HOUR)", action, queryString, string(body), string(body))
+			_, err = db.Exec("INSERT INTO 2kkiApiQueries (action, query, response, timestampExpired) VALUES (?, ?, ?, DATE_ADD(NOW(), INTERVAL ? MINUTE)) "+db.upsert("action, query", "response = ?, timestampExpired = DATE_ADD(NOW(), INTERVAL ? MINUTE)"), action, queryString, response, ttlMinutes, response, ttlMinutes)
 			if err != nil {
 				return "", err
 			}
 		}
+	}
 
-		return string(body), nil
+	if is2kkiErrorResponse(response) {
+		return response, errors.New("received error response from Yume 2kki Explorer API: " + response)
 	}
 
 	return response, nil
 }
 
+func is2kkiErrorResponse(response string) bool {
+	return strings.HasPrefix(response, "{\"error\"") || strings.HasPrefix(response, "<!DOCTYPE html>")
+}
+
+func get2kkiCacheTtl(action string) time.Duration {
+	if ttl, ok := getConfig().explorerCache.actionTtls[action]; ok {
+		return ttl
+	}
+
+	return getConfig().explorerCache.ttl
+}
+
 func queryWiki(action string, queryString string) (response string, err error) {
-	err = db.QueryRow("SELECT response FROM wikiApiQueries WHERE game = ? AND action = ? AND query = ? AND NOW() < timestampExpired", config.gameName, action, queryString).Scan(&response)
+	err = db.QueryRow("SELECT response FROM wikiApiQueries WHERE game = ? AND action = ? AND query = ? AND NOW() < timestampExpired", getConfig().gameName, action, queryString).Scan(&response)
 	if err != nil {
 		if err != sql.ErrNoRows {
 			return "", err
 		}
 
-		url := "https://wrapper.yume.wiki/" + action + "?game=" + config.gameName
+		url := "https://wrapper.yume.wiki/" + action + "?game=" + getConfig().gameName
 		if queryString != "" {
 			url += "&" + queryString
 		}
@@ -1555,7 +1951,7 @@ func queryWiki(action string, queryString string) (response string, err error) {
 		if strings.HasPrefix(bodyStr, "{\"error\"") || strings.HasPrefix(bodyStr, "<!DOCTYPE html>") {
 			return "", errors.New("received error response from Yume Wiki API: " + bodyStr)
 		} else {
-			_, err = db.Exec("INSERT INTO wikiApiQueries (game, action, query, response, timestampExpired) VALUES (?, ?, ?, ?, DATE_ADD(NOW(), INTERVAL 1 HOUR)) ON DUPLICATE KEY UPDATE response = ?, timestampExpired = DATE_ADD(NOW(), INTERVAL 12 HOUR)", config.gameName, action, queryString, bodyStr, bodyStr)
+			_, err = db.Exec("INSERT INTO wikiApiQueries (game, action, query, response, timestampExpired) VALUES (?, ?, ?, ?, DATE_ADD(NOW(), INTERVAL 1 HOUR)) "+db.upsert("game, action, query", "response = ?, timestampExpired = DATE_ADD(NOW(), INTERVAL 12 HOUR)"), getConfig().gameName, action, queryString, bodyStr, bodyStr)
 			if err != nil {
 				return "", err
 			}
@@ -151,7 +151,7 @@ func (a *Assets) IsValidSound(name string) bool {
 	if strings.Contains(name, "../") || strings.Contains(name, "..\\") {
 		return false
 	}
-	if config.badSounds[name] {
+	if getConfig().badSounds[name] {
 		return false
 	}
 
@@ -175,11 +175,11 @@ func (a *Assets) IsValidPicture(name string) bool {
 		return false
 	}
 
-	if config.pictures[name] {
+	if getConfig().pictures[name] {
 		return true
 	}
 
-	for _, prefix := range config.picturePrefixes {
+	for _, prefix := range getConfig().picturePrefixes {
 		if strings.HasPrefix(strings.ToLower(name), prefix) {
 			return true
 		}
@@ -0,0 +1,481 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SaveBackup struct {
+	Key       string    `json:"key"`
+	Timestamp time.Time `json:"timestamp"`
+	Size      int64     `json:"size"`
+}
+
+func initBackups() {
+	if getConfig().backups.schedule == "" || getConfig().backups.s3.bucket == "" {
+		return
+	}
+
+	logInitTask("save backups")
+
+	scheduleTask("saveBackup", scheduler.Cron(getConfig().backups.schedule), func() {
+		// every process of a cluster runs the task, one of them queues the backup
+		if !isClusterLeader() {
+			return
+		}
+
+		if _, err := enqueueJob(jobTypeSaveBackup); err != nil {
+			writeErrLog("SERVER", "backups", "failed to queue save backup: "+err.Error())
+		}
+	})
+}
+
+func getSaveBackupPrefix() string {
+	return getConfig().backups.s3.prefix + getConfig().gameName + "/"
+}
+
+// createSaveBackup uploads an archive of the game's current saves, and of the accounts table
+// if configured on the main server, to object storage
+func createSaveBackup(ctx context.Context) error {
+	file, err := os.CreateTemp("", "saves-*.tar.gz")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	err = writeSaveBackupArchive(ctx, file)
+	if err != nil {
+		return err
+	}
+
+	key := getSaveBackupPrefix() + time.Now().UTC().Format("20060102T150405Z") + ".tar.gz"
+
+	return putS3Object(ctx, key, file)
+}
+
+func writeSaveBackupArchive(ctx context.Context, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	savesDir := "saves/" + getConfig().gameName + "/"
+
+	entries, err := os.ReadDir(savesDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".osd") {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err = addFileToArchive(tw, savesDir+entry.Name(), "saves/"+entry.Name())
+		if err != nil {
+			return err
+		}
+	}
+
+	// accounts are shared between games, so only the main server exports them
+	if getConfig().backups.includeAccounts && isMainServer {
+		accountsJson, err := getAccountsExport(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = tw.WriteHeader(&tar.Header{Name: "accounts.json", Mode: 0644, Size: int64(len(accountsJson)), ModTime: time.Now()})
+		if err != nil {
+			return err
+		}
+
+		_, err = tw.Write(accountsJson)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+func addFileToArchive(tw *tar.Writer, filePath string, name string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) { // removed by a sync since it was listed
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime()})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, file)
+
+	return err
+}
+
+// getAccountsExport returns every row of the accounts table as a JSON array of column maps
+func getAccountsExport(ctx context.Context) ([]byte, error) {
+	// passwords and IP addresses are left out of backups
+	results, err := db.QueryContext(withoutQueryTimeout(ctx), "SELECT uuid, user, timestampRegistered, timestampLoggedIn, badge, badgeSlotRows, badgeSlotCols, screenshotLimit, inactive FROM accounts")
+	if err != nil {
+		return nil, err
+	}
+
+	defer results.Close()
+
+	columns, err := results.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := []map[string]*string{}
+
+	for results.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]any, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+
+		err = results.Scan(dest...)
+		if err != nil {
+			return nil, err
+		}
+
+		account := make(map[string]*string)
+		for i, column := range columns {
+			if values[i].Valid {
+				account[column] = &values[i].String
+			} else {
+				account[column] = nil
+			}
+		}
+
+		accounts = append(accounts, account)
+	}
+
+	return json.Marshal(accounts)
+}
+
+func deleteExpiredSaveBackups(ctx context.Context) error {
+	if getConfig().backups.retentionDays <= 0 {
+		return nil
+	}
+
+	backups, err := getSaveBackups(ctx)
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -getConfig().backups.retentionDays)
+
+	for _, backup := range backups {
+		if backup.Timestamp.Before(cutoff) {
+			err = deleteS3Object(ctx, backup.Key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// getSaveBackups returns the game's backups in object storage, newest first
+func getSaveBackups(ctx context.Context) ([]*SaveBackup, error) {
+	backups, err := listS3Objects(ctx, getSaveBackupPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Timestamp.After(backups[j].Timestamp)
+	})
+
+	return backups, nil
+}
+
+// restoreSaveBackup restores the saves in a backup, or only those of one player if playerUuid is set.
+// The saves being replaced are kept in the version history. Exported accounts are never restored automatically.
+func restoreSaveBackup(ctx context.Context, key string, playerUuid string) (restoredCount int, err error) {
+	if !strings.HasPrefix(key, getSaveBackupPrefix()) {
+		return 0, errors.New("backup belongs to another game")
+	}
+
+	resp, err := doS3Request(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return restoredCount, err
+		}
+
+		dir, name := path.Split(header.Name)
+		if dir != "saves/" || !strings.HasSuffix(name, ".osd") {
+			continue
+		}
+
+		saveName := strings.TrimSuffix(name, ".osd")
+		if playerUuid != "" && saveName != playerUuid && !strings.HasPrefix(saveName, playerUuid+"_") {
+			continue
+		}
+
+		err = restoreSaveBackupFile(saveName, tr)
+		if err != nil {
+			return restoredCount, err
+		}
+
+		restoredCount++
+	}
+
+	return restoredCount, nil
+}
+
+func restoreSaveBackupFile(saveName string, r io.Reader) error {
+	uuid, slot := saveName, 0
+	if i := strings.LastIndex(saveName, "_"); i != -1 {
+		var err error
+
+		uuid = saveName[:i]
+		slot, err = strconv.Atoi(saveName[i+1:])
+		if err != nil || slot < 0 || slot > maxSaveSlot {
+			return errors.New("invalid save slot in " + saveName)
+		}
+	}
+
+	err := archiveGameSaveData(uuid, slot)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(getSaveDataPath(uuid, slot))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, r)
+
+	return err
+}
+
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func listS3Objects(ctx context.Context, prefix string) ([]*SaveBackup, error) {
+	var objects []*SaveBackup
+
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+
+	for {
+		resp, err := doS3Request(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			objects = append(objects, &SaveBackup{Key: object.Key, Timestamp: object.LastModified, Size: object.Size})
+		}
+
+		if !result.IsTruncated {
+			return objects, nil
+		}
+
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func putS3Object(ctx context.Context, key string, file *os.File) error {
+	resp, err := doS3Request(ctx, http.MethodPut, key, nil, file)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+func deleteS3Object(ctx context.Context, key string) error {
+	resp, err := doS3Request(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// doS3Request sends a request signed with AWS Signature Version 4 using path-style addressing,
+// which works with AWS as well as other S3-compatible storage
+func doS3Request(ctx context.Context, method string, key string, query url.Values, body *os.File) (*http.Response, error) {
+	s3 := getConfig().backups.s3
+
+	endpoint, err := url.Parse(s3.endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	payloadHash := sha256.New()
+	var contentLength int64
+	if body != nil {
+		_, err = body.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+
+		contentLength, err = io.Copy(payloadHash, body)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = body.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+	}
+	payloadHashHex := hex.EncodeToString(payloadHash.Sum(nil))
+
+	endpoint.Path = "/" + s3.bucket + "/" + key
+	// url.Values encodes spaces as '+', signature version 4 expects '%20'
+	endpoint.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = body
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = contentLength
+
+	signS3Request(req, payloadHashHex, s3.accessKey, s3.secretKey, s3.region, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, errors.New("object storage returned " + resp.Status + ": " + string(respBody))
+	}
+
+	return resp, nil
+}
+
+// signS3Request signs a request with signature version 4 for the payload with the given SHA-256 hash
+func signS3Request(req *http.Request, payloadHashHex string, accessKey string, secretKey string, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + region + "/s3/aws4_request"
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHashHex)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHashHex + "\nx-amz-date:" + amzDate + "\n",
+		"host;x-amz-content-sha256;x-amz-date",
+		payloadHashHex,
+	}, "\n")
+
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalRequestHash[:])
+
+	signingKey := hmacSha256([]byte("AWS4"+secretKey), now.Format("20060102"))
+	signingKey = hmacSha256(signingKey, region)
+	signingKey = hmacSha256(signingKey, "s3")
+	signingKey = hmacSha256(signingKey, "aws4_request")
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature="+hex.EncodeToString(hmacSha256(signingKey, stringToSign)))
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
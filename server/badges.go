@@ -18,13 +18,17 @@
 package server
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -35,8 +39,17 @@ var (
 	badges                 map[string]map[string]*Badge
 	badgeUnlockPercentages map[string]float32
 	sortedBadgeIds         map[string][]string
+
+	badgeBatchDates map[string][]time.Time
+
+	// stored in the database, so they last across restarts and are shared by the servers of every game
+	badgeBatchOffsets    = make(map[string]int)
+	badgeBatchOffsetsMtx sync.RWMutex
 )
 
+// default weekly release schedule for games without a batch date list
+var firstBatchDate = time.Date(2022, time.April, 15, 20, 0, 0, 0, time.UTC)
+
 type Condition struct {
 	ConditionId  string   `json:"conditionId"`
 	Map          int      `json:"map"`
@@ -61,8 +74,17 @@ type Condition struct {
 	Trigger      string   `json:"trigger"`
 	Value        string   `json:"value"`
 	Values       []string `json:"values"`
+	Expression   string   `json:"expression"`
 	TimeTrial    bool     `json:"timeTrial"`
 	Disabled     bool     `json:"disabled"`
+
+	expr      *Expression
+	expiresAt time.Time
+}
+
+// isExpired reports whether the badge requiring this condition can no longer be unlocked
+func (c *Condition) isExpired() bool {
+	return !c.expiresAt.IsZero() && time.Now().After(c.expiresAt)
 }
 
 func (c *Condition) checkSwitch(switchId int, value bool) (bool, int) {
@@ -157,6 +179,22 @@ type Badge struct {
 	Animated        bool       `json:"animated"`
 	Batch           int        `json:"batch"`
 	Dev             bool       `json:"dev"`
+	ExpiresAt       time.Time  `json:"expiresAt"`
+
+	configDev bool
+}
+
+// isExpired reports whether the badge's unlock window has closed
+func (b *Badge) isExpired() bool {
+	return !b.ExpiresAt.IsZero() && time.Now().After(b.ExpiresAt)
+}
+
+type BadgeBatchInfo struct {
+	Game            string    `json:"game"`
+	CurrentBatch    int       `json:"currentBatch"`
+	Offset          int       `json:"offset"`
+	NextReleaseDate time.Time `json:"nextReleaseDate"`
+	NextBadgeIds    []string  `json:"nextBadgeIds"`
 }
 
 type SimplePlayerBadge struct {
@@ -190,22 +228,57 @@ type PlayerBadge struct {
 	Goals           int      `json:"goals"`
 	GoalsTotal      int      `json:"goalsTotal"`
 	Tags            []string `json:"tags"`
+	Expired         bool     `json:"expired"`
 	Unlocked        bool     `json:"unlocked"`
 	NewUnlock       bool     `json:"newUnlock"`
 }
 
+type GameBadgeListData struct {
+	Game    string `json:"game"`
+	TotalBp int    `json:"totalBp"`
+	Badges  any    `json:"badges"`
+}
+
+type PlayerBadgeGrant struct {
+	Uuid          string    `json:"uuid"`
+	Name          string    `json:"name"`
+	BadgeId       string    `json:"badgeId"`
+	Source        string    `json:"source"`
+	GrantedBy     string    `json:"grantedBy,omitempty"`
+	GrantedByName string    `json:"grantedByName,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+const maxBadgeSlotPresets = 10
+
+// badge slot preset errors shown to the player, unlike database errors
+var (
+	errBadgeSlotPresetLimit    = errors.New("preset limit reached")
+	errBadgeSlotPresetNotFound = errors.New("preset not found")
+)
+
+const badgeUnlockPercentagesCacheDuration = time.Hour
+
+// sources recorded for playerBadges rows
+const (
+	badgeSourceAuto  = "auto"
+	badgeSourceAdmin = "admin"
+)
+
 type TimeTrialRecord struct {
 	MapId   int `json:"mapId"`
 	Seconds int `json:"seconds"`
 }
 
 func initBadges() {
+	setBadgeBatchDates()
 	setBadgeData()
 
-	scheduler.Every(1).Tuesday().At("20:00").Do(updateActiveBadgesAndConditions)
-	scheduler.Every(1).Friday().At("20:00").Do(func() {
+	scheduleTask("badgeVisibility", scheduler.Every(1).Tuesday().At("20:00"), updateActiveBadgesAndConditions)
+	scheduleTask("badgeRefresh", scheduler.Every(1).Friday().At("20:00"), func() {
 		setConditions()
 		setBadges()
+		setBadgeBatchDates()
 		globalConditions = getGlobalConditions()
 		for _, roomId := range assets.maps {
 			rooms[roomId].conditions = getRoomConditions(roomId)
@@ -224,13 +297,16 @@ func setBadgeData() {
 
 	logUpdateTask("badge data")
 
-	badgeUnlockPercentages, _ = getBadgeUnlockPercentages()
+	badgeUnlockPercentages, _ = getCachedBadgeUnlockPercentages()
 	// Use main server to update badge data
 	if isMainServer {
-		if _, ok := badges[config.gameName]; ok {
+		if _, ok := badges[getConfig().gameName]; ok {
 			// Badge records needed for determining badge game
 			writeGameBadges()
-			updatePlayerBadgeSlotCounts("")
+			_, err := enqueueJob(jobTypeBadgeSlotCounts)
+			if err != nil {
+				writeErrLog("SERVER", "badges", err.Error())
+			}
 		}
 	}
 }
@@ -238,18 +314,19 @@ func setBadgeData() {
 func updateActiveBadgesAndConditions() {
 	logUpdateTask("badge visibility")
 
-	firstBatchDate := time.Date(2022, time.April, 15, 20, 0, 0, 0, time.UTC)
-	days := time.Now().UTC().Sub(firstBatchDate).Hours() / 24
-	currentBatch := int(math.Floor(days/7)) + 1
+	if err := setBadgeBatchOffsets(); err != nil {
+		writeErrLog("SERVER", "badges", err.Error())
+	}
+
+	now := time.Now().UTC()
 
 	for game, gameBadges := range badges {
+		currentBatch := getCurrentBadgeBatch(game, now)
 		for _, gameBadge := range gameBadges {
 			if gameBadge.Batch == 0 {
 				continue
 			}
-			if !gameBadge.Dev {
-				gameBadge.Dev = gameBadge.Batch > currentBatch
-			}
+			gameBadge.Dev = gameBadge.configDev || gameBadge.Batch > currentBatch
 			switch gameBadge.ReqType {
 			case "tag":
 				if condition, ok := conditions[game][gameBadge.ReqString]; ok {
@@ -272,10 +349,191 @@ func updateActiveBadgesAndConditions() {
 			}
 		}
 	}
+
+	updateConditionExpiry()
+	invalidatePlayerBadgeData()
+}
+
+// updateConditionExpiry applies badge unlock windows to the conditions they require
+func updateConditionExpiry() {
+	for game, gameBadges := range badges {
+		for _, gameBadge := range gameBadges {
+			if gameBadge.ExpiresAt.IsZero() {
+				continue
+			}
+
+			var tags []string
+			switch gameBadge.ReqType {
+			case "tag":
+				tags = append(tags, gameBadge.ReqString)
+			case "tags":
+				tags = append(tags, gameBadge.ReqStrings...)
+			case "tagArrays":
+				for _, tagArray := range gameBadge.ReqStringArrays {
+					tags = append(tags, tagArray...)
+				}
+			}
+
+			for _, tag := range tags {
+				if condition, ok := conditions[game][tag]; ok {
+					condition.expiresAt = gameBadge.ExpiresAt
+				}
+			}
+		}
+	}
+}
+
+// getCurrentBadgeBatch returns the latest released badge batch for a game,
+// including any manual adjustment made through the admin API
+func getCurrentBadgeBatch(game string, now time.Time) int {
+	var currentBatch int
+
+	if dates, ok := badgeBatchDates[game]; ok {
+		for _, date := range dates {
+			if date.After(now) {
+				break
+			}
+			currentBatch++
+		}
+	} else {
+		days := now.Sub(firstBatchDate).Hours() / 24
+		currentBatch = int(math.Floor(days/7)) + 1
+	}
+
+	return currentBatch + getBadgeBatchOffset(game)
+}
+
+func getBadgeBatchOffset(game string) int {
+	badgeBatchOffsetsMtx.RLock()
+	defer badgeBatchOffsetsMtx.RUnlock()
+
+	return badgeBatchOffsets[game]
+}
+
+// setBadgeBatchOffsets loads the badge batch adjustments of every game
+func setBadgeBatchOffsets() error {
+	results, err := db.Query("SELECT game, batchOffset FROM badgeBatchOffsets")
+	if err != nil {
+		return err
+	}
+
+	defer results.Close()
+
+	offsets := make(map[string]int)
+
+	for results.Next() {
+		var game string
+		var offset int
+
+		err := results.Scan(&game, &offset)
+		if err != nil {
+			return err
+		}
+
+		offsets[game] = offset
+	}
+
+	badgeBatchOffsetsMtx.Lock()
+	badgeBatchOffsets = offsets
+	badgeBatchOffsetsMtx.Unlock()
+
+	return nil
+}
+
+// writeBadgeBatchOffset adjusts the released badge batch of a game by delta
+func writeBadgeBatchOffset(game string, delta int) error {
+	_, err := db.Exec("INSERT INTO badgeBatchOffsets (game, batchOffset) VALUES (?, ?) "+db.upsert("game", "batchOffset = batchOffset + ?"), game, delta, delta)
+	if err != nil {
+		return err
+	}
+
+	var offset int
+
+	err = db.QueryRow("SELECT batchOffset FROM badgeBatchOffsets WHERE game = ?", game).Scan(&offset)
+	if err != nil {
+		return err
+	}
+
+	badgeBatchOffsetsMtx.Lock()
+	badgeBatchOffsets[game] = offset
+	badgeBatchOffsetsMtx.Unlock()
+
+	return nil
+}
+
+// getNextBadgeBatchReleaseDate returns the scheduled release date of the batch after the current one
+func getNextBadgeBatchReleaseDate(game string, now time.Time) time.Time {
+	if dates, ok := badgeBatchDates[game]; ok {
+		for _, date := range dates {
+			if date.After(now) {
+				return date
+			}
+		}
+		return time.Time{}
+	}
+
+	weeks := math.Floor(now.Sub(firstBatchDate).Hours()/24/7) + 1
+	return firstBatchDate.AddDate(0, 0, int(weeks)*7)
+}
+
+func getBadgeBatchInfo(game string) *BadgeBatchInfo {
+	now := time.Now().UTC()
+	currentBatch := getCurrentBadgeBatch(game, now)
+
+	batchInfo := &BadgeBatchInfo{
+		Game:            game,
+		CurrentBatch:    currentBatch,
+		Offset:          getBadgeBatchOffset(game),
+		NextReleaseDate: getNextBadgeBatchReleaseDate(game, now),
+		NextBadgeIds:    []string{},
+	}
+
+	for _, badgeId := range sortedBadgeIds[game] {
+		if badges[game][badgeId].Batch == currentBatch+1 {
+			batchInfo.NextBadgeIds = append(batchInfo.NextBadgeIds, badgeId)
+		}
+	}
+
+	return batchInfo
+}
+
+func setBadgeBatchDates() {
+	logUpdateTask("badge batch dates")
+
+	batchDateConfig := make(map[string][]time.Time)
+
+	batchConfigs, err := os.ReadDir("badges/batches/")
+	if err != nil {
+		badgeBatchDates = batchDateConfig
+		return
+	}
+
+	for _, batchConfigFile := range batchConfigs {
+		var dates []time.Time
+
+		data, err := os.ReadFile("badges/batches/" + batchConfigFile.Name())
+		if err != nil {
+			continue
+		}
+
+		err = json.Unmarshal(data, &dates)
+		if err != nil {
+			writeErrLog("SERVER", "Badge Batches", batchConfigFile.Name()+": "+err.Error())
+			continue
+		}
+
+		sort.Slice(dates, func(a, b int) bool {
+			return dates[a].Before(dates[b])
+		})
+
+		batchDateConfig[batchConfigFile.Name()[:len(batchConfigFile.Name())-5]] = dates
+	}
+
+	badgeBatchDates = batchDateConfig
 }
 
 func getGlobalConditions() (globalConditions []*Condition) {
-	if gameConditions, ok := conditions[config.gameName]; ok {
+	if gameConditions, ok := conditions[getConfig().gameName]; ok {
 		for _, condition := range gameConditions {
 			if condition.Map == 0 {
 				globalConditions = append(globalConditions, condition)
@@ -286,7 +544,7 @@ func getGlobalConditions() (globalConditions []*Condition) {
 }
 
 func getRoomConditions(roomId int) (roomConditions []*Condition) {
-	if gameConditions, ok := conditions[config.gameName]; ok {
+	if gameConditions, ok := conditions[getConfig().gameName]; ok {
 		for _, condition := range gameConditions {
 			if condition.Map == roomId {
 				roomConditions = append(roomConditions, condition)
@@ -314,7 +572,7 @@ func (c *RoomClient) checkRoomConditions(trigger string, value string) {
 }
 
 func (c *RoomClient) checkCondition(condition *Condition, roomId int, minigames []*Minigame, trigger string, value string) {
-	if condition.Disabled && c.session.rank < 2 {
+	if (condition.Disabled && c.session.rank < 2) || condition.isExpired() {
 		return
 	}
 
@@ -333,7 +591,26 @@ func (c *RoomClient) checkCondition(condition *Condition, roomId int, minigames
 	}
 
 	if condition.Trigger == trigger && valueMatched {
-		if (condition.SwitchId > 0 || len(condition.SwitchIds) != 0) && !condition.VarTrigger {
+		if condition.expr != nil {
+			var syncType int
+			if trigger == "" {
+				syncType = 2
+				if condition.SwitchDelay || condition.VarDelay {
+					syncType = 1
+				}
+			}
+			for _, switchId := range condition.expr.SwitchIds {
+				c.outbox <- buildMsg("ss", switchId, syncType)
+			}
+			for _, varId := range condition.expr.VarIds {
+				c.outbox <- buildMsg("sv", varId, syncType)
+			}
+			if len(condition.expr.SwitchIds) == 0 && len(condition.expr.VarIds) == 0 {
+				if err := c.checkConditionExpression(condition); err != nil {
+					writeErrLog(c.session.uuid, c.mapId, err.Error())
+				}
+			}
+		} else if (condition.SwitchId > 0 || len(condition.SwitchIds) != 0) && !condition.VarTrigger {
 			switchId := condition.SwitchId
 			if len(condition.SwitchIds) != 0 {
 				switchId = condition.SwitchIds[0]
@@ -374,7 +651,7 @@ func (c *RoomClient) checkCondition(condition *Condition, roomId int, minigames
 			}
 			c.outbox <- buildMsg("sv", varId, varSyncType)
 		} else if c.checkConditionCoords(condition) {
-			timeTrial := condition.TimeTrial && config.gameName == "2kki"
+			timeTrial := condition.TimeTrial && getConfig().gameName == "2kki"
 			if !timeTrial {
 				success, err := tryWritePlayerTag(c.session.uuid, condition.ConditionId)
 				if err != nil {
@@ -437,11 +714,147 @@ func (c *RoomClient) checkCondition(condition *Condition, roomId int, minigames
 	}
 }
 
+// checkConditionExpression writes the condition's tag if its expression holds
+// for the currently cached switch and var values
+func (c *RoomClient) checkConditionExpression(condition *Condition) error {
+	if (condition.Disabled && c.session.rank < 2) || condition.isExpired() {
+		return nil
+	}
+
+	valid, ok := condition.expr.Eval(c.switchCache, c.varCache)
+	if !ok || !valid || !c.checkConditionCoords(condition) {
+		return nil
+	}
+
+	if condition.TimeTrial {
+		if getConfig().gameName == "2kki" {
+			c.outbox <- buildMsg("ss", 1430, 0)
+		}
+		return nil
+	}
+
+	success, err := tryWritePlayerTag(c.session.uuid, condition.ConditionId)
+	if err != nil {
+		return err
+	}
+	if success {
+		c.outbox <- buildMsg("b")
+	}
+
+	return nil
+}
+
 func (c *RoomClient) checkConditionCoords(condition *Condition) bool {
-	return ((condition.MapX1 <= 0 && condition.MapX2 <= 0) ||
-		((condition.MapX1 == -1 || condition.MapX1 <= c.x) && (condition.MapX2 == -1 || condition.MapX2 >= c.x))) &&
-		((condition.MapY1 <= 0 && condition.MapY2 <= 0) ||
-			((condition.MapY1 == -1 || condition.MapY1 <= c.y) && (condition.MapY2 == -1 || condition.MapY2 >= c.y)))
+	return condition.checkCoords(c.x, c.y)
+}
+
+func (c *Condition) checkCoords(x int, y int) bool {
+	return ((c.MapX1 <= 0 && c.MapX2 <= 0) ||
+		((c.MapX1 == -1 || c.MapX1 <= x) && (c.MapX2 == -1 || c.MapX2 >= x))) &&
+		((c.MapY1 <= 0 && c.MapY2 <= 0) ||
+			((c.MapY1 == -1 || c.MapY1 <= y) && (c.MapY2 == -1 || c.MapY2 >= y)))
+}
+
+// checkState reports whether the condition's switch and var requirements hold for the given state
+func (c *Condition) checkState(switches map[int]bool, vars map[int]int) bool {
+	if c.expr != nil {
+		valid, ok := c.expr.Eval(switches, vars)
+		return valid && ok
+	}
+
+	if c.SwitchId > 0 {
+		if value, ok := switches[c.SwitchId]; !ok || value != c.SwitchValue {
+			return false
+		}
+	}
+	for s, sId := range c.SwitchIds {
+		if value, ok := switches[sId]; !ok || value != c.SwitchValues[s] {
+			return false
+		}
+	}
+
+	if c.VarId > 0 {
+		value, ok := vars[c.VarId]
+		if !ok {
+			return false
+		}
+		if valid, _ := c.checkVar(c.VarId, value); !valid {
+			return false
+		}
+	}
+	for _, vId := range c.VarIds {
+		value, ok := vars[vId]
+		if !ok {
+			return false
+		}
+		if valid, _ := c.checkVar(vId, value); !valid {
+			return false
+		}
+	}
+
+	return true
+}
+
+type ConditionTestResult struct {
+	ConditionId string `json:"conditionId"`
+	Map         int    `json:"map"`
+	TimeTrial   bool   `json:"timeTrial"`
+	Disabled    bool   `json:"disabled"`
+	Unlocked    bool   `json:"unlocked"`
+}
+
+// testConditions simulates condition checks for the given location and switch/var state
+// and returns the conditions that would fire, without writing any tags
+func testConditions(playerTags []string, mapId int, x int, y int, switches map[int]bool, vars map[int]int, trigger string, value string) []*ConditionTestResult {
+	results := []*ConditionTestResult{}
+
+	for _, condition := range conditions[getConfig().gameName] {
+		if condition.Map != 0 && condition.Map != mapId {
+			continue
+		}
+
+		if condition.Trigger != trigger {
+			continue
+		}
+
+		if trigger != "" {
+			valueMatched := value == condition.Value
+			for _, val := range condition.Values {
+				if value == val {
+					valueMatched = true
+					break
+				}
+			}
+			if !valueMatched {
+				continue
+			}
+		}
+
+		if !condition.checkState(switches, vars) || !condition.checkCoords(x, y) {
+			continue
+		}
+
+		result := &ConditionTestResult{
+			ConditionId: condition.ConditionId,
+			Map:         condition.Map,
+			TimeTrial:   condition.TimeTrial,
+			Disabled:    condition.Disabled,
+		}
+		for _, tag := range playerTags {
+			if tag == condition.ConditionId {
+				result.Unlocked = true
+				break
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(a, b int) bool {
+		return results[a].ConditionId < results[b].ConditionId
+	})
+
+	return results
 }
 
 func getPlayerBadgeData(playerUuid string, playerRank int, playerTags []string, account bool, simple bool) (playerBadges []*PlayerBadge, err error) {
@@ -449,10 +862,12 @@ func getPlayerBadgeData(playerUuid string, playerRank int, playerTags []string,
 	var playerEventLocationCount int
 	var playerEventLocationCompletion int
 	var playerEventVmCount int
+	var playerEventStreak int
 	var playerBadgeCount int
 	var yume2kkiLocationCompletion int
 	var timeTrialRecords []*TimeTrialRecord
 	var medalCounts [5]int
+	var gamePlaytimes map[string]int
 
 	if account {
 		playerExp, err = getPlayerTotalEventExp(playerUuid)
@@ -471,6 +886,10 @@ func getPlayerBadgeData(playerUuid string, playerRank int, playerTags []string,
 		if err != nil {
 			return playerBadges, err
 		}
+		_, playerEventStreak, err = getPlayerEventStreaks(playerUuid)
+		if err != nil {
+			return playerBadges, err
+		}
 		yume2kkiLocationCompletion, err = getPlayerGameLocationCompletion(playerUuid, "2kki")
 		if err != nil {
 			return playerBadges, err
@@ -480,6 +899,10 @@ func getPlayerBadgeData(playerUuid string, playerRank int, playerTags []string,
 			return playerBadges, err
 		}
 		medalCounts = getPlayerMedals(playerUuid)
+		gamePlaytimes, err = getPlayerGamePlaytimes(playerUuid)
+		if err != nil {
+			return playerBadges, err
+		}
 	}
 
 	playerBadgesMap := make(map[string]*PlayerBadge)
@@ -500,92 +923,101 @@ func getPlayerBadgeData(playerUuid string, playerRank int, playerTags []string,
 				continue
 			}
 
-			playerBadge := &PlayerBadge{BadgeId: badgeId, Game: game, Group: gameBadge.Group, Bp: gameBadge.Bp, MapId: gameBadge.Map, MapX: gameBadge.MapX, MapY: gameBadge.MapY, Secret: gameBadge.Secret, SecretCondition: gameBadge.SecretCondition, OverlayType: gameBadge.OverlayType, Art: gameBadge.Art, Animated: gameBadge.Animated, Percent: badgeUnlockPercentages[badgeId], Hidden: gameBadge.Hidden || gameBadge.Dev, Tags: []string{}}
+			playerBadge := &PlayerBadge{BadgeId: badgeId, Game: game, Group: gameBadge.Group, Bp: gameBadge.Bp, MapId: gameBadge.Map, MapX: gameBadge.MapX, MapY: gameBadge.MapY, Secret: gameBadge.Secret, SecretCondition: gameBadge.SecretCondition, OverlayType: gameBadge.OverlayType, Art: gameBadge.Art, Animated: gameBadge.Animated, Percent: badgeUnlockPercentages[badgeId], Hidden: gameBadge.Hidden || gameBadge.Dev, Expired: gameBadge.isExpired(), Tags: []string{}}
 			if gameBadge.SecretMap {
 				playerBadge.MapId = 0
 			}
 
 			if account {
-				switch gameBadge.ReqType {
-				case "tag":
-					for _, tag := range playerTags {
-						if tag == gameBadge.ReqString {
-							playerBadge.Unlocked = true
-							break
-						}
-					}
-				case "tags":
-					if gameBadge.ReqCount == 0 || gameBadge.ReqCount >= len(gameBadge.ReqStrings) {
-						playerBadge.GoalsTotal = len(gameBadge.ReqStrings)
-					} else {
-						playerBadge.GoalsTotal = gameBadge.ReqCount
-					}
-					for _, tag := range playerTags {
-						for _, cTag := range gameBadge.ReqStrings {
-							if tag == cTag {
-								playerBadge.Goals++
-								playerBadge.Tags = append(playerBadge.Tags, tag)
+				// expired badges can only be kept by players who unlocked them in time
+				if !playerBadge.Expired {
+					switch gameBadge.ReqType {
+					case "tag":
+						for _, tag := range playerTags {
+							if tag == gameBadge.ReqString {
+								playerBadge.Unlocked = true
 								break
 							}
 						}
-					}
-				case "tagArrays":
-					if gameBadge.ReqCount == 0 || gameBadge.ReqCount >= len(gameBadge.ReqStringArrays) {
-						playerBadge.GoalsTotal = len(gameBadge.ReqStringArrays)
-					} else {
-						playerBadge.GoalsTotal = gameBadge.ReqCount
-					}
-					for _, cTags := range gameBadge.ReqStringArrays {
-						var tagFound bool
+					case "tags":
+						if gameBadge.ReqCount == 0 || gameBadge.ReqCount >= len(gameBadge.ReqStrings) {
+							playerBadge.GoalsTotal = len(gameBadge.ReqStrings)
+						} else {
+							playerBadge.GoalsTotal = gameBadge.ReqCount
+						}
 						for _, tag := range playerTags {
-							for _, cTag := range cTags {
+							for _, cTag := range gameBadge.ReqStrings {
 								if tag == cTag {
-									tagFound = true
 									playerBadge.Goals++
 									playerBadge.Tags = append(playerBadge.Tags, tag)
 									break
 								}
 							}
-							if tagFound {
-								break
+						}
+					case "tagArrays":
+						if gameBadge.ReqCount == 0 || gameBadge.ReqCount >= len(gameBadge.ReqStringArrays) {
+							playerBadge.GoalsTotal = len(gameBadge.ReqStringArrays)
+						} else {
+							playerBadge.GoalsTotal = gameBadge.ReqCount
+						}
+						for _, cTags := range gameBadge.ReqStringArrays {
+							var tagFound bool
+							for _, tag := range playerTags {
+								for _, cTag := range cTags {
+									if tag == cTag {
+										tagFound = true
+										playerBadge.Goals++
+										playerBadge.Tags = append(playerBadge.Tags, tag)
+										break
+									}
+								}
+								if tagFound {
+									break
+								}
 							}
 						}
-					}
-				case "exp":
-					playerBadge.Goals = playerExp
-					playerBadge.GoalsTotal = gameBadge.ReqInt
-				case "expCount":
-					playerBadge.Goals = playerEventLocationCount
-					playerBadge.GoalsTotal = gameBadge.ReqInt
-				case "expCompletion":
-					playerBadge.Goals = playerEventLocationCompletion
-					playerBadge.GoalsTotal = gameBadge.ReqInt
-				case "vmCount":
-					playerBadge.Goals = playerEventVmCount
-					playerBadge.GoalsTotal = gameBadge.ReqInt
-				case "badgeCount":
-					badgeCountPlayerBadges = append(badgeCountPlayerBadges, playerBadge)
-				case "locationCompletion":
-					switch game {
-					case "2kki":
-						playerBadge.Goals = yume2kkiLocationCompletion
+					case "exp":
+						playerBadge.Goals = playerExp
 						playerBadge.GoalsTotal = gameBadge.ReqInt
-					}
-				case "timeTrial":
-					playerBadge.Seconds = gameBadge.ReqInt
-					for _, record := range timeTrialRecords {
-						if record.MapId == gameBadge.Map {
-							playerBadge.Unlocked = record.Seconds < gameBadge.ReqInt
+					case "expCount":
+						playerBadge.Goals = playerEventLocationCount
+						playerBadge.GoalsTotal = gameBadge.ReqInt
+					case "expCompletion":
+						playerBadge.Goals = playerEventLocationCompletion
+						playerBadge.GoalsTotal = gameBadge.ReqInt
+					case "vmCount":
+						playerBadge.Goals = playerEventVmCount
+						playerBadge.GoalsTotal = gameBadge.ReqInt
+					case "eventStreak":
+						playerBadge.Goals = playerEventStreak
+						playerBadge.GoalsTotal = gameBadge.ReqInt
+					case "badgeCount":
+						badgeCountPlayerBadges = append(badgeCountPlayerBadges, playerBadge)
+					case "playtime": // hours played in the badge's game
+						playerBadge.Goals = gamePlaytimes[game] / 3600
+						playerBadge.GoalsTotal = gameBadge.ReqInt
+					case "locationCompletion":
+						switch game {
+						case "2kki":
+							playerBadge.Goals = yume2kkiLocationCompletion
+							playerBadge.GoalsTotal = gameBadge.ReqInt
 						}
-					}
-				case "medal":
-					if gameBadge.ReqInt < 5 {
-						var medalCount int
-						for m := 4; m >= gameBadge.ReqInt; m-- {
-							medalCount += medalCounts[m]
+					case "timeTrial":
+						playerBadge.Seconds = gameBadge.ReqInt
+						for _, record := range timeTrialRecords {
+							if record.MapId == gameBadge.Map {
+								playerBadge.Unlocked = record.Seconds < gameBadge.ReqInt
+							}
 						}
-						if medalCount > 0 {
-							playerBadge.Unlocked = true
+					case "medal":
+						if gameBadge.ReqInt < 5 {
+							var medalCount int
+							for m := 4; m >= gameBadge.ReqInt; m-- {
+								medalCount += medalCounts[m]
+							}
+							if medalCount > 0 {
+								playerBadge.Unlocked = true
+							}
 						}
 					}
 				}
@@ -609,7 +1041,7 @@ func getPlayerBadgeData(playerUuid string, playerRank int, playerTags []string,
 				if !playerBadge.Hidden {
 					playerBadgeCount++
 				}
-			} else if !simple && gameBadge.Hidden && playerRank < 2 {
+			} else if playerBadge.Expired || (!simple && gameBadge.Hidden && playerRank < 2) {
 				continue
 			}
 
@@ -633,6 +1065,7 @@ func getPlayerBadgeData(playerUuid string, playerRank int, playerTags []string,
 	}
 
 	var unlockedBadge bool
+	var newUnlockedBadgeIds []string
 
 	for _, badge := range playerBadges {
 		if badge.Unlocked {
@@ -651,6 +1084,7 @@ func getPlayerBadgeData(playerUuid string, playerRank int, playerTags []string,
 				badge.Percent = badgeUnlockPercentages[badge.BadgeId]
 				badge.NewUnlock = true
 				unlockedBadge = true
+				newUnlockedBadgeIds = append(newUnlockedBadgeIds, badge.BadgeId)
 			}
 		}
 	}
@@ -674,6 +1108,7 @@ func getPlayerBadgeData(playerUuid string, playerRank int, playerTags []string,
 				}
 				playerBadge.Percent = badgeUnlockPercentages[playerBadge.BadgeId]
 				playerBadge.NewUnlock = true
+				newUnlockedBadgeIds = append(newUnlockedBadgeIds, playerBadge.BadgeId)
 			}
 		}
 	} else if !simple {
@@ -683,11 +1118,146 @@ func getPlayerBadgeData(playerUuid string, playerRank int, playerTags []string,
 		}
 	}
 
+	if len(newUnlockedBadgeIds) != 0 {
+		sendBadgeUnlocks(playerUuid, newUnlockedBadgeIds)
+
+		for _, badgeId := range newUnlockedBadgeIds {
+			dispatchWebhook(webhookBadgeUnlocked, map[string]string{"uuid": playerUuid, "badgeId": badgeId})
+		}
+	}
+
+	return playerBadges, nil
+}
+
+// sendBadgeUnlocks notifies the player's session, and optionally their party members, of new badge unlocks
+func sendBadgeUnlocks(playerUuid string, badgeIds []string) {
+	client, ok := clients.Load(playerUuid)
+	if !ok {
+		return
+	}
+
+	for _, badgeId := range badgeIds {
+		client.outbox <- buildMsg("bu", badgeId)
+	}
+
+	if !getConfig().badgeUnlockPartyNotify || client.partyId == 0 {
+		return
+	}
+
+	partyMemberUuids, err := getPartyMemberUuids(client.partyId)
+	if err != nil {
+		return
+	}
+
+	for _, memberUuid := range partyMemberUuids {
+		if memberUuid == playerUuid {
+			continue
+		}
+		if memberClient, ok := clients.Load(memberUuid); ok {
+			for _, badgeId := range badgeIds {
+				memberClient.outbox <- buildMsg("pbu", playerUuid, badgeId)
+			}
+		}
+	}
+}
+
+// getGameTotalBp returns the total BP obtainable from a game's released, visible badges
+func getGameTotalBp(game string) (totalBp int) {
+	for _, badge := range badges[game] {
+		if badge.Hidden || badge.Dev {
+			continue
+		}
+		totalBp += badge.Bp
+	}
+
+	return totalBp
+}
+
+// getCachedPlayerBadgeData returns badge data for an account, using the player's session cache when available.
+// Results containing new unlocks aren't cached so that they're only reported once.
+func getCachedPlayerBadgeData(playerUuid string, playerRank int, simple bool) (playerBadges []*PlayerBadge, err error) {
+	client, ok := clients.Load(playerUuid)
+
+	var gen int
+	if ok {
+		client.badgeDataMtx.Lock()
+		playerBadges, gen = client.badgeData[simple], client.badgeDataGen
+		client.badgeDataMtx.Unlock()
+
+		if playerBadges != nil {
+			return playerBadges, nil
+		}
+	}
+
+	playerTags, _, err := getPlayerTags(playerUuid)
+	if err != nil {
+		return playerBadges, err
+	}
+
+	playerBadges, err = getPlayerBadgeData(playerUuid, playerRank, playerTags, true, simple)
+	if err != nil || !ok {
+		return playerBadges, err
+	}
+
+	for _, badge := range playerBadges {
+		if badge.NewUnlock {
+			return playerBadges, nil
+		}
+	}
+
+	client.badgeDataMtx.Lock()
+	// skip caching if the data was invalidated while it was being computed
+	if client.badgeDataGen == gen {
+		if client.badgeData == nil {
+			client.badgeData = make(map[bool][]*PlayerBadge)
+		}
+		client.badgeData[simple] = playerBadges
+	}
+	client.badgeDataMtx.Unlock()
+
 	return playerBadges, nil
 }
 
+func hasCachedPlayerBadgeData(playerUuid string) bool {
+	client, ok := clients.Load(playerUuid)
+	if !ok {
+		return false
+	}
+
+	client.badgeDataMtx.Lock()
+	defer client.badgeDataMtx.Unlock()
+
+	return len(client.badgeData) != 0
+}
+
+// invalidatePlayerBadgeData clears the session badge cache of the given players, or of all players if none are given
+func invalidatePlayerBadgeData(playerUuids ...string) {
+	var sessionClients []*SessionClient
+	if len(playerUuids) == 0 {
+		sessionClients = clients.Get()
+	} else {
+		for _, playerUuid := range playerUuids {
+			if client, ok := clients.Load(playerUuid); ok {
+				sessionClients = append(sessionClients, client)
+			}
+		}
+	}
+
+	for _, client := range sessionClients {
+		client.badgeDataMtx.Lock()
+		client.badgeData = nil
+		client.badgeDataGen++
+		client.badgeDataMtx.Unlock()
+	}
+}
+
 func getSimplePlayerBadgeData(playerUuid string, playerRank int, playerTags []string, account bool) (playerBadges []*SimplePlayerBadge, err error) {
-	badgeData, err := getPlayerBadgeData(playerUuid, playerRank, playerTags, account, true)
+	var badgeData []*PlayerBadge
+	if account {
+		badgeData, err = getCachedPlayerBadgeData(playerUuid, playerRank, true)
+	} else {
+		badgeData, err = getPlayerBadgeData(playerUuid, playerRank, playerTags, account, true)
+	}
 	if err != nil {
 		return playerBadges, err
 	}
@@ -701,6 +1271,11 @@ func getSimplePlayerBadgeData(playerUuid string, playerRank int, playerTags []st
 }
 
 func getPlayerNewUnlockedBadgeIds(playerUuid string, playerRank int, playerTags []string) (badgeIds []string, err error) {
+	// nothing has been unlocked since the cached data was computed
+	if hasCachedPlayerBadgeData(playerUuid) {
+		return badgeIds, nil
+	}
+
 	badgeData, err := getPlayerBadgeData(playerUuid, playerRank, playerTags, true, true)
 	if err != nil {
 		return badgeIds, err
@@ -747,6 +1322,13 @@ func setConditions() {
 				if err == nil {
 					conditionId := conditionConfigFile.Name()[:len(conditionConfigFile.Name())-5]
 					condition.ConditionId = conditionId
+					if condition.Expression != "" {
+						condition.expr, err = parseExpression(condition.Expression)
+						if err != nil {
+							writeErrLog("SERVER", "conditions", gameId+"/"+conditionId+": invalid expression: "+err.Error())
+							continue
+						}
+					}
 					if condition.VarId > 0 {
 						if condition.VarOp == "" {
 							condition.VarOp = "="
@@ -803,6 +1385,8 @@ func setBadges() {
 				err = json.Unmarshal(data, &badge)
 				if err == nil {
 					badgeId := badgeConfigFile.Name()[:len(badgeConfigFile.Name())-5]
+					// Dev is recalculated from the batch, keep whether the badge itself is marked as one
+					badge.configDev = badge.Dev
 					badgeConfig[gameId][badgeId] = &badge
 					badgeIds = append(badgeIds, badgeId)
 				}
@@ -850,16 +1434,18 @@ func getPlayerBadgeSlotCounts(playerName string) (badgeSlotRows int, badgeSlotCo
 	return badgeSlotRows, badgeSlotCols
 }
 
-func updatePlayerBadgeSlotCounts(uuid string) (err error) {
-	query := "UPDATE accounts JOIN (SELECT pb.uuid, SUM(b.bp) bp, COUNT(b.badgeId) bc FROM playerBadges pb JOIN badges b ON b.badgeId = pb.badgeId AND b.hidden = 0 GROUP BY pb.uuid) AS pb ON pb.uuid = accounts.uuid " +
-		"SET badgeSlotRows = CASE WHEN bp < 300 THEN 1 WHEN bp < 1000 THEN 2 WHEN bp < 2000 THEN 3 WHEN bp < 4000 THEN 4 WHEN bp < 7500 THEN 5 WHEN bp < 12500 THEN 6 WHEN bp < 20000 THEN 7 WHEN bp < 30000 THEN 8 WHEN bp < 50000 THEN 9 ELSE 10 END, " +
+// updatePlayerBadgeSlotCounts updates badge slot and screenshot limits for the given players, or for all accounts if none are given
+func updatePlayerBadgeSlotCounts(ctx context.Context, uuids ...string) (err error) {
+	assignments := "badgeSlotRows = CASE WHEN bp < 300 THEN 1 WHEN bp < 1000 THEN 2 WHEN bp < 2000 THEN 3 WHEN bp < 4000 THEN 4 WHEN bp < 7500 THEN 5 WHEN bp < 12500 THEN 6 WHEN bp < 20000 THEN 7 WHEN bp < 30000 THEN 8 WHEN bp < 50000 THEN 9 ELSE 10 END, " +
 		"badgeSlotCols = CASE WHEN bc < 50 THEN 3 WHEN bc < 150 THEN 4 WHEN bc < 300 THEN 5 WHEN bc < 500 THEN 6 ELSE 7 END, " +
 		"screenshotLimit = GREATEST(CASE WHEN bp < 100 THEN 10 WHEN bp < 250 THEN 15 WHEN bp < 500 THEN 20 WHEN bp < 1000 THEN 25 WHEN bp < 2500 THEN 30 WHEN bp < 5000 THEN 35 WHEN bp < 7500 THEN 40 WHEN bp < 10000 THEN 45 WHEN bp < 12500 THEN 50 WHEN bp < 15000 THEN 55 WHEN bp < 17500 THEN 60 WHEN bp < 20000 THEN 65 WHEN bp < 25000 THEN 70 ELSE 75 END, screenshotLimit)"
-	if uuid == "" {
-		_, err = db.Exec(query)
+	badgeTotals := "(SELECT pb.uuid, SUM(b.bp) bp, COUNT(b.badgeId) bc FROM playerBadges pb JOIN badges b ON b.badgeId = pb.badgeId AND b.hidden = 0 GROUP BY pb.uuid) AS pb"
+	if len(uuids) == 0 {
+		// updating every account can take longer than the query timeout
+		_, err = db.ExecContext(withoutQueryTimeout(ctx), db.updateFrom("accounts", badgeTotals, "pb.uuid = accounts.uuid", assignments, ""))
 	} else {
-		query += " WHERE accounts.uuid = ?"
-		_, err = db.Exec(query, uuid)
+		placeholders, uuidParams := getPlaceholders(uuids...)
+		_, err = db.ExecContext(ctx, db.updateFrom("accounts", badgeTotals, "pb.uuid = accounts.uuid", assignments, "accounts.uuid IN ("+placeholders+")"), uuidParams...)
 	}
 	if err != nil {
 		return err
@@ -878,9 +1464,21 @@ func setPlayerBadge(uuid string, badge string) error {
 		return err
 	}
 
+	invalidatePlayerData(uuid)
+
 	return nil
 }
 
+// getPlayerBadgeCountAndBp returns the number of visible badges the player unlocked and their total BP
+func getPlayerBadgeCountAndBp(playerUuid string) (badgeCount int, bp int, err error) {
+	err = db.Replica().QueryRow("SELECT COUNT(b.badgeId), COALESCE(SUM(b.bp), 0) FROM playerBadges pb JOIN badges b ON b.badgeId = pb.badgeId AND b.hidden = 0 WHERE pb.uuid = ?", playerUuid).Scan(&badgeCount, &bp)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return badgeCount, bp, nil
+}
+
 func getPlayerBadgeSlots(playerName string, badgeSlotRows int, badgeSlotCols int) (badgeSlots [][]string, err error) {
 	results, err := db.Query("SELECT pb.badgeId, pb.slotRow, pb.slotCol FROM playerBadges pb JOIN accounts a ON a.uuid = pb.uuid WHERE a.user = ? AND pb.slotRow BETWEEN 1 AND ? AND pb.slotCol BETWEEN 1 AND ? ORDER BY pb.slotRow, pb.slotCol", playerName, badgeSlotRows, badgeSlotCols)
 	if err != nil {
@@ -967,6 +1565,108 @@ func setPlayerBadgeSlot(uuid string, badgeId string, slotRow int, slotCol int) e
 	return nil
 }
 
+func getBadgeSlotPresetNames(uuid string) (presetNames []string, err error) {
+	presetNames = []string{}
+
+	results, err := db.Query("SELECT DISTINCT name FROM badgeSlotPresets WHERE uuid = ? ORDER BY name", uuid)
+	if err != nil {
+		return presetNames, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		var name string
+		err := results.Scan(&name)
+		if err != nil {
+			return presetNames, err
+		}
+		presetNames = append(presetNames, name)
+	}
+
+	return presetNames, nil
+}
+
+// writeBadgeSlotPreset saves the player's current badge slot layout under the given name, replacing any existing preset of the same name
+func writeBadgeSlotPreset(uuid string, name string) error {
+	presetNames, err := getBadgeSlotPresetNames(uuid)
+	if err != nil {
+		return err
+	}
+
+	var presetExists bool
+	for _, presetName := range presetNames {
+		if presetName == name {
+			presetExists = true
+			break
+		}
+	}
+
+	if !presetExists && len(presetNames) >= maxBadgeSlotPresets {
+		return errBadgeSlotPresetLimit
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM badgeSlotPresets WHERE uuid = ? AND name = ?", uuid, name)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO badgeSlotPresets (uuid, name, badgeId, slotRow, slotCol) SELECT uuid, ?, badgeId, slotRow, slotCol FROM playerBadges WHERE uuid = ? AND slotRow > 0 AND slotCol > 0", name, uuid)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// applyBadgeSlotPreset replaces the player's badge slot layout with a saved preset
+func applyBadgeSlotPreset(uuid string, name string) error {
+	var presetExists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT * FROM badgeSlotPresets WHERE uuid = ? AND name = ?)", uuid, name).Scan(&presetExists)
+	if err != nil {
+		return err
+	}
+
+	if !presetExists {
+		return errBadgeSlotPresetNotFound
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE playerBadges SET slotRow = 0, slotCol = 0 WHERE uuid = ?", uuid)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE playerBadges SET slotRow = (SELECT bsp.slotRow FROM badgeSlotPresets bsp WHERE bsp.uuid = playerBadges.uuid AND bsp.badgeId = playerBadges.badgeId AND bsp.name = ?), "+
+		"slotCol = (SELECT bsp.slotCol FROM badgeSlotPresets bsp WHERE bsp.uuid = playerBadges.uuid AND bsp.badgeId = playerBadges.badgeId AND bsp.name = ?) "+
+		"WHERE uuid = ? AND EXISTS (SELECT * FROM badgeSlotPresets bsp WHERE bsp.uuid = playerBadges.uuid AND bsp.badgeId = playerBadges.badgeId AND bsp.name = ?)", name, name, uuid, name)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	invalidatePlayerBadgeData(uuid)
+
+	return nil
+}
+
 func writeGameBadges() error {
 	_, err := db.Exec("TRUNCATE TABLE badges")
 	if err != nil {
@@ -975,7 +1675,7 @@ func writeGameBadges() error {
 
 	for badgeGame := range badges {
 		for badgeId, badge := range badges[badgeGame] {
-			if _, ok := badges[config.gameName]; ok {
+			if _, ok := badges[getConfig().gameName]; ok {
 				badgeUnlockPercentage := badgeUnlockPercentages[badgeId]
 				_, err = db.Exec("INSERT INTO badges (badgeId, game, bp, hidden, percentUnlocked) VALUES (?, ?, ?, ?, ?)", badgeId, badgeGame, badge.Bp, badge.Hidden || badge.Dev, badgeUnlockPercentage)
 				if err != nil {
@@ -1009,11 +1709,13 @@ func getPlayerUnlockedBadgeIds(playerUuid string) (unlockedBadgeIds []string, er
 }
 
 func unlockPlayerBadge(playerUuid string, badgeId string) error {
-	_, err := db.Exec("INSERT INTO playerBadges (uuid, badgeId, timestampUnlocked) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE badgeId = badgeId", playerUuid, badgeId, time.Now())
+	_, err := db.ExecRetry("INSERT INTO playerBadges (uuid, badgeId, timestampUnlocked, source) VALUES (?, ?, ?, ?) "+db.upsertIgnore("uuid, badgeId"), playerUuid, badgeId, time.Now(), badgeSourceAuto)
 	if err != nil {
 		return err
 	}
 
+	invalidatePlayerBadgeData(playerUuid)
+
 	badgeUnlockPercentages[badgeId], err = getBadgeUnlockPercentage(badgeId)
 	if err != nil {
 		return err
@@ -1022,36 +1724,165 @@ func unlockPlayerBadge(playerUuid string, badgeId string) error {
 	return nil
 }
 
-func removePlayerBadge(playerUuid string, badgeId string) error {
-	var slotRow int
-	var slotCol int
+// unlockPlayerBadges grants every given badge to every given player in a single transaction
+func unlockPlayerBadges(playerUuids []string, badgeIds []string, grantedBy string) error {
+	timestampUnlocked := time.Now()
+
+	err := retryTransient(func() error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		defer tx.Rollback()
+
+		for _, playerUuid := range playerUuids {
+			for _, badgeId := range badgeIds {
+				_, err = tx.Exec("INSERT INTO playerBadges (uuid, badgeId, timestampUnlocked, source, grantedBy) VALUES (?, ?, ?, ?, ?) "+db.upsertIgnore("uuid, badgeId"), playerUuid, badgeId, timestampUnlocked, badgeSourceAdmin, grantedBy)
+				if err != nil {
+					return err
+				}
+			}
+		}
 
-	err := db.QueryRow("SELECT slotRow, slotCol FROM playerBadges WHERE uuid = ? AND badgeId = ?", playerUuid, badgeId).Scan(&slotRow, &slotCol)
+		return tx.Commit()
+	})
 	if err != nil {
 		return err
 	}
 
-	_, err = db.Exec("DELETE FROM playerBadges WHERE uuid = ? AND badgeId = ?", playerUuid, badgeId)
+	return updateBulkBadgeChanges(playerUuids, badgeIds)
+}
+
+// errPlayerBadgeNotOwned is returned when revoking badges which players don't have
+var errPlayerBadgeNotOwned = errors.New("badge not owned")
+
+// removePlayerBadges revokes every given badge from every given player in a single transaction.
+// Nothing is revoked if any of the players doesn't have one of the badges.
+func removePlayerBadges(playerUuids []string, badgeIds []string) error {
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 
-	_, err = db.Exec("UPDATE accounts SET badge = 'null' WHERE uuid = ? AND badge = ?", playerUuid, badgeId)
+	defer tx.Rollback()
+
+	var notOwned []string
+
+	for _, playerUuid := range playerUuids {
+		for _, badgeId := range badgeIds {
+			result, err := tx.Exec("DELETE FROM playerBadges WHERE uuid = ? AND badgeId = ?", playerUuid, badgeId)
+			if err != nil {
+				return err
+			}
+
+			removed, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+
+			if removed == 0 {
+				notOwned = append(notOwned, playerUuid+"/"+badgeId)
+				continue
+			}
+
+			_, err = tx.Exec("UPDATE accounts SET badge = 'null' WHERE uuid = ? AND badge = ?", playerUuid, badgeId)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(notOwned) != 0 {
+		return fmt.Errorf("%w: %s", errPlayerBadgeNotOwned, strings.Join(notOwned, ", "))
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return updateBulkBadgeChanges(playerUuids, badgeIds)
+}
+
+func updateBulkBadgeChanges(playerUuids []string, badgeIds []string) (err error) {
+	invalidatePlayerBadgeData(playerUuids...)
+
+	// revoking a badge unequips it
+	for _, playerUuid := range playerUuids {
+		invalidatePlayerData(playerUuid)
+	}
+
+	for _, badgeId := range badgeIds {
+		badgeUnlockPercentages[badgeId], err = getBadgeUnlockPercentage(badgeId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return updatePlayerBadgeSlotCounts(context.Background(), playerUuids...)
+}
+
+// getPlayerBadgeGrants returns the origin of badge rows matching the given player and/or badge
+func getPlayerBadgeGrants(playerUuid string, badgeId string) (grants []*PlayerBadgeGrant, err error) {
+	query := "SELECT pb.uuid, COALESCE(a.user, ''), pb.badgeId, pb.source, COALESCE(pb.grantedBy, ''), COALESCE(ga.user, ''), pb.timestampUnlocked FROM playerBadges pb LEFT JOIN accounts a ON a.uuid = pb.uuid LEFT JOIN accounts ga ON ga.uuid = pb.grantedBy WHERE 1 = 1"
+	var queryArgs []any
+
+	if playerUuid != "" {
+		query += " AND pb.uuid = ?"
+		queryArgs = append(queryArgs, playerUuid)
+	}
+	if badgeId != "" {
+		query += " AND pb.badgeId = ?"
+		queryArgs = append(queryArgs, badgeId)
+	}
+
+	query += " ORDER BY pb.timestampUnlocked DESC LIMIT 1000"
+
+	results, err := db.Query(query, queryArgs...)
+	if err != nil {
+		return grants, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		grant := &PlayerBadgeGrant{}
+		err := results.Scan(&grant.Uuid, &grant.Name, &grant.BadgeId, &grant.Source, &grant.GrantedBy, &grant.GrantedByName, &grant.Timestamp)
+		if err != nil {
+			return grants, err
+		}
+		grants = append(grants, grant)
+	}
+
+	return grants, nil
 }
 
 func getBadgeUnlockPercentage(badgeId string) (unlockPercentage float32, err error) {
-	err = db.QueryRow("SELECT COALESCE(COUNT(b.uuid) / aa.count, 0) * 100 FROM playerBadges b JOIN accounts a ON a.uuid = b.uuid JOIN (SELECT COUNT(aa.uuid) count FROM accounts aa WHERE EXISTS(SELECT * FROM playerBadges aab WHERE aab.uuid = aa.uuid AND aa.inactive = 0)) aa WHERE EXISTS(SELECT * FROM playerBadges ab WHERE ab.uuid = a.uuid AND a.inactive = 0) AND b.badgeId = ?", badgeId).Scan(&unlockPercentage)
+	err = db.Replica().QueryRow("SELECT COALESCE(COUNT(b.uuid) / aa.count, 0) * 100 FROM playerBadges b JOIN accounts a ON a.uuid = b.uuid JOIN (SELECT COUNT(aa.uuid) count FROM accounts aa WHERE EXISTS(SELECT * FROM playerBadges aab WHERE aab.uuid = aa.uuid AND aa.inactive = 0)) aa WHERE EXISTS(SELECT * FROM playerBadges ab WHERE ab.uuid = a.uuid AND a.inactive = 0) AND b.badgeId = ?", badgeId).Scan(&unlockPercentage)
 
 	return unlockPercentage, err
 }
 
+// getCachedBadgeUnlockPercentages shares the percentages between game servers through redis,
+// since every server computes the same ones when they are restarted together
+func getCachedBadgeUnlockPercentages() (unlockPercentages map[string]float32, err error) {
+	if getRedisCache("badgeUnlockPercentages", &unlockPercentages) {
+		return unlockPercentages, nil
+	}
+
+	unlockPercentages, err = getBadgeUnlockPercentages()
+	if err != nil {
+		return unlockPercentages, err
+	}
+
+	setRedisCache("badgeUnlockPercentages", unlockPercentages, badgeUnlockPercentagesCacheDuration)
+
+	return unlockPercentages, nil
+}
+
 func getBadgeUnlockPercentages() (unlockPercentages map[string]float32, err error) {
-	results, err := db.Query("SELECT b.badgeId, (COUNT(b.uuid) / aa.count) * 100 FROM playerBadges b JOIN accounts a ON a.uuid = b.uuid JOIN (SELECT COUNT(aa.uuid) count FROM accounts aa WHERE EXISTS(SELECT * FROM playerBadges aab WHERE aab.uuid = aa.uuid AND aa.inactive = 0)) aa WHERE EXISTS(SELECT * FROM playerBadges ab WHERE ab.uuid = a.uuid AND a.inactive = 0) GROUP BY b.badgeId")
+	results, err := db.Replica().Query("SELECT b.badgeId, (COUNT(b.uuid) / aa.count) * 100 FROM playerBadges b JOIN accounts a ON a.uuid = b.uuid JOIN (SELECT COUNT(aa.uuid) count FROM accounts aa WHERE EXISTS(SELECT * FROM playerBadges aab WHERE aab.uuid = aa.uuid AND aa.inactive = 0)) aa WHERE EXISTS(SELECT * FROM playerBadges ab WHERE ab.uuid = a.uuid AND a.inactive = 0) GROUP BY b.badgeId")
 	if err != nil {
 		return unlockPercentages, err
 	}
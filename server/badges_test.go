@@ -0,0 +1,69 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestRemovePlayerBadgesNotOwned(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetMaxOpenConns(1)
+
+	prevDb, prevConfig := db, getConfig()
+	defer func() {
+		db = prevDb
+		currentConfig.Store(prevConfig)
+	}()
+
+	db = &Database{DB: conn, dialect: dialectSqlite}
+	currentConfig.Store(&Config{gameName: "2kki"})
+
+	err = runMigrations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec("INSERT INTO playerBadges (uuid, badgeId, timestampUnlocked) VALUES (?, ?, UTC_TIMESTAMP())", "uuid", "owned")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = removePlayerBadges([]string{"uuid"}, []string{"owned", "typo"})
+	if !errors.Is(err, errPlayerBadgeNotOwned) {
+		t.Fatalf("revoking a badge not owned: error = %v, expected %v", err, errPlayerBadgeNotOwned)
+	}
+
+	// nothing is revoked when one of the badges isn't owned
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM playerBadges WHERE uuid = ?", "uuid").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("badge count = %d, expected 1", count)
+	}
+}
@@ -0,0 +1,136 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"errors"
+	"sync"
+)
+
+type ChatChannel struct {
+	id         string
+	name       string
+	maxMembers int
+
+	members map[string]*SessionClient
+	muted   map[string]bool
+	mutex   sync.RWMutex
+}
+
+type ChatChannelData struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	MemberCount int    `json:"memberCount"`
+	MaxMembers  int    `json:"maxMembers"`
+	Joined      bool   `json:"joined"`
+}
+
+var chatChannels = make(map[string]*ChatChannel)
+
+func initChannels() {
+	for _, channelConfig := range getConfig().chatChannels {
+		chatChannels[channelConfig.Id] = &ChatChannel{
+			id:         channelConfig.Id,
+			name:       channelConfig.Name,
+			maxMembers: channelConfig.MaxMembers,
+			members:    make(map[string]*SessionClient),
+			muted:      make(map[string]bool),
+		}
+	}
+}
+
+func getChatChannel(channelId string) (*ChatChannel, error) {
+	channel, ok := chatChannels[channelId]
+	if !ok {
+		return nil, errors.New("unknown channel")
+	}
+
+	return channel, nil
+}
+
+func (ch *ChatChannel) join(c *SessionClient) error {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+
+	if _, ok := ch.members[c.uuid]; ok {
+		return nil
+	}
+
+	// moderators can always join
+	if ch.maxMembers > 0 && len(ch.members) >= ch.maxMembers && c.rank == 0 {
+		return errors.New("channel is full")
+	}
+
+	ch.members[c.uuid] = c
+
+	return nil
+}
+
+func (ch *ChatChannel) leave(uuid string) {
+	ch.mutex.Lock()
+	delete(ch.members, uuid)
+	ch.mutex.Unlock()
+}
+
+func (ch *ChatChannel) isMember(uuid string) bool {
+	ch.mutex.RLock()
+	defer ch.mutex.RUnlock()
+
+	_, ok := ch.members[uuid]
+
+	return ok
+}
+
+func (ch *ChatChannel) getMembers() []*SessionClient {
+	ch.mutex.RLock()
+	defer ch.mutex.RUnlock()
+
+	members := make([]*SessionClient, 0, len(ch.members))
+	for _, member := range ch.members {
+		members = append(members, member)
+	}
+
+	return members
+}
+
+func (ch *ChatChannel) getData(uuid string) *ChatChannelData {
+	ch.mutex.RLock()
+	defer ch.mutex.RUnlock()
+
+	_, joined := ch.members[uuid]
+
+	return &ChatChannelData{
+		Id:          ch.id,
+		Name:        ch.name,
+		MemberCount: len(ch.members),
+		MaxMembers:  ch.maxMembers,
+		Joined:      joined,
+	}
+}
+
+// leaveChatChannels removes a disconnecting client from every channel
+func (c *SessionClient) leaveChatChannels() {
+	for _, channel := range chatChannels {
+		channel.mutex.Lock()
+		// a newer session of the same player may have taken its place
+		if channel.members[c.uuid] == c {
+			delete(channel.members, c.uuid)
+		}
+		channel.mutex.Unlock()
+	}
+}
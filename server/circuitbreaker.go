@@ -0,0 +1,135 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// calls to the Yume 2kki Explorer API go through a circuit breaker,
+// so that handlers fail fast instead of piling up while 2kki.app is down
+const (
+	explorerApiTimeout          = 10 * time.Second
+	explorerApiMaxConcurrent    = 8
+	explorerApiFailureThreshold = 3
+	explorerApiOpenDuration     = 2 * time.Minute
+)
+
+var (
+	errCircuitOpen  = errors.New("upstream unavailable")
+	errUpstreamBusy = errors.New("too many concurrent upstream requests")
+
+	explorerApiBreaker = newCircuitBreaker("2kki", explorerApiTimeout, explorerApiMaxConcurrent, explorerApiFailureThreshold, explorerApiOpenDuration)
+)
+
+// CircuitBreaker stops calling an upstream for a while after consecutive failures and limits concurrent calls to it
+type CircuitBreaker struct {
+	name             string
+	client           *http.Client
+	slots            chan struct{}
+	failureThreshold int
+	openDuration     time.Duration
+
+	mutex     sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func newCircuitBreaker(name string, timeout time.Duration, maxConcurrent int, failureThreshold int, openDuration time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		name:             name,
+		client:           &http.Client{Timeout: timeout},
+		slots:            make(chan struct{}, maxConcurrent),
+		failureThreshold: failureThreshold,
+		openDuration:     openDuration,
+	}
+}
+
+// get requests url and returns the response body,
+// failing immediately while the circuit is open or all concurrent request slots are taken
+func (b *CircuitBreaker) get(url string) ([]byte, error) {
+	if b.isOpen() {
+		return nil, errCircuitOpen
+	}
+
+	select {
+	case b.slots <- struct{}{}:
+		defer func() { <-b.slots }()
+	default:
+		return nil, errUpstreamBusy
+	}
+
+	body, err := b.doGet(url)
+	b.recordResult(err == nil)
+
+	return body, err
+}
+
+func (b *CircuitBreaker) doGet(url string) ([]byte, error) {
+	resp, err := b.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// error responses of the upstream itself are left to the caller, only server errors count as failures
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, errors.New("received status " + strconv.Itoa(resp.StatusCode) + " from " + b.name)
+	}
+
+	return body, nil
+}
+
+func (b *CircuitBreaker) isOpen() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return time.Now().Before(b.openUntil)
+}
+
+// recordResult opens the circuit once enough consecutive calls have failed.
+// Failures aren't reset when it opens, so a single failed call after it closes again reopens it.
+func (b *CircuitBreaker) recordResult(success bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if success {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.failureThreshold {
+		if !time.Now().Before(b.openUntil) {
+			writeErrLog("SERVER", b.name, "upstream unavailable, pausing requests for "+b.openDuration.String())
+		}
+
+		b.openUntil = time.Now().Add(b.openDuration)
+	}
+}
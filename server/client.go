@@ -20,7 +20,9 @@ package server
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fasthttp/websocket"
@@ -32,6 +34,11 @@ const (
 	pingPeriod     = (pongWait * 9) / 10
 	maxMessageSize = 4096
 
+	latencyPingPeriod = 15 * time.Second
+
+	partyTeleportCooldown = 30 * time.Second
+	latencyHintThreshold  = 20 // ms
+
 	maxPictures = 1000
 )
 
@@ -66,11 +73,20 @@ type SessionClient struct {
 	conn *websocket.Conn
 	ip   string
 
-	ctx    context.Context
+	ctx    context.Context // lifetime of the session, which can outlive its connection while it can be resumed
 	cancel context.CancelFunc
 
+	connCtx    context.Context
+	connCancel context.CancelFunc
+
 	outbox chan []byte
 
+	resumeKey string
+
+	resumeMtx   sync.Mutex
+	detached    bool
+	resumeQueue chan [][]byte // receives messages queued while detached on resume
+
 	id int
 
 	account bool
@@ -89,14 +105,30 @@ type SessionClient struct {
 
 	private      bool
 	hideLocation bool
-	partyId      int
+	status       string
+
+	allowTeleport    bool // party members may teleport to this player
+	lastTeleportTime time.Time
+	partyId          int
+
+	latency atomic.Int32 // round trip time in ms, measured with pi and po
 
 	onlineFriends map[string]bool
 	blockedUsers  map[string]bool
+
+	playtimeMtx        sync.Mutex
+	playtimeSampleTime time.Time // start of the playtime not yet written
+
+	visitedMapsMtx sync.Mutex
+	visitedMaps    map[int]bool // flushed on disconnect
+
+	badgeDataMtx sync.Mutex
+	badgeData    map[bool][]*PlayerBadge // keyed by simple
+	badgeDataGen int
 }
 
 func (c *SessionClient) msgReader() {
-	defer c.cancel()
+	defer c.connCancel()
 
 	c.conn.SetReadLimit(maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
@@ -104,7 +136,7 @@ func (c *SessionClient) msgReader() {
 
 	for {
 		select {
-		case <-c.ctx.Done():
+		case <-c.connCtx.Done():
 			return
 		default:
 			_, message, err := c.conn.ReadMessage()
@@ -112,9 +144,11 @@ func (c *SessionClient) msgReader() {
 				return
 			}
 
-			err = c.processMsg(message)
+			requestId := newRequestId()
+
+			err = c.processMsg(message, requestId)
 			if err != nil {
-				writeErrLog(c.uuid, "sess", err.Error())
+				writeErrLog(c.uuid, "sess", err.Error(), "requestId", requestId)
 			}
 		}
 	}
@@ -122,9 +156,19 @@ func (c *SessionClient) msgReader() {
 
 func (c *SessionClient) msgWriter() {
 	ticker := time.NewTicker(pingPeriod)
+	latencyTicker := time.NewTicker(latencyPingPeriod)
 
 	defer func() {
 		ticker.Stop()
+		latencyTicker.Stop()
+
+		c.connCancel()
+
+		// a dropped connection can be resumed, but a session ended by the server can't
+		if c.ctx.Err() == nil && getConfig().sessionResumeWindow > 0 {
+			c.detach()
+			return
+		}
 
 		c.cancel()
 		c.disconnect()
@@ -132,12 +176,13 @@ func (c *SessionClient) msgWriter() {
 
 	for {
 		select {
-		case <-c.ctx.Done():
+		case <-c.connCtx.Done():
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(1028, ""))
 
 			return
 		case message := <-c.outbox:
+			setWsWriteCompression(c.conn, len(message))
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			err := c.conn.WriteMessage(websocket.TextMessage, message)
 			if err != nil {
@@ -149,29 +194,74 @@ func (c *SessionClient) msgWriter() {
 			if err != nil {
 				return
 			}
+		case <-latencyTicker.C:
+			// echoed back by the client with po
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			err := c.conn.WriteMessage(websocket.TextMessage, buildMsg("pi", strconv.FormatInt(time.Now().UnixMilli(), 10)))
+			if err != nil {
+				return
+			}
 		}
 	}
 }
 
+func (c *SessionClient) isSpectating() bool {
+	return c.roomC != nil && c.roomC.spectator
+}
+
 func (c *SessionClient) disconnect() {
 	// unregister
-	clients.Delete(c.uuid)
+	clients.DeleteIf(c.uuid, c)
+	sessionResumes.Delete(c.resumeKey)
+
+	c.leaveChatChannels()
 
 	// close conn, ends reader and processor
 	c.conn.Close()
 
-	err := c.updatePlayerGameActivity(false)
-	if err != nil {
-		writeErrLog(c.uuid, "sess", err.Error())
+	c.updatePlayerGameActivity(false)
+
+	if c.account {
+		err := c.writePlaytimeSample()
+		if err != nil {
+			writeErrLog(c.uuid, "sess", err.Error())
+		}
+
+		c.visitedMapsMtx.Lock()
+		err = writePlayerVisitedMaps(c.uuid, c.visitedMaps)
+		c.visitedMapsMtx.Unlock()
+		if err != nil {
+			writeErrLog(c.uuid, "sess", err.Error())
+		}
+
+		sendFriendStatusUpdate(c.uuid, false)
 	}
 
 	writeLog(c.uuid, "sess", "disconnect", 200)
 }
 
+// writePlaytimeSample adds the time since the last sample to the player's playtime
+func (c *SessionClient) writePlaytimeSample() error {
+	c.playtimeMtx.Lock()
+	defer c.playtimeMtx.Unlock()
+
+	now := time.Now()
+
+	err := writePlayerPlaytime(c.uuid, int(now.Sub(c.playtimeSampleTime).Seconds()))
+	if err != nil {
+		return err
+	}
+
+	c.playtimeSampleTime = now
+
+	return nil
+}
+
 // RoomClient
 type RoomClient struct {
-	room    *Room
-	session *SessionClient
+	room     *Room
+	instance *RoomInstance
+	session  *SessionClient
 
 	conn *websocket.Conn
 
@@ -182,8 +272,14 @@ type RoomClient struct {
 
 	key, counter uint32
 
+	binary bool // uses the binary wire protocol
+
+	spectator bool // invisible and read-only
+
 	x, y, facing, speed int
 
+	latencyHint int // session latency last sent to the room
+
 	flash          [5]int
 	repeatingFlash bool
 	transparency   int
@@ -200,7 +296,12 @@ type RoomClient struct {
 
 	syncCoords bool
 
-	minigameScores []int
+	minigameScores  []int
+	timestampJoined time.Time
+
+	// kept across maps, a time trial spanning several of them
+	timeTrialStart time.Time
+	timeTrialGhost []*TimeTrialGhostPoint
 
 	switchCache map[int]bool
 	varCache    map[int]int
@@ -251,15 +352,25 @@ func (c *RoomClient) msgWriter() {
 
 			return
 		case message := <-c.outbox:
+			if c.binary {
+				message = appendBinaryFrame(nil, encodeBinaryMsg(message))
+			}
+
 			for len(c.outbox) != 0 { // for each extra message in the channel
 				if len(message) > maxMessageSize-256 { // stop if we're close to the message size limit
 					break
 				}
 
+				if c.binary {
+					message = appendBinaryFrame(message, encodeBinaryMsg(<-c.outbox))
+					continue
+				}
+
 				message = append(message, []byte(mdelim)...) // add message delimiter
 				message = append(message, <-c.outbox...)     // write next message contents
 			}
 
+			setWsWriteCompression(c.conn, len(message))
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			err := c.conn.WriteMessage(websocket.BinaryMessage, message)
 			if err != nil {
@@ -302,6 +413,14 @@ func (c *RoomClient) reset() {
 
 	c.mapId = fmt.Sprintf("%04d", c.room.id)
 	c.prevMapId = ""
+
+	if c.session.account {
+		incrementPlayerStat(c.session.uuid, statMapsVisited)
+
+		c.session.visitedMapsMtx.Lock()
+		c.session.visitedMaps[c.room.id] = true
+		c.session.visitedMapsMtx.Unlock()
+	}
 	c.prevLocations = ""
 
 	c.locations = nil
@@ -311,11 +430,26 @@ func (c *RoomClient) reset() {
 	c.syncCoords = false
 
 	c.minigameScores = nil
+	c.timestampJoined = time.Now()
 
 	c.switchCache = make(map[int]bool)
 	c.varCache = make(map[int]int)
 }
 
+// SessionClientStore holds the connected players, Load and Get only return those connected to this process
+// while GetAmount, Exists and LoadRemote also account for the other processes of a cluster
+type SessionClientStore interface {
+	Store(uuid string, client *SessionClient)
+	Load(uuid string) (*SessionClient, bool)
+	Delete(uuid string)
+	DeleteIf(uuid string, client *SessionClient)
+	Get() []*SessionClient
+	GetAmount() int
+	Exists(uuid string) bool
+	LoadRemote(uuid string) (*PlayerListFullData, bool)
+}
+
+// SClientMap stores the players connected to this process when it is not part of a cluster
 type SClientMap struct {
 	clients map[string]*SessionClient
 	mutex   sync.RWMutex
@@ -353,6 +487,18 @@ func (m *SClientMap) Delete(uuid string) {
 	m.mutex.Unlock()
 }
 
+// DeleteIf removes the client only if it is still the one registered for the uuid,
+// so a session being replaced doesn't unregister its replacement
+func (m *SClientMap) DeleteIf(uuid string, client *SessionClient) {
+	m.mutex.Lock()
+
+	if m.clients[uuid] == client {
+		delete(m.clients, uuid)
+	}
+
+	m.mutex.Unlock()
+}
+
 func (m *SClientMap) Get() []*SessionClient {
 	m.mutex.RLock()
 
@@ -382,3 +528,8 @@ func (m *SClientMap) Exists(uuid string) bool {
 
 	return ok
 }
+
+// LoadRemote never finds a player as there are no other processes
+func (m *SClientMap) LoadRemote(uuid string) (*PlayerListFullData, bool) {
+	return nil, false
+}
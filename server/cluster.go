@@ -0,0 +1,382 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// processes serving the same game with the same redis server form a cluster,
+// each one announcing its players so the others can show them as online
+const (
+	clusterPresenceInterval = 5 // seconds
+	clusterPresenceTimeout  = 3 * clusterPresenceInterval * time.Second
+
+	clusterMsgPresence  = "presence"
+	clusterMsgBroadcast = "broadcast"
+	clusterMsgParty     = "party"
+
+	clusterMsgInvalidatePlayer = "invalidatePlayer"
+)
+
+var (
+	// nil if redis is disabled
+	clusterClients *ClusterClientMap
+
+	// identifies the messages published by this process so they aren't delivered twice
+	clusterInstanceId = randString(16)
+)
+
+type ClusterMessage struct {
+	Instance string `json:"instance"`
+	Type     string `json:"type"`
+
+	Players []*PlayerListFullData `json:"players,omitempty"`
+
+	Uuid string `json:"uuid,omitempty"`
+
+	PartyId      int      `json:"partyId,omitempty"`
+	SenderUuid   string   `json:"senderUuid,omitempty"`
+	SenderBlocks []string `json:"senderBlocks,omitempty"`
+	Msg          []byte   `json:"msg,omitempty"`
+}
+
+// ClusterClientMap stores the players connected to this process along with those announced by the other processes
+type ClusterClientMap struct {
+	*SClientMap
+
+	instances map[string]*clusterInstance
+	mutex     sync.RWMutex
+}
+
+type clusterInstance struct {
+	players  map[string]*PlayerListFullData
+	lastSeen time.Time
+}
+
+func initCluster() {
+	if redisClient == nil {
+		return
+	}
+
+	logInitTask("cluster")
+
+	clusterClients = &ClusterClientMap{
+		SClientMap: NewSCMap(),
+		instances:  make(map[string]*clusterInstance),
+	}
+	clients = clusterClients
+
+	go redisClient.subscribe(getClusterChannel(), handleClusterMessage)
+	go redisClient.subscribe(getPlayersChannel(), handleClusterMessage)
+
+	scheduleTask("clusterPresence", scheduler.Every(clusterPresenceInterval).Seconds(), func() {
+		clusterClients.pruneInstances()
+		publishClusterPresence()
+	})
+}
+
+func getClusterChannel() string {
+	return getConfig().redis.keyPrefix + getConfig().gameName + ":cluster"
+}
+
+// getPlayersChannel returns the channel shared by the processes of every game, for changes to players' accounts
+func getPlayersChannel() string {
+	return getConfig().redis.keyPrefix + "players"
+}
+
+func (m *ClusterClientMap) GetAmount() int {
+	amount := m.SClientMap.GetAmount()
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	counted := make(map[string]bool)
+	for _, instance := range m.instances {
+		for uuid := range instance.players {
+			// players reconnecting to another process may briefly be announced by both
+			if counted[uuid] || m.SClientMap.Exists(uuid) {
+				continue
+			}
+
+			counted[uuid] = true
+			amount++
+		}
+	}
+
+	return amount
+}
+
+func (m *ClusterClientMap) Exists(uuid string) bool {
+	if m.SClientMap.Exists(uuid) {
+		return true
+	}
+
+	_, ok := m.LoadRemote(uuid)
+
+	return ok
+}
+
+// LoadRemote returns the presence last announced for a player connected to another process
+func (m *ClusterClientMap) LoadRemote(uuid string) (*PlayerListFullData, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, instance := range m.instances {
+		if player, ok := instance.players[uuid]; ok {
+			return player, true
+		}
+	}
+
+	return nil, false
+}
+
+func (m *ClusterClientMap) setInstancePlayers(instanceId string, players []*PlayerListFullData) {
+	instance := &clusterInstance{
+		players:  make(map[string]*PlayerListFullData, len(players)),
+		lastSeen: time.Now(),
+	}
+
+	for _, player := range players {
+		instance.players[player.Uuid] = player
+	}
+
+	m.mutex.Lock()
+
+	m.instances[instanceId] = instance
+
+	m.mutex.Unlock()
+}
+
+// pruneInstances forgets the players of processes that stopped announcing them, such as after a crash
+func (m *ClusterClientMap) pruneInstances() {
+	m.mutex.Lock()
+
+	for instanceId, instance := range m.instances {
+		if time.Since(instance.lastSeen) > clusterPresenceTimeout {
+			delete(m.instances, instanceId)
+		}
+	}
+
+	m.mutex.Unlock()
+}
+
+// isClusterLeader reports whether this process should run the tasks only one process of the cluster should run
+func isClusterLeader() bool {
+	if clusterClients == nil {
+		return true
+	}
+
+	clusterClients.mutex.RLock()
+	defer clusterClients.mutex.RUnlock()
+
+	instanceIds := []string{clusterInstanceId}
+	for instanceId := range clusterClients.instances {
+		instanceIds = append(instanceIds, instanceId)
+	}
+
+	sort.Strings(instanceIds)
+
+	return instanceIds[0] == clusterInstanceId
+}
+
+func (c *SessionClient) getPresence() *PlayerListFullData {
+	presence := &PlayerListFullData{
+		PlayerListData: PlayerListData{
+			Uuid:        c.uuid,
+			Name:        c.name,
+			SystemName:  c.system,
+			Rank:        c.rank,
+			Account:     c.account,
+			Badge:       c.badge,
+			Medals:      c.medals,
+			SpriteName:  c.sprite,
+			SpriteIndex: c.spriteIndex,
+		},
+		MapId:     "0000",
+		PrevMapId: "0000",
+		Online:    true,
+		Status:    c.status,
+	}
+
+	if c.roomC != nil {
+		presence.MapId = c.roomC.mapId
+		presence.PrevMapId = c.roomC.prevMapId
+		presence.PrevLocations = c.roomC.prevLocations
+		presence.X = c.roomC.x
+		presence.Y = c.roomC.y
+	}
+
+	return presence
+}
+
+// applyRemotePresence fills in the live data of a player connected to another process
+func (p *PlayerListFullData) applyRemotePresence(presence *PlayerListFullData) {
+	if presence.Name != "" {
+		p.Name = presence.Name
+	}
+	if presence.SystemName != "" {
+		p.SystemName = presence.SystemName
+	}
+	if presence.SpriteName != "" {
+		p.SpriteName = presence.SpriteName
+	}
+	if presence.SpriteIndex > -1 {
+		p.SpriteIndex = presence.SpriteIndex
+	}
+
+	p.Badge = presence.Badge
+	p.Medals = presence.Medals
+
+	p.MapId = presence.MapId
+	p.PrevMapId = presence.PrevMapId
+	p.PrevLocations = presence.PrevLocations
+	p.X = presence.X
+	p.Y = presence.Y
+
+	p.Online = true
+	p.Status = presence.Status
+}
+
+func publishClusterPresence() {
+	var players []*PlayerListFullData
+	for _, client := range clusterClients.SClientMap.Get() {
+		players = append(players, client.getPresence())
+	}
+
+	publishClusterMessage(&ClusterMessage{
+		Type:    clusterMsgPresence,
+		Players: players,
+	})
+}
+
+func publishClusterMessage(msg *ClusterMessage) {
+	publishRedisMessage(getClusterChannel(), msg)
+}
+
+func publishRedisMessage(channel string, msg *ClusterMessage) {
+	if redisClient == nil {
+		return
+	}
+
+	msg.Instance = clusterInstanceId
+
+	msgJson, err := json.Marshal(msg)
+	if err != nil {
+		writeErrLog("SERVER", "cluster", err.Error())
+		return
+	}
+
+	_, err = redisClient.Do("PUBLISH", channel, string(msgJson))
+	if err != nil {
+		writeErrLog("SERVER", "redis", err.Error())
+	}
+}
+
+func handleClusterMessage(payload []byte) {
+	var msg ClusterMessage
+	err := json.Unmarshal(payload, &msg)
+	if err != nil {
+		writeErrLog("SERVER", "cluster", err.Error())
+		return
+	}
+
+	if msg.Instance == clusterInstanceId {
+		return
+	}
+
+	switch msg.Type {
+	case clusterMsgPresence:
+		clusterClients.setInstancePlayers(msg.Instance, msg.Players)
+	case clusterMsgInvalidatePlayer:
+		invalidateLocalPlayerData(msg.Uuid)
+	case clusterMsgBroadcast, clusterMsgParty:
+		senderBlocks := make(map[string]bool, len(msg.SenderBlocks))
+		for _, uuid := range msg.SenderBlocks {
+			senderBlocks[uuid] = true
+		}
+
+		for _, client := range clients.Get() {
+			if msg.Type == clusterMsgParty && client.partyId != msg.PartyId {
+				continue
+			}
+			if msg.SenderUuid != "" && (client.blockedUsers[msg.SenderUuid] || senderBlocks[client.uuid]) {
+				continue
+			}
+
+			select {
+			case client.outbox <- buildMsg(msg.Msg):
+			default:
+				writeErrLog(client.uuid, "cluster", "send channel is full")
+			}
+		}
+	}
+}
+
+// broadcastAll sends a session message to the players of every process of the cluster
+func broadcastAll(msg []byte) {
+	var sender SessionClient
+	sender.broadcast(msg)
+
+	publishClusterMessage(&ClusterMessage{
+		Type: clusterMsgBroadcast,
+		Msg:  msg,
+	})
+}
+
+// broadcastUnblockedAll is broadcastUnblocked for the players of every process of the cluster
+func (c *SessionClient) broadcastUnblockedAll(msg []byte) {
+	c.broadcastUnblocked(msg)
+
+	publishClusterMessage(&ClusterMessage{
+		Type:         clusterMsgBroadcast,
+		SenderUuid:   c.uuid,
+		SenderBlocks: c.getBlockedUuids(),
+		Msg:          msg,
+	})
+}
+
+// broadcastParty sends a message from the client to the members of its party on every process of the cluster
+func (c *SessionClient) broadcastParty(msg []byte) {
+	for _, client := range clients.Get() {
+		if client.partyId == c.partyId && !client.blockedUsers[c.uuid] && !c.blockedUsers[client.uuid] {
+			client.outbox <- buildMsg(msg)
+		}
+	}
+
+	publishClusterMessage(&ClusterMessage{
+		Type:         clusterMsgParty,
+		PartyId:      c.partyId,
+		SenderUuid:   c.uuid,
+		SenderBlocks: c.getBlockedUuids(),
+		Msg:          msg,
+	})
+}
+
+func (c *SessionClient) getBlockedUuids() (uuids []string) {
+	for uuid, blocked := range c.blockedUsers {
+		if blocked {
+			uuids = append(uuids, uuid)
+		}
+	}
+
+	return uuids
+}
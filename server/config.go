@@ -18,21 +18,41 @@
 package server
 
 import (
+	"errors"
+	"log/slog"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
+// currentConfig is replaced as a whole when the config is reloaded, so goroutines reading it never see a partial update
+var currentConfig atomic.Pointer[Config]
+
+func getConfig() *Config {
+	return currentConfig.Load()
+}
+
 type Config struct {
 	gameName string
 	gamePath string
 
+	dbType                         string
 	dbUser, dbPass, dbAddr, dbName string
+	dbReplicaAddr                  string
+	dbQueryTimeout                 time.Duration
+
+	dbMaxOpenConns    int
+	dbMaxIdleConns    int
+	dbConnMaxLifetime time.Duration
 
 	spRooms         []int
+	roomPlayerCap   int
 	badSounds       map[string]bool
 	pictures        map[string]bool
 	picturePrefixes []string
@@ -41,20 +61,109 @@ type Config struct {
 	chatWebhook       string
 	screenshotWebhook string
 
+	badgeUnlockPartyNotify bool
+
+	weeklyExpCap int
+
+	eventRolloverOffset time.Duration
+	eventPeriodLength   int
+
+	saveSizeLimits map[int]int
+
+	sessionResumeWindow time.Duration
+
+	allowSpectators bool
+
+	wsCompression struct {
+		enabled   bool
+		threshold int
+		level     int
+	}
+
+	chatChannels []ChatChannelConfig
+
+	webhooks []WebhookConfig
+
+	minigames []MinigameConfig
+
+	medals []MedalConfig
+
+	timeTrials struct {
+		minSeconds     map[int]int
+		startTolerance time.Duration
+	}
+
+	explorerCache struct {
+		ttl        time.Duration
+		actionTtls map[string]time.Duration
+		errorTtl   time.Duration
+	}
+
+	freeEventLocations struct {
+		dailyQuota int
+		minDepth   int
+		maxDepth   int
+		cooldown   time.Duration
+	}
+
+	backups struct {
+		schedule        string
+		retentionDays   int
+		includeAccounts bool
+
+		s3 struct {
+			endpoint  string
+			region    string
+			bucket    string
+			accessKey string
+			secretKey string
+			prefix    string
+		}
+	}
+
+	cors struct {
+		allowedOrigins []string
+		allowedHeaders []string
+		allowedMethods []string
+		maxAge         int
+	}
+
 	moderation struct {
 		botToken  string
 		channelId string
 		modRoleId string
 	}
 
+	discordBridge struct {
+		botToken  string
+		channelId string
+	}
+
 	ipc struct {
 		deadline time.Duration
 	}
 
+	adminRpc struct {
+		listen       string
+		certFile     string
+		keyFile      string
+		clientCaFile string
+	}
+
+	redis struct {
+		addr      string
+		password  string
+		db        int
+		keyPrefix string
+	}
+
 	logging struct {
 		maxSize    int
 		maxBackups int
 		maxAge     int
+
+		level slog.Level
+		json  bool
 	}
 
 	vapidKeys struct {
@@ -67,12 +176,22 @@ type ConfigFile struct {
 	GameName string `yaml:"game_name"`
 	GamePath string `yaml:"game_path"`
 
+	DbType string `yaml:"db_type"`
 	DbUser string `yaml:"db_user"`
 	DbPass string `yaml:"db_pass"`
 	DbAddr string `yaml:"db_addr"`
 	DbName string `yaml:"db_name"`
 
+	DbReplicaAddr string `yaml:"db_replica_addr"`
+
+	DbQueryTimeout *int `yaml:"db_query_timeout"`
+
+	DbMaxOpenConns    int `yaml:"db_max_open_conns"`
+	DbMaxIdleConns    int `yaml:"db_max_idle_conns"`
+	DbConnMaxLifetime int `yaml:"db_conn_max_lifetime"`
+
 	SpRooms         string `yaml:"sp_rooms"`
+	RoomPlayerCap   int    `yaml:"room_player_cap"`
 	BadSounds       string `yaml:"bad_sounds"`
 	PictureNames    string `yaml:"picture_names"`
 	PicturePrefixes string `yaml:"picture_prefixes"`
@@ -81,16 +200,102 @@ type ConfigFile struct {
 	ChatWebhook       string `yaml:"chat_webhook"`
 	ScreenshotWebhook string `yaml:"screenshot_webhook"`
 
+	BadgeUnlockPartyNotify bool `yaml:"badge_unlock_party_notify"`
+
+	WeeklyExpCap *int `yaml:"weekly_exp_cap"`
+
+	EventRolloverTime string `yaml:"event_rollover_time"`
+	EventPeriodLength int    `yaml:"event_period_length"`
+
+	SaveSizeLimits map[int]int `yaml:"save_size_limits"`
+
+	SessionResumeSeconds *int `yaml:"session_resume_seconds"`
+
+	AllowSpectators bool `yaml:"allow_spectators"`
+
+	WsCompression struct {
+		Enabled   bool `yaml:"enabled"`
+		Threshold int  `yaml:"threshold"`
+		Level     int  `yaml:"level"`
+	} `yaml:"ws_compression"`
+
+	ChatChannels []ChatChannelConfig `yaml:"chat_channels"`
+
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+
+	Minigames []MinigameConfig `yaml:"minigames"`
+
+	Medals []MedalConfig `yaml:"medals"`
+
+	TimeTrials struct {
+		MinSeconds            map[int]int `yaml:"min_seconds"`
+		StartToleranceSeconds *int        `yaml:"start_tolerance_seconds"`
+	} `yaml:"time_trials"`
+
+	ExplorerCache struct {
+		TtlMinutes       int            `yaml:"ttl_minutes"`
+		ActionTtlMinutes map[string]int `yaml:"action_ttl_minutes"`
+		ErrorTtlMinutes  int            `yaml:"error_ttl_minutes"`
+	} `yaml:"explorer_cache"`
+
+	FreeEventLocations struct {
+		DailyQuota      int `yaml:"daily_quota"`
+		MinDepth        int `yaml:"min_depth"`
+		MaxDepth        int `yaml:"max_depth"`
+		CooldownMinutes int `yaml:"cooldown_minutes"`
+	} `yaml:"free_event_locations"`
+
+	Backups *struct {
+		Schedule        string `yaml:"schedule"`
+		RetentionDays   int    `yaml:"retention_days"`
+		IncludeAccounts bool   `yaml:"include_accounts"`
+
+		S3 struct {
+			Endpoint  string `yaml:"endpoint"`
+			Region    string `yaml:"region"`
+			Bucket    string `yaml:"bucket"`
+			AccessKey string `yaml:"access_key"`
+			SecretKey string `yaml:"secret_key"`
+			Prefix    string `yaml:"prefix"`
+		} `yaml:"s3"`
+	} `yaml:"backups"`
+
+	Cors struct {
+		AllowedOrigins []string `yaml:"allowed_origins"`
+		AllowedHeaders []string `yaml:"allowed_headers"`
+		AllowedMethods []string `yaml:"allowed_methods"`
+		MaxAge         int      `yaml:"max_age"`
+	} `yaml:"cors"`
+
 	Moderation *struct {
 		BotToken  string `yaml:"bot_token"`
 		ChannelID string `yaml:"channel_id"`
 		ModRoleID string `yaml:"mod_role_id"`
 	} `yaml:"moderation"`
 
+	DiscordBridge struct {
+		BotToken  string `yaml:"bot_token"`
+		ChannelID string `yaml:"channel_id"`
+	} `yaml:"discord_bridge"`
+
 	Ipc *struct {
 		DeadlineMs int `yaml:"deadline_ms"`
 	} `yaml:"ipc"`
 
+	AdminRpc struct {
+		Listen       string `yaml:"listen"`
+		CertFile     string `yaml:"cert_file"`
+		KeyFile      string `yaml:"key_file"`
+		ClientCaFile string `yaml:"client_ca_file"`
+	} `yaml:"admin_rpc"`
+
+	Redis struct {
+		Addr      string `yaml:"addr"`
+		Password  string `yaml:"password"`
+		Db        int    `yaml:"db"`
+		KeyPrefix string `yaml:"key_prefix"`
+	} `yaml:"redis"`
+
 	VapidKeys struct {
 		Private string `yaml:"private"`
 		Public  string `yaml:"public"`
@@ -100,20 +305,70 @@ type ConfigFile struct {
 		MaxSize    int `yaml:"max_size"`
 		MaxBackups int `yaml:"max_backups"`
 		MaxAge     int `yaml:"max_age"`
+
+		Level string `yaml:"level"`
+		Json  bool   `yaml:"json"`
 	} `yaml:"logging"`
 }
 
-func parseConfigFile(filename string) *Config {
-	yamlFile, err := os.ReadFile(filename)
-	if err != nil {
-		panic(err)
-	}
+type ChatChannelConfig struct {
+	Id         string `yaml:"id"`
+	Name       string `yaml:"name"`
+	MaxMembers int    `yaml:"max_members"`
+}
+
+type MinigameConfig struct {
+	Id             string    `yaml:"id"`
+	Name           string    `yaml:"name"`
+	RoomId         int       `yaml:"room_id"`
+	VarId          int       `yaml:"var_id"`
+	InitialVarSync bool      `yaml:"initial_var_sync"`
+	SwitchId       int       `yaml:"switch_id"`
+	SwitchValue    bool      `yaml:"switch_value"`
+	ScoreDirection string    `yaml:"score_direction"`
+	ActiveFrom     time.Time `yaml:"active_from"`
+	ActiveUntil    time.Time `yaml:"active_until"`
+	Dev            bool      `yaml:"dev"`
+
+	MaxScore         int   `yaml:"max_score"`
+	MinScore         int   `yaml:"min_score"`
+	MinSeconds       int   `yaml:"min_seconds"`
+	RequiredSwitches []int `yaml:"required_switches"`
+	RequiredVars     []int `yaml:"required_vars"`
+}
 
+type MedalConfig struct {
+	Id          string `yaml:"id"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Tier        string `yaml:"tier"`
+	Rule        string `yaml:"rule"`
+	Req         int    `yaml:"req"`
+}
+
+type WebhookConfig struct {
+	Url    string   `yaml:"url"`
+	Secret string   `yaml:"secret"`
+	Events []string `yaml:"events"`
+}
+
+func parseConfigFile(filename string) (*Config, error) {
 	var configFile ConfigFile
 
+	// the file can be left out when everything is given as flags or environment variables
+	yamlFile, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
 	err = yaml.Unmarshal(yamlFile, &configFile)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	err = applyConfigOverrides(&configFile)
+	if err != nil {
+		return nil, err
 	}
 
 	var config Config
@@ -121,10 +376,26 @@ func parseConfigFile(filename string) *Config {
 	config.gameName = configFile.GameName
 	config.gamePath = configFile.GamePath
 
+	if configFile.DbType != "" {
+		config.dbType = configFile.DbType
+	} else {
+		config.dbType = "mysql"
+	}
 	config.dbUser = configFile.DbUser
 	config.dbPass = configFile.DbPass
 	config.dbAddr = configFile.DbAddr
 	config.dbName = configFile.DbName
+	config.dbReplicaAddr = configFile.DbReplicaAddr
+
+	if configFile.DbQueryTimeout != nil {
+		config.dbQueryTimeout = time.Duration(*configFile.DbQueryTimeout) * time.Second
+	} else {
+		config.dbQueryTimeout = 30 * time.Second
+	}
+
+	config.dbMaxOpenConns = configFile.DbMaxOpenConns
+	config.dbMaxIdleConns = configFile.DbMaxIdleConns
+	config.dbConnMaxLifetime = time.Duration(configFile.DbConnMaxLifetime) * time.Second
 
 	if configFile.SpRooms != "" {
 		for _, str := range strings.Split(configFile.SpRooms, ",") {
@@ -137,6 +408,8 @@ func parseConfigFile(filename string) *Config {
 		}
 	}
 
+	config.roomPlayerCap = configFile.RoomPlayerCap
+
 	config.badSounds = make(map[string]bool)
 	if configFile.BadSounds != "" {
 		for _, name := range strings.Split(configFile.BadSounds, ",") {
@@ -170,18 +443,180 @@ func parseConfigFile(filename string) *Config {
 	config.chatWebhook = configFile.ChatWebhook
 	config.screenshotWebhook = configFile.ScreenshotWebhook
 
+	config.badgeUnlockPartyNotify = configFile.BadgeUnlockPartyNotify
+
+	// a cap of 0 disables event exp, so only a missing cap uses the default
+	if configFile.WeeklyExpCap != nil {
+		config.weeklyExpCap = *configFile.WeeklyExpCap
+	} else {
+		config.weeklyExpCap = defaultWeeklyExpCap
+	}
+
+	if configFile.EventRolloverTime != "" {
+		rolloverTime, err := time.Parse("15:04", configFile.EventRolloverTime)
+		if err != nil {
+			return nil, err
+		}
+
+		config.eventRolloverOffset = time.Duration(rolloverTime.Hour())*time.Hour + time.Duration(rolloverTime.Minute())*time.Minute
+	}
+
+	config.eventPeriodLength = configFile.EventPeriodLength
+
+	config.saveSizeLimits = make(map[int]int)
+	for rank, limit := range configFile.SaveSizeLimits {
+		config.saveSizeLimits[rank] = limit * 1024 * 1024
+	}
+
+	if configFile.SessionResumeSeconds != nil {
+		config.sessionResumeWindow = time.Duration(*configFile.SessionResumeSeconds) * time.Second
+	} else {
+		config.sessionResumeWindow = 30 * time.Second
+	}
+
+	config.allowSpectators = configFile.AllowSpectators
+
+	config.wsCompression.enabled = configFile.WsCompression.Enabled
+	if configFile.WsCompression.Threshold != 0 {
+		config.wsCompression.threshold = configFile.WsCompression.Threshold
+	} else {
+		config.wsCompression.threshold = 128 // bytes
+	}
+	if configFile.WsCompression.Level != 0 {
+		config.wsCompression.level = configFile.WsCompression.Level
+	} else {
+		config.wsCompression.level = 1 // fastest
+	}
+
+	config.chatChannels = configFile.ChatChannels
+
+	config.webhooks = configFile.Webhooks
+
+	for _, minigame := range configFile.Minigames {
+		switch minigame.ScoreDirection {
+		case "", minigameScoreDirectionHigher, minigameScoreDirectionLower:
+		default:
+			return nil, errors.New("invalid score_direction for minigame " + minigame.Id)
+		}
+	}
+	config.minigames = configFile.Minigames
+
+	for _, medal := range configFile.Medals {
+		if medal.Id == "" {
+			return nil, errors.New("medal id not specified")
+		}
+		if _, ok := getMedalTier(medal.Tier); !ok {
+			return nil, errors.New("invalid tier for medal " + medal.Id)
+		}
+		switch medal.Rule {
+		case "":
+		case medalRuleEventPlacement, medalRuleEventStreak:
+			if medal.Req <= 0 {
+				return nil, errors.New("req not specified for medal " + medal.Id)
+			}
+		default:
+			return nil, errors.New("invalid rule for medal " + medal.Id)
+		}
+	}
+	config.medals = configFile.Medals
+
+	config.timeTrials.minSeconds = make(map[int]int)
+	for mapId, seconds := range configFile.TimeTrials.MinSeconds {
+		config.timeTrials.minSeconds[mapId] = seconds
+	}
+	if configFile.TimeTrials.StartToleranceSeconds != nil {
+		config.timeTrials.startTolerance = time.Duration(*configFile.TimeTrials.StartToleranceSeconds) * time.Second
+	} else {
+		config.timeTrials.startTolerance = 30 * time.Second
+	}
+
+	if configFile.ExplorerCache.TtlMinutes != 0 {
+		config.explorerCache.ttl = time.Duration(configFile.ExplorerCache.TtlMinutes) * time.Minute
+	} else {
+		config.explorerCache.ttl = time.Hour
+	}
+	config.explorerCache.actionTtls = make(map[string]time.Duration)
+	for action, ttlMinutes := range configFile.ExplorerCache.ActionTtlMinutes {
+		config.explorerCache.actionTtls[action] = time.Duration(ttlMinutes) * time.Minute
+	}
+	if configFile.ExplorerCache.ErrorTtlMinutes != 0 {
+		config.explorerCache.errorTtl = time.Duration(configFile.ExplorerCache.ErrorTtlMinutes) * time.Minute
+	} else {
+		config.explorerCache.errorTtl = 5 * time.Minute
+	}
+
+	config.freeEventLocations.dailyQuota = configFile.FreeEventLocations.DailyQuota
+	if configFile.FreeEventLocations.MinDepth != 0 {
+		config.freeEventLocations.minDepth = configFile.FreeEventLocations.MinDepth
+	} else {
+		config.freeEventLocations.minDepth = defaultFreeEventLocationMinDepth
+	}
+	config.freeEventLocations.maxDepth = configFile.FreeEventLocations.MaxDepth
+	config.freeEventLocations.cooldown = time.Duration(configFile.FreeEventLocations.CooldownMinutes) * time.Minute
+
+	config.cors.allowedOrigins = configFile.Cors.AllowedOrigins
+	if len(configFile.Cors.AllowedHeaders) != 0 {
+		config.cors.allowedHeaders = configFile.Cors.AllowedHeaders
+	} else {
+		config.cors.allowedHeaders = []string{"Authorization", "Content-Type"}
+	}
+	if len(configFile.Cors.AllowedMethods) != 0 {
+		config.cors.allowedMethods = configFile.Cors.AllowedMethods
+	} else {
+		config.cors.allowedMethods = []string{"GET", "POST", "OPTIONS"}
+	}
+	if configFile.Cors.MaxAge != 0 {
+		config.cors.maxAge = configFile.Cors.MaxAge
+	} else {
+		config.cors.maxAge = 600 // seconds
+	}
+
+	if backups := configFile.Backups; backups != nil {
+		config.backups.schedule = backups.Schedule
+		config.backups.retentionDays = backups.RetentionDays
+		config.backups.includeAccounts = backups.IncludeAccounts
+
+		config.backups.s3.endpoint = backups.S3.Endpoint
+		if backups.S3.Region != "" {
+			config.backups.s3.region = backups.S3.Region
+		} else {
+			config.backups.s3.region = "us-east-1"
+		}
+		config.backups.s3.bucket = backups.S3.Bucket
+		config.backups.s3.accessKey = backups.S3.AccessKey
+		config.backups.s3.secretKey = backups.S3.SecretKey
+		config.backups.s3.prefix = backups.S3.Prefix
+	}
+
 	if mod := configFile.Moderation; mod != nil {
 		config.moderation.botToken = mod.BotToken
 		config.moderation.channelId = mod.ChannelID
 		config.moderation.modRoleId = mod.ModRoleID
 	}
 
+	config.discordBridge.botToken = configFile.DiscordBridge.BotToken
+	config.discordBridge.channelId = configFile.DiscordBridge.ChannelID
+
 	if ipc := configFile.Ipc; ipc != nil {
 		config.ipc.deadline = time.Duration(ipc.DeadlineMs) * time.Millisecond
 	} else {
 		config.ipc.deadline = 100 * time.Millisecond
 	}
 
+	config.adminRpc.listen = configFile.AdminRpc.Listen
+	config.adminRpc.certFile = configFile.AdminRpc.CertFile
+	config.adminRpc.keyFile = configFile.AdminRpc.KeyFile
+	config.adminRpc.clientCaFile = configFile.AdminRpc.ClientCaFile
+
+	config.redis.addr = configFile.Redis.Addr
+	config.redis.password = configFile.Redis.Password
+	config.redis.db = configFile.Redis.Db
+	if configFile.Redis.KeyPrefix != "" {
+		config.redis.keyPrefix = configFile.Redis.KeyPrefix
+	} else {
+		config.redis.keyPrefix = "ynoserver:"
+	}
+
 	if configFile.Logging.MaxSize != 0 {
 		config.logging.maxSize = configFile.Logging.MaxSize
 	} else {
@@ -197,9 +632,87 @@ func parseConfigFile(filename string) *Config {
 	} else {
 		config.logging.maxAge = 28 // Days
 	}
+	if configFile.Logging.Level != "" {
+		if err := config.logging.level.UnmarshalText([]byte(configFile.Logging.Level)); err != nil {
+			return nil, err
+		}
+	} else {
+		config.logging.level = slog.LevelInfo
+	}
+	config.logging.json = configFile.Logging.Json
 
 	config.vapidKeys.private = configFile.VapidKeys.Private
 	config.vapidKeys.public = configFile.VapidKeys.Public
 
-	return &config
+	return &config, nil
+}
+
+func initConfigReload() {
+	logInitTask("config reload")
+
+	go func() {
+		reload := make(chan os.Signal, 1)
+
+		signal.Notify(reload, syscall.SIGHUP)
+
+		for range reload {
+			if err := reloadConfig(); err != nil {
+				writeErrLog("SERVER", "config", err.Error())
+			}
+		}
+	}()
+}
+
+// reloadConfig reads the config file again and applies the settings that can change while the server runs,
+// the others keep the values the server was started with
+func reloadConfig() error {
+	newConfig, err := parseConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	prevConfig := getConfig()
+
+	newConfig.gameName = prevConfig.gameName
+	newConfig.gamePath = prevConfig.gamePath
+
+	newConfig.dbType = prevConfig.dbType
+	newConfig.dbUser, newConfig.dbPass, newConfig.dbAddr, newConfig.dbName = prevConfig.dbUser, prevConfig.dbPass, prevConfig.dbAddr, prevConfig.dbName
+	newConfig.dbReplicaAddr = prevConfig.dbReplicaAddr
+	newConfig.dbMaxOpenConns = prevConfig.dbMaxOpenConns
+	newConfig.dbMaxIdleConns = prevConfig.dbMaxIdleConns
+	newConfig.dbConnMaxLifetime = prevConfig.dbConnMaxLifetime
+
+	// rooms, chat channels and scheduled tasks are set up from these at startup
+	newConfig.spRooms = prevConfig.spRooms
+	newConfig.chatChannels = prevConfig.chatChannels
+	newConfig.eventRolloverOffset = prevConfig.eventRolloverOffset
+	newConfig.backups.schedule = prevConfig.backups.schedule
+
+	newConfig.wsCompression.enabled = prevConfig.wsCompression.enabled
+	newConfig.moderation = prevConfig.moderation
+	newConfig.discordBridge = prevConfig.discordBridge
+	newConfig.adminRpc = prevConfig.adminRpc
+	newConfig.redis = prevConfig.redis
+
+	newConfig.logging.maxSize = prevConfig.logging.maxSize
+	newConfig.logging.maxBackups = prevConfig.logging.maxBackups
+	newConfig.logging.maxAge = prevConfig.logging.maxAge
+	newConfig.logging.json = prevConfig.logging.json
+
+	currentConfig.Store(newConfig)
+
+	logLevel.Set(newConfig.logging.level)
+
+	if err := setWordFilter(); err != nil {
+		writeErrLog("SERVER", "config", "word filter: "+err.Error())
+	}
+
+	setBadgeBatchDates()
+	updateActiveBadgesAndConditions()
+	setMedals()
+
+	writeLog("SERVER", "config", "reloaded "+configPath, 200)
+
+	return nil
 }
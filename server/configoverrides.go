@@ -0,0 +1,184 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// every config file setting can also be given as a command-line flag named after its path in the file,
+// such as -db_pass or -backups.s3.bucket, or as an environment variable, such as YNO_DB_PASS or YNO_BACKUPS_S3_BUCKET.
+// Flags take precedence over environment variables, which take precedence over the file.
+// Lists are comma-separated, maps and lists of sections can only be set in the file.
+const (
+	configEnvPrefix = "YNO_"
+)
+
+func registerConfigFlags() {
+	for _, name := range getConfigOverrideNames(reflect.TypeOf(ConfigFile{}), "") {
+		flag.String(name, "", "Overrides "+name+" of the configuration file")
+	}
+}
+
+func getConfigOverrideNames(t reflect.Type, prefix string) (names []string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := prefix + field.Tag.Get("yaml")
+
+		if section, ok := getConfigSection(field.Type); ok {
+			names = append(names, getConfigOverrideNames(section, name+".")...)
+		} else if isConfigOverridable(field.Type) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// applyConfigOverrides sets the fields of configFile given as flags or environment variables
+func applyConfigOverrides(configFile *ConfigFile) error {
+	setFlags := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = f.Value.String()
+	})
+
+	_, err := applyConfigSectionOverrides(reflect.ValueOf(configFile).Elem(), "", setFlags)
+
+	return err
+}
+
+func applyConfigSectionOverrides(section reflect.Value, prefix string, setFlags map[string]string) (applied bool, err error) {
+	for i := 0; i < section.NumField(); i++ {
+		field := section.Type().Field(i)
+		fieldValue := section.Field(i)
+		name := prefix + field.Tag.Get("yaml")
+
+		if sectionType, ok := getConfigSection(field.Type); ok {
+			if field.Type.Kind() != reflect.Pointer {
+				sectionApplied, err := applyConfigSectionOverrides(fieldValue, name+".", setFlags)
+				if err != nil {
+					return false, err
+				}
+
+				applied = applied || sectionApplied
+				continue
+			}
+
+			// optional sections missing from the file are only added if one of their settings is given
+			sectionValue := fieldValue
+			if sectionValue.IsNil() {
+				sectionValue = reflect.New(sectionType)
+			}
+
+			sectionApplied, err := applyConfigSectionOverrides(sectionValue.Elem(), name+".", setFlags)
+			if err != nil {
+				return false, err
+			}
+
+			if sectionApplied {
+				fieldValue.Set(sectionValue)
+				applied = true
+			}
+
+			continue
+		}
+
+		if !isConfigOverridable(field.Type) {
+			continue
+		}
+
+		value, ok := setFlags[name]
+		if !ok {
+			value, ok = os.LookupEnv(configEnvPrefix + strings.ToUpper(strings.ReplaceAll(name, ".", "_")))
+		}
+		if !ok {
+			continue
+		}
+
+		if err := setConfigValue(fieldValue, value); err != nil {
+			return false, fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+
+		applied = true
+	}
+
+	return applied, nil
+}
+
+func getConfigSection(t reflect.Type) (reflect.Type, bool) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	return t, t.Kind() == reflect.Struct
+}
+
+func isConfigOverridable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Int, reflect.Bool:
+		return true
+	case reflect.Pointer:
+		return isConfigOverridable(t.Elem())
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+
+	return false
+}
+
+func setConfigValue(fieldValue reflect.Value, value string) error {
+	switch fieldValue.Kind() {
+	case reflect.String:
+		fieldValue.SetString(value)
+	case reflect.Int:
+		num, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+
+		fieldValue.SetInt(int64(num))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+
+		fieldValue.SetBool(b)
+	case reflect.Pointer:
+		elem := reflect.New(fieldValue.Type().Elem())
+		if err := setConfigValue(elem.Elem(), value); err != nil {
+			return err
+		}
+
+		fieldValue.Set(elem)
+	case reflect.Slice:
+		var values []string
+		if value != "" {
+			values = strings.Split(value, ",")
+		}
+
+		fieldValue.Set(reflect.ValueOf(values))
+	}
+
+	return nil
+}
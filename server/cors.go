@@ -0,0 +1,63 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// headers set by the server that clients need to read
+var corsExposedHeaders = []string{"X-Save-Size-Limit", "X-Save-Size-Remaining", "X-Request-Id"}
+
+// withCors sets the CORS headers of responses, reading the settings for every request as they can be reloaded
+func withCors(next http.Handler) http.Handler {
+	exposedHeaders := strings.Join(corsExposedHeaders, ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cors := getConfig().cors
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || !isCorsOriginAllowed(cors.allowedOrigins, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Add("Vary", "Origin")
+		header.Set("Access-Control-Expose-Headers", exposedHeaders)
+
+		// preflight
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			header.Set("Access-Control-Allow-Methods", strings.Join(cors.allowedMethods, ", "))
+			header.Set("Access-Control-Allow-Headers", strings.Join(cors.allowedHeaders, ", "))
+			header.Set("Access-Control-Max-Age", strconv.Itoa(cors.maxAge))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isCorsOriginAllowed(allowedOrigins []string, origin string) bool {
+	return slices.Contains(allowedOrigins, "*") || slices.Contains(allowedOrigins, origin)
+}
@@ -0,0 +1,63 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithCorsReloadedConfig(t *testing.T) {
+	prevConfig := getConfig()
+	defer currentConfig.Store(prevConfig)
+
+	handler := withCors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	preflight := func() http.Header {
+		r := httptest.NewRequest(http.MethodOptions, "/api/info", nil)
+		r.Header.Set("Origin", "https://ynoproject.net")
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w.Header()
+	}
+
+	currentConfig.Store(&Config{})
+	if origin := preflight().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("allowed origin %q without allowed origins", origin)
+	}
+
+	config := &Config{}
+	config.cors.allowedOrigins = []string{"https://ynoproject.net"}
+	config.cors.allowedMethods = []string{http.MethodGet}
+	currentConfig.Store(config)
+	if methods := preflight().Get("Access-Control-Allow-Methods"); methods != "GET" {
+		t.Errorf("allowed methods = %q, expected GET", methods)
+	}
+
+	reloadedConfig := &Config{}
+	reloadedConfig.cors.allowedOrigins = []string{"*"}
+	reloadedConfig.cors.allowedMethods = []string{http.MethodGet, http.MethodPost}
+	currentConfig.Store(reloadedConfig)
+	if methods := preflight().Get("Access-Control-Allow-Methods"); methods != "GET, POST" {
+		t.Errorf("allowed methods after reload = %q, expected GET, POST", methods)
+	}
+}
@@ -18,27 +18,65 @@
 package server
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
 	"math/rand"
+	"net/url"
 	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
 )
 
-var db *sql.DB
-
-func getDatabaseConn(user, password, addr, database string) *sql.DB {
-	conn, err := sql.Open("mysql", fmt.Sprintf("%s:%s@%s/%s?parseTime=true", user, password, addr, database))
+var db *Database
+
+func getDatabaseConn(dbType, user, password, addr, database string) *Database {
+	var dialect sqlDialect
+	var conn *sql.DB
+	var err error
+
+	switch dbType {
+	case "mysql":
+		dialect = dialectMysql
+		conn, err = sql.Open("mysql", fmt.Sprintf("%s:%s@%s/%s?parseTime=true", user, password, addr, database))
+	case "postgres":
+		dialect = dialectPostgres
+		conn, err = sql.Open("pgx", (&url.URL{Scheme: "postgres", User: url.UserPassword(user, password), Host: addr, Path: database}).String())
+	case "sqlite":
+		// database is the path of the file
+		dialect = dialectSqlite
+		conn, err = sql.Open("sqlite3", database)
+		if err == nil {
+			// SQLite only allows one writer at a time
+			conn.SetMaxOpenConns(1)
+		}
+	default:
+		err = fmt.Errorf("unsupported database type %s", dbType)
+	}
 	if err != nil {
 		panic(err)
 	}
 
-	return conn
+	// SQLite keeps its single connection
+	if dialect != dialectSqlite {
+		if getConfig().dbMaxOpenConns > 0 {
+			conn.SetMaxOpenConns(getConfig().dbMaxOpenConns)
+		}
+		if getConfig().dbMaxIdleConns > 0 {
+			conn.SetMaxIdleConns(getConfig().dbMaxIdleConns)
+		}
+	}
+	if getConfig().dbConnMaxLifetime > 0 {
+		conn.SetConnMaxLifetime(getConfig().dbConnMaxLifetime)
+	}
+
+	return &Database{DB: conn, dialect: dialect}
 }
 
 func getOrCreatePlayerData(ip string) (uuid string, banned bool, muted bool) {
@@ -62,11 +100,24 @@ func getOrCreatePlayerData(ip string) (uuid string, banned bool, muted bool) {
 }
 
 func getPlayerDataFromToken(token string) (uuid string, name string, rank int, badge string, banned bool, muted bool) {
+	if cached, ok := loadCachedTokenPlayerData(token); ok {
+		return cached.uuid, cached.name, cached.rank, cached.badge, cached.banned, cached.muted
+	}
+
 	err := db.QueryRow("SELECT a.uuid, a.user, pd.rank, a.badge, pd.banned, pd.muted FROM accounts a JOIN playerSessions ps ON ps.uuid = a.uuid JOIN players pd ON pd.uuid = a.uuid WHERE ps.sessionId = ? AND NOW() < ps.expiration", token).Scan(&uuid, &name, &rank, &badge, &banned, &muted)
 	if err != nil {
 		return "", "", 0, "", false, false
 	}
 
+	storeCachedTokenPlayerData(token, &cachedTokenPlayerData{
+		uuid:   uuid,
+		name:   name,
+		rank:   rank,
+		badge:  badge,
+		banned: banned,
+		muted:  muted,
+	})
+
 	return uuid, name, rank, badge, banned, muted
 }
 
@@ -75,11 +126,17 @@ func getPlayerRank(uuid string) (rank int) {
 		return client.rank // return rank from session if client is connected
 	}
 
+	if rank, ok := loadCachedPlayerRank(uuid); ok {
+		return rank
+	}
+
 	err := db.QueryRow("SELECT rank FROM players WHERE uuid = ?", uuid).Scan(&rank)
 	if err != nil {
 		return 0
 	}
 
+	storeCachedPlayerRank(uuid, rank)
+
 	return rank
 }
 
@@ -100,6 +157,8 @@ func banPlayerUnchecked(recipientUuid string, updateDb bool) error {
 		if err != nil {
 			return err
 		}
+
+		invalidatePlayerData(recipientUuid)
 	}
 
 	if client, ok := clients.Load(recipientUuid); ok {
@@ -112,9 +171,8 @@ func banPlayerUnchecked(recipientUuid string, updateDb bool) error {
 
 		if name != "" {
 			msg := fmt.Sprintf("*%s has been banned.*", name)
-			var sender *SessionClient
-			sender.broadcast(buildMsg("p", "0000000000000000", "YNO", "", 2, true, "null", [5]int{}))
-			sender.broadcast(buildMsg("gsay", "0000000000000000", "0000", "0000", "0", 0, 0, msg, randString(12)))
+			broadcastAll(buildMsg("p", "0000000000000000", "YNO", "", 2, true, "null", [5]int{}))
+			broadcastAll(buildMsg("gsay", "0000000000000000", "0000", "0000", "0", 0, 0, msg, randString(12)))
 		}
 	}
 
@@ -135,6 +193,8 @@ func tryUnbanPlayer(senderUuid string, recipientUuid string) error { // called b
 		return err
 	}
 
+	invalidatePlayerData(recipientUuid)
+
 	return nil
 }
 
@@ -155,6 +215,8 @@ func mutePlayerUnchecked(recipientUuid string, updateDb bool) error {
 		if err != nil {
 			return err
 		}
+
+		invalidatePlayerData(recipientUuid)
 	}
 
 	if client, ok := clients.Load(recipientUuid); ok { // mute client if they're connected
@@ -162,9 +224,8 @@ func mutePlayerUnchecked(recipientUuid string, updateDb bool) error {
 
 		if name := client.name; name != "" {
 			msg := fmt.Sprintf("*%s has been muted.*", name)
-			var sender *SessionClient
-			sender.broadcast(buildMsg("p", "0000000000000000", "YNO", "", 2, true, "null", [5]int{}))
-			sender.broadcast(buildMsg("gsay", "0000000000000000", "0000", "0000", "0", 0, 0, msg, randString(12)))
+			broadcastAll(buildMsg("p", "0000000000000000", "YNO", "", 2, true, "null", [5]int{}))
+			broadcastAll(buildMsg("gsay", "0000000000000000", "0000", "0000", "0", 0, 0, msg, randString(12)))
 		}
 	}
 
@@ -185,6 +246,8 @@ func tryUnmutePlayer(senderUuid string, recipientUuid string) error { // called
 		return err
 	}
 
+	invalidatePlayerData(recipientUuid)
+
 	if client, ok := clients.Load(recipientUuid); ok { // unmute client if they're connected
 		client.muted = false
 	}
@@ -210,6 +273,8 @@ func tryChangePlayerUsername(senderUuid string, recipientUuid string, newUsernam
 		return err
 	}
 
+	invalidatePlayerData(recipientUuid)
+
 	if client, ok := clients.Load(recipientUuid); ok { // change client username if they're connected
 		client.name = newUsername
 
@@ -226,7 +291,7 @@ func getPlayerMedals(uuid string) (medals [5]int) {
 		return client.medals // return medals from session if client is connected
 	}
 
-	err := db.QueryRow("SELECT pgd.medalCountBronze, pgd.medalCountSilver, pgd.medalCountGold, pgd.medalCountPlatinum, pgd.medalCountDiamond FROM players pd LEFT JOIN playerGameData pgd ON pgd.uuid = pd.uuid WHERE pd.uuid = ? AND pgd.game = ?", uuid, config.gameName).Scan(&medals[0], &medals[1], &medals[2], &medals[3], &medals[4])
+	err := db.QueryRow("SELECT pgd.medalCountBronze, pgd.medalCountSilver, pgd.medalCountGold, pgd.medalCountPlatinum, pgd.medalCountDiamond FROM players pd LEFT JOIN playerGameData pgd ON pgd.uuid = pd.uuid WHERE pd.uuid = ? AND pgd.game = ?", uuid, getConfig().gameName).Scan(&medals[0], &medals[1], &medals[2], &medals[3], &medals[4])
 	if err != nil {
 		return [5]int{}
 	}
@@ -269,10 +334,46 @@ func tryUnblockPlayer(uuid string, targetUuid string) error { // called by api o
 	return nil
 }
 
+// isPlayerBlocked returns whether either player has blocked the other
+func isPlayerBlocked(uuid string, targetUuid string) (blocked bool, err error) {
+	err = db.QueryRow("SELECT EXISTS (SELECT * FROM playerBlocks WHERE (uuid = ? AND targetUuid = ?) OR (uuid = ? AND targetUuid = ?))", uuid, targetUuid, targetUuid, uuid).Scan(&blocked)
+	if err != nil {
+		return false, err
+	}
+
+	return blocked, nil
+}
+
+func (c *SessionClient) cacheBlockedUsers() error {
+	results, err := db.Query("SELECT targetUuid FROM playerBlocks WHERE uuid = ?", c.uuid)
+	if err != nil {
+		return err
+	}
+
+	defer results.Close()
+
+	blockedUsers := make(map[string]bool)
+
+	for results.Next() {
+		var targetUuid string
+
+		err := results.Scan(&targetUuid)
+		if err != nil {
+			return err
+		}
+
+		blockedUsers[targetUuid] = true
+	}
+
+	c.blockedUsers = blockedUsers
+
+	return nil
+}
+
 func getBlockedPlayerData(uuid string) ([]*PlayerListData, error) {
 	var blockedPlayers []*PlayerListData
 
-	results, err := db.Query("SELECT pd.uuid, COALESCE(a.user, pgd.name), pd.rank, CASE WHEN a.user IS NULL THEN 0 ELSE 1 END, COALESCE(a.badge, ''), pgd.systemName, pgd.spriteName, pgd.spriteIndex, pgd.medalCountBronze, pgd.medalCountSilver, pgd.medalCountGold, pgd.medalCountPlatinum, pgd.medalCountDiamond FROM players pd JOIN playerBlocks pb ON pb.targetUuid = pd.uuid AND pb.uuid = ? JOIN playerGameData pgd ON pgd.uuid = pd.uuid LEFT JOIN accounts a ON a.uuid = pd.uuid WHERE pgd.game = ? ORDER BY pb.timestamp", uuid, config.gameName)
+	results, err := db.Query("SELECT pd.uuid, COALESCE(a.user, pgd.name), pd.rank, CASE WHEN a.user IS NULL THEN 0 ELSE 1 END, COALESCE(a.badge, ''), pgd.systemName, pgd.spriteName, pgd.spriteIndex, pgd.medalCountBronze, pgd.medalCountSilver, pgd.medalCountGold, pgd.medalCountPlatinum, pgd.medalCountDiamond FROM players pd JOIN playerBlocks pb ON pb.targetUuid = pd.uuid AND pb.uuid = ? JOIN playerGameData pgd ON pgd.uuid = pd.uuid LEFT JOIN accounts a ON a.uuid = pd.uuid WHERE pgd.game = ? ORDER BY pb.timestamp", uuid, getConfig().gameName)
 	if err != nil {
 		return blockedPlayers, err
 	}
@@ -303,7 +404,12 @@ func createPlayerData(ip string, uuid string, banned bool) error {
 }
 
 func getPlayerGameData(uuid string) (spriteName string, spriteIndex int, systemName string) {
-	err := db.QueryRow("SELECT pgd.spriteName, pgd.spriteIndex, pgd.systemName FROM players pd LEFT JOIN playerGameData pgd ON pgd.uuid = pd.uuid WHERE pd.uuid = ? AND pgd.game = ?", uuid, config.gameName).Scan(&spriteName, &spriteIndex, &systemName)
+	// a reconnecting player's last session may not be written yet
+	if update, ok := getPendingPlayerGameData(uuid); ok {
+		return update.sprite, update.spriteIndex, update.system
+	}
+
+	err := db.QueryRow("SELECT pgd.spriteName, pgd.spriteIndex, pgd.systemName FROM players pd LEFT JOIN playerGameData pgd ON pgd.uuid = pd.uuid WHERE pd.uuid = ? AND pgd.game = ?", uuid, getConfig().gameName).Scan(&spriteName, &spriteIndex, &systemName)
 	if err != nil {
 		return "", 0, ""
 	}
@@ -312,7 +418,68 @@ func getPlayerGameData(uuid string) (spriteName string, spriteIndex int, systemN
 }
 
 func (c *SessionClient) addOrUpdatePlayerGameData() error {
-	_, err := db.Exec("INSERT INTO playerGameData (uuid, game, online) VALUES (?, ?, 1) ON DUPLICATE KEY UPDATE online = 1, timestampLastActive = UTC_TIMESTAMP()", c.uuid, config.gameName)
+	_, err := db.ExecRetry("INSERT INTO playerGameData (uuid, game, online) VALUES (?, ?, 1) "+db.upsert("uuid, game", "online = 1, timestampLastActive = UTC_TIMESTAMP()"), c.uuid, getConfig().gameName)
+	if err != nil {
+		return err
+	}
+
+	// a disconnect from a previous session may still be waiting to be written
+	markPendingPlayerGameDataOnline(c.uuid)
+
+	return nil
+}
+
+// writePlayerPlaytime adds the length of a session to the player's total playtime for the game
+func writePlayerPlaytime(uuid string, seconds int) error {
+	_, err := db.Exec("INSERT INTO playerPlaytime (uuid, game, seconds) VALUES (?, ?, ?) "+db.upsert("uuid, game", "seconds = playerPlaytime.seconds + ?"), uuid, getConfig().gameName, seconds, seconds)
+	if err != nil {
+		return err
+	}
+
+	// playtime badges unlock at whole hours, which the cached badge data can't tell have been reached
+	invalidatePlayerBadgeData(uuid)
+
+	return nil
+}
+
+// getPlayerPlaytime returns the player's total playtime across all games in seconds
+func getPlayerPlaytime(uuid string) (seconds int, err error) {
+	err = db.Replica().QueryRow("SELECT COALESCE(SUM(seconds), 0) FROM playerPlaytime WHERE uuid = ?", uuid).Scan(&seconds)
+	if err != nil {
+		return 0, err
+	}
+
+	return seconds, nil
+}
+
+// getPlayerGamePlaytimes returns the player's playtime in seconds by game
+func getPlayerGamePlaytimes(uuid string) (playtimes map[string]int, err error) {
+	playtimes = make(map[string]int)
+
+	results, err := db.Query("SELECT game, seconds FROM playerPlaytime WHERE uuid = ?", uuid)
+	if err != nil {
+		return playtimes, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		var game string
+		var seconds int
+
+		err := results.Scan(&game, &seconds)
+		if err != nil {
+			return playtimes, err
+		}
+
+		playtimes[game] = seconds
+	}
+
+	return playtimes, nil
+}
+
+func writePlayerStatistic(uuid string, stat string, amount int) error {
+	_, err := db.Exec("INSERT INTO playerStatistics (uuid, game, stat, value) VALUES (?, ?, ?, ?) "+db.upsert("uuid, game, stat", "value = playerStatistics.value + ?"), uuid, getConfig().gameName, stat, amount, amount)
 	if err != nil {
 		return err
 	}
@@ -320,8 +487,58 @@ func (c *SessionClient) addOrUpdatePlayerGameData() error {
 	return nil
 }
 
-func (c *SessionClient) updatePlayerGameActivity(online bool) error {
-	_, err := db.Exec("UPDATE playerGameData SET name = ?, systemName = ?, spriteName = ?, spriteIndex = ?, online = ?, timestampLastActive = UTC_TIMESTAMP() WHERE uuid = ? AND game = ?", c.name, c.system, c.sprite, c.spriteIndex, online, c.uuid, config.gameName)
+// getPlayerStatistics returns the player's statistics in the given game, or summed across all games if none is given
+func getPlayerStatistics(uuid string, game string) (statistics map[string]int, err error) {
+	statistics = map[string]int{
+		statMapsVisited:     0,
+		statChatMessages:    0,
+		statPartiesJoined:   0,
+		statEventsCompleted: 0,
+	}
+
+	query := "SELECT stat, SUM(value) FROM playerStatistics WHERE uuid = ?"
+	args := []any{uuid}
+
+	if game != "" {
+		query += " AND game = ?"
+		args = append(args, game)
+	}
+
+	results, err := db.Replica().Query(query+" GROUP BY stat", args...)
+	if err != nil {
+		return statistics, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		var stat string
+		var value int
+
+		err := results.Scan(&stat, &value)
+		if err != nil {
+			return statistics, err
+		}
+
+		statistics[stat] = value
+	}
+
+	return statistics, nil
+}
+
+func writePlayerVisitedMaps(uuid string, mapIds map[int]bool) error {
+	if len(mapIds) == 0 {
+		return nil
+	}
+
+	var valuePlaceholders []string
+	var params []any
+	for mapId := range mapIds {
+		valuePlaceholders = append(valuePlaceholders, "(?, ?, ?)")
+		params = append(params, uuid, getConfig().gameName, mapId)
+	}
+
+	_, err := db.Exec("INSERT IGNORE INTO playerVisitedMaps (uuid, game, mapId) VALUES "+strings.Join(valuePlaceholders, ", "), params...)
 	if err != nil {
 		return err
 	}
@@ -329,8 +546,17 @@ func (c *SessionClient) updatePlayerGameActivity(online bool) error {
 	return nil
 }
 
+func getPlayerVisitedMapCount(uuid string, game string) (count int, err error) {
+	err = db.QueryRow("SELECT COUNT(*) FROM playerVisitedMaps WHERE uuid = ? AND game = ?", uuid, game).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
 func getPlayerInfo(ip string) (uuid string, name string, rank int) {
-	err := db.QueryRow("SELECT pd.uuid, pgd.name, pd.rank FROM players pd LEFT JOIN playerGameData pgd ON pgd.uuid = pd.uuid WHERE pd.ip = ? AND (pgd.uuid IS NULL OR pgd.game = ?)", ip, config.gameName).Scan(&uuid, &name, &rank)
+	err := db.QueryRow("SELECT pd.uuid, pgd.name, pd.rank FROM players pd LEFT JOIN playerGameData pgd ON pgd.uuid = pd.uuid WHERE pd.ip = ? AND (pgd.uuid IS NULL OR pgd.game = ?)", ip, getConfig().gameName).Scan(&uuid, &name, &rank)
 	if err != nil {
 		return "", "", 0
 	}
@@ -348,7 +574,9 @@ func getPlayerInfoFromToken(token string) (uuid string, name string, rank int, b
 }
 
 func updatePlayerActivity() error {
-	_, err := db.Exec("UPDATE accounts SET inactive = CASE WHEN timestampLoggedIn IS NULL OR timestampLoggedIn < DATE_SUB(NOW(), INTERVAL 3 MONTH) THEN 1 ELSE 0 END")
+	ctx := withoutQueryTimeout(context.Background())
+
+	_, err := db.ExecContext(ctx, "UPDATE accounts SET inactive = CASE WHEN timestampLoggedIn IS NULL OR timestampLoggedIn < DATE_SUB(NOW(), INTERVAL 3 MONTH) THEN 1 ELSE 0 END")
 	if err != nil {
 		return err
 	}
@@ -357,7 +585,7 @@ func updatePlayerActivity() error {
 }
 
 func writeGlobalChatMessage(msgId, uuid, mapId, prevMapId, prevLocations string, x, y int, contents string) error {
-	_, err := db.Exec("INSERT INTO chatMessages (msgId, game, uuid, mapId, prevMapId, prevLocations, x, y, contents) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", msgId, config.gameName, uuid, mapId, prevMapId, prevLocations, x, y, contents)
+	_, err := db.Exec("INSERT INTO chatMessages (msgId, game, uuid, mapId, prevMapId, prevLocations, x, y, contents) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", msgId, getConfig().gameName, uuid, mapId, prevMapId, prevLocations, x, y, contents)
 	if err != nil {
 		return err
 	}
@@ -376,7 +604,7 @@ func updatePlayerLastChatMessage(uuid, lastMsgId string, party bool) error {
 
 	query += " = ? WHERE uuid = ? AND game = ?"
 
-	_, err := db.Exec(query, lastMsgId, uuid, config.gameName)
+	_, err := db.Exec(query, lastMsgId, uuid, getConfig().gameName)
 	if err != nil {
 		return err
 	}
@@ -384,7 +612,7 @@ func updatePlayerLastChatMessage(uuid, lastMsgId string, party bool) error {
 	return nil
 }
 
-func getChatMessageHistory(uuid string, globalMsgLimit, partyMsgLimit int, lastMsgId string) (*ChatHistory, error) {
+func getChatMessageHistory(ctx context.Context, uuid string, globalMsgLimit, partyMsgLimit int, lastMsgId string) (*ChatHistory, error) {
 	var chatHistory ChatHistory
 
 	partyId, err := getPlayerPartyId(uuid)
@@ -411,7 +639,7 @@ func getChatMessageHistory(uuid string, globalMsgLimit, partyMsgLimit int, lastM
 
 	var messageQueryArgs []interface{}
 
-	messageQueryArgs = append(messageQueryArgs, config.gameName)
+	messageQueryArgs = append(messageQueryArgs, getConfig().gameName)
 
 	if lastMsgId != "" {
 		messageQueryArgs = append(messageQueryArgs, lastMsgId)
@@ -424,7 +652,7 @@ func getChatMessageHistory(uuid string, globalMsgLimit, partyMsgLimit int, lastM
 	if partyId == 0 {
 		query += globalSelectClause + fromClause + globalWhereClause + " LIMIT ?"
 	} else {
-		messageQueryArgs = append(messageQueryArgs, config.gameName)
+		messageQueryArgs = append(messageQueryArgs, getConfig().gameName)
 
 		if lastMsgId != "" {
 			messageQueryArgs = append(messageQueryArgs, lastMsgId)
@@ -437,7 +665,7 @@ func getChatMessageHistory(uuid string, globalMsgLimit, partyMsgLimit int, lastM
 
 	query += ") ORDER BY 9"
 
-	messageResults, err := db.Query(query, messageQueryArgs...)
+	messageResults, err := db.QueryContext(ctx, query, messageQueryArgs...)
 	if err != nil {
 		return &chatHistory, err
 	}
@@ -467,7 +695,7 @@ func getChatMessageHistory(uuid string, globalMsgLimit, partyMsgLimit int, lastM
 
 	var playerQueryArgs []interface{}
 
-	playerQueryArgs = append(playerQueryArgs, config.gameName, firstTimestamp, lastTimestamp)
+	playerQueryArgs = append(playerQueryArgs, getConfig().gameName, firstTimestamp, lastTimestamp)
 
 	if partyId == 0 {
 		playersQuery += "AND cm.partyId IS NULL"
@@ -479,7 +707,7 @@ func getChatMessageHistory(uuid string, globalMsgLimit, partyMsgLimit int, lastM
 
 	playersQuery += ")"
 
-	playerResults, err := db.Query(playersQuery, playerQueryArgs...)
+	playerResults, err := db.QueryContext(ctx, playersQuery, playerQueryArgs...)
 	if err != nil {
 		return &chatHistory, err
 	}
@@ -501,7 +729,20 @@ func getChatMessageHistory(uuid string, globalMsgLimit, partyMsgLimit int, lastM
 }
 
 func deleteOldChatMessages() error {
-	_, err := db.Exec("DELETE FROM chatMessages WHERE timestamp < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 DAY)")
+	if !isClusterLeader() {
+		return nil
+	}
+
+	ctx := withoutQueryTimeout(context.Background())
+
+	// party messages expire after a day
+	_, err := db.ExecContext(ctx, "DELETE FROM chatMessages WHERE partyId IS NOT NULL AND timestamp < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 DAY)")
+	if err != nil {
+		return err
+	}
+
+	// global messages expire after a day unless they are among the most recent of their game
+	_, err = db.ExecContext(ctx, "DELETE FROM chatMessages WHERE timestamp < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 DAY) AND msgId IN (SELECT rcm.msgId FROM (SELECT msgId, ROW_NUMBER() OVER (PARTITION BY game ORDER BY timestamp DESC) AS num FROM chatMessages WHERE partyId IS NULL) rcm WHERE rcm.num > ?)", chatHistoryGlobalMsgLimit)
 	if err != nil {
 		return err
 	}
@@ -512,18 +753,18 @@ func deleteOldChatMessages() error {
 func getGameLocationByName(locationName string) (gameLocation *GameLocation, err error) {
 	gameLocation = &GameLocation{}
 	var mapIdsJson []byte
-	err = db.QueryRow("SELECT id, game, title, mapIds FROM gameLocations WHERE title = ? AND game = ?", locationName, config.gameName).Scan(&gameLocation.Id, &gameLocation.Game, &gameLocation.Name, &mapIdsJson)
+	err = db.QueryRow("SELECT id, game, title, mapIds FROM gameLocations WHERE title = ? AND game = ?", locationName, getConfig().gameName).Scan(&gameLocation.Id, &gameLocation.Game, &gameLocation.Name, &mapIdsJson)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			var matchingEventLocation *EventLocationData
 
-			if config.gameName == "2kki" {
+			if getConfig().gameName == "2kki" {
 				matchingEventLocation, err = get2kkiEventLocationData(locationName)
 				if err != nil {
 					return gameLocation, err
 				}
 			} else {
-				for _, eventLocation := range gameEventLocations[config.gameName] {
+				for _, eventLocation := range gameEventLocations[getConfig().gameName] {
 					if eventLocation.Title == locationName {
 						matchingEventLocation = eventLocation
 						break
@@ -537,19 +778,14 @@ func getGameLocationByName(locationName string) (gameLocation *GameLocation, err
 					return gameLocation, err
 				}
 
-				res, err := db.Exec("INSERT INTO gameLocations (game, title, titleJP, depth, minDepth, mapIds) VALUES (?, ?, ?, ?, ?, ?)", config.gameName, matchingEventLocation.Title, matchingEventLocation.TitleJP, matchingEventLocation.Depth, matchingEventLocation.MinDepth, mapIdsJson)
-				if err != nil {
-					return gameLocation, err
-				}
-
-				locationId, err := res.LastInsertId()
+				locationId, err := db.ExecInsert("INSERT INTO gameLocations (game, title, titleJP, depth, minDepth, mapIds) VALUES (?, ?, ?, ?, ?, ?)", getConfig().gameName, matchingEventLocation.Title, matchingEventLocation.TitleJP, matchingEventLocation.Depth, matchingEventLocation.MinDepth, mapIdsJson)
 				if err != nil {
 					return gameLocation, err
 				}
 
 				gameLocation = &GameLocation{
 					Id:     int(locationId),
-					Game:   config.gameName,
+					Game:   getConfig().gameName,
 					Name:   matchingEventLocation.Title,
 					MapIds: matchingEventLocation.MapIds,
 				}
@@ -570,14 +806,49 @@ func getGameLocationByName(locationName string) (gameLocation *GameLocation, err
 }
 
 func writePlayerGameLocation(uuid string, locationName string) error {
-	_, err := db.Exec("INSERT IGNORE INTO playerGameLocations (uuid, locationId, timestamp) (SELECT ?, gl.id, UTC_TIMESTAMP() FROM gameLocations gl WHERE gl.title = ? AND gl.game = ? LIMIT 1)", uuid, locationName, config.gameName)
+	result, err := db.Exec("INSERT IGNORE INTO playerGameLocations (uuid, locationId, timestamp) (SELECT ?, gl.id, UTC_TIMESTAMP() FROM gameLocations gl WHERE gl.title = ? AND gl.game = ? LIMIT 1)", uuid, locationName, getConfig().gameName)
 	if err != nil {
 		return err
 	}
 
+	if rows, err := result.RowsAffected(); err == nil && rows != 0 {
+		invalidatePlayerBadgeData(uuid)
+	}
+
 	return nil
 }
 
+func writePlayerLocationHistory(uuid string, mapId string, prevMapId string, prevLocations string) error {
+	_, err := db.Exec("INSERT INTO playerLocationHistory (uuid, game, mapId, prevMapId, prevLocations, timestamp) VALUES (?, ?, ?, ?, ?, UTC_TIMESTAMP())", uuid, getConfig().gameName, mapId, prevMapId, prevLocations)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func getPlayerLocationHistory(uuid string, limit int) (locationHistory []*LocationHistoryEntry, err error) {
+	results, err := db.Query("SELECT mapId, prevMapId, prevLocations, timestamp FROM playerLocationHistory WHERE uuid = ? AND game = ? ORDER BY timestamp DESC, id DESC LIMIT ?", uuid, getConfig().gameName, limit)
+	if err != nil {
+		return locationHistory, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		entry := &LocationHistoryEntry{}
+
+		err := results.Scan(&entry.MapId, &entry.PrevMapId, &entry.PrevLocations, &entry.Timestamp)
+		if err != nil {
+			return locationHistory, err
+		}
+
+		locationHistory = append(locationHistory, entry)
+	}
+
+	return locationHistory, nil
+}
+
 func getPlayerGameLocationIds(uuid string, gameId string) (gameLocationIds []int, err error) {
 	var locationIds []int
 
@@ -621,7 +892,7 @@ func getPlayerMissingGameLocationNames(uuid string, locationNames []string) ([]s
 	}
 
 	var queryArgs []any
-	queryArgs = append(queryArgs, config.gameName)
+	queryArgs = append(queryArgs, getConfig().gameName)
 
 	for _, locationName := range locationNames {
 		queryArgs = append(queryArgs, locationName)
@@ -652,7 +923,7 @@ func getPlayerMissingGameLocationNames(uuid string, locationNames []string) ([]s
 func getPlayerAllMissingGameLocationNames(uuid string) ([]string, error) {
 	var missingGameLocationNames []string
 
-	results, err := db.Query("SELECT gl.title FROM gameLocations gl WHERE gl.game = ? AND gl.secret = 0 AND NOT EXISTS (SELECT * FROM playerGameLocations pgl WHERE pgl.uuid = ? AND pgl.locationId = gl.id)", config.gameName, uuid)
+	results, err := db.Query("SELECT gl.title FROM gameLocations gl WHERE gl.game = ? AND gl.secret = 0 AND NOT EXISTS (SELECT * FROM playerGameLocations pgl WHERE pgl.uuid = ? AND pgl.locationId = gl.id)", getConfig().gameName, uuid)
 	if err != nil {
 		return missingGameLocationNames, err
 	}
@@ -675,7 +946,7 @@ func getPlayerAllMissingGameLocationNames(uuid string) ([]string, error) {
 func setCurrentEventPeriodId() error {
 	var periodId int
 
-	err := db.QueryRow("SELECT id FROM eventPeriods WHERE UTC_DATE() >= startDate AND UTC_DATE() < endDate").Scan(&periodId)
+	err := db.QueryRow(withEventDate("SELECT id FROM eventPeriods WHERE UTC_DATE() >= startDate AND UTC_DATE() < endDate")).Scan(&periodId)
 	if err != nil {
 		currentEventPeriodId = 0
 		if err == sql.ErrNoRows {
@@ -690,7 +961,9 @@ func setCurrentEventPeriodId() error {
 }
 
 func getCurrentEventPeriodData() (eventPeriod EventPeriod, err error) {
-	err = db.QueryRow("SELECT ep.periodOrdinal, ep.endDate, gep.enableVms FROM eventPeriods ep JOIN gameEventPeriods gep ON gep.periodId = ep.id AND gep.game = ? WHERE UTC_DATE() >= ep.startDate AND UTC_DATE() < ep.endDate", config.gameName).Scan(&eventPeriod.PeriodOrdinal, &eventPeriod.EndDate, &eventPeriod.EnableVms)
+	var weeklyExpCap sql.NullInt64
+
+	err = db.QueryRow(withEventDate("SELECT ep.periodOrdinal, ep.endDate, ep.weeklyExpCap, gep.enableVms FROM eventPeriods ep JOIN gameEventPeriods gep ON gep.periodId = ep.id AND gep.game = ? WHERE UTC_DATE() >= ep.startDate AND UTC_DATE() < ep.endDate"), getConfig().gameName).Scan(&eventPeriod.PeriodOrdinal, &eventPeriod.EndDate, &weeklyExpCap, &eventPeriod.EnableVms)
 	if err != nil {
 		eventPeriod.PeriodOrdinal = -1
 		if err == sql.ErrNoRows {
@@ -699,13 +972,34 @@ func getCurrentEventPeriodData() (eventPeriod EventPeriod, err error) {
 		return eventPeriod, err
 	}
 
+	if weeklyExpCap.Valid {
+		eventPeriod.WeeklyExpCap = int(weeklyExpCap.Int64)
+	} else {
+		eventPeriod.WeeklyExpCap = getConfig().weeklyExpCap
+	}
+
+	eventPeriod.NextRollover, eventPeriod.NextWeeklyRollover = getNextEventRollovers()
+
 	return eventPeriod, nil
 }
 
+// getGameCurrentEventPeriodId returns the id of the game's current event period, or 0 if it has none
+func getGameCurrentEventPeriodId(gameId string) (gameEventPeriodId int, err error) {
+	err = db.QueryRow(withEventDate("SELECT gep.id FROM eventPeriods ep JOIN gameEventPeriods gep ON gep.periodId = ep.id WHERE gep.game = ? AND UTC_DATE() >= ep.startDate AND UTC_DATE() < ep.endDate"), gameId).Scan(&gameEventPeriodId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return gameEventPeriodId, nil
+}
+
 func getGameCurrentEventPeriodsData() (gameEventPeriods map[string]*EventPeriod, err error) {
 	gameEventPeriods = make(map[string]*EventPeriod)
 
-	results, err := db.Query("SELECT gep.id, ep.periodOrdinal, ep.endDate, gep.enableVms, gep.game FROM eventPeriods ep JOIN gameEventPeriods gep ON gep.periodId = ep.id WHERE UTC_DATE() >= ep.startDate AND UTC_DATE() < ep.endDate")
+	results, err := db.Query(withEventDate("SELECT gep.id, ep.periodOrdinal, ep.endDate, gep.enableVms, gep.game FROM eventPeriods ep JOIN gameEventPeriods gep ON gep.periodId = ep.id WHERE UTC_DATE() >= ep.startDate AND UTC_DATE() < ep.endDate"))
 	if err != nil {
 		return gameEventPeriods, err
 	}
@@ -724,27 +1018,235 @@ func getGameCurrentEventPeriodsData() (gameEventPeriods map[string]*EventPeriod,
 		gameEventPeriods[gameId] = &eventPeriod
 	}
 
-	return gameEventPeriods, nil
-}
+	return gameEventPeriods, nil
+}
+
+func setCurrentGameEventPeriodId() error {
+	var gamePeriodId int
+
+	err := db.QueryRow("SELECT id FROM gameEventPeriods WHERE game = ? AND periodId = ?", getConfig().gameName, currentEventPeriodId).Scan(&gamePeriodId)
+	if err != nil {
+		currentGameEventPeriodId = 0
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	currentGameEventPeriodId = gamePeriodId
+
+	return nil
+}
+
+func getGameEventPeriods(gameId string) (eventPeriods []*GameEventPeriod, err error) {
+	results, err := db.Query("SELECT ep.id, gep.game, ep.periodOrdinal, ep.startDate, ep.endDate, ep.weeklyExpCap, gep.enableVms FROM eventPeriods ep JOIN gameEventPeriods gep ON gep.periodId = ep.id WHERE gep.game = ? ORDER BY ep.startDate", gameId)
+	if err != nil {
+		return eventPeriods, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		eventPeriod := &GameEventPeriod{}
+
+		var weeklyExpCap sql.NullInt64
+
+		err = results.Scan(&eventPeriod.PeriodId, &eventPeriod.Game, &eventPeriod.PeriodOrdinal, &eventPeriod.StartDate, &eventPeriod.EndDate, &weeklyExpCap, &eventPeriod.EnableVms)
+		if err != nil {
+			return eventPeriods, err
+		}
+
+		if weeklyExpCap.Valid {
+			eventPeriod.WeeklyExpCap = new(int)
+			*eventPeriod.WeeklyExpCap = int(weeklyExpCap.Int64)
+		}
+
+		eventPeriods = append(eventPeriods, eventPeriod)
+	}
+
+	return eventPeriods, nil
+}
+
+// isEventPeriodOverlapping reports whether any game linked to periodId (or gameId,
+// for a period that is not linked yet) already has another period within the date range.
+func isEventPeriodOverlapping(tx *Tx, periodId int, gameId string, startDate time.Time, endDate time.Time) (overlapping bool, err error) {
+	err = tx.QueryRow("SELECT EXISTS (SELECT * FROM eventPeriods ep JOIN gameEventPeriods gep ON gep.periodId = ep.id WHERE ep.id <> ? AND (gep.game = ? OR gep.game IN (SELECT game FROM gameEventPeriods WHERE periodId = ?)) AND ep.startDate < ? AND ep.endDate > ?)", periodId, gameId, periodId, endDate, startDate).Scan(&overlapping)
+	if err != nil {
+		return false, err
+	}
+
+	return overlapping, nil
+}
+
+func writeGameEventPeriod(gameId string, periodOrdinal int, startDate time.Time, endDate time.Time, weeklyExpCap *int, enableVms bool) (periodId int, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	// periods are shared between games, so reuse the existing one if the ordinal matches
+	var existingStartDate, existingEndDate time.Time
+	err = tx.QueryRow("SELECT id, startDate, endDate FROM eventPeriods WHERE periodOrdinal = ?", periodOrdinal).Scan(&periodId, &existingStartDate, &existingEndDate)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			return 0, err
+		}
+
+		overlapping, err := isEventPeriodOverlapping(tx, 0, gameId, startDate, endDate)
+		if err != nil {
+			return 0, err
+		}
+		if overlapping {
+			return 0, errors.New("event period overlaps with an existing period")
+		}
+
+		lastInsertId, err := tx.ExecInsert("INSERT INTO eventPeriods (periodOrdinal, startDate, endDate, weeklyExpCap) VALUES (?, ?, ?, ?)", periodOrdinal, startDate, endDate, weeklyExpCap)
+		if err != nil {
+			return 0, err
+		}
+
+		periodId = int(lastInsertId)
+	} else {
+		if !existingStartDate.Equal(startDate) || !existingEndDate.Equal(endDate) {
+			return 0, errors.New("period ordinal already in use with different dates")
+		}
+
+		overlapping, err := isEventPeriodOverlapping(tx, periodId, gameId, startDate, endDate)
+		if err != nil {
+			return 0, err
+		}
+		if overlapping {
+			return 0, errors.New("event period overlaps with an existing period")
+		}
+	}
+
+	_, err = tx.Exec("INSERT INTO gameEventPeriods (game, periodId, enableVms) VALUES (?, ?, ?)", gameId, periodId, enableVms)
+	if err != nil {
+		return 0, err
+	}
+
+	return periodId, tx.Commit()
+}
+
+func updateGameEventPeriod(periodId int, gameId string, periodOrdinal int, startDate time.Time, endDate time.Time, weeklyExpCap *int, enableVms bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	overlapping, err := isEventPeriodOverlapping(tx, periodId, gameId, startDate, endDate)
+	if err != nil {
+		return err
+	}
+	if overlapping {
+		return errors.New("event period overlaps with an existing period")
+	}
+
+	_, err = tx.Exec("UPDATE eventPeriods SET periodOrdinal = ?, startDate = ?, endDate = ?, weeklyExpCap = ? WHERE id = ?", periodOrdinal, startDate, endDate, weeklyExpCap, periodId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE gameEventPeriods SET enableVms = ? WHERE periodId = ? AND game = ?", enableVms, periodId, gameId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// writeNextEventPeriod opens a period of the given length following the latest one if it has ended,
+// carrying over its games and settings, and returns the id of the ended period or 0 if none was opened
+func writeNextEventPeriod(lengthDays int) (endedPeriodId int, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	var lastPeriodId, lastPeriodOrdinal int
+	var lastPeriodEnded bool
+	var weeklyExpCap sql.NullInt64
+
+	err = tx.QueryRow(withEventDate("SELECT id, periodOrdinal, endDate <= UTC_DATE(), weeklyExpCap FROM eventPeriods ORDER BY endDate DESC LIMIT 1 FOR UPDATE")).Scan(&lastPeriodId, &lastPeriodOrdinal, &lastPeriodEnded, &weeklyExpCap)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// the first period has to be created manually
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	if !lastPeriodEnded {
+		return 0, nil
+	}
+
+	periodId, err := tx.ExecInsert(withEventDate("INSERT INTO eventPeriods (periodOrdinal, startDate, endDate, weeklyExpCap) VALUES (?, UTC_DATE(), DATE_ADD(UTC_DATE(), INTERVAL ? DAY), ?)"), lastPeriodOrdinal+1, lengthDays, weeklyExpCap)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("INSERT INTO gameEventPeriods (game, periodId, enableVms) SELECT game, ?, enableVms FROM gameEventPeriods WHERE periodId = ?", periodId, lastPeriodId)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return lastPeriodId, nil
+}
+
+// closeEventPeriod ends a period today, returning the end date it's left with
+func closeEventPeriod(periodId int) (endDate time.Time, err error) {
+	// a period that hasn't started yet ends on its start date, so it never ends before it starts
+	_, err = db.Exec(withEventDate("UPDATE eventPeriods SET endDate = CASE WHEN startDate > UTC_DATE() THEN startDate ELSE UTC_DATE() END WHERE id = ? AND endDate > UTC_DATE()"), periodId)
+	if err != nil {
+		return endDate, err
+	}
+
+	err = db.QueryRow("SELECT endDate FROM eventPeriods WHERE id = ?", periodId).Scan(&endDate)
+	if err != nil {
+		return endDate, err
+	}
+
+	return endDate, nil
+}
+
+// getFallbackEventLocations picks a random previously stored location of the game within the depth range.
+// A max depth lower than the min depth leaves the range unbounded.
+func getFallbackEventLocations(gameId string, minDepth int, maxDepth int) (eventLocations []*EventLocationData, err error) {
+	if maxDepth < minDepth {
+		maxDepth = math.MaxInt32
+	}
+
+	eventLocation := &EventLocationData{}
 
-func setCurrentGameEventPeriodId() error {
-	var gamePeriodId int
+	var mapIdsJson string
 
-	err := db.QueryRow("SELECT id FROM gameEventPeriods WHERE game = ? AND periodId = ?", config.gameName, currentEventPeriodId).Scan(&gamePeriodId)
+	err = db.QueryRow("SELECT title, titleJP, depth, minDepth, mapIds FROM gameLocations WHERE game = ? AND depth BETWEEN ? AND ? ORDER BY RAND() LIMIT 1", gameId, minDepth, maxDepth).Scan(&eventLocation.Title, &eventLocation.TitleJP, &eventLocation.Depth, &eventLocation.MinDepth, &mapIdsJson)
 	if err != nil {
-		currentGameEventPeriodId = 0
-		if err == sql.ErrNoRows {
-			return nil
-		}
-		return err
+		return eventLocations, err
 	}
 
-	currentGameEventPeriodId = gamePeriodId
+	err = json.Unmarshal([]byte(mapIdsJson), &eventLocation.MapIds)
+	if err != nil {
+		return eventLocations, err
+	}
 
-	return nil
+	eventLocations = append(eventLocations, eventLocation)
+
+	return eventLocations, nil
 }
 
-func getRandomGameForEventLocation(pool map[string][]*EventLocationData, eventLocationCountThreshold int) (gameId string, err error) {
+func getRandomGameForEventLocation(tier int, eventLocationCountThreshold int) (gameId string, err error) {
 	results, err := db.Query("SELECT CEIL(AVG(gpc.playerCount)), gpc.game FROM gamePlayerCounts gpc JOIN gameEventPeriods gep ON gep.periodId = ? AND gep.game = gpc.game GROUP BY gpc.game", currentEventPeriodId)
 	if err != nil {
 		return "", err
@@ -766,7 +1268,7 @@ func getRandomGameForEventLocation(pool map[string][]*EventLocationData, eventLo
 		}
 
 		// Ignore games with no event locations in the current pool
-		if eventLocations, ok := pool[currentGameId]; currentGameId != "2kki" && (!ok || len(eventLocations) < eventLocationCountThreshold) {
+		if getEventLocationProvider(currentGameId).getEventLocationCount(tier) < eventLocationCountThreshold {
 			continue
 		}
 
@@ -832,6 +1334,14 @@ func getPlayerEventExpData(playerUuid string) (eventExp EventExp, err error) {
 
 	eventExp.WeekExp = weekEventExp
 
+	currentStreak, bestStreak, err := getPlayerEventStreaks(playerUuid)
+	if err != nil {
+		return eventExp, err
+	}
+
+	eventExp.CurrentStreak = currentStreak
+	eventExp.BestStreak = bestStreak
+
 	return eventExp, nil
 }
 
@@ -854,9 +1364,9 @@ func getPlayerPeriodEventExp(playerUuid string) (periodEventExp int, err error)
 }
 
 func getPlayerWeekEventExp(playerUuid string) (weekEventExp int, err error) {
-	weekdayIndex := int(time.Now().UTC().Weekday())
+	weekdayIndex := int(getEventTime().Weekday())
 
-	err = db.QueryRow("SELECT SUM(exp) FROM ((SELECT COALESCE(SUM(ec.exp), 0) exp FROM eventCompletions ec JOIN eventLocations el ON el.id = ec.eventId AND ec.type = 0 JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId WHERE ep.id = ? AND ec.uuid = ? AND DATE_SUB(UTC_DATE(), INTERVAL ? DAY) <= el.startDate AND DATE_ADD(UTC_DATE(), INTERVAL ? DAY) >= el.endDate) UNION ALL (SELECT COALESCE(SUM(ec.exp), 0) exp FROM eventCompletions ec JOIN eventVms ev ON ev.id = ec.eventId AND ec.type = 2 JOIN gameEventPeriods gep ON gep.id = ev.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId WHERE ep.id = ? AND ec.uuid = ? AND DATE_SUB(UTC_DATE(), INTERVAL ? DAY) <= ev.startDate AND DATE_ADD(UTC_DATE(), INTERVAL ? DAY) >= ev.endDate)) eventExp", currentEventPeriodId, playerUuid, weekdayIndex, 7-weekdayIndex, currentEventPeriodId, playerUuid, weekdayIndex, 7-weekdayIndex).Scan(&weekEventExp)
+	err = db.QueryRow(withEventDate("SELECT SUM(exp) FROM ((SELECT COALESCE(SUM(ec.exp), 0) exp FROM eventCompletions ec JOIN eventLocations el ON el.id = ec.eventId AND ec.type = 0 JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId WHERE ep.id = ? AND ec.uuid = ? AND DATE_SUB(UTC_DATE(), INTERVAL ? DAY) <= el.startDate AND DATE_ADD(UTC_DATE(), INTERVAL ? DAY) >= el.endDate) UNION ALL (SELECT COALESCE(SUM(ec.exp), 0) exp FROM eventCompletions ec JOIN eventVms ev ON ev.id = ec.eventId AND ec.type = 2 JOIN gameEventPeriods gep ON gep.id = ev.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId WHERE ep.id = ? AND ec.uuid = ? AND DATE_SUB(UTC_DATE(), INTERVAL ? DAY) <= ev.startDate AND DATE_ADD(UTC_DATE(), INTERVAL ? DAY) >= ev.endDate)) eventExp"), currentEventPeriodId, playerUuid, weekdayIndex, 7-weekdayIndex, currentEventPeriodId, playerUuid, weekdayIndex, 7-weekdayIndex).Scan(&weekEventExp)
 	if err != nil {
 		return weekEventExp, err
 	}
@@ -864,6 +1374,120 @@ func getPlayerWeekEventExp(playerUuid string) (weekEventExp int, err error) {
 	return weekEventExp, nil
 }
 
+func getPlayerEventHistory(ctx context.Context, playerUuid string, limit int, offset int) (eventHistory EventHistory, err error) {
+	eventHistory.Entries = []*EventHistoryEntry{}
+
+	err = db.Replica().QueryRowContext(ctx, "SELECT COUNT(*) FROM eventCompletions WHERE uuid = ?", playerUuid).Scan(&eventHistory.TotalCount)
+	if err != nil {
+		return eventHistory, err
+	}
+
+	results, err := db.Replica().QueryContext(ctx, "(SELECT ec.eventId, ec.type, gep.game, l.title, l.titleJP, ec.exp, ec.timestampCompleted FROM eventCompletions ec JOIN eventLocations el ON el.id = ec.eventId AND ec.type = 0 JOIN gameLocations l ON l.id = el.locationId JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId WHERE ec.uuid = ?) UNION ALL (SELECT ec.eventId, ec.type, gep.game, l.title, l.titleJP, ec.exp, ec.timestampCompleted FROM eventCompletions ec JOIN playerEventLocations pel ON pel.id = ec.eventId AND ec.type = 1 JOIN gameLocations l ON l.id = pel.locationId JOIN gameEventPeriods gep ON gep.id = pel.gamePeriodId WHERE ec.uuid = ?) UNION ALL (SELECT ec.eventId, ec.type, gep.game, '', '', ec.exp, ec.timestampCompleted FROM eventCompletions ec JOIN eventVms ev ON ev.id = ec.eventId AND ec.type = 2 JOIN gameEventPeriods gep ON gep.id = ev.gamePeriodId WHERE ec.uuid = ?) ORDER BY 7 DESC LIMIT ? OFFSET ?", playerUuid, playerUuid, playerUuid, limit, offset)
+	if err != nil {
+		return eventHistory, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		entry := &EventHistoryEntry{}
+
+		err = results.Scan(&entry.EventId, &entry.Type, &entry.Game, &entry.Title, &entry.TitleJP, &entry.Exp, &entry.DateCompleted)
+		if err != nil {
+			return eventHistory, err
+		}
+
+		eventHistory.Entries = append(eventHistory.Entries, entry)
+	}
+
+	return eventHistory, nil
+}
+
+// getPlayerEventStreaks returns the player's current and best count of consecutive days with at least one completed event location
+func getPlayerEventStreaks(playerUuid string) (currentStreak int, bestStreak int, err error) {
+	results, err := db.Query("SELECT DISTINCT DATE(DATE_SUB(timestampCompleted, INTERVAL ? MINUTE)) FROM eventCompletions WHERE uuid = ? AND type < 2 ORDER BY 1", int(getConfig().eventRolloverOffset.Minutes()), playerUuid)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer results.Close()
+
+	var lastDate time.Time
+
+	for results.Next() {
+		var date time.Time
+
+		err = results.Scan(&date)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if !lastDate.IsZero() && date.Sub(lastDate) == 24*time.Hour {
+			currentStreak++
+		} else {
+			currentStreak = 1
+		}
+
+		if currentStreak > bestStreak {
+			bestStreak = currentStreak
+		}
+
+		lastDate = date
+	}
+
+	// the current streak is kept until a full day passes without a completion
+	if today := getEventTime().Truncate(24 * time.Hour); today.Sub(lastDate) > 24*time.Hour {
+		currentStreak = 0
+	}
+
+	return currentStreak, bestStreak, nil
+}
+
+func getEventLeaderboard(eventType int, eventId int, limit int) (entries []*EventLeaderboardEntry, err error) {
+	entries = []*EventLeaderboardEntry{}
+
+	results, err := db.Query("SELECT ec.uuid, a.user, ec.timestampCompleted FROM eventCompletions ec JOIN accounts a ON a.uuid = ec.uuid JOIN players pd ON pd.uuid = ec.uuid WHERE ec.eventId = ? AND ec.type = ? AND pd.banned = 0 ORDER BY ec.timestampCompleted LIMIT ?", eventId, eventType, limit)
+	if err != nil {
+		return entries, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		entry := &EventLeaderboardEntry{}
+
+		err = results.Scan(&entry.Uuid, &entry.Name, &entry.DateCompleted)
+		if err != nil {
+			return entries, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func getPlayerFreeEventLocationCount(playerUuid string) (count int, err error) {
+	err = db.QueryRow(withEventDate("SELECT COUNT(*) FROM playerEventLocations pel JOIN gameEventPeriods gep ON gep.id = pel.gamePeriodId WHERE pel.uuid = ? AND gep.game = ? AND pel.startDate = UTC_DATE()"), playerUuid, getConfig().gameName).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func getPlayerLastFreeEventLocationCompletion(playerUuid string) (lastCompleted time.Time, err error) {
+	err = db.QueryRow("SELECT ec.timestampCompleted FROM eventCompletions ec JOIN playerEventLocations pel ON pel.id = ec.eventId JOIN gameEventPeriods gep ON gep.id = pel.gamePeriodId WHERE ec.uuid = ? AND ec.type = 1 AND gep.game = ? ORDER BY ec.timestampCompleted DESC LIMIT 1", playerUuid, getConfig().gameName).Scan(&lastCompleted)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return lastCompleted, nil
+		}
+		return lastCompleted, err
+	}
+
+	return lastCompleted, nil
+}
+
 func getPlayerEventLocationCount(playerUuid string) (eventLocationCount int, err error) {
 	err = db.QueryRow("SELECT COUNT(eventId) FROM eventCompletions WHERE uuid = ? AND type < 2", playerUuid).Scan(&eventLocationCount)
 	if err != nil {
@@ -875,7 +1499,7 @@ func getPlayerEventLocationCount(playerUuid string) (eventLocationCount int, err
 
 func getPlayerEventLocationCompletion(playerUuid string) (eventLocationCompletion int, err error) {
 	// Relies on rankings but is much faster than calculating directly
-	err = db.QueryRow("SELECT FLOOR(valueFloat * 100) FROM rankingEntries WHERE uuid = ? AND categoryId = 'eventLocationCompletion' AND subCategoryId = 'all'", playerUuid).Scan(&eventLocationCompletion)
+	err = db.Replica().QueryRow("SELECT FLOOR(valueFloat * 100) FROM rankingEntries WHERE uuid = ? AND categoryId = 'eventLocationCompletion' AND subCategoryId = 'all'", playerUuid).Scan(&eventLocationCompletion)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return 0, nil
@@ -901,7 +1525,7 @@ func getOrWriteLocationIdForEventLocation(gameId string, gameEventPeriodId int,
 		return locationId, err
 	}
 
-	_, err = db.Exec("INSERT INTO gameLocations (game, title, titleJP, depth, minDepth, mapIds) VALUES (?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE titleJP = ?, depth = ?, minDepth = ?, mapIds = ?", gameId, title, titleJP, depth, minDepth, mapIdsJson, titleJP, depth, minDepth, mapIdsJson)
+	_, err = db.Exec("INSERT INTO gameLocations (game, title, titleJP, depth, minDepth, mapIds) VALUES (?, ?, ?, ?, ?, ?) "+db.upsert("game, title", "titleJP = ?, depth = ?, minDepth = ?, mapIds = ?"), gameId, title, titleJP, depth, minDepth, mapIdsJson, titleJP, depth, minDepth, mapIdsJson)
 	if err != nil {
 		return locationId, err
 	}
@@ -913,14 +1537,14 @@ func getOrWriteLocationIdForEventLocation(gameId string, gameEventPeriodId int,
 
 func getOrWriteLocationIdForPlayerEventLocation(gameId string, gameEventPeriodId int, playerUuid string, title string, titleJP string, depth int, minDepth int, mapIds []string) (locationId int, err error) {
 	var playerEventLocationQueueLength int
-	db.QueryRow("SELECT COUNT(*) FROM playerEventLocationQueue WHERE game = ? AND date = UTC_DATE()", gameId).Scan(&playerEventLocationQueueLength)
+	db.QueryRow(withEventDate("SELECT COUNT(*) FROM playerEventLocationQueue WHERE game = ? AND date = UTC_DATE()"), gameId).Scan(&playerEventLocationQueueLength)
 
 	if playerEventLocationQueueLength > 0 {
 		var currentPlayerEventLocationQueueLength int
-		db.QueryRow("SELECT COUNT(*) FROM eventCompletions ec JOIN playerEventLocations pel ON pel.id = ec.eventId AND ec.type = 1 WHERE pel.gamePeriodId = ? AND pel.startDate = UTC_DATE() AND pel.uuid = ?", gameEventPeriodId, playerUuid).Scan(&currentPlayerEventLocationQueueLength)
+		db.QueryRow(withEventDate("SELECT COUNT(*) FROM eventCompletions ec JOIN playerEventLocations pel ON pel.id = ec.eventId AND ec.type = 1 WHERE pel.gamePeriodId = ? AND pel.startDate = UTC_DATE() AND pel.uuid = ?"), gameEventPeriodId, playerUuid).Scan(&currentPlayerEventLocationQueueLength)
 
 		if currentPlayerEventLocationQueueLength < playerEventLocationQueueLength {
-			db.QueryRow("SELECT locationId FROM playerEventLocationQueue WHERE game = ? AND date = UTC_DATE() AND queueIndex = ?", gameId, currentPlayerEventLocationQueueLength+1).Scan(&locationId)
+			db.QueryRow(withEventDate("SELECT locationId FROM playerEventLocationQueue WHERE game = ? AND date = UTC_DATE() AND queueIndex = ?"), gameId, currentPlayerEventLocationQueueLength+1).Scan(&locationId)
 
 			return locationId, nil
 		}
@@ -931,7 +1555,7 @@ func getOrWriteLocationIdForPlayerEventLocation(gameId string, gameEventPeriodId
 		return locationId, err
 	}
 
-	_, err = db.Exec("INSERT INTO playerEventLocationQueue (game, date, queueIndex, locationId) VALUES (?, UTC_DATE(), ?, ?)", gameId, playerEventLocationQueueLength+1, locationId)
+	_, err = db.Exec(withEventDate("INSERT INTO playerEventLocationQueue (game, date, queueIndex, locationId) VALUES (?, UTC_DATE(), ?, ?)"), gameId, playerEventLocationQueueLength+1, locationId)
 	if err != nil {
 		return locationId, err
 	}
@@ -942,7 +1566,7 @@ func getOrWriteLocationIdForPlayerEventLocation(gameId string, gameEventPeriodId
 func writeEventLocationData(gameId string, gameEventPeriodId int, eventType int, title string, titleJP string, depth int, minDepth int, exp int, mapIds []string) error {
 	var days int
 	var offsetDays int
-	weekday := time.Now().UTC().Weekday()
+	weekday := getEventTime().Weekday()
 	switch eventType {
 	case 0:
 		days = 1
@@ -960,12 +1584,16 @@ func writeEventLocationData(gameId string, gameEventPeriodId int, eventType int,
 
 	days -= offsetDays
 
+	return writeEventLocationDataForDays(gameId, gameEventPeriodId, eventType, title, titleJP, depth, minDepth, exp, mapIds, offsetDays, days)
+}
+
+func writeEventLocationDataForDays(gameId string, gameEventPeriodId int, eventType int, title string, titleJP string, depth int, minDepth int, exp int, mapIds []string, offsetDays int, days int) error {
 	locationId, err := getOrWriteLocationIdForEventLocation(gameId, gameEventPeriodId, title, titleJP, depth, minDepth, mapIds)
 	if err != nil {
 		return err
 	}
 
-	_, err = db.Exec("INSERT INTO eventLocations (locationId, gamePeriodId, type, exp, startDate, endDate) VALUES (?, ?, ?, ?, DATE_SUB(UTC_DATE(), INTERVAL ? DAY), DATE_ADD(UTC_DATE(), INTERVAL ? DAY))", locationId, gameEventPeriodId, eventType, exp, offsetDays, days)
+	_, err = db.Exec(withEventDate("INSERT INTO eventLocations (locationId, gamePeriodId, type, exp, startDate, endDate) VALUES (?, ?, ?, ?, DATE_SUB(UTC_DATE(), INTERVAL ? DAY), DATE_ADD(UTC_DATE(), INTERVAL ? DAY))"), locationId, gameEventPeriodId, eventType, exp, offsetDays, days)
 	if err != nil {
 		return err
 	}
@@ -979,7 +1607,7 @@ func writePlayerEventLocationData(gameId string, gameEventPeriodId int, playerUu
 		return err
 	}
 
-	_, err = db.Exec("INSERT INTO playerEventLocations (locationId, gamePeriodId, uuid, startDate, endDate) SELECT ?, ?, ?, UTC_DATE(), DATE_ADD(UTC_DATE(), INTERVAL 1 DAY) WHERE NOT EXISTS(SELECT * FROM playerEventLocations pel LEFT JOIN eventCompletions ec ON ec.eventId = pel.id AND ec.type = 1 AND ec.uuid = pel.uuid WHERE pel.uuid = ? AND pel.gamePeriodId = ? AND ec.uuid IS NULL AND UTC_DATE() >= pel.startDate AND UTC_DATE() < pel.endDate)", locationId, gameEventPeriodId, playerUuid, playerUuid, gameEventPeriodId)
+	_, err = db.Exec(withEventDate("INSERT INTO playerEventLocations (locationId, gamePeriodId, uuid, startDate, endDate) SELECT ?, ?, ?, UTC_DATE(), DATE_ADD(UTC_DATE(), INTERVAL 1 DAY) WHERE NOT EXISTS(SELECT * FROM playerEventLocations pel LEFT JOIN eventCompletions ec ON ec.eventId = pel.id AND ec.type = 1 AND ec.uuid = pel.uuid WHERE pel.uuid = ? AND pel.gamePeriodId = ? AND ec.uuid IS NULL AND UTC_DATE() >= pel.startDate AND UTC_DATE() < pel.endDate)"), locationId, gameEventPeriodId, playerUuid, playerUuid, gameEventPeriodId)
 	if err != nil {
 		return err
 	}
@@ -988,7 +1616,7 @@ func writePlayerEventLocationData(gameId string, gameEventPeriodId int, playerUu
 }
 
 func getCurrentPlayerEventLocationsData(playerUuid string) (eventLocations []*EventLocation, err error) {
-	results, err := db.Query("SELECT el.id, el.type, gep.game, l.id, l.title, l.titleJP, l.depth, l.minDepth, el.exp, el.endDate, CASE WHEN ec.uuid IS NOT NULL THEN 1 ELSE 0 END FROM eventLocations el JOIN gameLocations l ON l.id = el.locationId JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId LEFT JOIN eventCompletions ec ON ec.eventId = el.id AND ec.type = 0 AND ec.uuid = ? WHERE gep.periodId = ? AND UTC_DATE() >= el.startDate AND UTC_DATE() < el.endDate ORDER BY 2, 1", playerUuid, currentEventPeriodId)
+	results, err := db.Query(withEventDate("SELECT el.id, el.type, gep.game, l.id, l.title, l.titleJP, l.depth, l.minDepth, el.exp, el.endDate, CASE WHEN ec.uuid IS NOT NULL THEN 1 ELSE 0 END FROM eventLocations el JOIN gameLocations l ON l.id = el.locationId JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId LEFT JOIN eventCompletions ec ON ec.eventId = el.id AND ec.type = 0 AND ec.uuid = ? WHERE gep.periodId = ? AND UTC_DATE() >= el.startDate AND UTC_DATE() < el.endDate ORDER BY 2, 1"), playerUuid, currentEventPeriodId)
 	if err != nil {
 		return eventLocations, err
 	}
@@ -1016,7 +1644,7 @@ func getCurrentPlayerEventLocationsData(playerUuid string) (eventLocations []*Ev
 		eventLocations = append(eventLocations, &eventLocation)
 	}
 
-	results, err = db.Query("SELECT pel.id, gep.game, pl.id, pl.title, pl.titleJP, pl.depth, pl.minDepth, pel.endDate FROM playerEventLocations pel JOIN gameLocations pl ON pl.id = pel.locationId JOIN gameEventPeriods gep ON gep.id = pel.gamePeriodId LEFT JOIN eventCompletions ec ON ec.eventId = pel.id AND ec.type = 1 AND ec.uuid = pel.uuid WHERE pel.uuid = ? AND gep.periodId = ? AND gep.game = ? AND ec.uuid IS NULL AND UTC_DATE() >= pel.startDate AND UTC_DATE() < pel.endDate ORDER BY 1", playerUuid, currentEventPeriodId, config.gameName)
+	results, err = db.Query(withEventDate("SELECT pel.id, gep.game, pl.id, pl.title, pl.titleJP, pl.depth, pl.minDepth, pel.endDate FROM playerEventLocations pel JOIN gameLocations pl ON pl.id = pel.locationId JOIN gameEventPeriods gep ON gep.id = pel.gamePeriodId LEFT JOIN eventCompletions ec ON ec.eventId = pel.id AND ec.type = 1 AND ec.uuid = pel.uuid WHERE pel.uuid = ? AND gep.periodId = ? AND gep.game = ? AND ec.uuid IS NULL AND UTC_DATE() >= pel.startDate AND UTC_DATE() < pel.endDate ORDER BY 1"), playerUuid, currentEventPeriodId, getConfig().gameName)
 	if err != nil {
 		return eventLocations, err
 	}
@@ -1052,7 +1680,7 @@ func tryCompleteEventLocation(playerUuid string, location string) (exp int, err
 		// prevent race condition
 		clientMapId := client.roomC.mapId
 
-		results, err := db.Query("SELECT el.id, el.type, el.exp, l.mapIds FROM eventLocations el JOIN gameLocations l ON l.id = el.locationId WHERE el.gamePeriodId = ? AND l.title = ? AND UTC_DATE() >= el.startDate AND UTC_DATE() < el.endDate ORDER BY 2", currentGameEventPeriodId, location)
+		results, err := db.Query(withEventDate("SELECT el.id, el.type, el.exp, l.mapIds FROM eventLocations el JOIN gameLocations l ON l.id = el.locationId WHERE el.gamePeriodId = ? AND l.title = ? AND UTC_DATE() >= el.startDate AND UTC_DATE() < el.endDate ORDER BY 2"), currentGameEventPeriodId, location)
 		if err != nil {
 			return -1, err
 		}
@@ -1064,6 +1692,16 @@ func tryCompleteEventLocation(playerUuid string, location string) (exp int, err
 			return -1, err
 		}
 
+		expMultiplier, err := getCurrentEventExpMultiplier()
+		if err != nil {
+			return -1, err
+		}
+
+		weeklyExpCap, err := getCurrentWeeklyExpCap()
+		if err != nil {
+			return -1, err
+		}
+
 		for results.Next() {
 			var eventId string
 			var eventType int
@@ -1085,17 +1723,15 @@ func tryCompleteEventLocation(playerUuid string, location string) (exp int, err
 				if clientMapId != mapId {
 					continue
 				}
-				if weekEventExp >= weeklyExpCap {
-					eventExp = 0
-				} else if weekEventExp+eventExp > weeklyExpCap {
-					eventExp = weeklyExpCap - weekEventExp
-				}
+				eventExp = applyWeeklyExpCap(applyEventExpMultiplier(eventExp, expMultiplier), weekEventExp, weeklyExpCap)
 
-				_, err = db.Exec("INSERT INTO eventCompletions (eventId, uuid, type, timestampCompleted, exp) VALUES (?, ?, 0, ?, ?)", eventId, playerUuid, time.Now(), eventExp)
+				_, err = db.ExecRetry("INSERT INTO eventCompletions (eventId, uuid, type, timestampCompleted, exp) VALUES (?, ?, 0, ?, ?)", eventId, playerUuid, time.Now(), eventExp)
 				if err != nil {
 					break
 				}
 
+				incrementPlayerStat(playerUuid, statEventsCompleted)
+
 				exp += eventExp
 				weekEventExp += eventExp
 				break
@@ -1117,7 +1753,7 @@ func tryCompletePlayerEventLocation(playerUuid string, location string) (success
 		// prevent race condition
 		clientMapId := client.roomC.mapId
 
-		results, err := db.Query("SELECT pel.id, pl.mapIds FROM playerEventLocations pel JOIN gameLocations pl ON pl.id = pel.locationId WHERE pel.gamePeriodId = ? AND pl.title = ? AND pel.uuid = ? AND UTC_DATE() >= pel.startDate AND UTC_DATE() < pel.endDate ORDER BY 2", currentGameEventPeriodId, location, playerUuid)
+		results, err := db.Query(withEventDate("SELECT pel.id, pl.mapIds FROM playerEventLocations pel JOIN gameLocations pl ON pl.id = pel.locationId WHERE pel.gamePeriodId = ? AND pl.title = ? AND pel.uuid = ? AND UTC_DATE() >= pel.startDate AND UTC_DATE() < pel.endDate ORDER BY 2"), currentGameEventPeriodId, location, playerUuid)
 		if err != nil {
 			return false, err
 		}
@@ -1144,11 +1780,13 @@ func tryCompletePlayerEventLocation(playerUuid string, location string) (success
 					continue
 				}
 
-				_, err = db.Exec("INSERT INTO eventCompletions (eventId, uuid, type, timestampCompleted, exp) VALUES (?, ?, 1, ?, 0)", eventId, playerUuid, time.Now())
+				_, err = db.ExecRetry("INSERT INTO eventCompletions (eventId, uuid, type, timestampCompleted, exp) VALUES (?, ?, 1, ?, 0)", eventId, playerUuid, time.Now())
 				if err != nil {
 					break
 				}
 
+				incrementPlayerStat(playerUuid, statEventsCompleted)
+
 				success = true
 				break
 			}
@@ -1160,6 +1798,37 @@ func tryCompletePlayerEventLocation(playerUuid string, location string) (success
 	return false, err
 }
 
+// getExpiringEventLocationCompletionCounts returns the number of event locations expiring at the end of the day along with how many of them each player has completed
+func getExpiringEventLocationCompletionCounts() (expiringCount int, completionCounts map[string]int, err error) {
+	completionCounts = make(map[string]int)
+
+	err = db.QueryRow(withEventDate("SELECT COUNT(*) FROM eventLocations el JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId WHERE gep.periodId = ? AND el.endDate = DATE_ADD(UTC_DATE(), INTERVAL 1 DAY)"), currentEventPeriodId).Scan(&expiringCount)
+	if err != nil || expiringCount == 0 {
+		return expiringCount, completionCounts, err
+	}
+
+	results, err := db.Query(withEventDate("SELECT ec.uuid, COUNT(*) FROM eventCompletions ec JOIN eventLocations el ON el.id = ec.eventId AND ec.type = 0 JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId WHERE gep.periodId = ? AND el.endDate = DATE_ADD(UTC_DATE(), INTERVAL 1 DAY) GROUP BY ec.uuid"), currentEventPeriodId)
+	if err != nil {
+		return expiringCount, completionCounts, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		var uuid string
+		var count int
+
+		err = results.Scan(&uuid, &count)
+		if err != nil {
+			return expiringCount, completionCounts, err
+		}
+
+		completionCounts[uuid] = count
+	}
+
+	return expiringCount, completionCounts, nil
+}
+
 func getPlayerEventVmCount(playerUuid string) (eventVmCount int, err error) {
 	err = db.QueryRow("SELECT COUNT(eventId) FROM eventCompletions WHERE uuid = ? AND type = 2", playerUuid).Scan(&eventVmCount)
 	if err != nil {
@@ -1170,7 +1839,7 @@ func getPlayerEventVmCount(playerUuid string) (eventVmCount int, err error) {
 }
 
 func getCurrentPlayerEventVmsData(playerUuid string) (eventVms []*EventVm, err error) {
-	results, err := db.Query("SELECT ev.id, gep.game, ev.exp, ev.endDate, CASE WHEN ec.uuid IS NOT NULL THEN 1 ELSE 0 END FROM eventVms ev JOIN gameEventPeriods gep ON gep.id = ev.gamePeriodId LEFT JOIN eventCompletions ec ON ec.eventId = ev.id AND ec.type = 2 AND ec.uuid = ? WHERE gep.periodId = ? AND UTC_DATE() >= ev.startDate AND UTC_DATE() < ev.endDate ORDER BY 2, 1", playerUuid, currentEventPeriodId)
+	results, err := db.Query(withEventDate("SELECT ev.id, gep.game, ev.exp, ev.endDate, CASE WHEN ec.uuid IS NOT NULL THEN 1 ELSE 0 END FROM eventVms ev JOIN gameEventPeriods gep ON gep.id = ev.gamePeriodId LEFT JOIN eventCompletions ec ON ec.eventId = ev.id AND ec.type = 2 AND ec.uuid = ? WHERE gep.periodId = ? AND UTC_DATE() >= ev.startDate AND UTC_DATE() < ev.endDate ORDER BY 2, 1"), playerUuid, currentEventPeriodId)
 	if err != nil {
 		return eventVms, err
 	}
@@ -1209,7 +1878,7 @@ func getEventVmInfo(id int) (mapId int, eventId int, err error) {
 func writeEventVmData(mapId int, eventId int, exp int) error {
 	var days int
 	var offsetDays int
-	weekday := time.Now().UTC().Weekday()
+	weekday := getEventTime().Weekday()
 
 	switch weekday {
 	case time.Sunday, time.Monday:
@@ -1225,7 +1894,7 @@ func writeEventVmData(mapId int, eventId int, exp int) error {
 
 	days -= offsetDays
 
-	_, err := db.Exec("INSERT INTO eventVms (gamePeriodId, mapId, eventId, exp, startDate, endDate) VALUES (?, ?, ?, ?, DATE_SUB(UTC_DATE(), INTERVAL ? DAY), DATE_ADD(UTC_DATE(), INTERVAL ? DAY))", currentGameEventPeriodId, mapId, eventId, exp, offsetDays, days)
+	_, err := db.Exec(withEventDate("INSERT INTO eventVms (gamePeriodId, mapId, eventId, exp, startDate, endDate) VALUES (?, ?, ?, ?, DATE_SUB(UTC_DATE(), INTERVAL ? DAY), DATE_ADD(UTC_DATE(), INTERVAL ? DAY))"), currentGameEventPeriodId, mapId, eventId, exp, offsetDays, days)
 	if err != nil {
 		return err
 	}
@@ -1242,7 +1911,7 @@ func tryCompleteEventVm(playerUuid string, mapId int, eventId int) (exp int, err
 		// prevent race condition
 		clientMapId := client.roomC.mapId
 
-		results, err := db.Query("SELECT ev.id, ev.mapId, ev.eventId, ev.exp FROM eventVms ev JOIN gameEventPeriods gep ON gep.id = ev.gamePeriodId WHERE gep.periodId = ? AND ev.mapId = ? AND ev.eventId = ? AND UTC_DATE() >= ev.startDate AND UTC_DATE() < ev.endDate ORDER BY 2", currentEventPeriodId, mapId, eventId)
+		results, err := db.Query(withEventDate("SELECT ev.id, ev.mapId, ev.eventId, ev.exp FROM eventVms ev JOIN gameEventPeriods gep ON gep.id = ev.gamePeriodId WHERE gep.periodId = ? AND ev.mapId = ? AND ev.eventId = ? AND UTC_DATE() >= ev.startDate AND UTC_DATE() < ev.endDate ORDER BY 2"), currentEventPeriodId, mapId, eventId)
 		if err != nil {
 			return -1, err
 		}
@@ -1259,6 +1928,16 @@ func tryCompleteEventVm(playerUuid string, mapId int, eventId int) (exp int, err
 			return -1, err
 		}
 
+		expMultiplier, err := getCurrentEventExpMultiplier()
+		if err != nil {
+			return -1, err
+		}
+
+		weeklyExpCap, err := getCurrentWeeklyExpCap()
+		if err != nil {
+			return -1, err
+		}
+
 		for results.Next() {
 			var eventId int
 			var eventMapId int
@@ -1282,17 +1961,15 @@ func tryCompleteEventVm(playerUuid string, mapId int, eventId int) (exp int, err
 			if clientMapId != fmt.Sprintf("%04d", eventMapId) {
 				continue
 			}
-			if weekEventExp >= weeklyExpCap {
-				eventExp = 0
-			} else if weekEventExp+eventExp > weeklyExpCap {
-				eventExp = weeklyExpCap - weekEventExp
-			}
+			eventExp = applyWeeklyExpCap(applyEventExpMultiplier(eventExp, expMultiplier), weekEventExp, weeklyExpCap)
 
-			_, err = db.Exec("INSERT INTO eventCompletions (eventId, uuid, type, timestampCompleted, exp) VALUES (?, ?, 2, ?, ?)", eventId, playerUuid, time.Now(), eventExp)
+			_, err = db.ExecRetry("INSERT INTO eventCompletions (eventId, uuid, type, timestampCompleted, exp) VALUES (?, ?, 2, ?, ?)", eventId, playerUuid, time.Now(), eventExp)
 			if err != nil {
 				break
 			}
 
+			incrementPlayerStat(playerUuid, statEventsCompleted)
+
 			exp += eventExp
 			weekEventExp += eventExp
 		}
@@ -1303,6 +1980,78 @@ func tryCompleteEventVm(playerUuid string, mapId int, eventId int) (exp int, err
 	return -1, err
 }
 
+// getCurrentWeeklyExpCap returns the weekly exp cap of the current period, falling back to the configured cap.
+// A negative cap means event exp is uncapped.
+func getCurrentWeeklyExpCap() (weeklyExpCap int, err error) {
+	var periodWeeklyExpCap sql.NullInt64
+
+	err = db.QueryRow("SELECT weeklyExpCap FROM eventPeriods WHERE id = ?", currentEventPeriodId).Scan(&periodWeeklyExpCap)
+	if err != nil && err != sql.ErrNoRows {
+		return getConfig().weeklyExpCap, err
+	}
+
+	if periodWeeklyExpCap.Valid {
+		return int(periodWeeklyExpCap.Int64), nil
+	}
+
+	return getConfig().weeklyExpCap, nil
+}
+
+// getCurrentEventExpMultiplier returns the highest multiplier among the bonus windows active for this game
+func getCurrentEventExpMultiplier() (multiplier float64, err error) {
+	err = db.QueryRow("SELECT COALESCE(MAX(multiplier), 1) FROM eventExpMultipliers WHERE (game = ? OR game IS NULL) AND UTC_TIMESTAMP() >= startTime AND UTC_TIMESTAMP() < endTime", getConfig().gameName).Scan(&multiplier)
+	if err != nil {
+		return 1, err
+	}
+
+	return multiplier, nil
+}
+
+func getEventExpMultipliers() (expMultipliers []*EventExpMultiplier, err error) {
+	results, err := db.Query("SELECT id, COALESCE(game, ''), multiplier, startTime, endTime FROM eventExpMultipliers WHERE endTime > UTC_TIMESTAMP() ORDER BY startTime")
+	if err != nil {
+		return expMultipliers, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		expMultiplier := &EventExpMultiplier{}
+
+		err = results.Scan(&expMultiplier.Id, &expMultiplier.Game, &expMultiplier.Multiplier, &expMultiplier.StartTime, &expMultiplier.EndTime)
+		if err != nil {
+			return expMultipliers, err
+		}
+
+		expMultipliers = append(expMultipliers, expMultiplier)
+	}
+
+	return expMultipliers, nil
+}
+
+func writeEventExpMultiplier(gameId string, multiplier float64, startTime time.Time, endTime time.Time) error {
+	var game any
+	if gameId != "" {
+		game = gameId
+	}
+
+	_, err := db.Exec("INSERT INTO eventExpMultipliers (game, multiplier, startTime, endTime) VALUES (?, ?, ?, ?)", game, multiplier, startTime, endTime)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func deleteEventExpMultiplier(id int) error {
+	_, err := db.Exec("DELETE FROM eventExpMultipliers WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func getPlayerTags(playerUuid string) (tags []string, lastUnlocked time.Time, err error) {
 	results, err := db.Query("SELECT name, timestampUnlocked FROM playerTags WHERE uuid = ?", playerUuid)
 	if err != nil {
@@ -1345,10 +2094,11 @@ func tryWritePlayerTag(playerUuid string, name string) (success bool, err error)
 			}
 		}
 		if !tagExists {
-			_, err = db.Exec("INSERT INTO playerTags (uuid, name, timestampUnlocked) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE name = name", playerUuid, name, time.Now())
+			_, err = db.Exec("INSERT INTO playerTags (uuid, name, timestampUnlocked) VALUES (?, ?, ?) "+db.upsertIgnore("uuid, name"), playerUuid, name, time.Now())
 			if err != nil {
 				return false, err
 			}
+			invalidatePlayerBadgeData(playerUuid)
 			return true, nil
 		}
 	}
@@ -1392,6 +2142,7 @@ func tryWritePlayerTimeTrial(playerUuid string, mapId int, seconds int) (success
 		if err != nil {
 			return false, err
 		}
+		invalidatePlayerBadgeData(playerUuid)
 		return true, nil
 	}
 
@@ -1400,9 +2151,55 @@ func tryWritePlayerTimeTrial(playerUuid string, mapId int, seconds int) (success
 		return false, err
 	}
 
+	invalidatePlayerBadgeData(playerUuid)
+
 	return true, nil
 }
 
+func getPlayerRecentChatMessages(uuid string, limit int) (chatMessages []*ChatMessage, err error) {
+	results, err := db.Query("SELECT msgId, uuid, mapId, prevMapId, prevLocations, x, y, contents, timestamp, partyId IS NOT NULL FROM chatMessages WHERE uuid = ? AND game = ? ORDER BY timestamp DESC LIMIT ?", uuid, getConfig().gameName, limit)
+	if err != nil {
+		return chatMessages, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		chatMessage := &ChatMessage{}
+
+		err := results.Scan(&chatMessage.MsgId, &chatMessage.Uuid, &chatMessage.MapId, &chatMessage.PrevMapId, &chatMessage.PrevLocations, &chatMessage.X, &chatMessage.Y, &chatMessage.Contents, &chatMessage.Timestamp, &chatMessage.Party)
+		if err != nil {
+			return chatMessages, err
+		}
+
+		chatMessages = append(chatMessages, chatMessage)
+	}
+
+	return chatMessages, nil
+}
+
+func getPlayerReports(targetUuid string) (reports []*PlayerReportRecord, err error) {
+	results, err := db.Query("SELECT uuid, COALESCE(msgId, ''), game, reason, timestampReported, actionTaken FROM playerReports WHERE targetUuid = ? ORDER BY timestampReported DESC", targetUuid)
+	if err != nil {
+		return reports, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		report := &PlayerReportRecord{}
+
+		err := results.Scan(&report.ReporterUuid, &report.MsgId, &report.Game, &report.Reason, &report.Timestamp, &report.ActionTaken)
+		if err != nil {
+			return reports, err
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
 func getBannedMutedPlayers(banned bool) (players []PlayerInfo) {
 	var actionStr string
 
@@ -1438,6 +2235,59 @@ func getBannedMutedPlayers(banned bool) (players []PlayerInfo) {
 	return players
 }
 
+// getPlayerSearchResults returns accounts, and guests who played this game, whose name contains the query.
+// Exact matches are listed first, then players who are online.
+func getPlayerSearchResults(ctx context.Context, query string, limit int, offset int) (searchResults PlayerSearchResults, err error) {
+	searchResults.Players = []*PlayerSearchResult{}
+
+	pattern := "%" + strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(query) + "%"
+
+	fromClause := " FROM players pd LEFT JOIN playerGameData pgd ON pgd.uuid = pd.uuid AND pgd.game = ? LEFT JOIN accounts a ON a.uuid = pd.uuid WHERE a.user LIKE ? OR (a.user IS NULL AND pgd.name LIKE ?)"
+
+	err = db.Replica().QueryRowContext(ctx, "SELECT COUNT(*)"+fromClause, getConfig().gameName, pattern, pattern).Scan(&searchResults.TotalCount)
+	if err != nil {
+		return searchResults, err
+	}
+
+	results, err := db.Replica().QueryContext(ctx, "SELECT pd.uuid, COALESCE(a.user, pgd.name), pd.rank, CASE WHEN a.user IS NULL THEN 0 ELSE 1 END, COALESCE(a.badge, ''), COALESCE(pgd.online, 0)"+fromClause+" ORDER BY COALESCE(a.user, pgd.name) = ? DESC, 6 DESC, 2 LIMIT ? OFFSET ?", getConfig().gameName, pattern, pattern, query, limit, offset)
+	if err != nil {
+		return searchResults, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		searchResult := &PlayerSearchResult{}
+
+		err = results.Scan(&searchResult.Uuid, &searchResult.Name, &searchResult.Rank, &searchResult.Account, &searchResult.Badge, &searchResult.Online)
+		if err != nil {
+			return searchResults, err
+		}
+
+		if client, ok := clients.Load(searchResult.Uuid); ok {
+			searchResult.Online = true
+			searchResult.Badge = client.badge
+		}
+
+		searchResults.Players = append(searchResults.Players, searchResult)
+	}
+
+	return searchResults, nil
+}
+
+// getPlayerProfileAccountData returns an empty name if the player has no account
+func getPlayerProfileAccountData(uuid string) (name string, rank int, badge string, registered time.Time, err error) {
+	err = db.Replica().QueryRow("SELECT a.user, pd.rank, COALESCE(a.badge, ''), a.timestampRegistered FROM accounts a JOIN players pd ON pd.uuid = a.uuid WHERE a.uuid = ?", uuid).Scan(&name, &rank, &badge, &registered)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", 0, "", registered, nil
+		}
+		return "", 0, "", registered, err
+	}
+
+	return name, rank, badge, registered, nil
+}
+
 func getUuidFromName(name string) (uuid string, err error) {
 	err = db.QueryRow("SELECT uuid FROM accounts WHERE user = ?", name).Scan(&uuid)
 	if err != nil {
@@ -1476,6 +2326,28 @@ func getNameFromUuid(uuid string) (name string) {
 	return name
 }
 
+// delete2kkiApiQueries removes cached Yume 2kki Explorer API responses, optionally only those of an action and query
+func delete2kkiApiQueries(action string, queryString string) (count int64, err error) {
+	query := "DELETE FROM 2kkiApiQueries WHERE 1 = 1"
+	var args []any
+
+	if action != "" {
+		query += " AND action = ?"
+		args = append(args, action)
+	}
+	if queryString != "" {
+		query += " AND query = ?"
+		args = append(args, queryString)
+	}
+
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
 func isIpBanned(ip string) bool {
 	var banned int
 
@@ -1499,25 +2371,29 @@ func isIpBanned(ip string) bool {
 }
 
 func getUuidFromToken(token string) (uuid string) {
+	if cached, ok := loadCachedTokenPlayerData(token); ok {
+		return cached.uuid
+	}
+
 	db.QueryRow("SELECT uuid FROM playerSessions WHERE sessionId = ? AND NOW() < expiration", token).Scan(&uuid)
 
 	return uuid
 }
 
 func writeGamePlayerCount(playerCount int) error {
-	_, err := db.Exec("INSERT INTO gamePlayerCounts (game, playerCount) VALUES (?, ?)", config.gameName, playerCount)
+	_, err := db.Exec("INSERT INTO gamePlayerCounts (game, playerCount) VALUES (?, ?)", getConfig().gameName, playerCount)
 	if err != nil {
 		return err
 	}
 
 	var playerCounts int
-	err = db.QueryRow("SELECT COUNT(*) FROM gamePlayerCounts WHERE game = ?", config.gameName).Scan(&playerCounts)
+	err = db.QueryRow("SELECT COUNT(*) FROM gamePlayerCounts WHERE game = ?", getConfig().gameName).Scan(&playerCounts)
 	if err != nil {
 		return err
 	}
 
 	if playerCounts > 28 {
-		_, err = db.Exec("DELETE FROM gamePlayerCounts WHERE game = ? ORDER BY id LIMIT ?", config.gameName, playerCounts-28)
+		_, err = db.Exec("DELETE FROM gamePlayerCounts WHERE game = ? ORDER BY id LIMIT ?", getConfig().gameName, playerCounts-28)
 		if err != nil {
 			return err
 		}
@@ -1552,32 +2428,52 @@ func getReportersForPlayer(targetUuid, msgId string) (result map[string]string,
 }
 
 func doCleanupQueries() error {
+	ctx := withoutQueryTimeout(context.Background())
+
 	// Remove player records with no game activity
-	_, err := db.Exec("DELETE IGNORE FROM players WHERE ip IS NOT NULL")
+	_, err := db.ExecContext(ctx, "DELETE FROM players WHERE ip IS NOT NULL")
 	if err != nil {
 		return err
 	}
 
 	// Remove player sessions that have expired
-	_, err = db.Exec("DELETE FROM playerSessions WHERE expiration < NOW()")
+	_, err = db.ExecContext(ctx, "DELETE FROM playerSessions WHERE expiration < NOW()")
 	if err != nil {
 		return err
 	}
 
 	// Remove player expeditions that were never completed
-	_, err = db.Exec("DELETE pel FROM playerEventLocations pel WHERE UTC_DATE() > pel.endDate AND NOT EXISTS (SELECT ec.eventId FROM eventCompletions ec WHERE ec.eventId = pel.id AND ec.type = 1)")
+	_, err = db.ExecContext(ctx, withEventDate("DELETE FROM playerEventLocations WHERE UTC_DATE() > endDate AND NOT EXISTS (SELECT ec.eventId FROM eventCompletions ec WHERE ec.eventId = playerEventLocations.id AND ec.type = 1)"))
 	if err != nil {
 		return err
 	}
 
 	// Remove player event location queue for past dates
-	_, err = db.Exec("DELETE FROM playerEventLocationQueue WHERE UTC_DATE() > date")
+	_, err = db.ExecContext(ctx, withEventDate("DELETE FROM playerEventLocationQueue WHERE UTC_DATE() > date"))
+	if err != nil {
+		return err
+	}
+
+	// Remove Yume 2kki Explorer API query cache records that expired a week ago, kept until then in case 2kki.app is unavailable
+	_, err = db.ExecContext(ctx, "DELETE FROM 2kkiApiQueries WHERE timestampExpired < DATE_SUB(NOW(), INTERVAL 1 WEEK)")
+	if err != nil {
+		return err
+	}
+
+	// Remove location history older than a month
+	_, err = db.ExecContext(ctx, "DELETE FROM playerLocationHistory WHERE timestamp < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 30 DAY)")
+	if err != nil {
+		return err
+	}
+
+	// Remove finished jobs older than a month
+	_, err = db.ExecContext(ctx, "DELETE FROM jobs WHERE timestampFinished < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 30 DAY)")
 	if err != nil {
 		return err
 	}
 
-	// Remove Yume 2kki Explorer API query cache records that have expired
-	_, err = db.Exec("DELETE FROM 2kkiApiQueries WHERE timestampExpired < NOW()")
+	// Remove whispers delivered over a week ago and undelivered whispers over a month old
+	_, err = db.ExecContext(ctx, "DELETE FROM playerWhispers WHERE (delivered = 1 AND timestamp < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 7 DAY)) OR timestamp < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 30 DAY)")
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,118 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	dbHealthCheckInterval = 5 * time.Second
+	dbHealthCheckTimeout  = 3 * time.Second
+	dbStartupRetryDelay   = 5 * time.Second
+)
+
+// while the database is unavailable, API requests and new sessions are refused
+// and connected players are left with what doesn't need it, such as chat
+func initDatabaseHealth() {
+	logInitTask("database health checks")
+
+	if db.replica != nil {
+		db.replica.available.Store(db.replica.ping() == nil)
+	}
+
+	go func() {
+		for range time.Tick(dbHealthCheckInterval) {
+			db.checkHealth("database")
+			if db.replica != nil {
+				db.replica.checkHealth("database replica")
+			}
+		}
+	}()
+}
+
+// waitForDatabase blocks until the database responds, so the server can be started before it
+func waitForDatabase() {
+	for {
+		err := db.ping()
+		if err == nil {
+			db.available.Store(true)
+			return
+		}
+
+		// logging is not initialized yet at startup
+		fmt.Printf("Waiting for database: %s\n", err)
+
+		time.Sleep(dbStartupRetryDelay)
+	}
+}
+
+func (d *Database) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbHealthCheckTimeout)
+	defer cancel()
+
+	// the pool reconnects by itself, a successful ping means it managed to
+	return d.PingContext(ctx)
+}
+
+func (d *Database) checkHealth(name string) {
+	err := d.ping()
+
+	if d.available.Swap(err == nil) == (err == nil) {
+		return
+	}
+
+	if err != nil {
+		writeErrLog("SERVER", "db", name+" unavailable: "+err.Error())
+	} else {
+		writeLog("SERVER", "db", name+" available again", 200)
+	}
+}
+
+func isDatabaseAvailable() bool {
+	return db.available.Load()
+}
+
+// withDatabase refuses requests while the database is unavailable instead of letting them fail halfway
+func withDatabase(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isDatabaseAvailable() {
+			handleServiceUnavailable(w, r)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func handleServiceUnavailable(w http.ResponseWriter, r *http.Request) {
+	const payload = "service temporarily unavailable"
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(dbHealthCheckInterval.Seconds())))
+
+	if getApiVersion(r) >= apiV2 {
+		writeApiError(w, r, payload, http.StatusServiceUnavailable)
+		return
+	}
+
+	http.Error(w, payload, http.StatusServiceUnavailable)
+}
@@ -0,0 +1,581 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// queries are written for MySQL and rewritten for other dialects when they are run
+type sqlDialect int
+
+const (
+	dialectMysql sqlDialect = iota
+	dialectPostgres
+	dialectSqlite
+)
+
+var (
+	ddlCreateTableRegexp   = regexp.MustCompile(`^CREATE TABLE (?:IF NOT EXISTS )?(\w+)`)
+	ddlAutoIncrementRegexp = regexp.MustCompile(`\b(BIG)?INT NOT NULL AUTO_INCREMENT\b`)
+
+	// secondary indexes within CREATE TABLE statements, which other databases don't support
+	ddlIndexRegexp = regexp.MustCompile(`(?m),\n\s*KEY \(([^)]*)\)`)
+
+	// column types of MySQL that PostgreSQL names differently,
+	// booleans are kept as integers since queries compare them with 0 and 1
+	postgresColumnTypeReplacer = strings.NewReplacer(
+		" DATETIME", " TIMESTAMP",
+		" DOUBLE", " DOUBLE PRECISION",
+		" MEDIUMTEXT", " TEXT",
+		" BOOLEAN", " SMALLINT",
+	)
+
+	// user is a reserved word in PostgreSQL, but not after a table alias
+	postgresUserColumnRegexp = regexp.MustCompile(`(^|[^.\w"'])user\b`)
+)
+
+// Database wraps the connection pool to rewrite queries for its dialect
+type Database struct {
+	*sql.DB
+
+	dialect sqlDialect
+
+	replica *Database
+
+	// updated by the health checks
+	available atomic.Bool
+}
+
+type Tx struct {
+	*sql.Tx
+
+	dialect sqlDialect
+}
+
+const (
+	dbRetryAttempts  = 4
+	dbRetryBaseDelay = 100 * time.Millisecond
+)
+
+var (
+	// writes retried after a transient error, and those still failing once out of attempts
+	dbRetryCount        atomic.Int64
+	dbRetryFailureCount atomic.Int64
+)
+
+var dateArithmeticRegexp = regexp.MustCompile(`DATE_(ADD|SUB)\((NOW\(\)|UTC_DATE\(\)|UTC_TIMESTAMP\(\)|[A-Za-z.]+), INTERVAL (\?|[A-Za-z0-9]+) ((?i:MINUTE|HOUR|DAY|WEEK|MONTH|YEAR))\)`)
+
+// Rows cancels the context of its query when closed
+type Rows struct {
+	*sql.Rows
+
+	cancel context.CancelFunc
+}
+
+// Row cancels the context of its query once scanned or closed
+type Row struct {
+	*sql.Row
+
+	cancel context.CancelFunc
+}
+
+func (r *Rows) Close() error {
+	defer r.cancel()
+
+	return r.Rows.Close()
+}
+
+func (r *Row) Scan(dest ...any) error {
+	defer r.cancel()
+
+	return r.Row.Scan(dest...)
+}
+
+// Close releases the connection of a row that won't be scanned
+func (r *Row) Close() {
+	r.cancel()
+}
+
+type noQueryTimeoutKey struct{}
+
+// withoutQueryTimeout exempts the queries run with ctx from the configured timeout,
+// for maintenance writes that can take long on large tables. They still end once ctx is done.
+func withoutQueryTimeout(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noQueryTimeoutKey{}, true)
+}
+
+// withQueryTimeout bounds a query by the configured timeout in addition to the given context
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if getConfig().dbQueryTimeout == 0 || ctx.Value(noQueryTimeoutKey{}) != nil {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, getConfig().dbQueryTimeout)
+}
+
+func (d *Database) Query(query string, args ...any) (*Rows, error) {
+	return d.QueryContext(context.Background(), query, args...)
+}
+
+func (d *Database) QueryRow(query string, args ...any) *Row {
+	return d.QueryRowContext(context.Background(), query, args...)
+}
+
+func (d *Database) Exec(query string, args ...any) (sql.Result, error) {
+	return d.ExecContext(context.Background(), query, args...)
+}
+
+// QueryContext runs a query until it completes, times out or ctx is done, such as when the requesting client disconnects
+func (d *Database) QueryContext(ctx context.Context, query string, args ...any) (*Rows, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+
+	rows, err := d.DB.QueryContext(ctx, d.dialect.rebind(query), d.dialect.bindArgs(args)...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &Rows{Rows: rows, cancel: cancel}, nil
+}
+
+func (d *Database) QueryRowContext(ctx context.Context, query string, args ...any) *Row {
+	ctx, cancel := withQueryTimeout(ctx)
+
+	return &Row{Row: d.DB.QueryRowContext(ctx, d.dialect.rebind(query), d.dialect.bindArgs(args)...), cancel: cancel}
+}
+
+func (d *Database) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	return d.DB.ExecContext(ctx, d.dialect.rebind(query), d.dialect.bindArgs(args)...)
+}
+
+// ExecRetry runs a write, retrying it on errors that are likely to go away such as deadlocks or a restarting server
+func (d *Database) ExecRetry(query string, args ...any) (result sql.Result, err error) {
+	err = retryTransient(func() error {
+		result, err = d.Exec(query, args...)
+		return err
+	})
+
+	return result, err
+}
+
+// retryTransient runs fn until it succeeds, fails with an error that isn't transient or runs out of attempts,
+// fn must be safe to run again such as a single statement or a whole transaction
+func retryTransient(fn func() error) error {
+	delay := dbRetryBaseDelay
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || !isTransientDbError(err) {
+			return err
+		}
+
+		// waiting is pointless while the health checks can't reach the database either
+		if attempt == dbRetryAttempts || !isDatabaseAvailable() {
+			break
+		}
+
+		dbRetryCount.Add(1)
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	dbRetryFailureCount.Add(1)
+	writeErrLog("SERVER", "db", "giving up after transient errors: "+err.Error())
+
+	return err
+}
+
+func isTransientDbError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		// deadlock and lock wait timeout, the statement was rolled back
+		return mysqlErr.Number == 1213 || mysqlErr.Number == 1205
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// deadlock and serialization failure
+		return pgErr.Code == "40P01" || pgErr.Code == "40001"
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	// the SQLite driver isn't imported, so its errors are matched by message
+	return strings.Contains(err.Error(), "database is locked")
+}
+
+// Replica returns the read replica if one is configured and available, for heavy reads that can tolerate replication lag
+func (d *Database) Replica() *Database {
+	if d.replica != nil && d.replica.available.Load() {
+		return d.replica
+	}
+
+	return d
+}
+
+func (d *Database) Begin() (*Tx, error) {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{Tx: tx, dialect: d.dialect}, nil
+}
+
+func (t *Tx) Query(query string, args ...any) (*Rows, error) {
+	ctx, cancel := withQueryTimeout(context.Background())
+
+	rows, err := t.Tx.QueryContext(ctx, t.dialect.rebind(query), t.dialect.bindArgs(args)...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &Rows{Rows: rows, cancel: cancel}, nil
+}
+
+func (t *Tx) QueryRow(query string, args ...any) *Row {
+	ctx, cancel := withQueryTimeout(context.Background())
+
+	return &Row{Row: t.Tx.QueryRowContext(ctx, t.dialect.rebind(query), t.dialect.bindArgs(args)...), cancel: cancel}
+}
+
+func (t *Tx) Exec(query string, args ...any) (sql.Result, error) {
+	ctx, cancel := withQueryTimeout(context.Background())
+	defer cancel()
+
+	return t.Tx.ExecContext(ctx, t.dialect.rebind(query), t.dialect.bindArgs(args)...)
+}
+
+// ExecInsert runs an insert into a table with an auto-incremented id column and returns the id of the new row
+func (d *Database) ExecInsert(query string, args ...any) (int64, error) {
+	if d.dialect == dialectPostgres {
+		// the PostgreSQL driver doesn't report inserted ids
+		var id int64
+		err := d.QueryRow(query+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := d.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+func (t *Tx) ExecInsert(query string, args ...any) (int64, error) {
+	if t.dialect == dialectPostgres {
+		var id int64
+		err := t.QueryRow(query+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := t.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// upsert returns the clause updating the conflicting row of an insert,
+// assignments referring to current values must qualify them with the table name
+func (d *Database) upsert(conflictColumns string, assignments string) string {
+	if d.dialect != dialectMysql {
+		return "ON CONFLICT (" + conflictColumns + ") DO UPDATE SET " + assignments
+	}
+
+	return "ON DUPLICATE KEY UPDATE " + assignments
+}
+
+// upsertIgnore returns the clause keeping the conflicting row of an insert as it is
+func (d *Database) upsertIgnore(conflictColumns string) string {
+	if d.dialect != dialectMysql {
+		return "ON CONFLICT (" + conflictColumns + ") DO NOTHING"
+	}
+
+	column, _, _ := strings.Cut(conflictColumns, ",")
+
+	return "ON DUPLICATE KEY UPDATE " + column + " = " + column
+}
+
+// updateFrom returns an update of table with the rows of another table or subquery matching the condition,
+// assignments must not qualify the columns they set and where may be empty
+func (d *Database) updateFrom(table string, from string, condition string, assignments string, where string) string {
+	if d.dialect == dialectMysql {
+		query := "UPDATE " + table + " JOIN " + from + " ON " + condition + " SET " + assignments
+		if where != "" {
+			query += " WHERE " + where
+		}
+		return query
+	}
+
+	query := "UPDATE " + table + " SET " + assignments + " FROM " + from + " WHERE " + condition
+	if where != "" {
+		query += " AND " + where
+	}
+	return query
+}
+
+// bindArgs converts query arguments for the dialect
+func (dialect sqlDialect) bindArgs(args []any) []any {
+	if dialect != dialectPostgres {
+		return args
+	}
+
+	// boolean columns are integers in PostgreSQL, which its driver doesn't convert booleans to
+	var boundArgs []any
+	for i, arg := range args {
+		if value, ok := arg.(bool); ok {
+			if boundArgs == nil {
+				boundArgs = append([]any(nil), args...)
+			}
+			boundArgs[i] = 0
+			if value {
+				boundArgs[i] = 1
+			}
+		}
+	}
+
+	if boundArgs == nil {
+		return args
+	}
+
+	return boundArgs
+}
+
+// rebind rewrites a MySQL query for the dialect
+func (dialect sqlDialect) rebind(query string) string {
+	switch dialect {
+	case dialectPostgres:
+		return rebindPostgres(query)
+	case dialectSqlite:
+		return rebindSqlite(query)
+	}
+
+	return query
+}
+
+func rebindPostgres(query string) string {
+	query = dateArithmeticRegexp.ReplaceAllStringFunc(query, func(match string) string {
+		groups := dateArithmeticRegexp.FindStringSubmatch(match)
+
+		operator := "+"
+		if groups[1] == "SUB" {
+			operator = "-"
+		}
+
+		amount := groups[3]
+		if amount == "?" {
+			amount = "CAST(? AS INTEGER)"
+		}
+
+		return fmt.Sprintf("(%s %s %s * INTERVAL '1 %s')", groups[2], operator, amount, strings.ToLower(groups[4]))
+	})
+
+	query = strings.ReplaceAll(query, "UTC_DATE()", "CAST(NOW() AT TIME ZONE 'UTC' AS DATE)")
+	query = strings.ReplaceAll(query, "UTC_TIMESTAMP()", "(NOW() AT TIME ZONE 'UTC')")
+	query = strings.ReplaceAll(query, "RAND()", "RANDOM()")
+	query = quoteIdentifiers(query)
+	query = quotePostgresUserColumn(query)
+
+	if strings.Contains(query, "INSERT IGNORE INTO") {
+		query = strings.Replace(query, "INSERT IGNORE INTO", "INSERT INTO", 1) + " ON CONFLICT DO NOTHING"
+	}
+
+	return bindNumberedParams(query)
+}
+
+// rebindSqlite rewrites date functions to SQLite ones, which work on UTC text dates
+func rebindSqlite(query string) string {
+	query = dateArithmeticRegexp.ReplaceAllStringFunc(query, func(match string) string {
+		groups := dateArithmeticRegexp.FindStringSubmatch(match)
+
+		amount := groups[3]
+		if groups[1] == "SUB" {
+			amount = "-(" + amount + ")"
+		}
+
+		unit := strings.ToLower(groups[4])
+		if unit == "week" {
+			amount = "7 * " + amount
+			unit = "day"
+		}
+
+		// date arithmetic on dates keeps them comparable with date columns
+		function := "DATETIME"
+		base := groups[2]
+		switch base {
+		case "UTC_DATE()":
+			function = "DATE"
+			base = "'now'"
+		case "NOW()", "UTC_TIMESTAMP()":
+			base = "'now'"
+		}
+
+		return fmt.Sprintf("%s(%s, printf('%%+d %ss', %s))", function, base, unit, amount)
+	})
+
+	query = strings.ReplaceAll(query, "UTC_DATE()", "DATE('now')")
+	query = strings.ReplaceAll(query, "UTC_TIMESTAMP()", "DATETIME('now')")
+	query = strings.ReplaceAll(query, "NOW()", "DATETIME('now')")
+	query = strings.ReplaceAll(query, "RAND()", "RANDOM()")
+	query = strings.ReplaceAll(query, "GREATEST(", "MAX(")
+	query = strings.ReplaceAll(query, " FOR UPDATE", "")
+	query = strings.ReplaceAll(query, "INSERT IGNORE INTO", "INSERT OR IGNORE INTO")
+	query = strings.ReplaceAll(query, "TRUNCATE TABLE ", "DELETE FROM ")
+
+	return quoteIdentifiers(query)
+}
+
+// quoteIdentifiers quotes table names only MySQL accepts unquoted
+func quoteIdentifiers(query string) string {
+	return strings.ReplaceAll(query, "2kkiApiQueries", `"2kkiApiQueries"`)
+}
+
+// quotePostgresUserColumn quotes the user column outside of string literals and quoted identifiers
+func quotePostgresUserColumn(query string) string {
+	var builder strings.Builder
+	var quote rune
+	start := 0
+
+	for i, char := range query {
+		switch {
+		case quote == 0 && (char == '\'' || char == '"'):
+			builder.WriteString(postgresUserColumnRegexp.ReplaceAllString(query[start:i], `$1"user"`))
+			quote = char
+			start = i
+		case char == quote:
+			builder.WriteString(query[start : i+1])
+			quote = 0
+			start = i + 1
+		}
+	}
+
+	if quote == 0 {
+		builder.WriteString(postgresUserColumnRegexp.ReplaceAllString(query[start:], `$1"user"`))
+	} else {
+		builder.WriteString(query[start:])
+	}
+
+	return builder.String()
+}
+
+// bindNumberedParams replaces ? placeholders outside of string literals with $1, $2...
+func bindNumberedParams(query string) string {
+	var builder strings.Builder
+	var inString bool
+	var paramNum int
+
+	for _, char := range query {
+		switch {
+		case char == '\'':
+			inString = !inString
+		case char == '?' && !inString:
+			paramNum++
+			builder.WriteString("$" + strconv.Itoa(paramNum))
+			continue
+		}
+
+		builder.WriteRune(char)
+	}
+
+	return builder.String()
+}
+
+// translateSqliteDdl rewrites a MySQL CREATE TABLE statement for SQLite,
+// followed by statements creating the secondary indexes of the table
+func translateSqliteDdl(statement string) []string {
+	indexStatements, statement := extractDdlIndexes(statement)
+
+	if ddlAutoIncrementRegexp.MatchString(statement) {
+		// auto-incremented columns have to be declared as the primary key
+		statement = ddlAutoIncrementRegexp.ReplaceAllString(statement, "INTEGER PRIMARY KEY AUTOINCREMENT")
+		statement = strings.Replace(statement, ",\n\tPRIMARY KEY (id)", "", 1)
+	}
+
+	statement = strings.ReplaceAll(statement, "UNIQUE KEY (", "UNIQUE (")
+
+	statements := append([]string{statement}, indexStatements...)
+	for i := range statements {
+		statements[i] = quoteIdentifiers(statements[i])
+	}
+
+	return statements
+}
+
+// extractDdlIndexes removes the secondary indexes from a CREATE TABLE statement, which only MySQL allows in it,
+// and returns the statements creating them
+func extractDdlIndexes(statement string) (indexStatements []string, tableStatement string) {
+	match := ddlCreateTableRegexp.FindStringSubmatch(statement)
+	if match == nil {
+		return nil, statement
+	}
+
+	table := match[1]
+	for _, index := range ddlIndexRegexp.FindAllStringSubmatch(statement, -1) {
+		columns := strings.Split(index[1], ", ")
+		indexStatements = append(indexStatements, "CREATE INDEX IF NOT EXISTS "+table+"_"+strings.Join(columns, "_")+" ON "+table+" ("+index[1]+")")
+	}
+
+	return indexStatements, ddlIndexRegexp.ReplaceAllString(statement, "")
+}
+
+// translatePostgresDdl rewrites a MySQL CREATE TABLE or ALTER TABLE statement for PostgreSQL,
+// followed by statements creating the secondary indexes of the table
+func translatePostgresDdl(statement string) []string {
+	indexStatements, statement := extractDdlIndexes(statement)
+
+	statement = ddlAutoIncrementRegexp.ReplaceAllStringFunc(statement, func(match string) string {
+		if strings.HasPrefix(match, "BIG") {
+			return "BIGSERIAL NOT NULL"
+		}
+		return "SERIAL NOT NULL"
+	})
+	statement = strings.ReplaceAll(statement, "UNIQUE KEY (", "UNIQUE (")
+	statement = postgresColumnTypeReplacer.Replace(statement)
+
+	statements := append([]string{statement}, indexStatements...)
+	for i := range statements {
+		statements[i] = quotePostgresUserColumn(quoteIdentifiers(statements[i]))
+	}
+
+	return statements
+}
@@ -0,0 +1,279 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRebindPostgres(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{
+			query:    "SELECT uuid FROM players WHERE uuid = ? AND rank > ?",
+			expected: "SELECT uuid FROM players WHERE uuid = $1 AND rank > $2",
+		},
+		{
+			query:    "SELECT COUNT(*) FROM chatMessages WHERE contents = '?' AND uuid = ?",
+			expected: "SELECT COUNT(*) FROM chatMessages WHERE contents = '?' AND uuid = $1",
+		},
+		{
+			query:    "DELETE FROM jobs WHERE timestampFinished < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 30 DAY)",
+			expected: "DELETE FROM jobs WHERE timestampFinished < ((NOW() AT TIME ZONE 'UTC') - 30 * INTERVAL '1 day')",
+		},
+		{
+			query:    "SELECT id FROM eventLocations WHERE endDate = DATE_ADD(UTC_DATE(), INTERVAL ? DAY)",
+			expected: "SELECT id FROM eventLocations WHERE endDate = (CAST(NOW() AT TIME ZONE 'UTC' AS DATE) + CAST($1 AS INTEGER) * INTERVAL '1 day')",
+		},
+		{
+			query:    "SELECT COALESCE(MAX(multiplier), 1) FROM eventExpMultipliers WHERE UTC_TIMESTAMP() >= startTime AND UTC_TIMESTAMP() < endTime",
+			expected: "SELECT COALESCE(MAX(multiplier), 1) FROM eventExpMultipliers WHERE (NOW() AT TIME ZONE 'UTC') >= startTime AND (NOW() AT TIME ZONE 'UTC') < endTime",
+		},
+		{
+			query:    "INSERT IGNORE INTO playerVisitedMaps (uuid, game, mapId) VALUES (?, ?, ?), (?, ?, ?)",
+			expected: "INSERT INTO playerVisitedMaps (uuid, game, mapId) VALUES ($1, $2, $3), ($4, $5, $6) ON CONFLICT DO NOTHING",
+		},
+		{
+			query:    "SELECT title FROM gameLocations ORDER BY RAND() LIMIT 1",
+			expected: "SELECT title FROM gameLocations ORDER BY RANDOM() LIMIT 1",
+		},
+		{
+			query:    "DELETE FROM 2kkiApiQueries WHERE timestampExpired < DATE_SUB(NOW(), INTERVAL 1 WEEK)",
+			expected: `DELETE FROM "2kkiApiQueries" WHERE timestampExpired < (NOW() - 1 * INTERVAL '1 week')`,
+		},
+		{
+			query:    "SELECT a.user, pgd.name FROM accounts a JOIN playerGameData pgd ON pgd.uuid = a.uuid WHERE user = ?",
+			expected: `SELECT a.user, pgd.name FROM accounts a JOIN playerGameData pgd ON pgd.uuid = a.uuid WHERE "user" = $1`,
+		},
+		{
+			query:    "UPDATE chatMessages SET contents = 'user (deleted)' WHERE uuid = ? AND contents <> 'a user'",
+			expected: "UPDATE chatMessages SET contents = 'user (deleted)' WHERE uuid = $1 AND contents <> 'a user'",
+		},
+		{
+			query:    `SELECT user AS "user name" FROM accounts WHERE user = ?`,
+			expected: `SELECT "user" AS "user name" FROM accounts WHERE "user" = $1`,
+		},
+	}
+
+	for _, test := range tests {
+		if actual := dialectPostgres.rebind(test.query); actual != test.expected {
+			t.Errorf("rebind(%q) = %q, expected %q", test.query, actual, test.expected)
+		}
+	}
+}
+
+func TestRebindSqlite(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{
+			query:    "SELECT uuid FROM players WHERE uuid = ?",
+			expected: "SELECT uuid FROM players WHERE uuid = ?",
+		},
+		{
+			query:    "DELETE FROM jobs WHERE timestampFinished < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 30 DAY)",
+			expected: "DELETE FROM jobs WHERE timestampFinished < DATETIME('now', printf('%+d days', -(30)))",
+		},
+		{
+			query:    "SELECT id FROM eventLocations WHERE endDate = DATE_ADD(UTC_DATE(), INTERVAL ? DAY)",
+			expected: "SELECT id FROM eventLocations WHERE endDate = DATE('now', printf('%+d days', ?))",
+		},
+		{
+			query:    "DELETE FROM 2kkiApiQueries WHERE timestampExpired < DATE_SUB(NOW(), INTERVAL 1 WEEK)",
+			expected: `DELETE FROM "2kkiApiQueries" WHERE timestampExpired < DATETIME('now', printf('%+d days', 7 * -(1)))`,
+		},
+		{
+			query:    "INSERT IGNORE INTO playerFriends (uuid, targetUuid, accepted) VALUES (?, ?, ?)",
+			expected: "INSERT OR IGNORE INTO playerFriends (uuid, targetUuid, accepted) VALUES (?, ?, ?)",
+		},
+		{
+			query:    "SELECT GREATEST(badgeSlotRows, ?) FROM accounts WHERE uuid = ? FOR UPDATE",
+			expected: "SELECT MAX(badgeSlotRows, ?) FROM accounts WHERE uuid = ?",
+		},
+	}
+
+	for _, test := range tests {
+		if actual := dialectSqlite.rebind(test.query); actual != test.expected {
+			t.Errorf("rebind(%q) = %q, expected %q", test.query, actual, test.expected)
+		}
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	tests := []struct {
+		dialect        sqlDialect
+		upsert         string
+		upsertIgnore   string
+		updateFrom     string
+		updateFromNone string
+	}{
+		{
+			dialect:        dialectMysql,
+			upsert:         "ON DUPLICATE KEY UPDATE seconds = seconds + ?",
+			upsertIgnore:   "ON DUPLICATE KEY UPDATE uuid = uuid",
+			updateFrom:     "UPDATE accounts JOIN totals t ON t.uuid = accounts.uuid SET badgeSlotRows = t.rows WHERE accounts.uuid = ?",
+			updateFromNone: "UPDATE accounts JOIN totals t ON t.uuid = accounts.uuid SET badgeSlotRows = t.rows",
+		},
+		{
+			dialect:        dialectPostgres,
+			upsert:         "ON CONFLICT (uuid, game) DO UPDATE SET seconds = seconds + ?",
+			upsertIgnore:   "ON CONFLICT (uuid, game) DO NOTHING",
+			updateFrom:     "UPDATE accounts SET badgeSlotRows = t.rows FROM totals t WHERE t.uuid = accounts.uuid AND accounts.uuid = ?",
+			updateFromNone: "UPDATE accounts SET badgeSlotRows = t.rows FROM totals t WHERE t.uuid = accounts.uuid",
+		},
+		{
+			dialect:        dialectSqlite,
+			upsert:         "ON CONFLICT (uuid, game) DO UPDATE SET seconds = seconds + ?",
+			upsertIgnore:   "ON CONFLICT (uuid, game) DO NOTHING",
+			updateFrom:     "UPDATE accounts SET badgeSlotRows = t.rows FROM totals t WHERE t.uuid = accounts.uuid AND accounts.uuid = ?",
+			updateFromNone: "UPDATE accounts SET badgeSlotRows = t.rows FROM totals t WHERE t.uuid = accounts.uuid",
+		},
+	}
+
+	for _, test := range tests {
+		d := &Database{dialect: test.dialect}
+
+		if actual := d.upsert("uuid, game", "seconds = seconds + ?"); actual != test.upsert {
+			t.Errorf("dialect %d: upsert = %q, expected %q", test.dialect, actual, test.upsert)
+		}
+		if actual := d.upsertIgnore("uuid, game"); actual != test.upsertIgnore {
+			t.Errorf("dialect %d: upsertIgnore = %q, expected %q", test.dialect, actual, test.upsertIgnore)
+		}
+		if actual := d.updateFrom("accounts", "totals t", "t.uuid = accounts.uuid", "badgeSlotRows = t.rows", "accounts.uuid = ?"); actual != test.updateFrom {
+			t.Errorf("dialect %d: updateFrom = %q, expected %q", test.dialect, actual, test.updateFrom)
+		}
+		if actual := d.updateFrom("accounts", "totals t", "t.uuid = accounts.uuid", "badgeSlotRows = t.rows", ""); actual != test.updateFromNone {
+			t.Errorf("dialect %d: updateFrom without condition = %q, expected %q", test.dialect, actual, test.updateFromNone)
+		}
+	}
+}
+
+func TestBindArgs(t *testing.T) {
+	args := []any{"uuid", true, false, 3}
+
+	if actual := dialectPostgres.bindArgs(args); !reflect.DeepEqual(actual, []any{"uuid", 1, 0, 3}) {
+		t.Errorf("postgres bindArgs = %v", actual)
+	}
+	if actual := dialectMysql.bindArgs(args); !reflect.DeepEqual(actual, args) {
+		t.Errorf("mysql bindArgs = %v", actual)
+	}
+	if args[1] != true {
+		t.Errorf("bindArgs modified its arguments")
+	}
+}
+
+func TestTranslatePostgresDdl(t *testing.T) {
+	tests := []struct {
+		statement string
+		expected  []string
+	}{
+		{
+			statement: "CREATE TABLE IF NOT EXISTS playerLocationHistory (\n\tid BIGINT NOT NULL AUTO_INCREMENT,\n\tuuid VARCHAR(16) NOT NULL,\n\ttimestamp DATETIME NOT NULL,\n\tPRIMARY KEY (id),\n\tKEY (uuid, timestamp),\n\tKEY (timestamp)\n)",
+			expected: []string{
+				"CREATE TABLE IF NOT EXISTS playerLocationHistory (\n\tid BIGSERIAL NOT NULL,\n\tuuid VARCHAR(16) NOT NULL,\n\ttimestamp TIMESTAMP NOT NULL,\n\tPRIMARY KEY (id)\n)",
+				"CREATE INDEX IF NOT EXISTS playerLocationHistory_uuid_timestamp ON playerLocationHistory (uuid, timestamp)",
+				"CREATE INDEX IF NOT EXISTS playerLocationHistory_timestamp ON playerLocationHistory (timestamp)",
+			},
+		},
+		{
+			statement: "CREATE TABLE accounts (\n\tuuid VARCHAR(16) NOT NULL,\n\tuser VARCHAR(12) NOT NULL,\n\tinactive BOOLEAN NOT NULL DEFAULT 0,\n\tPRIMARY KEY (uuid),\n\tUNIQUE KEY (user)\n)",
+			expected: []string{
+				"CREATE TABLE accounts (\n\tuuid VARCHAR(16) NOT NULL,\n\t\"user\" VARCHAR(12) NOT NULL,\n\tinactive SMALLINT NOT NULL DEFAULT 0,\n\tPRIMARY KEY (uuid),\n\tUNIQUE (\"user\")\n)",
+			},
+		},
+		{
+			statement: "CREATE TABLE 2kkiApiQueries (\n\tid INT NOT NULL AUTO_INCREMENT,\n\tresponse MEDIUMTEXT NOT NULL,\n\tmultiplier DOUBLE NOT NULL,\n\tPRIMARY KEY (id)\n)",
+			expected: []string{
+				"CREATE TABLE \"2kkiApiQueries\" (\n\tid SERIAL NOT NULL,\n\tresponse TEXT NOT NULL,\n\tmultiplier DOUBLE PRECISION NOT NULL,\n\tPRIMARY KEY (id)\n)",
+			},
+		},
+		{
+			statement: "ALTER TABLE parties ADD COLUMN coLocate BOOLEAN NOT NULL DEFAULT 0",
+			expected:  []string{"ALTER TABLE parties ADD COLUMN coLocate SMALLINT NOT NULL DEFAULT 0"},
+		},
+	}
+
+	for _, test := range tests {
+		if actual := translatePostgresDdl(test.statement); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("translatePostgresDdl(%q) = %q, expected %q", test.statement, actual, test.expected)
+		}
+	}
+}
+
+func TestTranslateSqliteDdl(t *testing.T) {
+	tests := []struct {
+		statement string
+		expected  []string
+	}{
+		{
+			statement: "CREATE TABLE IF NOT EXISTS playerLocationHistory (\n\tid BIGINT NOT NULL AUTO_INCREMENT,\n\tuuid VARCHAR(16) NOT NULL,\n\tPRIMARY KEY (id),\n\tKEY (uuid)\n)",
+			expected: []string{
+				"CREATE TABLE IF NOT EXISTS playerLocationHistory (\n\tid INTEGER PRIMARY KEY AUTOINCREMENT,\n\tuuid VARCHAR(16) NOT NULL\n)",
+				"CREATE INDEX IF NOT EXISTS playerLocationHistory_uuid ON playerLocationHistory (uuid)",
+			},
+		},
+		{
+			statement: "CREATE TABLE playerMedals (\n\tid INT NOT NULL AUTO_INCREMENT,\n\tseconds BIGINT NOT NULL DEFAULT 0,\n\tPRIMARY KEY (id),\n\tUNIQUE KEY (uuid, medalId)\n)",
+			expected: []string{
+				"CREATE TABLE playerMedals (\n\tid INTEGER PRIMARY KEY AUTOINCREMENT,\n\tseconds BIGINT NOT NULL DEFAULT 0,\n\tUNIQUE (uuid, medalId)\n)",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		if actual := translateSqliteDdl(test.statement); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("translateSqliteDdl(%q) = %q, expected %q", test.statement, actual, test.expected)
+		}
+	}
+}
+
+func TestWithQueryTimeout(t *testing.T) {
+	prevConfig := getConfig()
+	defer currentConfig.Store(prevConfig)
+
+	tests := []struct {
+		timeout     time.Duration
+		ctx         context.Context
+		hasDeadline bool
+	}{
+		{30 * time.Second, context.Background(), true},
+		{0, context.Background(), false},
+		{30 * time.Second, withoutQueryTimeout(context.Background()), false},
+	}
+
+	for _, tt := range tests {
+		currentConfig.Store(&Config{dbQueryTimeout: tt.timeout})
+
+		ctx, cancel := withQueryTimeout(tt.ctx)
+		_, hasDeadline := ctx.Deadline()
+		cancel()
+
+		if hasDeadline != tt.hasDeadline {
+			t.Errorf("withQueryTimeout with timeout %v: deadline set = %v, expected %v", tt.timeout, hasDeadline, tt.hasDeadline)
+		}
+		if ctx.Err() == nil {
+			t.Errorf("withQueryTimeout with timeout %v: context not done after cancel", tt.timeout)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// the Discord bridge mirrors global chat to a Discord channel and relays the channel's messages back into global chat,
+// where they are sent by a player named after the Discord user with a marker
+const (
+	discordBridgeNameSuffix = " (Discord)"
+	discordBridgeUuidPrefix = "dc"
+
+	// the longest name players can have, which is that of accounts
+	discordBridgeMaxNameLength = 12
+)
+
+var (
+	discordBridge *discordgo.Session
+
+	// Discord messages can't contain the delimiters of the protocol
+	discordBridgeDelimReplacer = strings.NewReplacer(delim, "", mdelim, "")
+
+	discordBridgeNameCharRegexp = regexp.MustCompile("[^A-Za-z0-9]")
+)
+
+func initDiscordBridge() {
+	if getConfig().discordBridge.botToken == "" || getConfig().discordBridge.channelId == "" {
+		return
+	}
+
+	logInitTask("Discord bridge")
+
+	session, err := discordgo.New("Bot " + getConfig().discordBridge.botToken)
+	if err != nil {
+		writeErrLog("SERVER", "discord", err.Error())
+		return
+	}
+
+	session.Identify.Intents = discordgo.IntentsGuildMessages | discordgo.IntentMessageContent
+	session.AddHandler(relayDiscordMessage)
+
+	err = session.Open()
+	if err != nil {
+		writeErrLog("SERVER", "discord", err.Error())
+		return
+	}
+
+	discordBridge = session
+}
+
+// mirrorGlobalMessageToDiscord sends a global chat message, which passed the mute check and word filter, to the Discord channel
+func mirrorGlobalMessageToDiscord(name string, msgContents string) {
+	if discordBridge == nil {
+		return
+	}
+
+	game := getConfig().gameName
+	if gameName, ok := gameIdToName[game]; ok {
+		game = gameName
+	}
+
+	go func() {
+		_, err := discordBridge.ChannelMessageSendComplex(getConfig().discordBridge.channelId, &discordgo.MessageSend{
+			Content: fmt.Sprintf("**%s (%s)**: %s", name, game, msgContents),
+			// players can't ping anyone
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+		if err != nil {
+			writeErrLog("SERVER", "discord", err.Error())
+		}
+	}()
+}
+
+func relayDiscordMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
+	// messages of bots and webhooks include those mirrored from global chat
+	if m.ChannelID != getConfig().discordBridge.channelId || m.Author == nil || m.Author.Bot || m.WebhookID != "" {
+		return
+	}
+
+	// every process of a cluster receives the message, one of them broadcasts it to all
+	if !isClusterLeader() {
+		return
+	}
+
+	muted, err := isDiscordUserMuted(m.Author.ID)
+	if err != nil {
+		writeErrLog("SERVER", "discord", err.Error())
+		return
+	}
+	if muted {
+		return
+	}
+
+	msgContents := wordFilter.ReplaceAllString(strings.TrimSpace(discordBridgeDelimReplacer.Replace(m.Content)), ":2kkiSign:")
+	if msgContents == "" || len(msgContents) > 150 {
+		return
+	}
+
+	name := getDiscordBridgeName(m)
+	if name == "" {
+		return
+	}
+
+	uuid := getDiscordBridgeUuid(m.Author.ID)
+
+	broadcastAll(buildMsg("p", uuid, name+discordBridgeNameSuffix, "", 0, false, "null", [5]int{}))
+	broadcastAll(buildMsg("gsay", uuid, "0000", "0000", "", -1, -1, msgContents, randString(12)))
+}
+
+// getDiscordBridgeName returns the first name of a Discord user that is valid as a player name,
+// or the username without the characters player names can't have
+func getDiscordBridgeName(m *discordgo.MessageCreate) string {
+	var names []string
+	if m.Member != nil {
+		names = append(names, m.Member.Nick)
+	}
+	names = append(names, m.Author.GlobalName, m.Author.Username)
+
+	for _, name := range names {
+		if name != "" && isOkString(name) && len(name) <= discordBridgeMaxNameLength {
+			return name
+		}
+	}
+
+	name := discordBridgeNameCharRegexp.ReplaceAllString(m.Author.Username, "")
+	if len(name) > discordBridgeMaxNameLength {
+		name = name[:discordBridgeMaxNameLength]
+	}
+
+	return name
+}
+
+// getDiscordBridgeUuid returns the player uuid used for the messages of a Discord user
+func getDiscordBridgeUuid(discordUserId string) string {
+	hash := sha256.Sum256([]byte(discordUserId))
+
+	return discordBridgeUuidPrefix + hex.EncodeToString(hash[:])[:16-len(discordBridgeUuidPrefix)]
+}
+
+func isDiscordUserMuted(discordUserId string) (muted bool, err error) {
+	err = db.QueryRow("SELECT EXISTS (SELECT * FROM discordBridgeMutes WHERE discordUserId = ?)", discordUserId).Scan(&muted)
+
+	return muted, err
+}
+
+func getDiscordBridgeMutes() (discordUserIds []string, err error) {
+	results, err := db.Query("SELECT discordUserId FROM discordBridgeMutes ORDER BY timestampMuted")
+	if err != nil {
+		return discordUserIds, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		var discordUserId string
+
+		err := results.Scan(&discordUserId)
+		if err != nil {
+			return discordUserIds, err
+		}
+
+		discordUserIds = append(discordUserIds, discordUserId)
+	}
+
+	return discordUserIds, nil
+}
+
+func muteDiscordUser(discordUserId string) error {
+	_, err := db.Exec("INSERT INTO discordBridgeMutes (discordUserId, timestampMuted) VALUES (?, UTC_TIMESTAMP()) "+db.upsertIgnore("discordUserId"), discordUserId)
+
+	return err
+}
+
+func unmuteDiscordUser(discordUserId string) error {
+	_, err := db.Exec("DELETE FROM discordBridgeMutes WHERE discordUserId = ?", discordUserId)
+
+	return err
+}
+
+func adminDiscordBridge(w http.ResponseWriter, r *http.Request) {
+	_, _, rank, _, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
+	if rank == 0 {
+		handleError(w, r, "access denied")
+		return
+	}
+
+	query := r.URL.Query()
+
+	switch query.Get("command") {
+	case "list":
+		discordUserIds, err := getDiscordBridgeMutes()
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+
+		discordUserIdsJson, err := json.Marshal(discordUserIds)
+		if err != nil {
+			handleError(w, r, "error while marshaling")
+			return
+		}
+
+		w.Write(discordUserIdsJson)
+	case "mute", "unmute":
+		userParam := query.Get("user")
+		if userParam == "" {
+			handleError(w, r, "user not specified")
+			return
+		}
+
+		var err error
+		if query.Get("command") == "mute" {
+			err = muteDiscordUser(userParam)
+		} else {
+			err = unmuteDiscordUser(userParam)
+		}
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+
+		w.Write([]byte("ok"))
+	default:
+		handleError(w, r, "unknown command")
+	}
+}
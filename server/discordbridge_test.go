@@ -0,0 +1,59 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestGetDiscordBridgeName(t *testing.T) {
+	tests := []struct {
+		nick       string
+		globalName string
+		username   string
+		expected   string
+	}{
+		{nick: "Nick", globalName: "Global", username: "user", expected: "Nick"},
+		{nick: "", globalName: "Global", username: "user", expected: "Global"},
+		{nick: "Nick Name", globalName: "Global", username: "user", expected: "Global"},
+		{nick: "VeryLongNickname", globalName: "", username: "user", expected: "user"},
+		{nick: "", globalName: "Name\uffffp\ufffe", username: "user.name_1", expected: "username1"},
+		{nick: "", globalName: "", username: "a.very.long.user.name", expected: "averylonguse"},
+		{nick: "", globalName: "", username: "._.", expected: ""},
+	}
+
+	for _, test := range tests {
+		m := &discordgo.MessageCreate{Message: &discordgo.Message{
+			Author: &discordgo.User{Username: test.username, GlobalName: test.globalName},
+			Member: &discordgo.Member{Nick: test.nick},
+		}}
+
+		if actual := getDiscordBridgeName(m); actual != test.expected {
+			t.Errorf("getDiscordBridgeName(%q, %q, %q) = %q, expected %q", test.nick, test.globalName, test.username, actual, test.expected)
+		}
+	}
+}
+
+func TestDiscordBridgeDelimReplacer(t *testing.T) {
+	content := "hi" + delim + "gsay" + mdelim + "p"
+	if actual := discordBridgeDelimReplacer.Replace(content); actual != "higsayp" {
+		t.Errorf("replaced delimiters = %q", actual)
+	}
+}
@@ -0,0 +1,174 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tiers of event locations, each with its own depth range
+const (
+	eventLocationTierDaily = iota
+	eventLocationTierDaily2
+	eventLocationTierWeekly
+	eventLocationTierWeekend
+	eventLocationTierFree
+)
+
+const (
+	remoteEventLocationMaxAttempts = 3
+	remoteEventLocationRetryDelay  = time.Second
+)
+
+// EventLocationProvider sources the locations used for a game's expeditions.
+// Games without a registered provider draw from their eventlocations pool.
+type EventLocationProvider interface {
+	// getEventLocations returns the locations to add for an expedition of the given tier
+	getEventLocations(tier int) ([]*EventLocationData, error)
+	// getEventLocationCount returns how many locations are available for the given tier
+	getEventLocationCount(tier int) int
+}
+
+var eventLocationProviders = map[string]EventLocationProvider{
+	"2kki": &remote2kkiEventLocationProvider{},
+}
+
+func getEventLocationProvider(gameId string) EventLocationProvider {
+	if provider, ok := eventLocationProviders[gameId]; ok {
+		return provider
+	}
+
+	return &poolEventLocationProvider{gameId: gameId}
+}
+
+// poolEventLocationProvider picks a random location from the pools loaded from eventlocations/<game>.json
+type poolEventLocationProvider struct {
+	gameId string
+}
+
+func (p *poolEventLocationProvider) getPool(tier int) []*EventLocationData {
+	switch tier {
+	case eventLocationTierDaily:
+		return gameDailyEventLocationPools[p.gameId]
+	case eventLocationTierDaily2:
+		return gameDailyEventLocation2Pools[p.gameId]
+	case eventLocationTierWeekly:
+		return gameWeeklyEventLocationPools[p.gameId]
+	case eventLocationTierWeekend:
+		return gameWeekendEventLocationPools[p.gameId]
+	case eventLocationTierFree:
+		if p.gameId == getConfig().gameName {
+			return freeEventLocationPool
+		}
+	}
+
+	return nil
+}
+
+func (p *poolEventLocationProvider) getEventLocations(tier int) ([]*EventLocationData, error) {
+	pool := p.getPool(tier)
+	if len(pool) == 0 {
+		return nil, nil
+	}
+
+	return []*EventLocationData{pool[rand.Intn(len(pool))]}, nil
+}
+
+func (p *poolEventLocationProvider) getEventLocationCount(tier int) int {
+	return len(p.getPool(tier))
+}
+
+// remote2kkiEventLocationProvider queries random locations from the Yume 2kki Explorer API,
+// falling back to stored ones while it is unavailable
+type remote2kkiEventLocationProvider struct{}
+
+func (p *remote2kkiEventLocationProvider) getDepthRange(tier int) (minDepth int, maxDepth int) {
+	switch tier {
+	case eventLocationTierDaily:
+		return daily2kkiEventLocationMinDepth, daily2kkiEventLocationMaxDepth
+	case eventLocationTierDaily2:
+		return daily2kkiEventLocation2MinDepth, daily2kkiEventLocation2MaxDepth
+	case eventLocationTierWeekly:
+		return weekly2kkiEventLocationMinDepth, weekly2kkiEventLocationMaxDepth
+	case eventLocationTierWeekend:
+		return weekend2kkiEventLocationMinDepth, weekend2kkiEventLocationMaxDepth
+	default:
+		return getConfig().freeEventLocations.minDepth, getConfig().freeEventLocations.maxDepth
+	}
+}
+
+func (p *remote2kkiEventLocationProvider) getEventLocations(tier int) ([]*EventLocationData, error) {
+	minDepth, maxDepth := p.getDepthRange(tier)
+
+	for attempt := 1; ; attempt++ {
+		eventLocations, err := p.fetchEventLocations(minDepth, maxDepth)
+		if err == nil {
+			return eventLocations, nil
+		}
+
+		// retrying is pointless while the circuit breaker refuses requests
+		if attempt == remoteEventLocationMaxAttempts || errors.Is(err, errCircuitOpen) || errors.Is(err, errUpstreamBusy) {
+			writeErrLog("SERVER", "2kki", "Falling back to stored event locations: "+err.Error())
+			break
+		}
+
+		time.Sleep(remoteEventLocationRetryDelay << (attempt - 1))
+	}
+
+	// previously fetched locations are kept in gameLocations
+	return getFallbackEventLocations("2kki", minDepth, maxDepth)
+}
+
+func (p *remote2kkiEventLocationProvider) fetchEventLocations(minDepth int, maxDepth int) ([]*EventLocationData, error) {
+	url := "https://2kki.app/getRandomLocations?ignoreSecret=1&minDepth=" + strconv.Itoa(minDepth)
+	if maxDepth >= minDepth {
+		url += "&maxDepth=" + strconv.Itoa(maxDepth)
+	}
+
+	body, err := explorerApiBreaker.get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(string(body), "{\"error\"") {
+		return nil, errors.New("Invalid event location data: " + string(body))
+	}
+
+	var eventLocations []*EventLocationData
+	err = json.Unmarshal(body, &eventLocations)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(eventLocations) == 0 {
+		return nil, errors.New("no event locations returned")
+	}
+
+	return eventLocations, nil
+}
+
+func (p *remote2kkiEventLocationProvider) getEventLocationCount(tier int) int {
+	// locations are fetched on demand, so the remote pool is never considered too small
+	return math.MaxInt
+}
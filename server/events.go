@@ -18,9 +18,13 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"hash/crc32"
+	"image"
+	"image/png"
 	"math"
 	"math/rand"
 	"net/http"
@@ -28,6 +32,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -35,13 +40,29 @@ type EventPeriod struct {
 	Id            int       `json:"-"`
 	PeriodOrdinal int       `json:"periodOrdinal"`
 	EndDate       time.Time `json:"endDate"`
+	WeeklyExpCap  int       `json:"weeklyExpCap"`
+	EnableVms     bool      `json:"enableVms"`
+
+	NextRollover       time.Time `json:"nextRollover"`
+	NextWeeklyRollover time.Time `json:"nextWeeklyRollover"`
+}
+
+type GameEventPeriod struct {
+	PeriodId      int       `json:"periodId"`
+	Game          string    `json:"game"`
+	PeriodOrdinal int       `json:"periodOrdinal"`
+	StartDate     time.Time `json:"startDate"`
+	EndDate       time.Time `json:"endDate"`
+	WeeklyExpCap  *int      `json:"weeklyExpCap,omitempty"`
 	EnableVms     bool      `json:"enableVms"`
 }
 
 type EventExp struct {
-	WeekExp   int `json:"weekExp"`
-	PeriodExp int `json:"periodExp"`
-	TotalExp  int `json:"totalExp"`
+	WeekExp       int `json:"weekExp"`
+	PeriodExp     int `json:"periodExp"`
+	TotalExp      int `json:"totalExp"`
+	CurrentStreak int `json:"currentStreak"`
+	BestStreak    int `json:"bestStreak"`
 }
 
 type EventLocation struct {
@@ -67,8 +88,47 @@ type EventVm struct {
 }
 
 type EventsData struct {
-	Locations []*EventLocation `json:"locations"`
-	Vms       []*EventVm       `json:"vms"`
+	Locations     []*EventLocation `json:"locations"`
+	Vms           []*EventVm       `json:"vms"`
+	ExpMultiplier float64          `json:"expMultiplier"`
+	// remaining free expeditions for the day, or -1 if unlimited
+	FreeLocationQuota int `json:"freeLocationQuota"`
+}
+
+// EventExpMultiplier is a bonus window during which event exp is multiplied.
+// An empty Game applies the window to every game.
+type EventExpMultiplier struct {
+	Id         int       `json:"id"`
+	Game       string    `json:"game,omitempty"`
+	Multiplier float64   `json:"multiplier"`
+	StartTime  time.Time `json:"startTime"`
+	EndTime    time.Time `json:"endTime"`
+}
+
+type EventHistoryEntry struct {
+	EventId       int       `json:"eventId"`
+	Type          int       `json:"type"`
+	Game          string    `json:"game"`
+	Title         string    `json:"title,omitempty"`
+	TitleJP       string    `json:"titleJP,omitempty"`
+	Exp           int       `json:"exp"`
+	DateCompleted time.Time `json:"dateCompleted"`
+}
+
+type EventHistory struct {
+	Entries    []*EventHistoryEntry `json:"entries"`
+	TotalCount int                  `json:"totalCount"`
+}
+
+type EventLeaderboardEntry struct {
+	Uuid          string    `json:"uuid"`
+	Name          string    `json:"name"`
+	DateCompleted time.Time `json:"dateCompleted"`
+}
+
+type cachedEventLeaderboard struct {
+	entries    []*EventLeaderboardEntry
+	expiration time.Time
 }
 
 type EventLocationData struct {
@@ -103,11 +163,18 @@ const (
 	eventLocationCountWeeklyThreshold  = 3
 	eventLocationCountWeekendThreshold = 5
 
-	freeEventLocationMinDepth = 2
+	defaultFreeEventLocationMinDepth = 2
 
 	eventVmExp = 4
 
-	weeklyExpCap = 50
+	maxEventVmImageScale = 4
+
+	eventHistoryPageSize = 25
+
+	eventLeaderboardSize          = 10
+	eventLeaderboardCacheDuration = time.Minute
+
+	defaultWeeklyExpCap = 50
 
 	gameEventShareFactor = 0.25
 )
@@ -143,11 +210,33 @@ var (
 
 	gameEventLocations map[string][]*EventLocationData
 	gameLocationColors map[string][]string
+
+	eventLeaderboards    = make(map[[2]int]*cachedEventLeaderboard)
+	eventLeaderboardsMtx sync.Mutex
+
+	eventVmImages    = make(map[[3]int]*cachedEventVmImage)
+	eventVmImagesMtx sync.Mutex
 )
 
 func initEvents() {
 	logInitTask("events")
 
+	if isMainServer && isClusterLeader() {
+		openNextEventPeriod()
+	}
+
+	// pick up periods opened at rollover by the cluster leader of the main server
+	scheduleTask("eventPeriodRefresh", scheduler.Every(1).Day().At(formatEventRolloverTime(time.Minute)), func() {
+		if isMainServer && isClusterLeader() {
+			return
+		}
+
+		err := refreshCurrentEventPeriod()
+		if err != nil {
+			handleInternalEventError(-1, err)
+		}
+	})
+
 	err := setCurrentEventPeriodId()
 	if err != nil {
 		return
@@ -171,13 +260,23 @@ func initEvents() {
 
 	setGameEventLocationPoolsAndLocationColors()
 
+	// remind players 2 hours before event locations expire
+	scheduleTask("eventExpiryReminders", scheduler.Every(1).Day().At(formatEventRolloverTime(-2*time.Hour)), sendEventExpiryReminders)
+
 	if !isMainServer {
 		return
 	}
 
 	db.QueryRow("SELECT COUNT(*) FROM eventLocations el").Scan(&eventsCount)
 
-	scheduler.Every(1).Day().At("00:00").Do(func() {
+	// every process of the main server keeps the event location pools, so any of them can take over as cluster leader
+	scheduleTask("eventRollover", scheduler.Every(1).Day().At(formatEventRolloverTime(0)), func() {
+		if !isClusterLeader() {
+			return
+		}
+
+		openNextEventPeriod()
+
 		err := setCurrentEventPeriodId()
 		if err != nil {
 			return
@@ -197,7 +296,7 @@ func initEvents() {
 		addDailyEventLocation(true)
 		eventsCount += 2
 
-		switch time.Now().UTC().Weekday() {
+		switch getEventTime().Weekday() {
 		case time.Sunday:
 			addWeeklyEventLocation()
 			addEventVm()
@@ -224,7 +323,7 @@ func initEvents() {
 		}, nil)
 	})
 
-	scheduler.Every(5).Minutes().Do(func() {
+	scheduleTask("eventListCheck", scheduler.Every(5).Minutes(), func() {
 		var newEventLocationsCount int
 		db.QueryRow("SELECT COUNT(*) FROM eventLocations").Scan(&newEventLocationsCount)
 		if newEventLocationsCount != eventsCount {
@@ -233,24 +332,28 @@ func initEvents() {
 		}
 	})
 
+	if !isClusterLeader() {
+		return
+	}
+
 	var count int
 
 	// daily easy expedition
-	db.QueryRow("SELECT COUNT(el.id) FROM eventLocations el JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId WHERE el.type = 0 AND ep.id = ? AND el.startDate = UTC_DATE() AND el.exp = 1", currentEventPeriodId).Scan(&count)
+	db.QueryRow(withEventDate("SELECT COUNT(el.id) FROM eventLocations el JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId WHERE el.type = 0 AND ep.id = ? AND el.startDate = UTC_DATE() AND el.exp = 1"), currentEventPeriodId).Scan(&count)
 	if count == 0 {
 		addDailyEventLocation(false)
 	}
 
 	// daily deeper expedition
-	db.QueryRow("SELECT COUNT(el.id) FROM eventLocations el JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId WHERE el.type = 0 AND ep.id = ? AND el.startDate = UTC_DATE() AND el.exp = 3", currentEventPeriodId).Scan(&count)
+	db.QueryRow(withEventDate("SELECT COUNT(el.id) FROM eventLocations el JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId WHERE el.type = 0 AND ep.id = ? AND el.startDate = UTC_DATE() AND el.exp = 3"), currentEventPeriodId).Scan(&count)
 	if count == 0 {
 		addDailyEventLocation(true)
 	}
 
-	weekday := time.Now().UTC().Weekday()
+	weekday := getEventTime().Weekday()
 
 	// weekly expedition
-	db.QueryRow("SELECT COUNT(el.id) FROM eventLocations el JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId WHERE el.type = 1 AND ep.id = ? AND el.startDate = DATE_SUB(UTC_DATE(), INTERVAL ? DAY)", currentEventPeriodId, int(weekday)).Scan(&count)
+	db.QueryRow(withEventDate("SELECT COUNT(el.id) FROM eventLocations el JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId WHERE el.type = 1 AND ep.id = ? AND el.startDate = DATE_SUB(UTC_DATE(), INTERVAL ? DAY)"), currentEventPeriodId, int(weekday)).Scan(&count)
 	if count == 0 {
 		addWeeklyEventLocation()
 	}
@@ -264,7 +367,7 @@ func initEvents() {
 		lastVmWeekday = time.Tuesday
 	case time.Friday, time.Saturday:
 		// weekend expedition
-		db.QueryRow("SELECT COUNT(el.id) FROM eventLocations el JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId WHERE el.type = 2 AND ep.id = ? AND el.startDate = DATE_SUB(UTC_DATE(), INTERVAL ? DAY)", currentEventPeriodId, int(weekday-time.Friday)).Scan(&count)
+		db.QueryRow(withEventDate("SELECT COUNT(el.id) FROM eventLocations el JOIN gameEventPeriods gep ON gep.id = el.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId WHERE el.type = 2 AND ep.id = ? AND el.startDate = DATE_SUB(UTC_DATE(), INTERVAL ? DAY)"), currentEventPeriodId, int(weekday-time.Friday)).Scan(&count)
 		if count == 0 {
 			addWeekendEventLocation()
 		}
@@ -273,154 +376,241 @@ func initEvents() {
 	}
 
 	// vending machine expedition
-	db.QueryRow("SELECT ev.mapId, ev.eventId FROM eventVms ev JOIN gameEventPeriods gep ON gep.id = ev.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId WHERE ep.id = ? AND ev.startDate = DATE_SUB(UTC_DATE(), INTERVAL ? DAY)", currentEventPeriodId, int(weekday-lastVmWeekday)).Scan(&currentEventVmMapId, &currentEventVmEventId)
+	db.QueryRow(withEventDate("SELECT ev.mapId, ev.eventId FROM eventVms ev JOIN gameEventPeriods gep ON gep.id = ev.gamePeriodId JOIN eventPeriods ep ON ep.id = gep.periodId WHERE ep.id = ? AND ev.startDate = DATE_SUB(UTC_DATE(), INTERVAL ? DAY)"), currentEventPeriodId, int(weekday-lastVmWeekday)).Scan(&currentEventVmMapId, &currentEventVmEventId)
 	if currentEventVmMapId == 0 && currentEventVmEventId == 0 {
 		addEventVm()
 	}
 }
 
-func sendEventsUpdate() {
-	for _, client := range clients.Get() {
-		if client.account {
-			client.handleE()
-		}
+// getEventTime returns the current UTC time shifted back by the rollover offset,
+// so that its date and weekday match the current event day
+func getEventTime() time.Time {
+	return time.Now().UTC().Add(-getConfig().eventRolloverOffset)
+}
+
+// withEventDate adjusts UTC_DATE() in an event query to the current event day
+func withEventDate(query string) string {
+	if getConfig().eventRolloverOffset == 0 {
+		return query
 	}
+
+	return strings.ReplaceAll(query, "UTC_DATE()", fmt.Sprintf("DATE(DATE_SUB(UTC_TIMESTAMP(), INTERVAL %d MINUTE))", int(getConfig().eventRolloverOffset.Minutes())))
 }
 
-func addDailyEventLocation(deeper bool) {
-	var pools map[string][]*EventLocationData
-	if !deeper {
-		pools = gameDailyEventLocationPools
-	} else {
-		pools = gameDailyEventLocation2Pools
+// formatEventRolloverTime returns the UTC time of day of the rollover shifted by the given offset, for use with the scheduler
+func formatEventRolloverTime(offset time.Duration) string {
+	return time.Time{}.Add(getConfig().eventRolloverOffset + 24*time.Hour + offset).Format("15:04")
+}
+
+func getNextEventRollovers() (nextRollover time.Time, nextWeeklyRollover time.Time) {
+	eventTime := getEventTime()
+
+	today := time.Date(eventTime.Year(), eventTime.Month(), eventTime.Day(), 0, 0, 0, 0, time.UTC)
+
+	nextRollover = today.AddDate(0, 0, 1).Add(getConfig().eventRolloverOffset)
+	nextWeeklyRollover = today.AddDate(0, 0, 7-int(eventTime.Weekday())).Add(getConfig().eventRolloverOffset)
+
+	return nextRollover, nextWeeklyRollover
+}
+
+// openNextEventPeriod starts a new period once the current one ends, if automatic periods are enabled
+func openNextEventPeriod() {
+	if getConfig().eventPeriodLength <= 0 {
+		return
 	}
 
-	gameId, err := getRandomGameForEventLocation(pools, eventLocationCountDailyThreshold)
+	endedPeriodId, err := writeNextEventPeriod(getConfig().eventPeriodLength)
 	if err != nil {
-		handleInternalEventError(0, err)
+		handleInternalEventError(-1, err)
 		return
 	}
 
-	if gameId == "2kki" {
-		if !deeper {
-			add2kkiEventLocation(0, daily2kkiEventLocationMinDepth, daily2kkiEventLocationMaxDepth, dailyEventLocationExp)
-		} else {
-			add2kkiEventLocation(0, daily2kkiEventLocation2MinDepth, daily2kkiEventLocation2MaxDepth, dailyEventLocation2Exp)
-		}
-	} else {
-		if !deeper {
-			addEventLocation(gameId, 0, dailyEventLocationExp, pools)
-		} else {
-			addEventLocation(gameId, 0, dailyEventLocation2Exp, pools)
-		}
+	if endedPeriodId != 0 {
+		writeLog("SERVER", "Events", "Opened next event period", 200)
+
+		dispatchWebhook(webhookEventPeriodEnded, map[string]int{"periodId": endedPeriodId})
 	}
 }
 
-func addWeeklyEventLocation() {
-	gameId, err := getRandomGameForEventLocation(gameWeeklyEventLocationPools, eventLocationCountWeeklyThreshold)
+// refreshCurrentEventPeriod reloads the active period after it was changed through the admin API
+func refreshCurrentEventPeriod() error {
+	err := setCurrentEventPeriodId()
 	if err != nil {
-		handleInternalEventError(1, err)
-		return
+		return err
 	}
 
-	if gameId == "2kki" {
-		add2kkiEventLocation(1, weekly2kkiEventLocationMinDepth, weekly2kkiEventLocationMaxDepth, weeklyEventLocationExp)
-	} else {
-		addEventLocation(gameId, 1, weeklyEventLocationExp, gameWeeklyEventLocationPools)
+	err = setCurrentGameEventPeriodId()
+	if err != nil {
+		return err
 	}
+
+	if isMainServer {
+		gameCurrentEventPeriods, err = getGameCurrentEventPeriodsData()
+		if err != nil {
+			return err
+		}
+	}
+
+	sendEventsUpdate()
+
+	return nil
 }
 
-func addWeekendEventLocation() {
-	gameId, err := getRandomGameForEventLocation(gameWeekendEventLocationPools, eventLocationCountWeekendThreshold)
+// sendEventExpiryReminders notifies players of event locations expiring at the end of the day that they haven't completed yet
+func sendEventExpiryReminders() {
+	expiringCount, completionCounts, err := getExpiringEventLocationCompletionCounts()
 	if err != nil {
-		handleInternalEventError(2, err)
+		handleInternalEventError(-1, err)
 		return
 	}
 
-	if gameId == "2kki" {
-		add2kkiEventLocation(2, weekend2kkiEventLocationMinDepth, weekend2kkiEventLocationMaxDepth, weekendEventLocationExp)
-	} else {
-		addEventLocation(gameId, 2, weekendEventLocationExp, gameWeekendEventLocationPools)
+	if expiringCount == 0 {
+		return
 	}
-}
 
-func addEventLocation(gameId string, eventType int, exp int, pools map[string][]*EventLocationData) {
-	addPlayerEventLocation(gameId, eventType, exp, pools[gameId], "")
-}
+	for _, client := range clients.Get() {
+		if !client.account {
+			continue
+		}
 
-// eventType: 0 - daily, 1 - weekly, 2 - weekend, 3 - manual
-func addPlayerEventLocation(gameId string, eventType int, exp int, pool []*EventLocationData, playerUuid string) {
-	eventLocation := pool[rand.Intn(len(pool))]
+		if incompleteCount := expiringCount - completionCounts[client.uuid]; incompleteCount > 0 {
+			client.outbox <- buildMsg("eer", incompleteCount)
+		}
+	}
+}
 
-	var gameEventPeriodId int
-	if gameId == config.gameName {
-		gameEventPeriodId = currentGameEventPeriodId
-	} else {
-		gameEventPeriodId = gameCurrentEventPeriods[gameId].Id
+func sendEventsUpdate() {
+	for _, client := range clients.Get() {
+		if client.account {
+			client.handleE()
+		}
 	}
+}
 
-	var err error
-	if playerUuid == "" {
-		err = writeEventLocationData(gameId, gameEventPeriodId, eventType, eventLocation.Title, eventLocation.TitleJP, eventLocation.Depth, eventLocation.MinDepth, exp, eventLocation.MapIds)
+func addDailyEventLocation(deeper bool) {
+	if !deeper {
+		addEventLocation(eventLocationTierDaily, 0, dailyEventLocationExp, eventLocationCountDailyThreshold)
 	} else {
-		err = writePlayerEventLocationData(gameId, gameEventPeriodId, playerUuid, eventLocation.Title, eventLocation.TitleJP, eventLocation.Depth, eventLocation.MinDepth, eventLocation.MapIds)
+		addEventLocation(eventLocationTierDaily2, 0, dailyEventLocation2Exp, eventLocationCountDailyThreshold)
 	}
+}
+
+func addWeeklyEventLocation() {
+	addEventLocation(eventLocationTierWeekly, 1, weeklyEventLocationExp, eventLocationCountWeeklyThreshold)
+}
+
+func addWeekendEventLocation() {
+	addEventLocation(eventLocationTierWeekend, 2, weekendEventLocationExp, eventLocationCountWeekendThreshold)
+}
+
+// eventType: 0 - daily, 1 - weekly, 2 - weekend, 3 - manual
+func addEventLocation(tier int, eventType int, exp int, eventLocationCountThreshold int) {
+	gameId, err := getRandomGameForEventLocation(tier, eventLocationCountThreshold)
 	if err != nil {
 		handleInternalEventError(eventType, err)
+		return
 	}
-}
 
-func add2kkiEventLocation(eventType int, minDepth int, maxDepth int, exp int) {
 	var gameEventPeriodId int
-	if config.gameName == "2kki" {
+	if gameId == getConfig().gameName {
 		gameEventPeriodId = currentGameEventPeriodId
 	} else {
-		gameEventPeriodId = gameCurrentEventPeriods["2kki"].Id
-	}
-	addPlayer2kkiEventLocation(gameEventPeriodId, eventType, minDepth, maxDepth, exp, "")
-}
-
-// eventType: 0 - daily, 1 - weekly, 2 - weekend, 3 - manual
-func addPlayer2kkiEventLocation(gameEventPeriodId int, eventType int, minDepth int, maxDepth int, exp int, playerUuid string) {
-	url := "https://2kki.app/getRandomLocations?ignoreSecret=1&minDepth=" + strconv.Itoa(minDepth)
-	if maxDepth >= minDepth {
-		url += "&maxDepth=" + strconv.Itoa(maxDepth)
+		gameEventPeriodId = gameCurrentEventPeriods[gameId].Id
 	}
 
-	resp, err := http.Get(url)
+	eventLocations, err := getEventLocationProvider(gameId).getEventLocations(tier)
 	if err != nil {
 		handleInternalEventError(eventType, err)
 		return
 	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+
+	for _, eventLocation := range eventLocations {
+		err = writeEventLocationData(gameId, gameEventPeriodId, eventType, eventLocation.Title, eventLocation.TitleJP, eventLocation.Depth, eventLocation.MinDepth, exp, eventLocation.MapIds)
+		if err != nil {
+			handleInternalEventError(eventType, err)
+		}
+	}
+}
+
+// addPlayerEventLocation adds a free expedition for the player, which awards no exp
+func addPlayerEventLocation(playerUuid string) {
+	remainingQuota, onCooldown, err := getPlayerFreeEventLocationQuota(playerUuid)
 	if err != nil {
-		handleInternalEventError(eventType, err)
+		handleInternalEventError(-1, err)
 		return
 	}
 
-	if strings.HasPrefix(string(body), "{\"error\"") {
-		handleEventError(eventType, "Invalid event location data: "+string(body))
+	if remainingQuota == 0 || onCooldown {
 		return
 	}
 
-	var eventLocations []EventLocationData
-	err = json.Unmarshal(body, &eventLocations)
+	eventLocations, err := getEventLocationProvider(getConfig().gameName).getEventLocations(eventLocationTierFree)
 	if err != nil {
-		handleInternalEventError(eventType, err)
+		handleInternalEventError(-1, err)
 		return
 	}
 
 	for _, eventLocation := range eventLocations {
-		if playerUuid == "" {
-			err = writeEventLocationData("2kki", gameEventPeriodId, eventType, eventLocation.Title, eventLocation.TitleJP, eventLocation.Depth, eventLocation.MinDepth, exp, eventLocation.MapIds)
-		} else {
-			err = writePlayerEventLocationData("2kki", gameEventPeriodId, playerUuid, eventLocation.Title, eventLocation.TitleJP, eventLocation.Depth, eventLocation.MinDepth, eventLocation.MapIds)
+		err = writePlayerEventLocationData(getConfig().gameName, currentGameEventPeriodId, playerUuid, eventLocation.Title, eventLocation.TitleJP, eventLocation.Depth, eventLocation.MinDepth, eventLocation.MapIds)
+		if err != nil {
+			handleInternalEventError(-1, err)
 		}
+	}
+}
+
+// getPlayerFreeEventLocationQuota returns how many more free expeditions the player can receive today (-1 if unlimited)
+// and whether the player completed one too recently to receive another
+func getPlayerFreeEventLocationQuota(playerUuid string) (remainingQuota int, onCooldown bool, err error) {
+	remainingQuota = -1
+
+	if getConfig().freeEventLocations.dailyQuota > 0 {
+		count, err := getPlayerFreeEventLocationCount(playerUuid)
 		if err != nil {
-			handleInternalEventError(eventType, err)
+			return 0, false, err
 		}
+
+		remainingQuota = max(getConfig().freeEventLocations.dailyQuota-count, 0)
 	}
+
+	if getConfig().freeEventLocations.cooldown > 0 {
+		lastCompleted, err := getPlayerLastFreeEventLocationCompletion(playerUuid)
+		if err != nil {
+			return remainingQuota, false, err
+		}
+
+		onCooldown = time.Since(lastCompleted) < getConfig().freeEventLocations.cooldown
+	}
+
+	return remainingQuota, onCooldown, nil
+}
+
+// addManualEventLocation adds a custom expedition lasting the given number of days, starting today
+func addManualEventLocation(gameId string, title string, titleJP string, depth int, minDepth int, exp int, mapIds []string, days int) error {
+	gameEventPeriodId := currentGameEventPeriodId
+
+	// the periods of other games are only kept by the main server
+	if gameId != getConfig().gameName {
+		var err error
+		gameEventPeriodId, err = getGameCurrentEventPeriodId(gameId)
+		if err != nil {
+			return err
+		}
+	}
+
+	if gameEventPeriodId <= 0 {
+		return errors.New("no active event period for " + gameId)
+	}
+
+	err := writeEventLocationDataForDays(gameId, gameEventPeriodId, 3, title, titleJP, depth, minDepth, exp, mapIds, 0, days)
+	if err != nil {
+		return err
+	}
+
+	eventsCount++
+
+	sendEventsUpdate()
+
+	return nil
 }
 
 func get2kkiEventLocationData(locationName string) (*EventLocationData, error) {
@@ -429,12 +619,7 @@ func get2kkiEventLocationData(locationName string) (*EventLocationData, error) {
 	v.Set("ignoreRemoved", "1")
 
 	url := fmt.Sprintf("https://2kki.app/getLocationInfo?%s", v.Encode())
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	body, err := explorerApiBreaker.get(url)
 	if err != nil {
 		return nil, err
 	}
@@ -474,6 +659,143 @@ func addEventVm() {
 	}
 }
 
+// applyWeeklyExpCap limits exp so the player's exp for the week doesn't exceed the cap
+func applyWeeklyExpCap(exp int, weekExp int, weeklyExpCap int) int {
+	if weeklyExpCap < 0 {
+		return exp
+	}
+	if weekExp >= weeklyExpCap {
+		return 0
+	}
+	if weekExp+exp > weeklyExpCap {
+		return weeklyExpCap - weekExp
+	}
+	return exp
+}
+
+func applyEventExpMultiplier(exp int, multiplier float64) int {
+	return int(math.Round(float64(exp) * multiplier))
+}
+
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	commandParam := r.URL.Query().Get("command")
+	if commandParam == "" {
+		handleError(w, r, "command not specified")
+		return
+	}
+
+	switch commandParam {
+	case "history":
+		token := r.Header.Get("Authorization")
+		if token == "" {
+			handleError(w, r, "token not specified")
+			return
+		}
+
+		uuid, _, _, _, banned, _ := getPlayerDataFromToken(token)
+		if uuid == "" {
+			handleError(w, r, "invalid token")
+			return
+		}
+
+		if banned {
+			handleError(w, r, "player is banned")
+			return
+		}
+
+		page := 1
+		if pageParam := r.URL.Query().Get("page"); pageParam != "" {
+			var err error
+			page, err = strconv.Atoi(pageParam)
+			if err != nil || page < 1 {
+				handleError(w, r, "invalid page")
+				return
+			}
+		}
+
+		eventHistory, err := getPlayerEventHistory(r.Context(), uuid, eventHistoryPageSize, (page-1)*eventHistoryPageSize)
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+
+		eventHistoryJson, err := json.Marshal(eventHistory)
+		if err != nil {
+			handleError(w, r, "error while marshaling")
+			return
+		}
+
+		w.Write(eventHistoryJson)
+	case "leaderboard":
+		eventId, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			handleError(w, r, "invalid id")
+			return
+		}
+
+		var eventType int
+		if typeParam := r.URL.Query().Get("type"); typeParam != "" {
+			eventType, err = strconv.Atoi(typeParam)
+			if err != nil || (eventType != 0 && eventType != 2) {
+				handleError(w, r, "invalid type")
+				return
+			}
+		}
+
+		leaderboard, err := getCachedEventLeaderboard(eventType, eventId)
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+
+		leaderboardJson, err := json.Marshal(leaderboard)
+		if err != nil {
+			handleError(w, r, "error while marshaling")
+			return
+		}
+
+		w.Write(leaderboardJson)
+	default:
+		handleError(w, r, "unknown command")
+	}
+}
+
+// getCachedEventLeaderboard returns the first players to complete an event location (type 0) or VM (type 2)
+func getCachedEventLeaderboard(eventType int, eventId int) ([]*EventLeaderboardEntry, error) {
+	key := [2]int{eventType, eventId}
+
+	eventLeaderboardsMtx.Lock()
+	cached, ok := eventLeaderboards[key]
+	eventLeaderboardsMtx.Unlock()
+
+	if ok && time.Now().Before(cached.expiration) {
+		return cached.entries, nil
+	}
+
+	// queried without holding the lock, so other leaderboards aren't held up by it
+	entries, err := getEventLeaderboard(eventType, eventId, eventLeaderboardSize)
+	if err != nil {
+		return nil, err
+	}
+
+	eventLeaderboardsMtx.Lock()
+	defer eventLeaderboardsMtx.Unlock()
+
+	// drop expired leaderboards so the cache doesn't grow indefinitely
+	for k, cached := range eventLeaderboards {
+		if time.Now().After(cached.expiration) {
+			delete(eventLeaderboards, k)
+		}
+	}
+
+	eventLeaderboards[key] = &cachedEventLeaderboard{
+		entries:    entries,
+		expiration: time.Now().Add(eventLeaderboardCacheDuration),
+	}
+
+	return entries, nil
+}
+
 func handleInternalEventError(eventType int, err error) {
 	handleEventError(eventType, err.Error())
 }
@@ -511,6 +833,82 @@ func setEventVms() {
 	}
 }
 
+type cachedEventVmImage struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+}
+
+// getEventVmImage returns the VM image at the given scale, reading it from disk only if it changed since it was cached
+func getEventVmImage(mapId int, eventId int, scale int) (*cachedEventVmImage, error) {
+	filename := "vms/Map" + fmt.Sprintf("%04d", mapId) + "_EV" + fmt.Sprintf("%04d", eventId) + ".png"
+
+	fileInfo, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	key := [3]int{mapId, eventId, scale}
+
+	eventVmImagesMtx.Lock()
+	vmImage, ok := eventVmImages[key]
+	eventVmImagesMtx.Unlock()
+
+	if ok && vmImage.modTime.Equal(fileInfo.ModTime()) {
+		return vmImage, nil
+	}
+
+	// read and scaled without holding the lock, so other images aren't held up by it
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if scale > 1 {
+		data, err = scaleImage(data, scale)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	vmImage = &cachedEventVmImage{
+		data:    data,
+		etag:    fmt.Sprintf("\"%08x\"", crc32.ChecksumIEEE(data)),
+		modTime: fileInfo.ModTime(),
+	}
+
+	eventVmImagesMtx.Lock()
+	eventVmImages[key] = vmImage
+	eventVmImagesMtx.Unlock()
+
+	return vmImage, nil
+}
+
+// scaleImage upscales a PNG image by an integer factor using nearest neighbor sampling
+func scaleImage(data []byte, scale int) ([]byte, error) {
+	src, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx()*scale, bounds.Dy()*scale))
+
+	for y := 0; y < dst.Bounds().Dy(); y++ {
+		for x := 0; x < dst.Bounds().Dx(); x++ {
+			dst.Set(x, y, src.At(bounds.Min.X+x/scale, bounds.Min.Y+y/scale))
+		}
+	}
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
 func setGameEventLocationPoolsAndLocationColors() {
 	if isMainServer {
 		gameDailyEventLocationPools = make(map[string][]*EventLocationData)
@@ -532,7 +930,7 @@ func setGameEventLocationPoolsAndLocationColors() {
 			gameIds = append(gameIds, gameId)
 		}
 	} else {
-		gameIds = append(gameIds, config.gameName)
+		gameIds = append(gameIds, getConfig().gameName)
 	}
 
 	for _, gameId := range gameIds {
@@ -567,7 +965,7 @@ func setGameEventLocationPoolsAndLocationColors() {
 		gameMaxDepth := math.Min(float64(gameMaxDepths[gameId]), 15)
 
 		for _, eventLocation := range eventLocations {
-			if gameId == config.gameName {
+			if gameId == getConfig().gameName {
 				var locationColors []string
 				locationColors = append(locationColors, eventLocation.FgColor, eventLocation.BgColor)
 				gameLocationColors[eventLocation.Title] = locationColors
@@ -599,7 +997,7 @@ func setGameEventLocationPoolsAndLocationColors() {
 					gameWeekendEventLocationPools[gameId] = append(gameWeekendEventLocationPools[gameId], eventLocation)
 				}
 			}
-			if gameId == config.gameName && adjustedDepth >= freeEventLocationMinDepth {
+			if gameId == getConfig().gameName && adjustedDepth >= getConfig().freeEventLocations.minDepth && (getConfig().freeEventLocations.maxDepth < getConfig().freeEventLocations.minDepth || adjustedDepth <= getConfig().freeEventLocations.maxDepth) {
 				freeEventLocationPool = append(freeEventLocationPool, eventLocation)
 			}
 		}
@@ -0,0 +1,160 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestPlayerFreeEventLocationsPerGame(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetMaxOpenConns(1)
+
+	prevDb, prevConfig := db, getConfig()
+	defer func() {
+		db = prevDb
+		currentConfig.Store(prevConfig)
+	}()
+
+	db = &Database{DB: conn, dialect: dialectSqlite}
+	currentConfig.Store(&Config{gameName: "2kki"})
+
+	err = runMigrations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a free expedition received and completed today in another game
+	gamePeriodId, err := db.ExecInsert("INSERT INTO gameEventPeriods (periodId, game) VALUES (?, ?)", 1, "yume")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.ExecInsert("INSERT INTO gameEventPeriods (periodId, game) VALUES (?, ?)", 1, "2kki")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eventId, err := db.ExecInsert("INSERT INTO playerEventLocations (gamePeriodId, locationId, uuid, startDate, endDate) VALUES (?, ?, ?, UTC_DATE(), DATE_ADD(UTC_DATE(), INTERVAL 1 DAY))", gamePeriodId, 1, "uuid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec("INSERT INTO eventCompletions (eventId, uuid, type, timestampCompleted, exp) VALUES (?, ?, 1, UTC_TIMESTAMP(), 0)", eventId, "uuid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		game          string
+		count         int
+		lastCompleted bool
+	}{
+		{"2kki", 0, false},
+		{"yume", 1, true},
+	} {
+		currentConfig.Store(&Config{gameName: test.game})
+
+		count, err := getPlayerFreeEventLocationCount("uuid")
+		if err != nil {
+			t.Fatalf("%s: counting free expeditions: %v", test.game, err)
+		}
+		if count != test.count {
+			t.Errorf("%s: free expedition count = %d, expected %d", test.game, count, test.count)
+		}
+
+		lastCompleted, err := getPlayerLastFreeEventLocationCompletion("uuid")
+		if err != nil {
+			t.Fatalf("%s: getting last free expedition completion: %v", test.game, err)
+		}
+		if lastCompleted.IsZero() == test.lastCompleted {
+			t.Errorf("%s: last free expedition completion = %v, expected completed: %t", test.game, lastCompleted, test.lastCompleted)
+		}
+	}
+}
+
+func TestGetGameCurrentEventPeriodId(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetMaxOpenConns(1)
+
+	prevDb, prevConfig := db, getConfig()
+	defer func() {
+		db = prevDb
+		currentConfig.Store(prevConfig)
+	}()
+
+	db = &Database{DB: conn, dialect: dialectSqlite}
+	currentConfig.Store(&Config{gameName: "2kki"})
+
+	err = runMigrations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a current period of another game, and an ended one
+	currentPeriodId, err := db.ExecInsert("INSERT INTO eventPeriods (periodOrdinal, startDate, endDate) VALUES (?, DATE_SUB(UTC_DATE(), INTERVAL 1 DAY), DATE_ADD(UTC_DATE(), INTERVAL 1 DAY))", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	endedPeriodId, err := db.ExecInsert("INSERT INTO eventPeriods (periodOrdinal, startDate, endDate) VALUES (?, DATE_SUB(UTC_DATE(), INTERVAL 2 DAY), DATE_SUB(UTC_DATE(), INTERVAL 1 DAY))", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gamePeriodId, err := db.ExecInsert("INSERT INTO gameEventPeriods (periodId, game) VALUES (?, ?)", currentPeriodId, "yume")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.ExecInsert("INSERT INTO gameEventPeriods (periodId, game) VALUES (?, ?)", endedPeriodId, "flow")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		game         string
+		gamePeriodId int
+	}{
+		{"yume", int(gamePeriodId)},
+		{"flow", 0},
+		{"2kki", 0},
+	}
+
+	for _, tt := range tests {
+		id, err := getGameCurrentEventPeriodId(tt.game)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.game, err)
+		}
+		if id != tt.gamePeriodId {
+			t.Errorf("%s: current game period id = %d, expected %d", tt.game, id, tt.gamePeriodId)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"unicode"
+)
+
+// Expression is a parsed condition expression such as
+// "var(102) >= 5 && switch(330)". Switch and var references are evaluated
+// against the client's switch and var caches.
+type Expression struct {
+	root exprNode
+
+	SwitchIds []int
+	VarIds    []int
+}
+
+type exprNode interface {
+	eval(switches map[int]bool, vars map[int]int) (int, bool)
+}
+
+type exprLiteral struct {
+	value int
+}
+
+type exprSwitch struct {
+	id int
+}
+
+type exprVar struct {
+	id int
+}
+
+type exprNot struct {
+	operand exprNode
+}
+
+type exprBinary struct {
+	op    string
+	left  exprNode
+	right exprNode
+}
+
+func (e *exprLiteral) eval(_ map[int]bool, _ map[int]int) (int, bool) {
+	return e.value, true
+}
+
+func (e *exprSwitch) eval(switches map[int]bool, _ map[int]int) (int, bool) {
+	value, ok := switches[e.id]
+	if !ok {
+		return 0, false
+	}
+	return boolToInt(value), true
+}
+
+func (e *exprVar) eval(_ map[int]bool, vars map[int]int) (int, bool) {
+	value, ok := vars[e.id]
+	return value, ok
+}
+
+func (e *exprNot) eval(switches map[int]bool, vars map[int]int) (int, bool) {
+	value, ok := e.operand.eval(switches, vars)
+	return boolToInt(value == 0), ok
+}
+
+func (e *exprBinary) eval(switches map[int]bool, vars map[int]int) (int, bool) {
+	left, ok := e.left.eval(switches, vars)
+	if !ok {
+		return 0, false
+	}
+
+	// short circuit so unsynced values on the other side don't block the result
+	switch e.op {
+	case "&&":
+		if left == 0 {
+			return 0, true
+		}
+	case "||":
+		if left != 0 {
+			return 1, true
+		}
+	}
+
+	right, ok := e.right.eval(switches, vars)
+	if !ok {
+		return 0, false
+	}
+
+	switch e.op {
+	case "&&", "||":
+		return boolToInt(right != 0), true
+	case "=", "==":
+		return boolToInt(left == right), true
+	case "!=":
+		return boolToInt(left != right), true
+	case "<":
+		return boolToInt(left < right), true
+	case ">":
+		return boolToInt(left > right), true
+	case "<=":
+		return boolToInt(left <= right), true
+	case ">=":
+		return boolToInt(left >= right), true
+	}
+
+	return 0, false
+}
+
+// Eval returns whether the expression holds. The second return value is false
+// if a referenced switch or var has not been synced yet.
+func (e *Expression) Eval(switches map[int]bool, vars map[int]int) (bool, bool) {
+	value, ok := e.root.eval(switches, vars)
+	return value != 0, ok
+}
+
+func (e *Expression) hasSwitch(switchId int) bool {
+	for _, sId := range e.SwitchIds {
+		if sId == switchId {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Expression) hasVar(varId int) bool {
+	for _, vId := range e.VarIds {
+		if vId == varId {
+			return true
+		}
+	}
+	return false
+}
+
+func boolToInt(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+type exprParser struct {
+	tokens []string
+	pos    int
+	expr   *Expression
+}
+
+func parseExpression(input string) (*Expression, error) {
+	tokens, err := tokenizeExpression(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("empty expression")
+	}
+
+	expr := &Expression{}
+	p := &exprParser{tokens: tokens, expr: expr}
+
+	expr.root, err = p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected token %q", p.tokens[p.pos])
+	}
+
+	return expr, nil
+}
+
+func tokenizeExpression(input string) (tokens []string, err error) {
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			i++
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		case unicode.IsLetter(r):
+			start := i
+			for i < len(runes) && unicode.IsLetter(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		case r == '(' || r == ')':
+			tokens = append(tokens, string(r))
+			i++
+		default:
+			if i+1 < len(runes) {
+				switch op := string(runes[i : i+2]); op {
+				case "&&", "||", "==", "!=", "<=", ">=":
+					tokens = append(tokens, op)
+					i += 2
+					continue
+				}
+			}
+			switch r {
+			case '!', '<', '>', '=':
+				tokens = append(tokens, string(r))
+				i++
+			default:
+				return nil, fmt.Errorf("unexpected character %q", r)
+			}
+		}
+	}
+
+	return tokens, nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *exprParser) expect(token string) error {
+	if next := p.next(); next != token {
+		return fmt.Errorf("expected %q but found %q", token, next)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprBinary{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprBinary{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.peek() == "!" {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprNot{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	switch op := p.peek(); op {
+	case "=", "==", "!=", "<", ">", "<=", ">=":
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &exprBinary{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseOperand() (exprNode, error) {
+	token := p.next()
+	switch token {
+	case "":
+		return nil, errors.New("unexpected end of expression")
+	case "(":
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case "true":
+		return &exprLiteral{value: 1}, nil
+	case "false":
+		return &exprLiteral{value: 0}, nil
+	case "switch", "var":
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		id, err := strconv.Atoi(p.next())
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid %s id", token)
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		if token == "switch" {
+			if !p.expr.hasSwitch(id) {
+				p.expr.SwitchIds = append(p.expr.SwitchIds, id)
+			}
+			return &exprSwitch{id: id}, nil
+		}
+		if !p.expr.hasVar(id) {
+			p.expr.VarIds = append(p.expr.VarIds, id)
+		}
+		return &exprVar{id: id}, nil
+	}
+
+	value, err := strconv.Atoi(token)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected token %q", token)
+	}
+	return &exprLiteral{value: value}, nil
+}
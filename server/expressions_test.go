@@ -0,0 +1,96 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseExpression(t *testing.T) {
+	switches := map[int]bool{1: true, 2: false}
+	vars := map[int]int{10: 5, 11: -3}
+
+	tests := []struct {
+		input     string
+		valid     bool
+		synced    bool
+		switchIds []int
+		varIds    []int
+	}{
+		{input: "switch(1)", valid: true, synced: true, switchIds: []int{1}},
+		{input: "!switch(2)", valid: true, synced: true, switchIds: []int{2}},
+		{input: "var(10) >= 5", valid: true, synced: true, varIds: []int{10}},
+		{input: "var(10) > 5", valid: false, synced: true, varIds: []int{10}},
+		{input: "var(10) = 5 && var(11) == -3", valid: true, synced: true, varIds: []int{10, 11}},
+		{input: "var(10) != 5 || switch(1)", valid: true, synced: true, switchIds: []int{1}, varIds: []int{10}},
+		{input: "switch(1) && (var(10) < 3 || var(10) <= 5)", valid: true, synced: true, switchIds: []int{1}, varIds: []int{10}},
+		{input: "switch(1) && switch(1)", valid: true, synced: true, switchIds: []int{1}},
+		{input: "true && !false", valid: true, synced: true},
+		{input: "switch(3)", valid: false, synced: false, switchIds: []int{3}},
+		// unsynced values on the short circuited side don't block the result
+		{input: "switch(2) && var(12) = 1", valid: false, synced: true, switchIds: []int{2}, varIds: []int{12}},
+		{input: "switch(1) || var(12) = 1", valid: true, synced: true, switchIds: []int{1}, varIds: []int{12}},
+		{input: "switch(1) && var(12) = 1", valid: false, synced: false, switchIds: []int{1}, varIds: []int{12}},
+	}
+
+	for _, test := range tests {
+		expr, err := parseExpression(test.input)
+		if err != nil {
+			t.Errorf("parseExpression(%q) returned error: %v", test.input, err)
+			continue
+		}
+
+		valid, synced := expr.Eval(switches, vars)
+		if valid != test.valid || synced != test.synced {
+			t.Errorf("parseExpression(%q).Eval() = %v, %v, want %v, %v", test.input, valid, synced, test.valid, test.synced)
+		}
+		if !reflect.DeepEqual(expr.SwitchIds, test.switchIds) {
+			t.Errorf("parseExpression(%q).SwitchIds = %v, want %v", test.input, expr.SwitchIds, test.switchIds)
+		}
+		if !reflect.DeepEqual(expr.VarIds, test.varIds) {
+			t.Errorf("parseExpression(%q).VarIds = %v, want %v", test.input, expr.VarIds, test.varIds)
+		}
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"   ",
+		"switch(1",
+		"switch 1",
+		"switch(0)",
+		"var(-1) = 1",
+		"var(x) = 1",
+		"switch(1) &&",
+		"switch(1) switch(2)",
+		"var(1) = = 1",
+		"(switch(1)",
+		"switch(1))",
+		"foo(1)",
+		"switch(1) & switch(2)",
+		"var(1) + 1",
+	}
+
+	for _, input := range tests {
+		if _, err := parseExpression(input); err == nil {
+			t.Errorf("parseExpression(%q) returned no error", input)
+		}
+	}
+}
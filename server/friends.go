@@ -48,6 +48,15 @@ func addPlayerFriend(uuid string, targetUuid string) error {
 		return errors.New("attempted adding self as friend")
 	}
 
+	blocked, err := isPlayerBlocked(uuid, targetUuid)
+	if err != nil {
+		return err
+	}
+
+	if blocked {
+		return errors.New("player is blocked")
+	}
+
 	var accepted bool
 
 	results, err := db.Exec("UPDATE playerFriends SET accepted = 1 WHERE uuid = ? AND targetUuid = ?", targetUuid, uuid)
@@ -78,7 +87,7 @@ func removePlayerFriend(uuid string, targetUuid string) error {
 
 func getPlayerFriendData(uuid string) (playerFriends []*PlayerFriend, err error) {
 	results, err := db.Query("SELECT pf.targetUuid, pf.accepted, 0, a.user, pd.rank, COALESCE(a.badge, ''), pgd.game, pgd.online, pgd.timestampLastActive, pgd.systemName, pgd.spriteName, pgd.spriteIndex, pgd.medalCountBronze, pgd.medalCountSilver, pgd.medalCountGold, pgd.medalCountPlatinum, pgd.medalCountDiamond FROM playerFriends pf JOIN playerGameData pgd ON pgd.uuid = pf.targetUuid JOIN players pd ON pd.uuid = pgd.uuid JOIN accounts a ON a.uuid = pd.uuid WHERE pf.uuid       = ? AND pgd.game = (SELECT rpgd.game FROM playerGameData rpgd WHERE rpgd.uuid = pf.targetUuid AND rpgd.spriteName <> '' ORDER BY online DESC, timestampLastActive DESC, CASE WHEN game = ? THEN 1 ELSE 0 END DESC LIMIT 1) UNION "+
-		"                       SELECT pf.uuid,       pf.accepted, 1, a.user, pd.rank, COALESCE(a.badge, ''), pgd.game, pgd.online, pgd.timestampLastActive, pgd.systemName, pgd.spriteName, pgd.spriteIndex, pgd.medalCountBronze, pgd.medalCountSilver, pgd.medalCountGold, pgd.medalCountPlatinum, pgd.medalCountDiamond FROM playerFriends pf JOIN playerGameData pgd ON pgd.uuid = pf.uuid       JOIN players pd ON pd.uuid = pgd.uuid JOIN accounts a ON a.uuid = pd.uuid WHERE pf.targetUuid = ? AND pgd.game = (SELECT rpgd.game FROM playerGameData rpgd WHERE rpgd.uuid = pf.uuid       AND rpgd.spriteName <> '' ORDER BY online DESC, timestampLastActive DESC, CASE WHEN game = ? THEN 1 ELSE 0 END DESC LIMIT 1) AND NOT EXISTS (SELECT * FROM playerFriends opf WHERE opf.uuid = pf.targetUuid AND opf.targetUuid = pf.uuid) ORDER BY user", uuid, config.gameName, uuid, config.gameName)
+		"                       SELECT pf.uuid,       pf.accepted, 1, a.user, pd.rank, COALESCE(a.badge, ''), pgd.game, pgd.online, pgd.timestampLastActive, pgd.systemName, pgd.spriteName, pgd.spriteIndex, pgd.medalCountBronze, pgd.medalCountSilver, pgd.medalCountGold, pgd.medalCountPlatinum, pgd.medalCountDiamond FROM playerFriends pf JOIN playerGameData pgd ON pgd.uuid = pf.uuid       JOIN players pd ON pd.uuid = pgd.uuid JOIN accounts a ON a.uuid = pd.uuid WHERE pf.targetUuid = ? AND pgd.game = (SELECT rpgd.game FROM playerGameData rpgd WHERE rpgd.uuid = pf.uuid       AND rpgd.spriteName <> '' ORDER BY online DESC, timestampLastActive DESC, CASE WHEN game = ? THEN 1 ELSE 0 END DESC LIMIT 1) AND NOT EXISTS (SELECT * FROM playerFriends opf WHERE opf.uuid = pf.targetUuid AND opf.targetUuid = pf.uuid) ORDER BY user", uuid, getConfig().gameName, uuid, getConfig().gameName)
 	if err != nil {
 		return playerFriends, err
 	}
@@ -103,7 +112,7 @@ func getPlayerFriendData(uuid string) (playerFriends []*PlayerFriend, err error)
 			return playerFriends, err
 		}
 
-		if playerFriend.Accepted && playerFriend.Game == config.gameName {
+		if playerFriend.Accepted && playerFriend.Game == getConfig().gameName {
 			client, ok := clients.Load(playerFriend.Uuid)
 			if ok {
 				if client.system != "" {
@@ -128,6 +137,9 @@ func getPlayerFriendData(uuid string) (playerFriends []*PlayerFriend, err error)
 				}
 
 				playerFriend.Online = true
+				playerFriend.Status = client.status
+			} else if presence, ok := clients.LoadRemote(playerFriend.Uuid); ok {
+				playerFriend.applyRemotePresence(presence)
 			}
 		}
 
@@ -136,3 +148,54 @@ func getPlayerFriendData(uuid string) (playerFriends []*PlayerFriend, err error)
 
 	return playerFriends, nil
 }
+
+// sendFriendListUpdate sends the player their friend list if they are connected to this server
+func sendFriendListUpdate(uuid string) {
+	client, ok := clients.Load(uuid)
+	if !ok {
+		return
+	}
+
+	err := client.handlePf()
+	if err != nil {
+		writeErrLog(uuid, "sess", err.Error())
+	}
+}
+
+// sendFriendStatusUpdate notifies the player's friends connected to this server that they came online or went offline
+func sendFriendStatusUpdate(uuid string, online bool) {
+	friendUuids, err := getPlayerFriendUuids(uuid)
+	if err != nil {
+		writeErrLog(uuid, "sess", err.Error())
+		return
+	}
+
+	for _, friendUuid := range friendUuids {
+		if client, ok := clients.Load(friendUuid); ok {
+			client.outbox <- buildMsg("fs", uuid, online)
+		}
+	}
+}
+
+// getPlayerFriendUuids returns the players who accepted a friend request from the player or whose request the player accepted
+func getPlayerFriendUuids(uuid string) (friendUuids []string, err error) {
+	results, err := db.Query("SELECT targetUuid FROM playerFriends WHERE uuid = ? AND accepted = 1", uuid)
+	if err != nil {
+		return friendUuids, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		var friendUuid string
+
+		err := results.Scan(&friendUuid)
+		if err != nil {
+			return friendUuids, err
+		}
+
+		friendUuids = append(friendUuids, friendUuid)
+	}
+
+	return friendUuids, nil
+}
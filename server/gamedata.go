@@ -0,0 +1,169 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	playerGameDataFlushInterval = 10 // seconds
+)
+
+// player game data updates are buffered and written together,
+// so only the last of several sprite changes and reconnects of a player is written
+var (
+	pendingPlayerGameData    = make(map[string]*PlayerGameDataUpdate)
+	pendingPlayerGameDataMtx sync.Mutex
+
+	// the updates being written by a flush, and the players of them who reconnected meanwhile
+	flushingPlayerGameData    map[string]*PlayerGameDataUpdate
+	reconnectedPlayerGameData = make(map[string]bool)
+
+	// flushes run one at a time, so a flush on shutdown waits for a scheduled one
+	playerGameDataFlushMtx sync.Mutex
+)
+
+type PlayerGameDataUpdate struct {
+	name        string
+	system      string
+	sprite      string
+	spriteIndex int
+	online      bool
+
+	timestampLastActive time.Time
+}
+
+// updatePlayerGameActivity queues the player's current name, system and sprite to be written with the next flush
+func (c *SessionClient) updatePlayerGameActivity(online bool) {
+	update := &PlayerGameDataUpdate{
+		name:                c.name,
+		system:              c.system,
+		sprite:              c.sprite,
+		spriteIndex:         c.spriteIndex,
+		online:              online,
+		timestampLastActive: time.Now().UTC(),
+	}
+
+	pendingPlayerGameDataMtx.Lock()
+
+	pendingPlayerGameData[c.uuid] = update
+
+	pendingPlayerGameDataMtx.Unlock()
+}
+
+func getPendingPlayerGameData(uuid string) (PlayerGameDataUpdate, bool) {
+	pendingPlayerGameDataMtx.Lock()
+	defer pendingPlayerGameDataMtx.Unlock()
+
+	if update, ok := pendingPlayerGameData[uuid]; ok {
+		return *update, true
+	}
+	if update, ok := flushingPlayerGameData[uuid]; ok {
+		return *update, true
+	}
+
+	return PlayerGameDataUpdate{}, false
+}
+
+// markPendingPlayerGameDataOnline keeps a queued disconnect from marking a player who reconnected as offline
+func markPendingPlayerGameDataOnline(uuid string) {
+	pendingPlayerGameDataMtx.Lock()
+
+	if update, ok := pendingPlayerGameData[uuid]; ok {
+		update.online = true
+	}
+
+	// a disconnect being written is marked online again once the flush is done
+	if update, ok := flushingPlayerGameData[uuid]; ok && !update.online {
+		reconnectedPlayerGameData[uuid] = true
+	}
+
+	pendingPlayerGameDataMtx.Unlock()
+}
+
+// flushPlayerGameData writes the queued updates in one transaction, requeueing them if it fails
+func flushPlayerGameData() {
+	playerGameDataFlushMtx.Lock()
+	defer playerGameDataFlushMtx.Unlock()
+
+	pendingPlayerGameDataMtx.Lock()
+
+	updates := pendingPlayerGameData
+	if len(updates) == 0 {
+		pendingPlayerGameDataMtx.Unlock()
+		return
+	}
+
+	pendingPlayerGameData = make(map[string]*PlayerGameDataUpdate)
+	flushingPlayerGameData = updates
+
+	pendingPlayerGameDataMtx.Unlock()
+
+	err := retryTransient(func() error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		defer tx.Rollback()
+
+		for uuid, update := range updates {
+			_, err = tx.Exec("UPDATE playerGameData SET name = ?, systemName = ?, spriteName = ?, spriteIndex = ?, online = ?, timestampLastActive = ? WHERE uuid = ? AND game = ?", update.name, update.system, update.sprite, update.spriteIndex, update.online, update.timestampLastActive, uuid, getConfig().gameName)
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Commit()
+	})
+
+	pendingPlayerGameDataMtx.Lock()
+
+	reconnected := reconnectedPlayerGameData
+	reconnectedPlayerGameData = make(map[string]bool)
+	flushingPlayerGameData = nil
+
+	if err != nil {
+		writeErrLog("SERVER", "gamedata", err.Error())
+
+		for uuid, update := range updates {
+			// newer updates queued during the flush replace the failed ones
+			if _, ok := pendingPlayerGameData[uuid]; !ok {
+				if reconnected[uuid] {
+					update.online = true
+				}
+				pendingPlayerGameData[uuid] = update
+			}
+		}
+
+		pendingPlayerGameDataMtx.Unlock()
+		return
+	}
+
+	pendingPlayerGameDataMtx.Unlock()
+
+	// the flush wrote these players as offline after they reconnected
+	for uuid := range reconnected {
+		_, err := db.ExecRetry("UPDATE playerGameData SET online = 1 WHERE uuid = ? AND game = ?", uuid, getConfig().gameName)
+		if err != nil {
+			writeErrLog(uuid, "gamedata", err.Error())
+		}
+	}
+}
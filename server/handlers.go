@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func (c *RoomClient) handleSr(msg []string) error {
@@ -77,6 +78,8 @@ func (c *RoomClient) handleM(msg []string) error {
 	c.x = x
 	c.y = y
 
+	c.recordTimeTrialGhostPoint()
+
 	if msg[0] == "tp" {
 		c.checkRoomConditions("teleport", "")
 	}
@@ -91,6 +94,8 @@ func (c *RoomClient) handleM(msg []string) error {
 		c.broadcast(buildMsg("m", c.session.id, msg[1:])) // user %id% moved to x y
 	}
 
+	c.sendLatencyHint()
+
 	return nil
 }
 
@@ -138,7 +143,7 @@ func (c *RoomClient) handleSpr(msg []string) error {
 		return errors.New("invalid sprite")
 	}
 
-	if config.gameName == "2kki" && !isValid2kkiSprite(msg[1], c.room.id) {
+	if getConfig().gameName == "2kki" && !isValid2kkiSprite(msg[1], c.room.id) {
 		return errors.New("invalid 2kki sprite")
 	}
 
@@ -494,7 +499,7 @@ func (c *RoomClient) handleBa(msg []string) error {
 		return errconv
 	}
 
-	if !config.battleAnimIds[id] {
+	if !getConfig().battleAnimIds[id] {
 		return errors.New("invalid battle animation id")
 	}
 
@@ -515,14 +520,20 @@ func (c *RoomClient) handleSs(msg []string) error {
 
 	value := msg[2] == "1"
 
-	if config.gameName == "2kki" && c.session.rank == 0 && switchId == 11 && value {
+	if getConfig().gameName == "2kki" && c.session.rank == 0 && switchId == 11 && value {
 		c.session.cancel()
 	}
 
 	c.switchCache[switchId] = value
-	if switchId == 1430 && config.gameName == "2kki" { // time trial mode
+	if switchId == 1430 && getConfig().gameName == "2kki" { // time trial mode
 		if value {
+			c.timeTrialStart = time.Now()
+			c.timeTrialGhost = nil
+			c.recordTimeTrialGhostPoint()
 			c.outbox <- buildMsg("sv", 88, 0) // time elapsed
+		} else {
+			c.timeTrialStart = time.Time{}
+			c.timeTrialGhost = nil
 		}
 	} else {
 		if len(c.room.minigames) != 0 {
@@ -530,13 +541,27 @@ func (c *RoomClient) handleSs(msg []string) error {
 				if minigame.Dev && c.session.rank < 1 {
 					continue
 				}
-				if minigame.SwitchId == switchId && minigame.SwitchValue == value && c.minigameScores[m] < c.varCache[minigame.VarId] {
-					tryWritePlayerMinigameScore(c.session.uuid, minigame.Id, c.varCache[minigame.VarId])
+				if minigame.SwitchId == switchId && minigame.SwitchValue == value && minigame.isBetterScore(c.varCache[minigame.VarId], c.minigameScores[m]) {
+					c.submitMinigameScore(minigame, c.varCache[minigame.VarId])
 				}
 			}
 		}
 
 		for _, condition := range append(globalConditions, c.room.conditions...) {
+			if condition.isExpired() {
+				continue
+			}
+
+			if condition.expr != nil {
+				if condition.expr.hasSwitch(switchId) {
+					err := c.checkConditionExpression(condition)
+					if err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
 			validVars := !condition.VarTrigger
 			if condition.VarTrigger {
 				if condition.VarId > 0 {
@@ -577,7 +602,7 @@ func (c *RoomClient) handleSs(msg []string) error {
 										c.outbox <- buildMsg("b")
 									}
 								}
-							} else if config.gameName == "2kki" {
+							} else if getConfig().gameName == "2kki" {
 								c.outbox <- buildMsg("ss", 1430, 0)
 							}
 						} else {
@@ -602,7 +627,7 @@ func (c *RoomClient) handleSs(msg []string) error {
 											c.outbox <- buildMsg("b")
 										}
 									}
-								} else if config.gameName == "2kki" {
+								} else if getConfig().gameName == "2kki" {
 									c.outbox <- buildMsg("ss", 1430, 0)
 								}
 							} else {
@@ -641,16 +666,26 @@ func (c *RoomClient) handleSv(msg []string) error {
 
 	conditions := append(globalConditions, c.room.conditions...)
 
-	if varId == 88 && config.gameName == "2kki" {
+	if varId == 88 && getConfig().gameName == "2kki" {
 		for _, condition := range conditions {
 			if condition.TimeTrial && value < 3600 {
 				if c.checkConditionCoords(condition) {
+					if err := c.validateTimeTrial(value); err != nil {
+						writeErrLog(c.session.uuid, c.mapId, "rejected time trial of "+strconv.Itoa(value)+" seconds: "+err.Error())
+						continue
+					}
+
 					success, err := tryWritePlayerTimeTrial(c.session.uuid, c.room.id, value)
 					if err != nil {
 						return err
 					}
 					if success {
 						c.outbox <- buildMsg("b")
+
+						err = writePlayerTimeTrialGhost(c.session.uuid, c.room.id, c.timeTrialGhost)
+						if err != nil {
+							writeErrLog(c.session.uuid, c.mapId, "failed to write time trial ghost: "+err.Error())
+						}
 					}
 				}
 			}
@@ -661,17 +696,31 @@ func (c *RoomClient) handleSv(msg []string) error {
 				if minigame.Dev && c.session.rank < 1 {
 					continue
 				}
-				if minigame.VarId == varId && c.minigameScores[m] < value {
+				if minigame.VarId == varId && minigame.isBetterScore(value, c.minigameScores[m]) {
 					if minigame.SwitchId > 0 {
 						c.outbox <- buildMsg("ss", minigame.SwitchId, 0)
 					} else {
-						tryWritePlayerMinigameScore(c.session.uuid, minigame.Id, value)
+						c.submitMinigameScore(minigame, value)
 					}
 				}
 			}
 		}
 
 		for _, condition := range conditions {
+			if condition.isExpired() {
+				continue
+			}
+
+			if condition.expr != nil {
+				if condition.expr.hasVar(varId) {
+					err := c.checkConditionExpression(condition)
+					if err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
 			validSwitches := condition.VarTrigger
 			if !condition.VarTrigger {
 				if condition.SwitchId > 0 {
@@ -712,7 +761,7 @@ func (c *RoomClient) handleSv(msg []string) error {
 										c.outbox <- buildMsg("b")
 									}
 								}
-							} else if config.gameName == "2kki" {
+							} else if getConfig().gameName == "2kki" {
 								c.outbox <- buildMsg("ss", 1430, 0)
 							}
 						} else {
@@ -737,7 +786,7 @@ func (c *RoomClient) handleSv(msg []string) error {
 											c.outbox <- buildMsg("b")
 										}
 									}
-								} else if config.gameName == "2kki" {
+								} else if getConfig().gameName == "2kki" {
 									c.outbox <- buildMsg("ss", 1430, 0)
 								}
 							} else {
@@ -788,6 +837,7 @@ func (c *RoomClient) handleSev(msg []string) error {
 		return err
 	}
 	if exp > -1 {
+		invalidatePlayerBadgeData(c.session.uuid)
 		c.session.outbox <- buildMsg("vm", exp)
 	}
 
@@ -859,6 +909,13 @@ func (c *SessionClient) handlePloc(msg []string) error {
 
 	c.roomC.checkRoomConditions("prevMap", c.roomC.prevMapId)
 
+	if c.account {
+		err := writePlayerLocationHistory(c.uuid, c.roomC.mapId, c.roomC.prevMapId, c.roomC.prevLocations)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -892,6 +949,10 @@ func (c *SessionClient) handleSay(msg []string) error {
 		return errors.New("player is muted")
 	}
 
+	if c.isSpectating() {
+		return errors.New("spectators cannot chat")
+	}
+
 	if len(msg) != 2 {
 		return errors.New("segment count mismatch")
 	}
@@ -905,7 +966,7 @@ func (c *SessionClient) handleSay(msg []string) error {
 		return errors.New("invalid message")
 	}
 
-	for _, client := range c.roomC.room.clients {
+	for _, client := range c.roomC.instance.clients {
 		if client.session == c {
 			continue
 		}
@@ -924,6 +985,8 @@ func (c *SessionClient) handleSay(msg []string) error {
 	// so local echo appears
 	c.outbox <- buildMsg("say", c.uuid, msgContents)
 
+	incrementPlayerStat(c.uuid, statChatMessages)
+
 	return nil
 }
 
@@ -932,6 +995,10 @@ func (c *SessionClient) handleGPSay(msg []string) error {
 		return errors.New("player is muted")
 	}
 
+	if c.isSpectating() {
+		return errors.New("spectators cannot chat")
+	}
+
 	if len(msg) != 2 {
 		return errors.New("segment count mismatch")
 	}
@@ -966,31 +1033,29 @@ func (c *SessionClient) handleGPSay(msg []string) error {
 	msgId := randString(12)
 
 	if msg[0] == "gsay" {
-		c.broadcast(buildMsg("p", c.uuid, c.name, c.system, c.rank, c.account, c.badge, c.medals[:]))
-		c.broadcast(buildMsg("gsay", c.uuid, mapId, prevMapId, prevLocations, x, y, msgContents, msgId))
+		c.broadcastUnblockedAll(buildMsg("p", c.uuid, c.name, c.system, c.rank, c.account, c.badge, c.medals[:]))
+		c.broadcastUnblockedAll(buildMsg("gsay", c.uuid, mapId, prevMapId, prevLocations, x, y, msgContents, msgId))
 
 		err := writeGlobalChatMessage(msgId, c.uuid, mapId, prevMapId, prevLocations, x, y, msgContents)
 		if err != nil {
 			return err
 		}
 
-		if c.account && config.chatWebhook != "" {
-			game := config.gameName
+		if c.account && getConfig().chatWebhook != "" {
+			game := getConfig().gameName
 			if gameName, ok := gameIdToName[game]; ok {
 				game = gameName
 			}
 
-			err = sendWebhookMessage(config.chatWebhook, fmt.Sprintf("%s (%s)", c.name, game), c.badge, msgContents, true)
+			err = sendWebhookMessage(getConfig().chatWebhook, fmt.Sprintf("%s (%s)", c.name, game), c.badge, msgContents, true)
 			if err != nil {
 				return err
 			}
 		}
+
+		mirrorGlobalMessageToDiscord(c.name, msgContents)
 	} else {
-		for _, client := range clients.Get() {
-			if client.partyId == c.partyId {
-				client.outbox <- buildMsg("psay", c.uuid, msgContents, msgId)
-			}
-		}
+		c.broadcastParty(buildMsg("psay", c.uuid, msgContents, msgId))
 
 		err := writePartyChatMessage(msgId, c.uuid, mapId, prevMapId, prevLocations, x, y, msgContents, c.partyId)
 		if err != nil {
@@ -998,6 +1063,130 @@ func (c *SessionClient) handleGPSay(msg []string) error {
 		}
 	}
 
+	incrementPlayerStat(c.uuid, statChatMessages)
+
+	return nil
+}
+
+func (c *SessionClient) handleW(msg []string) error {
+	if !c.account {
+		return errors.New("guests cannot whisper")
+	}
+
+	if c.muted {
+		return errors.New("player is muted")
+	}
+
+	if len(msg) != 3 {
+		return errors.New("segment count mismatch")
+	}
+
+	targetUuid := msg[1]
+	if targetUuid == c.uuid {
+		return errors.New("attempted whispering self")
+	}
+
+	msgContents := wordFilter.ReplaceAllString(strings.TrimSpace(msg[2]), ":2kkiSign:")
+	if msgContents == "" || len(msgContents) > 150 {
+		return errors.New("invalid message")
+	}
+
+	if getNameFromUuid(targetUuid) == "" {
+		return errors.New("target is not a registered player")
+	}
+
+	blocked, err := isPlayerBlocked(c.uuid, targetUuid)
+	if err != nil {
+		return err
+	}
+
+	if blocked {
+		return errors.New("player is blocked")
+	}
+
+	msgId := randString(12)
+
+	// whispers to players not connected to this server or in do-not-disturb are kept until they can be delivered
+	client, delivered := clients.Load(targetUuid)
+	if delivered && client.status == playerStatusDnd {
+		delivered = false
+	}
+
+	err = writePlayerWhisper(msgId, c.uuid, targetUuid, msgContents, delivered)
+	if err != nil {
+		return err
+	}
+
+	if delivered {
+		client.outbox <- buildMsg("w", c.uuid, c.name, msgId, time.Now().UTC().Format(time.RFC3339), msgContents)
+	}
+
+	// delivery receipt
+	c.outbox <- buildMsg("wr", msgId, delivered)
+
+	return nil
+}
+
+func (c *SessionClient) handleCh(msg []string) error {
+	globalMsgLimit := chatHistoryGlobalMsgLimit
+
+	if len(msg) > 1 {
+		limit, err := strconv.Atoi(msg[1])
+		if err != nil {
+			return err
+		}
+
+		if limit > 0 && limit < chatHistoryGlobalMsgLimit {
+			globalMsgLimit = limit
+		}
+	}
+
+	chatHistory, err := getChatMessageHistory(c.connCtx, c.uuid, globalMsgLimit, chatHistoryPartyMsgLimit, "")
+	if err != nil {
+		return err
+	}
+
+	chatHistoryJson, err := json.Marshal(chatHistory)
+	if err != nil {
+		return err
+	}
+
+	c.outbox <- buildMsg("ch", chatHistoryJson)
+
+	return nil
+}
+
+func (c *SessionClient) handleLh(msg []string) error {
+	if !c.account {
+		return errors.New("guests have no location history")
+	}
+
+	limit := locationHistoryLimit
+
+	if len(msg) > 1 {
+		var err error
+		limit, err = strconv.Atoi(msg[1])
+		if err != nil {
+			return err
+		}
+
+		if limit <= 0 || limit > locationHistoryLimit {
+			limit = locationHistoryLimit
+		}
+	}
+
+	locationHistory, err := getPlayerLocationHistory(c.uuid, limit)
+	if err != nil {
+		return err
+	}
+
+	locationHistoryJson, err := json.Marshal(locationHistory)
+	if err != nil {
+		return err
+	}
+
+	c.outbox <- buildMsg("lh", locationHistoryJson)
+
 	return nil
 }
 
@@ -1121,6 +1310,33 @@ func (c *SessionClient) handlePf() error {
 	return nil
 }
 
+func (c *SessionClient) handleFaFr(msg []string) error {
+	if len(msg) != 2 {
+		return errors.New("segment count mismatch")
+	}
+
+	if !c.account {
+		return errors.New("guests cannot add friends")
+	}
+
+	targetUuid := msg[1]
+
+	var err error
+	if msg[0] == "fa" {
+		err = addPlayerFriend(c.uuid, targetUuid)
+	} else {
+		err = removePlayerFriend(c.uuid, targetUuid)
+	}
+	if err != nil {
+		return err
+	}
+
+	sendFriendListUpdate(c.uuid)
+	sendFriendListUpdate(targetUuid)
+
+	return nil
+}
+
 func (c *SessionClient) handlePt() error {
 	if c.partyId == 0 {
 		return errors.New("player not in a party")
@@ -1161,17 +1377,13 @@ func (c *SessionClient) handleE() error {
 	}
 	var hasIncompleteEvent bool
 	for _, currentEventLocation := range currentEventLocationsData {
-		if !currentEventLocation.Complete && currentEventLocation.Game == config.gameName {
+		if !currentEventLocation.Complete && currentEventLocation.Game == getConfig().gameName {
 			hasIncompleteEvent = true
 			break
 		}
 	}
 	if !hasIncompleteEvent {
-		if config.gameName == "2kki" {
-			addPlayer2kkiEventLocation(currentGameEventPeriodId, -1, freeEventLocationMinDepth, 0, 0, c.uuid)
-		} else if len(freeEventLocationPool) > 0 {
-			addPlayerEventLocation(config.gameName, -1, 0, freeEventLocationPool, c.uuid)
-		}
+		addPlayerEventLocation(c.uuid)
 		currentEventLocationsData, err = getCurrentPlayerEventLocationsData(c.uuid)
 		if err != nil {
 			return err
@@ -1183,9 +1395,21 @@ func (c *SessionClient) handleE() error {
 		return err
 	}
 
+	expMultiplier, err := getCurrentEventExpMultiplier()
+	if err != nil {
+		return err
+	}
+
+	freeLocationQuota, _, err := getPlayerFreeEventLocationQuota(c.uuid)
+	if err != nil {
+		return err
+	}
+
 	eventsData := &EventsData{
-		Locations: currentEventLocationsData,
-		Vms:       currentEventVmsData,
+		Locations:         currentEventLocationsData,
+		Vms:               currentEventVmsData,
+		ExpMultiplier:     expMultiplier,
+		FreeLocationQuota: freeLocationQuota,
 	}
 
 	eventsDataJson, err := json.Marshal(eventsData)
@@ -1257,6 +1481,14 @@ func (c *SessionClient) handleEec(msg []string) error {
 				exp = 0
 			}
 		}
+		if exp > -1 {
+			invalidatePlayerBadgeData(c.uuid)
+
+			err := awardEventStreakMedals(c.uuid)
+			if err != nil {
+				writeErrLog(c.uuid, "medals", err.Error())
+			}
+		}
 	}
 	currentEventLocationsData, err := getCurrentPlayerEventLocationsData(c.uuid)
 	if err != nil {
@@ -1265,17 +1497,13 @@ func (c *SessionClient) handleEec(msg []string) error {
 	}
 	var hasIncompleteEvent bool
 	for _, currentEventLocation := range currentEventLocationsData {
-		if !currentEventLocation.Complete && currentEventLocation.Game == config.gameName {
+		if !currentEventLocation.Complete && currentEventLocation.Game == getConfig().gameName {
 			hasIncompleteEvent = true
 			break
 		}
 	}
 	if !hasIncompleteEvent {
-		if config.gameName == "2kki" {
-			addPlayer2kkiEventLocation(currentGameEventPeriodId, -1, freeEventLocationMinDepth, 0, 0, c.uuid)
-		} else if len(freeEventLocationPool) > 0 {
-			addPlayerEventLocation(config.gameName, -1, 0, freeEventLocationPool, c.uuid)
-		}
+		addPlayerEventLocation(c.uuid)
 	}
 
 	c.outbox <- buildMsg("eec", exp, true)
@@ -1315,6 +1543,98 @@ func (c *SessionClient) handlePr(msg []string) error {
 	return nil
 }
 
+func (c *SessionClient) handlePo(msg []string) error {
+	if len(msg) != 2 {
+		return errors.New("segment count mismatch")
+	}
+
+	sentTime, err := strconv.ParseInt(msg[1], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	latency := time.Now().UnixMilli() - sentTime
+	if latency < 0 || latency > latencyPingPeriod.Milliseconds() {
+		return errors.New("invalid pong")
+	}
+
+	c.latency.Store(int32(latency))
+
+	return nil
+}
+
+func (c *SessionClient) handleSt(msg []string) error {
+	if len(msg) != 2 {
+		return errors.New("segment count mismatch")
+	}
+
+	switch msg[1] {
+	case playerStatusOnline, playerStatusAfk, playerStatusBusy, playerStatusDnd:
+	default:
+		return errors.New("invalid status")
+	}
+
+	prevStatus := c.status
+	c.status = msg[1]
+
+	// whispers held back while in do-not-disturb
+	if prevStatus == playerStatusDnd && c.status != playerStatusDnd && c.account {
+		return c.sendUndeliveredWhispers()
+	}
+
+	return nil
+}
+
+func (c *SessionClient) handleAtp(msg []string) error {
+	if len(msg) != 2 {
+		return errors.New("segment count mismatch")
+	}
+
+	c.allowTeleport = msg[1] == "1"
+
+	return nil
+}
+
+func (c *SessionClient) handlePtp(msg []string) error {
+	if len(msg) != 2 {
+		return errors.New("segment count mismatch")
+	}
+
+	if c.roomC == nil {
+		return errors.New("room client does not exist")
+	}
+
+	if time.Since(c.lastTeleportTime) < partyTeleportCooldown {
+		return errors.New("teleport on cooldown")
+	}
+
+	target, ok := clients.Load(msg[1])
+	if !ok || target == c || target.roomC == nil {
+		return errors.New("target not found")
+	}
+
+	// moderators can teleport to anyone
+	if c.rank == 0 {
+		if c.partyId == 0 || target.partyId != c.partyId {
+			return errors.New("target not in party")
+		}
+
+		if !target.allowTeleport || target.hideLocation || target.blockedUsers[c.uuid] {
+			return errors.New("target does not allow teleport")
+		}
+	}
+
+	c.lastTeleportTime = time.Now()
+
+	c.outbox <- buildMsg("ptp", target.uuid, target.roomC.mapId, target.roomC.x, target.roomC.y)
+
+	if c.rank == 0 {
+		target.outbox <- buildMsg("ptpn", c.uuid) // let the target know who is coming
+	}
+
+	return nil
+}
+
 func (c *SessionClient) handleHl(msg []string) error {
 	if len(msg) != 2 {
 		return errors.New("segment count mismatch")
@@ -1324,3 +1644,141 @@ func (c *SessionClient) handleHl(msg []string) error {
 
 	return nil
 }
+
+func (c *SessionClient) handleCls() error {
+	channelsData := make([]*ChatChannelData, 0, len(chatChannels))
+	for _, channel := range chatChannels {
+		channelsData = append(channelsData, channel.getData(c.uuid))
+	}
+
+	channelsDataJson, err := json.Marshal(channelsData)
+	if err != nil {
+		return err
+	}
+
+	c.outbox <- buildMsg("cls", channelsDataJson)
+
+	return nil
+}
+
+func (c *SessionClient) handleCjCl(msg []string) error {
+	if len(msg) != 2 {
+		return errors.New("segment count mismatch")
+	}
+
+	channel, err := getChatChannel(msg[1])
+	if err != nil {
+		return err
+	}
+
+	if msg[0] == "cj" {
+		err = channel.join(c)
+		if err != nil {
+			return err
+		}
+	} else {
+		channel.leave(c.uuid)
+	}
+
+	// confirm membership change
+	c.outbox <- buildMsg(msg[0], channel.id)
+
+	return nil
+}
+
+func (c *SessionClient) handleCsay(msg []string) error {
+	if c.muted {
+		return errors.New("player is muted")
+	}
+
+	if c.isSpectating() {
+		return errors.New("spectators cannot chat")
+	}
+
+	if len(msg) != 3 {
+		return errors.New("segment count mismatch")
+	}
+
+	if c.name == "" {
+		return errors.New("no name set")
+	}
+
+	channel, err := getChatChannel(msg[1])
+	if err != nil {
+		return err
+	}
+
+	if !channel.isMember(c.uuid) {
+		return errors.New("player not in channel")
+	}
+
+	channel.mutex.RLock()
+	muted := channel.muted[c.uuid]
+	channel.mutex.RUnlock()
+	if muted {
+		return errors.New("player is muted in channel")
+	}
+
+	msgContents := wordFilter.ReplaceAllString(strings.TrimSpace(msg[2]), ":2kkiSign:")
+	if msgContents == "" || len(msgContents) > 150 {
+		return errors.New("invalid message")
+	}
+
+	msgId := randString(12)
+
+	for _, member := range channel.getMembers() {
+		if member.blockedUsers[c.uuid] || c.blockedUsers[member.uuid] {
+			continue
+		}
+
+		member.outbox <- buildMsg("p", c.uuid, c.name, c.system, c.rank, c.account, c.badge, c.medals[:])
+		member.outbox <- buildMsg("csay", channel.id, c.uuid, msgContents, msgId)
+	}
+
+	incrementPlayerStat(c.uuid, statChatMessages)
+
+	return nil
+}
+
+// handleCmod lets moderators kick players from a channel or mute and unmute them in it
+func (c *SessionClient) handleCmod(msg []string) error {
+	if c.rank == 0 {
+		return errors.New("access denied")
+	}
+
+	if len(msg) != 4 {
+		return errors.New("segment count mismatch")
+	}
+
+	channel, err := getChatChannel(msg[1])
+	if err != nil {
+		return err
+	}
+
+	targetUuid := msg[3]
+
+	if c.rank <= getPlayerRank(targetUuid) {
+		return errors.New("insufficient rank")
+	}
+
+	switch msg[2] {
+	case "kick":
+		channel.leave(targetUuid)
+
+		if client, ok := clients.Load(targetUuid); ok {
+			client.outbox <- buildMsg("cl", channel.id)
+		}
+	case "mute", "unmute":
+		channel.mutex.Lock()
+		if msg[2] == "mute" {
+			channel.muted[targetUuid] = true
+		} else {
+			delete(channel.muted, targetUuid)
+		}
+		channel.mutex.Unlock()
+	default:
+		return errors.New("unknown command")
+	}
+
+	return nil
+}
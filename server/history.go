@@ -44,16 +44,22 @@ type ChatMessage struct {
 	Party         bool      `json:"party"`
 }
 
+const (
+	// most recent global messages kept per game regardless of age, so quiet games don't show an empty chat
+	chatHistoryGlobalMsgLimit = 100
+	chatHistoryPartyMsgLimit  = 250
+)
+
 type ChatHistory struct {
 	Players  []*ChatPlayer  `json:"players"`
 	Messages []*ChatMessage `json:"messages"`
 }
 
 func initHistory() {
-	// Use main server to process chat message cleaning task for all games
+	// Use main server to process chat message cleaning task for all games, its cluster leader runs it
 	if isMainServer {
 		logInitTask("history")
 
-		scheduler.Cron("0 * * * *").Do(deleteOldChatMessages)
+		scheduleTask("chatHistoryCleanup", scheduler.Cron("0 * * * *"), deleteOldChatMessages)
 	}
 }
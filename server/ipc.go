@@ -24,6 +24,7 @@ import (
 	"net"
 	"net/rpc"
 	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -40,6 +41,13 @@ func (_ *IPC) TryMute(args string, _ *Void) error {
 	return mutePlayerUnchecked(args, false)
 }
 
+func (_ *IPC) InvalidatePlayers(args []string, _ *Void) error {
+	for _, uuid := range args {
+		invalidateLocalPlayerData(uuid)
+	}
+	return nil
+}
+
 type SendReportLogArgs struct {
 	Uuid, YnoMsgId, OriginalMsg string
 }
@@ -49,7 +57,7 @@ func (_ *IPC) SendReportLog(args SendReportLogArgs, _ *Void) error {
 }
 
 func banPlayerInGameUnchecked(game, uuid string) error {
-	if game == config.gameName {
+	if game == getConfig().gameName {
 		return banPlayerUnchecked(uuid, true)
 	}
 	client, err := rpc.Dial("unix", fmt.Sprintf("/tmp/yno/%s.sck", game))
@@ -62,13 +70,13 @@ func banPlayerInGameUnchecked(game, uuid string) error {
 	select {
 	case <-call.Done:
 		return call.Error
-	case <-time.After(config.ipc.deadline):
+	case <-time.After(getConfig().ipc.deadline):
 		return errors.New("banPlayerInGameUnchecked: timed out")
 	}
 }
 
 func mutePlayerInGameUnchecked(game, uuid string) error {
-	if game == config.gameName {
+	if game == getConfig().gameName {
 		return mutePlayerUnchecked(uuid, true)
 	}
 	client, err := rpc.Dial("unix", fmt.Sprintf("/tmp/yno/%s.sck", game))
@@ -81,11 +89,71 @@ func mutePlayerInGameUnchecked(game, uuid string) error {
 	select {
 	case <-call.Done:
 		return call.Error
-	case <-time.After(config.ipc.deadline):
+	case <-time.After(getConfig().ipc.deadline):
 		return errors.New("mutePlayerInGameUnchecked: timed out")
 	}
 }
 
+// players whose cached data the processes of the other games on this host should drop, sent in batches
+var siblingPlayerInvalidations = make(chan string, 1024)
+
+const maxSiblingPlayerInvalidationBatch = 256
+
+// invalidateSiblingPlayerData queues a player whose cached data the other games on this host should drop
+func invalidateSiblingPlayerData(uuid string) {
+	select {
+	case siblingPlayerInvalidations <- uuid:
+	default:
+		// the cache TTL still bounds how long the change takes to apply there
+		writeErrLog("SERVER", "ipc", "invalidateSiblingPlayerData: queue is full")
+	}
+}
+
+func runSiblingPlayerInvalidations() {
+	for uuid := range siblingPlayerInvalidations {
+		uuids := []string{uuid}
+
+	batch:
+		for len(uuids) < maxSiblingPlayerInvalidationBatch {
+			select {
+			case uuid := <-siblingPlayerInvalidations:
+				uuids = append(uuids, uuid)
+			default:
+				break batch
+			}
+		}
+
+		socketPaths, err := filepath.Glob("/tmp/yno/*.sck")
+		if err != nil {
+			writeErrLog("SERVER", "ipc", err.Error())
+			continue
+		}
+
+		for _, socketPath := range socketPaths {
+			if socketPath == fmt.Sprintf("/tmp/yno/%s.sck", getConfig().gameName) {
+				continue
+			}
+
+			client, err := rpc.Dial("unix", socketPath)
+			if err != nil {
+				continue // left behind by a game that isn't running
+			}
+
+			call := client.Go("IPC.InvalidatePlayers", uuids, new(Void), make(chan *rpc.Call, 1))
+			select {
+			case <-call.Done:
+				if call.Error != nil {
+					writeErrLog("SERVER", "ipc", "invalidateSiblingPlayerData: "+call.Error.Error())
+				}
+			case <-time.After(getConfig().ipc.deadline):
+				writeErrLog("SERVER", "ipc", "invalidateSiblingPlayerData: timed out")
+			}
+
+			client.Close()
+		}
+	}
+}
+
 func sendReportLog(uuid, ynoMsgId, originalMsg string) error {
 	if isMainServer {
 		return sendReportLogMainServer(uuid, ynoMsgId, originalMsg)
@@ -100,14 +168,14 @@ func sendReportLog(uuid, ynoMsgId, originalMsg string) error {
 	select {
 	case <-call.Done:
 		return call.Error
-	case <-time.After(config.ipc.deadline):
+	case <-time.After(getConfig().ipc.deadline):
 		return errors.New("mutePlayerInGameUnchecked: timed out")
 	}
 }
 
 func initRpc() {
 	var err error
-	socketPath := fmt.Sprintf("/tmp/yno/%s.sck", config.gameName)
+	socketPath := fmt.Sprintf("/tmp/yno/%s.sck", getConfig().gameName)
 
 	os.MkdirAll("/tmp/yno", 0777)
 	os.Remove(socketPath)
@@ -124,4 +192,6 @@ func initRpc() {
 	ipc := new(IPC)
 	rpc.Register(ipc)
 	go rpc.Accept(socket)
+
+	go runSiblingPlayerInvalidations()
 }
@@ -0,0 +1,386 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// expensive work runs in background jobs, recorded in the database so they can be listed and retried.
+// Jobs are claimed before they run, so processes sharing the database never run one twice.
+const (
+	jobWorkerCount   = 2
+	jobQueueSize     = 256
+	jobListLimit     = 100
+	jobSweepInterval = 1 // minutes
+
+	// running jobs are marked alive regularly, and considered interrupted once their process stops doing so
+	jobHeartbeatInterval = 30 * time.Second
+	jobHeartbeatTimeout  = 3 // minutes
+)
+
+const (
+	jobStatusQueued    = "queued"
+	jobStatusRunning   = "running"
+	jobStatusDone      = "done"
+	jobStatusFailed    = "failed"
+	jobStatusCancelled = "cancelled"
+)
+
+const (
+	jobTypeBadgeSlotCounts = "badgeSlotCounts"
+	jobTypeSaveBackup      = "saveBackup"
+)
+
+// jobHandlers are the job types and the work they do, which stops early once ctx is cancelled
+var jobHandlers = map[string]func(ctx context.Context) error{
+	jobTypeBadgeSlotCounts: func(ctx context.Context) error {
+		return updatePlayerBadgeSlotCounts(ctx)
+	},
+	jobTypeSaveBackup: func(ctx context.Context) error {
+		if getConfig().backups.s3.bucket == "" {
+			return errors.New("backups are not configured")
+		}
+
+		err := createSaveBackup(ctx)
+		if err != nil {
+			return err
+		}
+
+		return deleteExpiredSaveBackups(ctx)
+	},
+}
+
+type Job struct {
+	Id                int        `json:"id"`
+	Type              string     `json:"type"`
+	Status            string     `json:"status"`
+	Attempts          int        `json:"attempts"`
+	Error             string     `json:"error,omitempty"`
+	TimestampQueued   time.Time  `json:"timestampQueued"`
+	TimestampStarted  *time.Time `json:"timestampStarted,omitempty"`
+	TimestampFinished *time.Time `json:"timestampFinished,omitempty"`
+}
+
+var (
+	jobQueue = make(chan int, jobQueueSize)
+
+	// cancel functions of the jobs running in this process
+	runningJobs    = make(map[int]context.CancelFunc)
+	runningJobsMtx sync.Mutex
+)
+
+func initJobs() {
+	logInitTask("jobs")
+
+	for i := 0; i < jobWorkerCount; i++ {
+		go runJobWorker()
+	}
+
+	sweepQueuedJobs()
+
+	// picks up jobs that didn't fit in the queue or were queued or retried by another process,
+	// and fails the jobs of processes that stopped
+	scheduleTask("jobSweep", scheduler.Every(jobSweepInterval).Minutes(), sweepQueuedJobs)
+}
+
+// enqueueJob records a job and queues it for the workers
+func enqueueJob(jobType string) (jobId int, err error) {
+	if _, ok := jobHandlers[jobType]; !ok {
+		return 0, errors.New("unknown job type")
+	}
+
+	jobId64, err := db.ExecInsert("INSERT INTO jobs (game, type, status, timestampQueued) VALUES (?, ?, ?, UTC_TIMESTAMP())", getConfig().gameName, jobType, jobStatusQueued)
+	if err != nil {
+		return 0, err
+	}
+
+	jobId = int(jobId64)
+
+	queueJob(jobId)
+
+	return jobId, nil
+}
+
+func queueJob(jobId int) {
+	select {
+	case jobQueue <- jobId:
+	default:
+		// left for the next sweep
+	}
+}
+
+func sweepQueuedJobs() {
+	failStaleJobs()
+
+	results, err := db.Query("SELECT id FROM jobs WHERE game = ? AND status = ? ORDER BY id", getConfig().gameName, jobStatusQueued)
+	if err != nil {
+		writeErrLog("SERVER", "jobs", err.Error())
+		return
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		var jobId int
+		if err := results.Scan(&jobId); err != nil {
+			writeErrLog("SERVER", "jobs", err.Error())
+			return
+		}
+
+		queueJob(jobId)
+	}
+}
+
+// failStaleJobs fails the running jobs whose process stopped without finishing them.
+// Jobs still running in other processes keep their heartbeat fresh and are left alone.
+func failStaleJobs() {
+	_, err := db.Exec("UPDATE jobs SET status = ?, error = 'interrupted: the server running it stopped', timestampFinished = UTC_TIMESTAMP() WHERE game = ? AND status = ? AND COALESCE(timestampHeartbeat, timestampStarted) < DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? MINUTE)", jobStatusFailed, getConfig().gameName, jobStatusRunning, jobHeartbeatTimeout)
+	if err != nil {
+		writeErrLog("SERVER", "jobs", err.Error())
+	}
+}
+
+// keepJobAlive refreshes the heartbeat of a running job until ctx is done
+func keepJobAlive(ctx context.Context, jobId int) {
+	ticker := time.NewTicker(jobHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := db.Exec("UPDATE jobs SET timestampHeartbeat = UTC_TIMESTAMP() WHERE id = ? AND status = ?", jobId, jobStatusRunning)
+			if err != nil {
+				writeErrLog("SERVER", "jobs", "job "+strconv.Itoa(jobId)+": "+err.Error())
+			}
+		}
+	}
+}
+
+func runJobWorker() {
+	for jobId := range jobQueue {
+		err := runJob(jobId)
+		if err != nil {
+			writeErrLog("SERVER", "jobs", "job "+strconv.Itoa(jobId)+": "+err.Error())
+		}
+	}
+}
+
+func runJob(jobId int) error {
+	// only one worker gets to run a job queued several times
+	result, err := db.Exec("UPDATE jobs SET status = ?, attempts = attempts + 1, error = NULL, timestampStarted = UTC_TIMESTAMP(), timestampHeartbeat = UTC_TIMESTAMP() WHERE id = ? AND status = ?", jobStatusRunning, jobId, jobStatusQueued)
+	if err != nil {
+		return err
+	}
+
+	claimed, err := result.RowsAffected()
+	if err != nil || claimed == 0 {
+		return err
+	}
+
+	var jobType string
+	err = db.QueryRow("SELECT type FROM jobs WHERE id = ?", jobId).Scan(&jobType)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runningJobsMtx.Lock()
+	runningJobs[jobId] = cancel
+	runningJobsMtx.Unlock()
+
+	defer func() {
+		runningJobsMtx.Lock()
+		delete(runningJobs, jobId)
+		runningJobsMtx.Unlock()
+	}()
+
+	go keepJobAlive(ctx, jobId)
+
+	writeLog("SERVER", "jobs", "running job "+strconv.Itoa(jobId)+" ("+jobType+")", 200)
+
+	status := jobStatusDone
+	var jobErr sql.NullString
+
+	handler, ok := jobHandlers[jobType]
+	if !ok {
+		err = errors.New("unknown job type")
+	} else {
+		err = handler(ctx)
+	}
+	if err != nil {
+		status = jobStatusFailed
+		jobErr = sql.NullString{String: err.Error(), Valid: true}
+	}
+
+	// a job cancelled while it ran stays cancelled
+	_, err = db.Exec("UPDATE jobs SET status = ?, error = ?, timestampFinished = UTC_TIMESTAMP() WHERE id = ? AND status = ?", status, jobErr, jobId, jobStatusRunning)
+
+	return err
+}
+
+func getJobs(status string) (jobs []*Job, err error) {
+	query := "SELECT id, type, status, attempts, COALESCE(error, ''), timestampQueued, timestampStarted, timestampFinished FROM jobs WHERE game = ?"
+	args := []any{getConfig().gameName}
+
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, jobListLimit)
+
+	results, err := db.Query(query, args...)
+	if err != nil {
+		return jobs, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		job := &Job{}
+
+		var timestampStarted, timestampFinished sql.NullTime
+
+		err := results.Scan(&job.Id, &job.Type, &job.Status, &job.Attempts, &job.Error, &job.TimestampQueued, &timestampStarted, &timestampFinished)
+		if err != nil {
+			return jobs, err
+		}
+
+		if timestampStarted.Valid {
+			job.TimestampStarted = &timestampStarted.Time
+		}
+		if timestampFinished.Valid {
+			job.TimestampFinished = &timestampFinished.Time
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// retryJob queues a failed or cancelled job again
+func retryJob(jobId int) (bool, error) {
+	result, err := db.Exec("UPDATE jobs SET status = ?, timestampQueued = UTC_TIMESTAMP(), timestampStarted = NULL, timestampFinished = NULL WHERE id = ? AND game = ? AND status IN (?, ?)", jobStatusQueued, jobId, getConfig().gameName, jobStatusFailed, jobStatusCancelled)
+	if err != nil {
+		return false, err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil || updated == 0 {
+		return false, err
+	}
+
+	queueJob(jobId)
+
+	return true, nil
+}
+
+// cancelJob cancels a queued job, or a running one which stops once its work checks for cancellation
+func cancelJob(jobId int) (bool, error) {
+	result, err := db.Exec("UPDATE jobs SET status = ?, timestampFinished = UTC_TIMESTAMP() WHERE id = ? AND game = ? AND status IN (?, ?)", jobStatusCancelled, jobId, getConfig().gameName, jobStatusQueued, jobStatusRunning)
+	if err != nil {
+		return false, err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil || updated == 0 {
+		return false, err
+	}
+
+	runningJobsMtx.Lock()
+	if cancel, ok := runningJobs[jobId]; ok {
+		cancel()
+	}
+	runningJobsMtx.Unlock()
+
+	return true, nil
+}
+
+func adminJobs(w http.ResponseWriter, r *http.Request) {
+	_, _, rank, _, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
+	if rank == 0 {
+		handleError(w, r, "access denied")
+		return
+	}
+
+	query := r.URL.Query()
+
+	switch commandParam := query.Get("command"); commandParam {
+	case "list":
+		jobs, err := getJobs(query.Get("status"))
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+
+		jobsJson, err := json.Marshal(jobs)
+		if err != nil {
+			handleError(w, r, "error while marshaling")
+			return
+		}
+
+		w.Write(jobsJson)
+	case "run":
+		jobId, err := enqueueJob(query.Get("type"))
+		if err != nil {
+			handleError(w, r, err.Error())
+			return
+		}
+
+		w.Write([]byte(strconv.Itoa(jobId)))
+	case "retry", "cancel":
+		jobId, err := strconv.Atoi(query.Get("id"))
+		if err != nil {
+			handleError(w, r, "invalid id value")
+			return
+		}
+
+		var ok bool
+		if commandParam == "retry" {
+			ok, err = retryJob(jobId)
+		} else {
+			ok, err = cancelJob(jobId)
+		}
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+		if !ok {
+			handleError(w, r, "job not found or not in a state allowing this command")
+			return
+		}
+
+		w.Write([]byte("ok"))
+	default:
+		handleError(w, r, "unknown command")
+	}
+}
@@ -0,0 +1,166 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFailStaleJobs(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetMaxOpenConns(1)
+
+	prevDb, prevConfig := db, getConfig()
+	defer func() {
+		db = prevDb
+		currentConfig.Store(prevConfig)
+	}()
+
+	db = &Database{DB: conn, dialect: dialectSqlite}
+	currentConfig.Store(&Config{gameName: "2kki"})
+
+	err = runMigrations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a job kept alive by another process, and one whose process stopped
+	aliveJobId, err := db.ExecInsert("INSERT INTO jobs (game, type, status, timestampQueued, timestampStarted, timestampHeartbeat) VALUES (?, ?, ?, UTC_TIMESTAMP(), DATE_SUB(UTC_TIMESTAMP(), INTERVAL 10 MINUTE), UTC_TIMESTAMP())", "2kki", jobTypeBadgeSlotCounts, jobStatusRunning)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staleJobId, err := db.ExecInsert("INSERT INTO jobs (game, type, status, timestampQueued, timestampStarted, timestampHeartbeat) VALUES (?, ?, ?, UTC_TIMESTAMP(), DATE_SUB(UTC_TIMESTAMP(), INTERVAL 10 MINUTE), DATE_SUB(UTC_TIMESTAMP(), INTERVAL 10 MINUTE))", "2kki", jobTypeBadgeSlotCounts, jobStatusRunning)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failStaleJobs()
+
+	tests := []struct {
+		jobId  int64
+		status string
+	}{
+		{aliveJobId, jobStatusRunning},
+		{staleJobId, jobStatusFailed},
+	}
+
+	for _, tt := range tests {
+		var status string
+		err := db.QueryRow("SELECT status FROM jobs WHERE id = ?", tt.jobId).Scan(&status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("job %d status = %q, expected %q", tt.jobId, status, tt.status)
+		}
+	}
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetMaxOpenConns(1)
+
+	prevDb, prevConfig := db, getConfig()
+	defer func() {
+		db = prevDb
+		currentConfig.Store(prevConfig)
+	}()
+
+	db = &Database{DB: conn, dialect: dialectSqlite}
+	currentConfig.Store(&Config{gameName: "2kki"})
+
+	err = runMigrations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the slot count update gives up once cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = jobHandlers[jobTypeBadgeSlotCounts](ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled badge slot count job error = %v, expected %v", err, context.Canceled)
+	}
+
+	// a running job stops once cancelled, and stays cancelled
+	started := make(chan struct{})
+	handlerErr := make(chan error, 1)
+
+	jobHandlers["test"] = func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		handlerErr <- ctx.Err()
+		return ctx.Err()
+	}
+	defer delete(jobHandlers, "test")
+
+	jobId, err := db.ExecInsert("INSERT INTO jobs (game, type, status, timestampQueued) VALUES (?, ?, ?, UTC_TIMESTAMP())", "2kki", "test", jobStatusQueued)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runJob(int(jobId))
+	}()
+
+	<-started
+
+	ok, err := cancelJob(int(jobId))
+	if err != nil || !ok {
+		t.Fatalf("cancelling running job = %t, %v", ok, err)
+	}
+
+	select {
+	case err := <-handlerErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("cancelled job handler error = %v, expected %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled job kept running")
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	var status string
+	err = db.QueryRow("SELECT status FROM jobs WHERE id = ?", jobId).Scan(&status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != jobStatusCancelled {
+		t.Errorf("cancelled job status = %q, expected %q", status, jobStatusCancelled)
+	}
+}
@@ -22,6 +22,11 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"time"
+)
+
+const (
+	locationHistoryLimit = 50
 )
 
 type GameLocation struct {
@@ -31,6 +36,13 @@ type GameLocation struct {
 	MapIds []string `json:"mapIds"`
 }
 
+type LocationHistoryEntry struct {
+	MapId         string    `json:"mapId"`
+	PrevMapId     string    `json:"prevMapId"`
+	PrevLocations string    `json:"prevLocations"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
 type PathLocations struct {
 	Locations []PathLocation `json:"locations"`
 }
@@ -91,8 +103,8 @@ func initLocations() {
 
 	locationPlayerCounts = make(map[int]int)
 
-	scheduler.Every(6).Hours().Do(updateLocationCache)
-	scheduler.Every(30).Seconds().Do(updateLocationPlayerCounts)
+	scheduleTask("locationCache", scheduler.Every(6).Hours(), updateLocationCache)
+	scheduleTask("locationPlayerCounts", scheduler.Every(30).Seconds(), updateLocationPlayerCounts)
 
 	go updateLocationCache()
 }
@@ -155,7 +167,7 @@ func updateLocationCache() {
 
 	locationsMap := make(map[string]*Location)
 
-	results, err := db.Query("SELECT id, title, depth, minDepth, secret FROM gameLocations WHERE game = ?", config.gameName)
+	results, err := db.Query("SELECT id, title, depth, minDepth, secret FROM gameLocations WHERE game = ?", getConfig().gameName)
 	if err != nil {
 		writeErrLog("SERVER", "Locations", err.Error())
 		return
@@ -196,7 +208,7 @@ func updateLocationPlayerCounts() {
 	}
 
 	for _, client := range clients.Get() {
-		if client.private || client.hideLocation || client.roomC == nil {
+		if client.private || client.hideLocation || client.roomC == nil || client.roomC.spectator {
 			continue
 		}
 		for _, locationId := range client.roomC.locationIds {
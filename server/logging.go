@@ -0,0 +1,93 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// log subsystems
+const (
+	logSession = "session"
+	logHub     = "hub"
+	logApi     = "api"
+)
+
+var (
+	logger   = slog.Default()
+	logLevel = new(slog.LevelVar) // can be changed at runtime
+)
+
+func initLogging() {
+	writer := &lumberjack.Logger{
+		Filename:   "logs/" + getConfig().gameName + "/ynoserver.log",
+		MaxSize:    getConfig().logging.maxSize,
+		MaxBackups: getConfig().logging.maxBackups,
+		MaxAge:     getConfig().logging.maxAge,
+	}
+
+	logLevel.Set(getConfig().logging.level)
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+
+	var handler slog.Handler
+	if getConfig().logging.json {
+		handler = slog.NewJSONHandler(writer, opts)
+	} else {
+		handler = slog.NewTextHandler(writer, opts)
+	}
+
+	logger = slog.New(handler)
+
+	// also routes the standard logger through the handler
+	slog.SetDefault(logger)
+}
+
+// getLogSubsystem derives the subsystem from the location passed to writeLog,
+// which is "sess" for sessions, a map id for rooms and a path for API requests,
+// other locations such as "stats" are used as is
+func getLogSubsystem(location string) string {
+	switch {
+	case location == "sess":
+		return logSession
+	case strings.HasPrefix(location, "/"):
+		return logApi
+	case len(location) == 4 && strings.Trim(location, "0123456789") == "":
+		return logHub
+	default:
+		return location
+	}
+}
+
+// writeLog logs a message, attrs are extra key value pairs such as the request id
+func writeLog(uuid string, location string, payload string, errorcode int, attrs ...any) {
+	level := slog.LevelInfo
+	if errorcode >= 400 {
+		level = slog.LevelError
+	}
+
+	logger.Log(context.Background(), level, payload, append([]any{"subsystem", getLogSubsystem(location), "uuid", uuid, "location", location, "status", errorcode}, attrs...)...)
+}
+
+func writeErrLog(uuid string, location string, payload string, attrs ...any) {
+	writeLog(uuid, location, payload, 400, attrs...)
+}
@@ -0,0 +1,566 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Medal struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Tier        int    `json:"tier"`
+	// how the medal is awarded, left empty for medals only granted by admins
+	Rule string `json:"rule,omitempty"`
+	// the placement or streak length the rule requires
+	ReqInt int `json:"reqInt,omitempty"`
+}
+
+type PlayerMedal struct {
+	Medal
+	Game             string    `json:"game"`
+	TimestampAwarded time.Time `json:"timestampAwarded"`
+}
+
+// medal tiers index the medal counts of players, which are stored per game
+const (
+	medalTierBronze = iota
+	medalTierSilver
+	medalTierGold
+	medalTierPlatinum
+	medalTierDiamond
+)
+
+const (
+	// awarded to players placing at or above ReqInt in the event exp of one of the game's event periods,
+	// only the best medal they qualify for is awarded for a period
+	medalRuleEventPlacement = "eventPlacement"
+	// awarded once to players whose best daily event streak reaches ReqInt
+	medalRuleEventStreak = "eventStreak"
+
+	// periods are checked for this long after they end, so a missed run is caught up on
+	medalEventPlacementWindowDays = 7
+)
+
+var (
+	medalTierNames        = [5]string{"bronze", "silver", "gold", "platinum", "diamond"}
+	medalTierCountColumns = [5]string{"medalCountBronze", "medalCountSilver", "medalCountGold", "medalCountPlatinum", "medalCountDiamond"}
+)
+
+// medals built into the server, which the medals config can replace or add to
+var defaultMedals = []*Medal{
+	{Id: "event_first", Name: "Event Champion", Description: "Placed first in an event period", Tier: medalTierDiamond, Rule: medalRuleEventPlacement, ReqInt: 1},
+	{Id: "event_top3", Name: "Event Podium", Description: "Placed in the top 3 of an event period", Tier: medalTierPlatinum, Rule: medalRuleEventPlacement, ReqInt: 3},
+	{Id: "event_top10", Name: "Event Top 10", Description: "Placed in the top 10 of an event period", Tier: medalTierGold, Rule: medalRuleEventPlacement, ReqInt: 10},
+	{Id: "event_top25", Name: "Event Top 25", Description: "Placed in the top 25 of an event period", Tier: medalTierSilver, Rule: medalRuleEventPlacement, ReqInt: 25},
+	{Id: "event_top50", Name: "Event Top 50", Description: "Placed in the top 50 of an event period", Tier: medalTierBronze, Rule: medalRuleEventPlacement, ReqInt: 50},
+	{Id: "streak_7", Name: "Weekly Explorer", Description: "Completed event locations 7 days in a row", Tier: medalTierBronze, Rule: medalRuleEventStreak, ReqInt: 7},
+	{Id: "streak_30", Name: "Monthly Explorer", Description: "Completed event locations 30 days in a row", Tier: medalTierSilver, Rule: medalRuleEventStreak, ReqInt: 30},
+	{Id: "streak_100", Name: "Seasoned Explorer", Description: "Completed event locations 100 days in a row", Tier: medalTierGold, Rule: medalRuleEventStreak, ReqInt: 100},
+	{Id: "streak_365", Name: "Tireless Explorer", Description: "Completed event locations 365 days in a row", Tier: medalTierPlatinum, Rule: medalRuleEventStreak, ReqInt: 365},
+}
+
+var gameMedals []*Medal
+
+func initMedals() {
+	logInitTask("medals")
+
+	// after the main server opens the next period at rollover
+	scheduleTask("medalAwards", scheduler.Every(1).Day().At(formatEventRolloverTime(5*time.Minute)), awardEventPlacementMedals)
+}
+
+// setMedals builds the medals from the built-in ones and the config, where entries replace built-in medals with the same id
+func setMedals() {
+	var medals []*Medal
+
+	for _, defaultMedal := range defaultMedals {
+		medal := *defaultMedal
+		medals = append(medals, &medal)
+	}
+
+	for _, medalConfig := range getConfig().medals {
+		tier, ok := getMedalTier(medalConfig.Tier)
+		if !ok {
+			// config parsing rejects these, so this only guards against medals set some other way
+			writeErrLog("SERVER", "medals", "skipping medal "+medalConfig.Id+" with invalid tier: "+medalConfig.Tier)
+			continue
+		}
+
+		medal := &Medal{
+			Id:          medalConfig.Id,
+			Name:        medalConfig.Name,
+			Description: medalConfig.Description,
+			Tier:        tier,
+			Rule:        medalConfig.Rule,
+			ReqInt:      medalConfig.Req,
+		}
+		if medal.Name == "" {
+			medal.Name = medal.Id
+		}
+
+		replaced := false
+		for i, existingMedal := range medals {
+			if existingMedal.Id == medal.Id {
+				medals[i] = medal
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			medals = append(medals, medal)
+		}
+	}
+
+	gameMedals = medals
+}
+
+func getMedalTier(tierName string) (int, bool) {
+	for tier, name := range medalTierNames {
+		if name == tierName {
+			return tier, true
+		}
+	}
+
+	return 0, false
+}
+
+func getMedal(medalId string) (*Medal, bool) {
+	for _, medal := range gameMedals {
+		if medal.Id == medalId {
+			return medal, true
+		}
+	}
+
+	return nil, false
+}
+
+// getRuleMedals returns the medals awarded by a rule, the easiest to reach last
+func getRuleMedals(rule string) (medals []*Medal) {
+	for _, medal := range gameMedals {
+		if medal.Rule == rule {
+			medals = append(medals, medal)
+		}
+	}
+
+	sort.SliceStable(medals, func(i, j int) bool {
+		if rule == medalRuleEventStreak {
+			return medals[i].ReqInt > medals[j].ReqInt
+		}
+		return medals[i].ReqInt < medals[j].ReqInt
+	})
+
+	return medals
+}
+
+// awardEventPlacementMedals awards the placement medals of the game's recently ended event periods
+func awardEventPlacementMedals() error {
+	// every process of a cluster runs the task, one of them awards the medals
+	if !isClusterLeader() {
+		return nil
+	}
+
+	placementMedals := getRuleMedals(medalRuleEventPlacement)
+	if len(placementMedals) == 0 {
+		return nil
+	}
+
+	gamePeriodIds, err := getRecentlyEndedGameEventPeriodIds(medalEventPlacementWindowDays)
+	if err != nil {
+		return err
+	}
+
+	for _, gamePeriodId := range gamePeriodIds {
+		placements, err := getGameEventPeriodPlacements(gamePeriodId, placementMedals[len(placementMedals)-1].ReqInt)
+		if err != nil {
+			return err
+		}
+
+		for _, placement := range placements {
+			for _, medal := range placementMedals {
+				if placement.position > medal.ReqInt {
+					continue
+				}
+
+				awarded, err := awardPlayerMedal(placement.uuid, getConfig().gameName, medal, "gamePeriod:"+strconv.Itoa(gamePeriodId))
+				if err != nil {
+					return err
+				}
+				if awarded {
+					writeLog(placement.uuid, "medals", "awarded "+medal.Id+" for placing "+strconv.Itoa(placement.position)+" in game period "+strconv.Itoa(gamePeriodId), 200)
+				}
+
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// awardEventStreakMedals awards the streak medals the player's best event streak has reached
+func awardEventStreakMedals(playerUuid string) error {
+	streakMedals := getRuleMedals(medalRuleEventStreak)
+	if len(streakMedals) == 0 {
+		return nil
+	}
+
+	_, bestStreak, err := getPlayerEventStreaks(playerUuid)
+	if err != nil {
+		return err
+	}
+
+	for _, medal := range streakMedals {
+		if bestStreak < medal.ReqInt {
+			continue
+		}
+
+		// streak medals are only awarded once, in whichever game the streak was reached
+		awarded, err := awardPlayerMedal(playerUuid, getConfig().gameName, medal, "")
+		if err != nil {
+			return err
+		}
+		if awarded {
+			writeLog(playerUuid, "medals", "awarded "+medal.Id+" for an event streak of "+strconv.Itoa(bestStreak), 200)
+		}
+	}
+
+	return nil
+}
+
+func handleMedal(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Query().Get("command") {
+	case "list":
+		medalsJson, err := json.Marshal(gameMedals)
+		if err != nil {
+			handleError(w, r, "error while marshaling")
+			return
+		}
+
+		w.Write(medalsJson)
+	case "player":
+		var uuid string
+
+		if playerParam := r.URL.Query().Get("player"); playerParam != "" {
+			var err error
+			uuid, err = getUuidFromName(playerParam)
+			if err != nil {
+				handleInternalError(w, r, err)
+				return
+			}
+			if uuid == "" {
+				uuid = playerParam
+			}
+		} else if token := r.Header.Get("Authorization"); token != "" {
+			uuid = getUuidFromToken(token)
+		}
+
+		if uuid == "" {
+			handleError(w, r, "player not found")
+			return
+		}
+
+		playerMedals, err := getPlayerMedalList(uuid, r.URL.Query().Get("game"))
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+
+		playerMedalsJson, err := json.Marshal(playerMedals)
+		if err != nil {
+			handleError(w, r, "error while marshaling")
+			return
+		}
+
+		w.Write(playerMedalsJson)
+	default:
+		handleError(w, r, "unknown command")
+	}
+}
+
+func adminManageMedal(w http.ResponseWriter, r *http.Request) {
+	adminUuid, _, rank, _, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
+	if rank == 0 {
+		handleError(w, r, "access denied")
+		return
+	}
+
+	uuid := r.URL.Query().Get("uuid")
+	if userParam := r.URL.Query().Get("user"); userParam != "" {
+		var err error
+		uuid, err = getUuidFromName(userParam)
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+		if uuid == "" {
+			handleError(w, r, "invalid user specified: "+userParam)
+			return
+		}
+	}
+
+	if uuid == "" {
+		handleError(w, r, "uuid or user not specified")
+		return
+	}
+
+	idParam := r.URL.Query().Get("id")
+	if idParam == "" {
+		handleError(w, r, "medal ID not specified")
+		return
+	}
+
+	gameParam := r.URL.Query().Get("game")
+	if gameParam == "" {
+		gameParam = getConfig().gameName
+	}
+
+	var ok bool
+	var err error
+	if r.URL.Path == "/admin/grantmedal" {
+		medal, medalOk := getMedal(idParam)
+		if !medalOk {
+			handleError(w, r, "medal not found for the provided medal ID: "+idParam)
+			return
+		}
+
+		// grants are keyed to be unique, so a medal can be granted to a player any number of times
+		ok, err = awardPlayerMedal(uuid, gameParam, medal, "admin:"+getNanoId())
+	} else {
+		ok, err = revokePlayerMedal(uuid, gameParam, idParam)
+	}
+	if err != nil {
+		handleInternalError(w, r, err)
+		return
+	}
+	if !ok {
+		handleError(w, r, "player has no game data or medal for the provided game")
+		return
+	}
+
+	writeLog(adminUuid, "medals", strings.TrimPrefix(r.URL.Path, "/admin/")+" "+idParam+" "+uuid+" "+gameParam, 200)
+
+	w.Write([]byte("ok"))
+}
+
+// awardPlayerMedal awards a medal once per award key and adds it to the player's medal counts of the game.
+// It returns false if the medal was already awarded with the key or the player hasn't played the game.
+func awardPlayerMedal(playerUuid string, gameId string, medal *Medal, awardKey string) (awarded bool, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO playerMedals (uuid, game, medalId, tier, awardKey, timestampAwarded) VALUES (?, ?, ?, ?, ?, ?) "+db.upsertIgnore("uuid, medalId, awardKey"), playerUuid, gameId, medal.Id, medal.Tier, awardKey, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return false, err
+	}
+
+	countColumn := medalTierCountColumns[medal.Tier]
+
+	result, err = tx.Exec("UPDATE playerGameData SET "+countColumn+" = "+countColumn+" + 1 WHERE uuid = ? AND game = ?", playerUuid, gameId)
+	if err != nil {
+		return false, err
+	}
+
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	// sessions load their medal counts on connect, so ones connected to this process are updated directly
+	if gameId == getConfig().gameName {
+		if client, ok := clients.Load(playerUuid); ok {
+			client.medals[medal.Tier]++
+		}
+	}
+
+	invalidatePlayerBadgeData(playerUuid)
+
+	return true, nil
+}
+
+// revokePlayerMedal removes the player's latest award of a medal in the game and takes it off their medal counts
+func revokePlayerMedal(playerUuid string, gameId string, medalId string) (revoked bool, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	defer tx.Rollback()
+
+	var id, tier int
+
+	err = tx.QueryRow("SELECT id, tier FROM playerMedals WHERE uuid = ? AND game = ? AND medalId = ? ORDER BY timestampAwarded DESC, id DESC LIMIT 1", playerUuid, gameId, medalId).Scan(&id, &tier)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	_, err = tx.Exec("DELETE FROM playerMedals WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+
+	if tier >= 0 && tier < len(medalTierCountColumns) {
+		countColumn := medalTierCountColumns[tier]
+
+		_, err = tx.Exec("UPDATE playerGameData SET "+countColumn+" = CASE WHEN "+countColumn+" > 0 THEN "+countColumn+" - 1 ELSE 0 END WHERE uuid = ? AND game = ?", playerUuid, gameId)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	if gameId == getConfig().gameName && tier >= 0 && tier < len(medalTierCountColumns) {
+		if client, ok := clients.Load(playerUuid); ok && client.medals[tier] > 0 {
+			client.medals[tier]--
+		}
+	}
+
+	invalidatePlayerBadgeData(playerUuid)
+
+	return true, nil
+}
+
+// getPlayerMedalList returns the medals awarded to the player, in all games if gameId is empty, the latest first.
+// Medals no longer defined keep their id and tier.
+func getPlayerMedalList(playerUuid string, gameId string) (playerMedals []*PlayerMedal, err error) {
+	playerMedals = []*PlayerMedal{}
+
+	query := "SELECT medalId, tier, game, timestampAwarded FROM playerMedals WHERE uuid = ?"
+	args := []any{playerUuid}
+	if gameId != "" {
+		query += " AND game = ?"
+		args = append(args, gameId)
+	}
+	query += " ORDER BY timestampAwarded DESC, id DESC"
+
+	results, err := db.Replica().Query(query, args...)
+	if err != nil {
+		return playerMedals, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		playerMedal := &PlayerMedal{}
+
+		err := results.Scan(&playerMedal.Id, &playerMedal.Tier, &playerMedal.Game, &playerMedal.TimestampAwarded)
+		if err != nil {
+			return playerMedals, err
+		}
+
+		if medal, ok := getMedal(playerMedal.Id); ok {
+			// keeping the tier it was awarded at, should the definition have changed since
+			tier := playerMedal.Tier
+			playerMedal.Medal = *medal
+			playerMedal.Tier = tier
+		}
+
+		playerMedals = append(playerMedals, playerMedal)
+	}
+
+	return playerMedals, nil
+}
+
+// getRecentlyEndedGameEventPeriodIds returns the ids of the game's event periods that ended within the given number of days
+func getRecentlyEndedGameEventPeriodIds(days int) (gamePeriodIds []int, err error) {
+	results, err := db.Query(withEventDate("SELECT gep.id FROM gameEventPeriods gep JOIN eventPeriods ep ON ep.id = gep.periodId WHERE gep.game = ? AND ep.endDate <= UTC_DATE() AND ep.endDate > DATE_SUB(UTC_DATE(), INTERVAL ? DAY)"), getConfig().gameName, days)
+	if err != nil {
+		return gamePeriodIds, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		var gamePeriodId int
+
+		err := results.Scan(&gamePeriodId)
+		if err != nil {
+			return gamePeriodIds, err
+		}
+
+		gamePeriodIds = append(gamePeriodIds, gamePeriodId)
+	}
+
+	return gamePeriodIds, nil
+}
+
+type eventPeriodPlacement struct {
+	uuid     string
+	position int
+}
+
+// getGameEventPeriodPlacements returns the players placing at or above maxPosition in the event exp of a game period,
+// players with equal exp sharing a position
+func getGameEventPeriodPlacements(gamePeriodId int, maxPosition int) (placements []*eventPeriodPlacement, err error) {
+	results, err := db.Query("SELECT e.uuid, SUM(e.exp) FROM ((SELECT ec.uuid, ec.exp FROM eventCompletions ec JOIN eventLocations el ON el.id = ec.eventId AND ec.type = 0 WHERE el.gamePeriodId = ?) UNION ALL (SELECT ec.uuid, ec.exp FROM eventCompletions ec JOIN eventVms ev ON ev.id = ec.eventId AND ec.type = 2 WHERE ev.gamePeriodId = ?)) e JOIN players pd ON pd.uuid = e.uuid WHERE pd.banned = 0 GROUP BY e.uuid HAVING SUM(e.exp) > 0 ORDER BY 2 DESC", gamePeriodId, gamePeriodId)
+	if err != nil {
+		return placements, err
+	}
+
+	defer results.Close()
+
+	var prevExp int
+
+	for i := 0; results.Next(); i++ {
+		placement := &eventPeriodPlacement{position: i + 1}
+
+		var exp int
+
+		err := results.Scan(&placement.uuid, &exp)
+		if err != nil {
+			return placements, err
+		}
+
+		if i > 0 && exp == prevExp {
+			placement.position = placements[i-1].position
+		}
+		if placement.position > maxPosition {
+			break
+		}
+
+		placements = append(placements, placement)
+		prevExp = exp
+	}
+
+	return placements, nil
+}
@@ -0,0 +1,286 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// migrations are applied in file name order, so new ones should keep the numbered prefix
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// schema of the tables that predate migrations, used to bootstrap empty databases
+//
+//go:embed schema.sql
+var baseSchema string
+
+const (
+	baseSchemaVersion = "schema"
+
+	// every game server shares the database, only one of them migrates it at a time
+	migrationLockName    = "ynoserver_migrations"
+	migrationLockTimeout = 300 // seconds
+)
+
+var addColumnRegexp = regexp.MustCompile(`^ALTER TABLE (\w+) ADD COLUMN (\w+) `)
+
+func migrateDatabase() {
+	logInitTask("migrations")
+
+	err := runMigrations(context.Background())
+	if err != nil {
+		panic(fmt.Errorf("error migrating database: %w", err))
+	}
+}
+
+func runMigrations(ctx context.Context) error {
+	// locks are held by connections, so everything runs on the one holding it
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// SQLite databases are local to one server
+	switch db.dialect {
+	case dialectMysql:
+		var locked sql.NullBool
+		err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLockName, migrationLockTimeout).Scan(&locked)
+		if err != nil {
+			return err
+		}
+		if !locked.Bool {
+			return errors.New("timed out waiting for migration lock")
+		}
+		defer conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", migrationLockName)
+	case dialectPostgres:
+		lockCtx, cancel := context.WithTimeout(ctx, migrationLockTimeout*time.Second)
+		_, err = conn.ExecContext(lockCtx, "SELECT pg_advisory_lock(hashtext($1))", migrationLockName)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("waiting for migration lock: %w", err)
+		}
+		defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext($1))", migrationLockName)
+	}
+
+	// the schema and migrations are written for MySQL, with table definitions translated for the other dialects
+	for _, statement := range translateDdl("CREATE TABLE IF NOT EXISTS schema_migrations (version VARCHAR(255) NOT NULL, appliedAt DATETIME NOT NULL, PRIMARY KEY (version))") {
+		_, err = conn.ExecContext(ctx, statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	applied, err := getAppliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	if !applied[baseSchemaVersion] {
+		empty, err := isDatabaseEmpty(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		// existing deployments already have the base tables, the version is only recorded for them
+		var script string
+		if empty {
+			fmt.Print("Creating database schema...\n")
+			script = baseSchema
+		}
+
+		err = applyMigration(ctx, conn, baseSchemaVersion, script)
+		if err != nil {
+			return fmt.Errorf("base schema: %w", err)
+		}
+	}
+
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		version := strings.TrimSuffix(entry.Name(), ".sql")
+		if applied[version] {
+			continue
+		}
+
+		script, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return err
+		}
+
+		err = applyMigration(ctx, conn, version, string(script))
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", version, err)
+		}
+
+		// logging is not initialized yet at startup
+		fmt.Print("Applied migration " + version + "\n")
+	}
+
+	return nil
+}
+
+func getAppliedMigrations(ctx context.Context, conn *sql.Conn) (map[string]bool, error) {
+	applied := make(map[string]bool)
+
+	results, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return applied, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		var version string
+		err := results.Scan(&version)
+		if err != nil {
+			return applied, err
+		}
+
+		applied[version] = true
+	}
+
+	return applied, results.Err()
+}
+
+// isDatabaseEmpty reports whether the database has no tables other than schema_migrations
+func isDatabaseEmpty(ctx context.Context, conn *sql.Conn) (bool, error) {
+	query := "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name <> 'schema_migrations'"
+	switch db.dialect {
+	case dialectPostgres:
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name <> 'schema_migrations'"
+	case dialectSqlite:
+		query = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name NOT IN ('schema_migrations', 'sqlite_sequence')"
+	}
+
+	var tableCount int
+	err := conn.QueryRowContext(ctx, query).Scan(&tableCount)
+	if err != nil {
+		return false, err
+	}
+
+	return tableCount == 0, nil
+}
+
+// applyMigration runs the statements of a migration in a transaction,
+// though DDL statements are committed as they run in MySQL so migrations should be safe to rerun
+func applyMigration(ctx context.Context, conn *sql.Conn, version string, script string) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range splitSqlStatements(script) {
+		// neither MySQL nor SQLite can add a column only if it is missing
+		if match := addColumnRegexp.FindStringSubmatch(statement); match != nil {
+			exists, err := columnExists(ctx, tx, match[1], match[2])
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+		}
+
+		for _, statement := range translateDdl(statement) {
+			_, err = tx.ExecContext(ctx, statement)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, db.dialect.rebind("INSERT INTO schema_migrations (version, appliedAt) VALUES (?, UTC_TIMESTAMP())"), version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func columnExists(ctx context.Context, tx *sql.Tx, table string, column string) (bool, error) {
+	query := "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?"
+	switch db.dialect {
+	case dialectPostgres:
+		// unquoted identifiers are folded to lower case
+		query = "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = CURRENT_SCHEMA() AND table_name = LOWER($1) AND column_name = LOWER($2)"
+	case dialectSqlite:
+		query = "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?"
+	}
+
+	var count int
+	err := tx.QueryRowContext(ctx, query, table, column).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count != 0, nil
+}
+
+// translateDdl rewrites a statement of the schema or of a migration for the dialect, which may take several statements
+func translateDdl(statement string) []string {
+	switch {
+	case db.dialect == dialectPostgres && (strings.HasPrefix(statement, "CREATE TABLE") || strings.HasPrefix(statement, "ALTER TABLE")):
+		return translatePostgresDdl(statement)
+	case db.dialect == dialectSqlite && strings.HasPrefix(statement, "CREATE TABLE"):
+		return translateSqliteDdl(statement)
+	}
+
+	return []string{db.dialect.rebind(statement)}
+}
+
+// splitSqlStatements splits a script into statements ending with a semicolon at the end of a line,
+// since the driver is not configured to run several statements at once
+func splitSqlStatements(script string) (statements []string) {
+	var statement strings.Builder
+
+	for _, line := range strings.Split(script, "\n") {
+		trimmedLine := strings.TrimSpace(line)
+		if trimmedLine == "" || strings.HasPrefix(trimmedLine, "--") {
+			continue
+		}
+
+		statement.WriteString(line)
+		statement.WriteString("\n")
+
+		if strings.HasSuffix(trimmedLine, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(statement.String()), ";"))
+			statement.Reset()
+		}
+	}
+
+	if remaining := strings.TrimSpace(statement.String()); remaining != "" {
+		statements = append(statements, remaining)
+	}
+
+	return statements
+}
@@ -0,0 +1,68 @@
+-- Tables added alongside features before migrations existed, created only if missing
+-- so databases where they were set up by hand are left as they are
+
+CREATE TABLE IF NOT EXISTS badgeSlotPresets (
+	uuid VARCHAR(16) NOT NULL,
+	name VARCHAR(32) NOT NULL,
+	badgeId VARCHAR(32) NOT NULL,
+	slotRow INT NOT NULL,
+	slotCol INT NOT NULL,
+	PRIMARY KEY (uuid, name, badgeId)
+);
+
+CREATE TABLE IF NOT EXISTS eventExpMultipliers (
+	id INT NOT NULL AUTO_INCREMENT,
+	game VARCHAR(32) NULL,
+	multiplier DOUBLE NOT NULL,
+	startTime DATETIME NOT NULL,
+	endTime DATETIME NOT NULL,
+	PRIMARY KEY (id),
+	KEY (endTime)
+);
+
+CREATE TABLE IF NOT EXISTS playerLocationHistory (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	uuid VARCHAR(16) NOT NULL,
+	game VARCHAR(32) NOT NULL,
+	mapId CHAR(4) NOT NULL,
+	prevMapId CHAR(4) NOT NULL,
+	prevLocations TEXT NOT NULL,
+	timestamp DATETIME NOT NULL,
+	PRIMARY KEY (id),
+	KEY (uuid, game, timestamp),
+	KEY (timestamp)
+);
+
+CREATE TABLE IF NOT EXISTS playerPlaytime (
+	uuid VARCHAR(16) NOT NULL,
+	game VARCHAR(32) NOT NULL,
+	seconds BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (uuid, game)
+);
+
+CREATE TABLE IF NOT EXISTS playerStatistics (
+	uuid VARCHAR(16) NOT NULL,
+	game VARCHAR(32) NOT NULL,
+	stat VARCHAR(32) NOT NULL,
+	value BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (uuid, game, stat)
+);
+
+CREATE TABLE IF NOT EXISTS playerVisitedMaps (
+	uuid VARCHAR(16) NOT NULL,
+	game VARCHAR(32) NOT NULL,
+	mapId CHAR(4) NOT NULL,
+	PRIMARY KEY (uuid, game, mapId)
+);
+
+CREATE TABLE IF NOT EXISTS playerWhispers (
+	msgId VARCHAR(12) NOT NULL,
+	uuid VARCHAR(16) NOT NULL,
+	targetUuid VARCHAR(16) NOT NULL,
+	contents VARCHAR(600) NOT NULL,
+	timestamp DATETIME NOT NULL,
+	delivered BOOLEAN NOT NULL DEFAULT 0,
+	PRIMARY KEY (msgId),
+	KEY (targetUuid, delivered),
+	KEY (timestamp)
+);
@@ -0,0 +1,15 @@
+-- Background jobs run by the game servers, kept for their status to be listed and retried
+
+CREATE TABLE IF NOT EXISTS jobs (
+	id INT NOT NULL AUTO_INCREMENT,
+	game VARCHAR(32) NOT NULL,
+	type VARCHAR(32) NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	error TEXT NULL,
+	timestampQueued DATETIME NOT NULL,
+	timestampStarted DATETIME NULL,
+	timestampFinished DATETIME NULL,
+	PRIMARY KEY (id),
+	KEY (game, status)
+);
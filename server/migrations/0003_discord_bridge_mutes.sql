@@ -0,0 +1,7 @@
+-- Discord users whose messages the Discord bridge doesn't relay into global chat
+
+CREATE TABLE IF NOT EXISTS discordBridgeMutes (
+	discordUserId VARCHAR(32) NOT NULL,
+	timestampMuted DATETIME NOT NULL,
+	PRIMARY KEY (discordUserId)
+);
@@ -0,0 +1,9 @@
+-- Coordinate traces of the players' best time trials, replayed by clients as ghosts
+
+CREATE TABLE IF NOT EXISTS playerTimeTrialGhosts (
+	uuid VARCHAR(16) NOT NULL,
+	mapId INT NOT NULL,
+	points MEDIUMTEXT NOT NULL,
+	timestampRecorded DATETIME NOT NULL,
+	PRIMARY KEY (uuid, mapId)
+);
@@ -0,0 +1,14 @@
+-- Medals awarded to players, which are also counted by tier in playerGameData
+
+CREATE TABLE IF NOT EXISTS playerMedals (
+	id INT NOT NULL AUTO_INCREMENT,
+	uuid VARCHAR(16) NOT NULL,
+	game VARCHAR(32) NOT NULL,
+	medalId VARCHAR(32) NOT NULL,
+	tier INT NOT NULL,
+	awardKey VARCHAR(64) NOT NULL,
+	timestampAwarded DATETIME NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY (uuid, medalId, awardKey),
+	KEY (uuid, game)
+);
@@ -0,0 +1,7 @@
+-- Manual adjustments of the released badge batch of each game, made through the admin API
+
+CREATE TABLE IF NOT EXISTS badgeBatchOffsets (
+	game VARCHAR(32) NOT NULL,
+	batchOffset INT NOT NULL DEFAULT 0,
+	PRIMARY KEY (game)
+);
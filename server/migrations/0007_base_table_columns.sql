@@ -0,0 +1,9 @@
+-- Columns added to tables of the base schema, skipped for databases that already have them
+
+ALTER TABLE eventPeriods ADD COLUMN weeklyExpCap INT NULL;
+
+ALTER TABLE playerBadges ADD COLUMN source VARCHAR(16) NOT NULL DEFAULT 'auto';
+
+ALTER TABLE playerBadges ADD COLUMN grantedBy VARCHAR(16) NULL;
+
+ALTER TABLE parties ADD COLUMN coLocate BOOLEAN NOT NULL DEFAULT 0;
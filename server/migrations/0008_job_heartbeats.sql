@@ -0,0 +1,3 @@
+-- Heartbeats of running jobs, so a process only fails the jobs of processes that stopped
+
+ALTER TABLE jobs ADD COLUMN timestampHeartbeat DATETIME NULL;
@@ -0,0 +1,75 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestMigrateSqlite(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// every connection to an in-memory database gets its own
+	conn.SetMaxOpenConns(1)
+
+	prevDb, prevConfig := db, getConfig()
+	defer func() {
+		db = prevDb
+		currentConfig.Store(prevConfig)
+	}()
+
+	db = &Database{DB: conn, dialect: dialectSqlite}
+	currentConfig.Store(&Config{gameName: "2kki"})
+
+	err = runMigrations(context.Background())
+	if err != nil {
+		t.Fatalf("migrating empty database: %v", err)
+	}
+
+	// applied migrations are skipped
+	err = runMigrations(context.Background())
+	if err != nil {
+		t.Fatalf("migrating migrated database: %v", err)
+	}
+
+	// columns added by migrations are usable
+	partyId, err := db.ExecInsert("INSERT INTO parties (game, owner, name, public, pass, theme, description, coLocate) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", "2kki", "owner", "party", true, "", "", "", true)
+	if err != nil {
+		t.Fatalf("inserting party: %v", err)
+	}
+	if partyId != 1 {
+		t.Errorf("inserted party id = %d, expected 1", partyId)
+	}
+
+	_, err = db.Exec("INSERT INTO playerBadges (uuid, badgeId, timestampUnlocked, source, grantedBy) VALUES (?, ?, UTC_TIMESTAMP(), ?, ?)", "uuid", "badge", "admin", "mod")
+	if err != nil {
+		t.Fatalf("inserting badge: %v", err)
+	}
+
+	var weeklyExpCap sql.NullInt64
+	err = db.QueryRow("SELECT weeklyExpCap FROM eventPeriods").Scan(&weeklyExpCap)
+	if err != sql.ErrNoRows {
+		t.Errorf("selecting weekly exp cap: %v", err)
+	}
+}
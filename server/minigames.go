@@ -19,54 +19,233 @@ package server
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
 	"time"
 )
 
 type Minigame struct {
-	Id             string `json:"id"`
-	VarId          int    `json:"varId"`
-	InitialVarSync bool   `json:"initialVarSync"`
-	SwitchId       int    `json:"switchId"`
-	SwitchValue    bool   `json:"switchValue"`
-	Dev            bool   `json:"dev"`
+	Id             string    `json:"id"`
+	Name           string    `json:"name"`
+	RoomId         int       `json:"roomId"`
+	VarId          int       `json:"varId"`
+	InitialVarSync bool      `json:"initialVarSync"`
+	SwitchId       int       `json:"switchId"`
+	SwitchValue    bool      `json:"switchValue"`
+	LowerIsBetter  bool      `json:"lowerIsBetter"`
+	ActiveFrom     time.Time `json:"activeFrom"`
+	ActiveUntil    time.Time `json:"activeUntil"`
+	Dev            bool      `json:"dev"`
+}
+
+type MinigameLeaderboardEntry struct {
+	Position           int       `json:"position"`
+	Uuid               string    `json:"uuid"`
+	Name               string    `json:"name"`
+	Rank               int       `json:"rank"`
+	Badge              string    `json:"badge"`
+	Score              int       `json:"score"`
+	TimestampCompleted time.Time `json:"timestampCompleted"`
+}
+
+type MinigameLeaderboard struct {
+	Name    string                      `json:"name"`
+	Entries []*MinigameLeaderboardEntry `json:"entries"`
+	// the requesting player's own best, which may be outside of the top entries
+	PlayerEntry *MinigameLeaderboardEntry `json:"playerEntry,omitempty"`
+}
+
+const (
+	minigameLeaderboardSize = 50
+
+	minigameScoreDirectionHigher = "higher"
+	minigameScoreDirectionLower  = "lower"
+)
+
+// minigames of each game built into the server, which the minigames config can change or add to
+var defaultMinigames = map[string][]*Minigame{
+	"yume": {
+		{Id: "nasu", RoomId: 155, VarId: 88, SwitchId: 215},
+	},
+	"2kki": {
+		{Id: "rby", RoomId: 102, VarId: 1010, InitialVarSync: true},
+		{Id: "rby_ex", RoomId: 618, VarId: 79, InitialVarSync: true},
+		{Id: "fuji_ex", RoomId: 344, VarId: 3218, SwitchId: 3219, SwitchValue: true},
+		{Id: "hozo", RoomId: 1899, VarId: 4268, SwitchId: 5019, SwitchValue: true},
+	},
+	//"amillusion": {
+	//	{Id: "cartoonboy", RoomId: 185, VarId: 86, SwitchId: 137, SwitchValue: true},
+	//},
+	"mikan": {
+		{Id: "ta_be", RoomId: 6, VarId: 17, SwitchId: 14, SwitchValue: true},
+		{Id: "ta_be_hardcore", RoomId: 86, VarId: 17, SwitchId: 14, SwitchValue: true},
+	},
+	"ultraviolet": {
+		{Id: "panerabbit", RoomId: 118, VarId: 152, SwitchId: 302, SwitchValue: true},
+	},
+}
+
+var gameMinigames []*Minigame
+
+// setMinigames builds the game's minigames from the built-in ones and the config,
+// where entries with a room_id define a minigame and others only adjust a built-in one
+func setMinigames() {
+	gameMinigames = nil
+
+	for _, defaultMinigame := range defaultMinigames[getConfig().gameName] {
+		minigame := *defaultMinigame
+		gameMinigames = append(gameMinigames, &minigame)
+	}
+
+	for _, minigameConfig := range getConfig().minigames {
+		minigame, ok := getMinigame(minigameConfig.Id)
+
+		if minigameConfig.RoomId != 0 {
+			definedMinigame := &Minigame{
+				Id:             minigameConfig.Id,
+				RoomId:         minigameConfig.RoomId,
+				VarId:          minigameConfig.VarId,
+				InitialVarSync: minigameConfig.InitialVarSync,
+				SwitchId:       minigameConfig.SwitchId,
+				SwitchValue:    minigameConfig.SwitchValue,
+				Dev:            minigameConfig.Dev,
+			}
+
+			if ok {
+				*minigame = *definedMinigame
+			} else {
+				minigame = definedMinigame
+				gameMinigames = append(gameMinigames, minigame)
+			}
+		} else if !ok {
+			continue
+		}
+
+		if minigameConfig.Name != "" {
+			minigame.Name = minigameConfig.Name
+		}
+		if minigameConfig.ScoreDirection != "" {
+			minigame.LowerIsBetter = minigameConfig.ScoreDirection == minigameScoreDirectionLower
+		}
+		minigame.ActiveFrom = minigameConfig.ActiveFrom
+		minigame.ActiveUntil = minigameConfig.ActiveUntil
+	}
+
+	for _, minigame := range gameMinigames {
+		if minigame.Name == "" {
+			minigame.Name = minigame.Id
+		}
+	}
+}
+
+func getMinigame(minigameId string) (*Minigame, bool) {
+	for _, minigame := range gameMinigames {
+		if minigame.Id == minigameId {
+			return minigame, true
+		}
+	}
+
+	return nil, false
 }
 
 func getRoomMinigames(roomId int) (minigames []*Minigame) {
-	switch config.gameName {
-	case "yume":
-		if roomId == 155 {
-			minigames = append(minigames, &Minigame{Id: "nasu", VarId: 88, SwitchId: 215})
-		}
-	case "2kki":
-		switch roomId {
-		case 102:
-			minigames = append(minigames, &Minigame{Id: "rby", VarId: 1010, InitialVarSync: true})
-		case 618:
-			minigames = append(minigames, &Minigame{Id: "rby_ex", VarId: 79, InitialVarSync: true})
-		case 344:
-			minigames = append(minigames, &Minigame{Id: "fuji_ex", VarId: 3218, SwitchId: 3219, SwitchValue: true})
-		case 1899:
-			minigames = append(minigames, &Minigame{Id: "hozo", VarId: 4268, SwitchId: 5019, SwitchValue: true})
-		}
-	//case "amillusion":
-	//	if roomId == 185 {
-	//		minigames = append(minigames, &Minigame{Id: "cartoonboy", VarId: 86, SwitchId: 137, SwitchValue: true})
-	//	}
-	case "mikan":
-		switch roomId {
-		case 6:
-			minigames = append(minigames, &Minigame{Id: "ta_be", VarId: 17, SwitchId: 14, SwitchValue: true})
-		case 86:
-			minigames = append(minigames, &Minigame{Id: "ta_be_hardcore", VarId: 17, SwitchId: 14, SwitchValue: true})
-		}
-	case "ultraviolet":
-		if roomId == 118 {
-			minigames = append(minigames, &Minigame{Id: "panerabbit", VarId: 152, SwitchId: 302, SwitchValue: true})
+	for _, minigame := range gameMinigames {
+		if minigame.RoomId == roomId {
+			minigames = append(minigames, minigame)
 		}
 	}
+
 	return minigames
 }
 
+// isBetterScore reports whether score beats prevScore, a prevScore of 0 meaning there is none yet
+func (m *Minigame) isBetterScore(score int, prevScore int) bool {
+	if prevScore <= 0 {
+		return score > 0
+	}
+
+	if m.LowerIsBetter {
+		return score < prevScore
+	}
+
+	return score > prevScore
+}
+
+// isActive reports whether scores can currently be submitted
+func (m *Minigame) isActive() bool {
+	now := time.Now()
+
+	if !m.ActiveFrom.IsZero() && now.Before(m.ActiveFrom) {
+		return false
+	}
+
+	return m.ActiveUntil.IsZero() || now.Before(m.ActiveUntil)
+}
+
+func getMinigameConfig(minigameId string) (*MinigameConfig, bool) {
+	for i := range getConfig().minigames {
+		if getConfig().minigames[i].Id == minigameId {
+			return &getConfig().minigames[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// submitMinigameScore records a new high score if it passes the minigame's configured checks
+func (c *RoomClient) submitMinigameScore(minigame *Minigame, score int) {
+	err := c.validateMinigameScore(minigame, score)
+	if err != nil {
+		writeErrLog(c.session.uuid, c.mapId, "rejected "+minigame.Id+" score "+strconv.Itoa(score)+": "+err.Error())
+		return
+	}
+
+	_, err = tryWritePlayerMinigameScore(c.session.uuid, minigame, score)
+	if err != nil {
+		writeErrLog(c.session.uuid, c.mapId, "failed to write player minigame score for "+minigame.Id+": "+err.Error())
+	}
+}
+
+// validateMinigameScore checks a score against the plausible maximum of the minigame
+// and what the client did in the map before submitting it
+func (c *RoomClient) validateMinigameScore(minigame *Minigame, score int) error {
+	if !minigame.isActive() {
+		return errors.New("minigame not active")
+	}
+
+	minigameConfig, ok := getMinigameConfig(minigame.Id)
+	if !ok {
+		return nil
+	}
+
+	if minigameConfig.MaxScore > 0 && score > minigameConfig.MaxScore {
+		return errors.New("score above maximum")
+	}
+	if score < minigameConfig.MinScore {
+		return errors.New("score below minimum")
+	}
+
+	if minigameConfig.MinSeconds > 0 && time.Since(c.timestampJoined) < time.Duration(minigameConfig.MinSeconds)*time.Second {
+		return errors.New("played for too short a time")
+	}
+
+	for _, switchId := range minigameConfig.RequiredSwitches {
+		if !c.switchCache[switchId] {
+			return errors.New("required switch " + strconv.Itoa(switchId) + " not observed")
+		}
+	}
+
+	for _, varId := range minigameConfig.RequiredVars {
+		if _, ok := c.varCache[varId]; !ok {
+			return errors.New("required variable " + strconv.Itoa(varId) + " not observed")
+		}
+	}
+
+	return nil
+}
+
 func getPlayerMinigameScore(playerUuid string, minigameId string) (score int, err error) {
 	err = db.QueryRow("SELECT score FROM playerMinigameScores WHERE uuid = ? AND minigameId = ?", playerUuid, minigameId).Scan(&score)
 	if err != nil {
@@ -79,28 +258,159 @@ func getPlayerMinigameScore(playerUuid string, minigameId string) (score int, er
 	return score, nil
 }
 
-func tryWritePlayerMinigameScore(playerUuid string, minigameId string, score int) (success bool, err error) {
+// getPlayerMinigameScores returns the player's high scores in every game by minigame ID
+func getPlayerMinigameScores(playerUuid string) (scores map[string]int, err error) {
+	scores = make(map[string]int)
+
+	results, err := db.Replica().Query("SELECT minigameId, score FROM playerMinigameScores WHERE uuid = ?", playerUuid)
+	if err != nil {
+		return scores, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		var minigameId string
+		var score int
+
+		err := results.Scan(&minigameId, &score)
+		if err != nil {
+			return scores, err
+		}
+
+		scores[minigameId] = score
+	}
+
+	return scores, nil
+}
+
+func tryWritePlayerMinigameScore(playerUuid string, minigame *Minigame, score int) (success bool, err error) {
 	if score <= 0 {
 		return false, nil
 	}
 
+	minigameId := minigame.Id
+
 	prevScore, err := getPlayerMinigameScore(playerUuid, minigameId)
 	if err != nil {
 		return false, err
-	} else if score <= prevScore {
+	} else if !minigame.isBetterScore(score, prevScore) {
 		return false, nil
 	} else if prevScore > 0 {
-		_, err = db.Exec("UPDATE playerMinigameScores SET score = ?, timestampCompleted = ? WHERE uuid = ? AND game = ? AND minigameId = ?", score, time.Now(), playerUuid, config.gameName, minigameId)
+		_, err = db.Exec("UPDATE playerMinigameScores SET score = ?, timestampCompleted = ? WHERE uuid = ? AND game = ? AND minigameId = ?", score, time.Now(), playerUuid, getConfig().gameName, minigameId)
 		if err != nil {
 			return false, err
 		}
 		return true, nil
 	}
 
-	_, err = db.Exec("INSERT INTO playerMinigameScores (uuid, game, minigameId, score, timestampCompleted) VALUES (?, ?, ?, ?, ?)", playerUuid, config.gameName, minigameId, score, time.Now())
+	_, err = db.Exec("INSERT INTO playerMinigameScores (uuid, game, minigameId, score, timestampCompleted) VALUES (?, ?, ?, ?, ?)", playerUuid, getConfig().gameName, minigameId, score, time.Now())
 	if err != nil {
 		return false, err
 	}
 
 	return true, nil
 }
+
+func handleMinigame(w http.ResponseWriter, r *http.Request) {
+	commandParam := r.URL.Query().Get("command")
+	if commandParam == "" {
+		handleError(w, r, "command not specified")
+		return
+	}
+
+	switch commandParam {
+	case "list":
+		minigame, ok := getMinigame(r.URL.Query().Get("id"))
+		if !ok {
+			handleError(w, r, "unknown minigame")
+			return
+		}
+
+		leaderboard := MinigameLeaderboard{Name: minigame.Name}
+		var err error
+
+		leaderboard.Entries, err = getMinigameLeaderboard(minigame, minigameLeaderboardSize)
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+
+		if token := r.Header.Get("Authorization"); token != "" {
+			if uuid := getUuidFromToken(token); uuid != "" {
+				leaderboard.PlayerEntry, err = getPlayerMinigameLeaderboardEntry(uuid, minigame)
+				if err != nil {
+					handleInternalError(w, r, err)
+					return
+				}
+			}
+		}
+
+		leaderboardJson, err := json.Marshal(leaderboard)
+		if err != nil {
+			handleError(w, r, "error while marshaling")
+			return
+		}
+
+		w.Write(leaderboardJson)
+	default:
+		handleError(w, r, "unknown command")
+	}
+}
+
+// getMinigameLeaderboard returns the best scores of a minigame, ties going to whoever reached the score first
+func getMinigameLeaderboard(minigame *Minigame, limit int) (entries []*MinigameLeaderboardEntry, err error) {
+	entries = []*MinigameLeaderboardEntry{}
+
+	order := "DESC"
+	if minigame.LowerIsBetter {
+		order = "ASC"
+	}
+
+	results, err := db.Replica().Query("SELECT ms.uuid, a.user, pd.rank, COALESCE(a.badge, ''), ms.score, ms.timestampCompleted FROM playerMinigameScores ms JOIN accounts a ON a.uuid = ms.uuid JOIN players pd ON pd.uuid = ms.uuid WHERE ms.minigameId = ? AND pd.banned = 0 ORDER BY ms.score "+order+", ms.timestampCompleted LIMIT ?", minigame.Id, limit)
+	if err != nil {
+		return entries, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		entry := &MinigameLeaderboardEntry{Position: len(entries) + 1}
+
+		err := results.Scan(&entry.Uuid, &entry.Name, &entry.Rank, &entry.Badge, &entry.Score, &entry.TimestampCompleted)
+		if err != nil {
+			return entries, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// getPlayerMinigameLeaderboardEntry returns the player's best score and its position, or nil if they have none
+func getPlayerMinigameLeaderboardEntry(playerUuid string, minigame *Minigame) (*MinigameLeaderboardEntry, error) {
+	entry := &MinigameLeaderboardEntry{Uuid: playerUuid}
+
+	minigameId := minigame.Id
+
+	comparison := ">"
+	if minigame.LowerIsBetter {
+		comparison = "<"
+	}
+
+	err := db.Replica().QueryRow("SELECT a.user, pd.rank, COALESCE(a.badge, ''), ms.score, ms.timestampCompleted FROM playerMinigameScores ms JOIN accounts a ON a.uuid = ms.uuid JOIN players pd ON pd.uuid = ms.uuid WHERE ms.uuid = ? AND ms.minigameId = ?", playerUuid, minigameId).Scan(&entry.Name, &entry.Rank, &entry.Badge, &entry.Score, &entry.TimestampCompleted)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	err = db.Replica().QueryRow("SELECT COUNT(*) + 1 FROM playerMinigameScores ms JOIN players pd ON pd.uuid = ms.uuid JOIN accounts a ON a.uuid = ms.uuid WHERE ms.minigameId = ? AND pd.banned = 0 AND (ms.score "+comparison+" ? OR (ms.score = ? AND ms.timestampCompleted < ?))", minigameId, entry.Score, entry.Score, entry.TimestampCompleted).Scan(&entry.Position)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
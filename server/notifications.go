@@ -145,17 +145,31 @@ func handleUnregisterSubscriber(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleVapidPublicKeyRequest(w http.ResponseWriter, _ *http.Request) {
-	w.Write([]byte(config.vapidKeys.public))
+	w.Write([]byte(getConfig().vapidKeys.public))
 }
 
 // If `uuids` is nil, sends the message to all users.
 func sendPushNotification(notification *Notification, uuids []string) error {
 	placeholder, uuidParams := getPlaceholders(uuids...)
 
-	query := "SELECT endpoint, p256dh, auth FROM pushSubscriptions"
+	query := "SELECT endpoint, p256dh, auth FROM pushSubscriptions WHERE 1"
 	if len(uuidParams) > 0 {
-		query += " WHERE uuid IN (" + placeholder + ")"
+		query += " AND uuid IN (" + placeholder + ")"
 	}
+
+	// players in do-not-disturb don't receive notifications
+	var dndUuids []string
+	for _, client := range clients.Get() {
+		if client.status == playerStatusDnd {
+			dndUuids = append(dndUuids, client.uuid)
+		}
+	}
+	if len(dndUuids) > 0 {
+		dndPlaceholder, dndParams := getPlaceholders(dndUuids...)
+		query += " AND uuid NOT IN (" + dndPlaceholder + ")"
+		uuidParams = append(uuidParams, dndParams...)
+	}
+
 	results, err := db.Query(query, uuidParams...)
 	if err != nil {
 		return err
@@ -178,8 +192,8 @@ func sendPushNotification(notification *Notification, uuids []string) error {
 		}
 		resp, err := webpush.SendNotification(notificationString, &s, &webpush.Options{
 			Subscriber:      "contact@ynoproject.net",
-			VAPIDPublicKey:  config.vapidKeys.public,
-			VAPIDPrivateKey: config.vapidKeys.private,
+			VAPIDPublicKey:  getConfig().vapidKeys.public,
+			VAPIDPrivateKey: getConfig().vapidKeys.private,
 			TTL:             30, // seconds,
 		})
 		if err != nil {
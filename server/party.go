@@ -18,9 +18,11 @@
 package server
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"sync"
 )
 
 type Party struct {
@@ -31,33 +33,120 @@ type Party struct {
 	SystemName  string                `json:"systemName"`
 	Description string                `json:"description"`
 	OwnerUuid   string                `json:"ownerUuid"`
+	CoLocate    bool                  `json:"coLocate"` // share overflow room instances
 	Members     []*PlayerListFullData `json:"members"`
 }
 
-var parties = make(map[int]*Party)
+// PartyUpdate is the last party data sent to the members of a party, along with the sessions it was sent to
+type PartyUpdate struct {
+	json       []byte
+	recipients map[string]*SessionClient
+}
+
+var (
+	parties = make(map[int]*Party)
 
+	// only used by sendPartyUpdate
+	partyUpdates = make(map[int]*PartyUpdate)
+
+	// response of the party list, rebuilt with every party update and whenever a party changes
+	partyListJson    []byte
+	partyListJsonMtx sync.Mutex
+)
+
+// sendPartyUpdate sends party data to the online members of parties that changed since the last update
+// and to members who haven't received it in their current session
 func sendPartyUpdate() {
 	parties, err := getAllPartyData()
 	if err != nil {
 		return
 	}
 
+	setPartyListJson(parties)
+
+	updatedPartyIds := make(map[int]bool)
+
 	for _, party := range parties { // for every party
 		partyDataJson, err := json.Marshal(party)
 		if err != nil {
 			continue
 		}
 
+		update, ok := partyUpdates[party.Id]
+		if !ok || !bytes.Equal(update.json, partyDataJson) {
+			update = &PartyUpdate{
+				json:       partyDataJson,
+				recipients: make(map[string]*SessionClient),
+			}
+			partyUpdates[party.Id] = update
+		}
+
+		updatedPartyIds[party.Id] = true
+
 		for _, member := range party.Members { // for every member
-			if member.Online {
-				if client, ok := clients.Load(member.Uuid); ok {
-					client.outbox <- buildMsg("pt", partyDataJson) // send JSON to client
-				}
+			if !member.Online {
+				continue
 			}
+
+			client, ok := clients.Load(member.Uuid)
+			if !ok || update.recipients[member.Uuid] == client {
+				continue
+			}
+
+			client.outbox <- buildMsg("pt", partyDataJson) // send JSON to client
+
+			update.recipients[member.Uuid] = client
+		}
+	}
+
+	// parties without online members are dropped from the cache
+	for partyId := range partyUpdates {
+		if !updatedPartyIds[partyId] {
+			delete(partyUpdates, partyId)
 		}
 	}
 }
 
+// getPartyListJson returns the party list from the last party update, building it if a party changed since
+func getPartyListJson() ([]byte, error) {
+	partyListJsonMtx.Lock()
+	defer partyListJsonMtx.Unlock()
+
+	if partyListJson != nil {
+		return partyListJson, nil
+	}
+
+	parties, err := getAllPartyData()
+	if err != nil {
+		return nil, err
+	}
+
+	partyListJson, err = json.Marshal(parties)
+
+	return partyListJson, err
+}
+
+func setPartyListJson(parties []*Party) {
+	partyListDataJson, err := json.Marshal(parties)
+	if err != nil {
+		return
+	}
+
+	partyListJsonMtx.Lock()
+
+	partyListJson = partyListDataJson
+
+	partyListJsonMtx.Unlock()
+}
+
+func invalidatePartyListJson() {
+	partyListJsonMtx.Lock()
+
+	partyListJson = nil
+
+	partyListJsonMtx.Unlock()
+}
+
 func (c *SessionClient) cacheParty() error {
 	partyId, err := getPlayerPartyId(c.uuid)
 	if err != nil {
@@ -77,11 +166,13 @@ func (c *SessionClient) cacheParty() error {
 
 	parties[party.Id] = &party
 
+	invalidatePartyListJson()
+
 	return nil
 }
 
 func getPlayerPartyId(uuid string) (partyId int, err error) {
-	err = db.QueryRow("SELECT pm.partyId FROM partyMembers pm JOIN parties p ON p.id = pm.partyId WHERE pm.uuid = ? AND p.game = ?", uuid, config.gameName).Scan(&partyId)
+	err = db.QueryRow("SELECT pm.partyId FROM partyMembers pm JOIN parties p ON p.id = pm.partyId WHERE pm.uuid = ? AND p.game = ?", uuid, getConfig().gameName).Scan(&partyId)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return 0, nil
@@ -103,7 +194,14 @@ func getPartyData(partyId int) (*Party, error) {
 	for _, member := range party.Members {
 		client, ok := clients.Load(member.Uuid)
 		if !ok {
+			if presence, ok := clients.LoadRemote(member.Uuid); ok {
+				hasOnlineMember = true
+				member.applyRemotePresence(presence)
+				continue
+			}
+
 			member.Online = false
+			member.Status = ""
 
 			member.MapId = "0000"
 			member.PrevMapId = "0000"
@@ -141,6 +239,7 @@ func getPartyData(partyId int) (*Party, error) {
 		}
 
 		member.Online = true
+		member.Status = client.status
 	}
 
 	if !hasOnlineMember {
@@ -166,7 +265,7 @@ func getAllPartyData() ([]*Party, error) {
 }
 
 func getPartyDataFromDatabase(playerUuid string) (party Party, err error) {
-	err = db.QueryRow("SELECT p.id, p.owner, p.name, p.public, p.pass, p.theme, p.description FROM parties p JOIN partyMembers pm ON pm.partyId = p.id JOIN playerGameData pgd ON pgd.uuid = pm.uuid AND pgd.game = p.game WHERE p.game = ? AND pm.uuid = ?", config.gameName, playerUuid).Scan(&party.Id, &party.OwnerUuid, &party.Name, &party.Public, &party.Pass, &party.SystemName, &party.Description)
+	err = db.QueryRow("SELECT p.id, p.owner, p.name, p.public, p.pass, p.theme, p.description, p.coLocate FROM parties p JOIN partyMembers pm ON pm.partyId = p.id JOIN playerGameData pgd ON pgd.uuid = pm.uuid AND pgd.game = p.game WHERE p.game = ? AND pm.uuid = ?", getConfig().gameName, playerUuid).Scan(&party.Id, &party.OwnerUuid, &party.Name, &party.Public, &party.Pass, &party.SystemName, &party.Description, &party.CoLocate)
 	if err != nil {
 		return party, err
 	}
@@ -182,7 +281,7 @@ func getPartyDataFromDatabase(playerUuid string) (party Party, err error) {
 }
 
 func getPartyMemberDataFromDatabase(partyId int) (partyMembers []*PlayerListFullData, err error) {
-	results, err := db.Query("SELECT pm.partyId, pm.uuid, COALESCE(a.user, pgd.name), pd.rank, CASE WHEN a.user IS NULL THEN 0 ELSE 1 END, COALESCE(a.badge, ''), pgd.timestampLastActive, pgd.systemName, pgd.spriteName, pgd.spriteIndex, pgd.medalCountBronze, pgd.medalCountSilver, pgd.medalCountGold, pgd.medalCountPlatinum, pgd.medalCountDiamond FROM partyMembers pm JOIN playerGameData pgd ON pgd.uuid = pm.uuid JOIN players pd ON pd.uuid = pgd.uuid JOIN parties p ON p.id = pm.partyId LEFT JOIN accounts a ON a.uuid = pd.uuid WHERE pm.partyId = ? AND pgd.game = ? ORDER BY CASE WHEN p.owner = pm.uuid THEN 0 ELSE 1 END, pd.rank DESC, pm.id", partyId, config.gameName)
+	results, err := db.Query("SELECT pm.partyId, pm.uuid, COALESCE(a.user, pgd.name), pd.rank, CASE WHEN a.user IS NULL THEN 0 ELSE 1 END, COALESCE(a.badge, ''), pgd.timestampLastActive, pgd.systemName, pgd.spriteName, pgd.spriteIndex, pgd.medalCountBronze, pgd.medalCountSilver, pgd.medalCountGold, pgd.medalCountPlatinum, pgd.medalCountDiamond FROM partyMembers pm JOIN playerGameData pgd ON pgd.uuid = pm.uuid JOIN players pd ON pd.uuid = pgd.uuid JOIN parties p ON p.id = pm.partyId LEFT JOIN accounts a ON a.uuid = pd.uuid WHERE pm.partyId = ? AND pgd.game = ? ORDER BY CASE WHEN p.owner = pm.uuid THEN 0 ELSE 1 END, pd.rank DESC, pm.id", partyId, getConfig().gameName)
 	if err != nil {
 		return partyMembers, err
 	}
@@ -212,15 +311,8 @@ func getPartyMemberDataFromDatabase(partyId int) (partyMembers []*PlayerListFull
 	return partyMembers, nil
 }
 
-func createPartyData(name string, public bool, pass string, theme string, description string, playerUuid string) (partyId int, err error) {
-	results, err := db.Exec("INSERT INTO parties (game, owner, name, public, pass, theme, description) VALUES (?, ?, ?, ?, ?, ?, ?)", config.gameName, playerUuid, name, public, pass, theme, description)
-	if err != nil {
-		return 0, err
-	}
-
-	var partyId64 int64
-
-	partyId64, err = results.LastInsertId()
+func createPartyData(name string, public bool, pass string, theme string, description string, coLocate bool, playerUuid string) (partyId int, err error) {
+	partyId64, err := db.ExecInsert("INSERT INTO parties (game, owner, name, public, pass, theme, description, coLocate) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", getConfig().gameName, playerUuid, name, public, pass, theme, description, coLocate)
 	if err != nil {
 		return 0, err
 	}
@@ -230,8 +322,8 @@ func createPartyData(name string, public bool, pass string, theme string, descri
 	return partyId, nil
 }
 
-func updatePartyData(partyId int, name string, public bool, pass string, theme string, description string, playerUuid string) error {
-	_, err := db.Exec("UPDATE parties SET game = ?, owner = ?, name = ?, public = ?, pass = ?, theme = ?, description = ? WHERE id = ?", config.gameName, playerUuid, name, public, pass, theme, description, partyId)
+func updatePartyData(partyId int, name string, public bool, pass string, theme string, description string, coLocate bool, playerUuid string) error {
+	_, err := db.Exec("UPDATE parties SET game = ?, owner = ?, name = ?, public = ?, pass = ?, theme = ?, description = ?, coLocate = ? WHERE id = ?", getConfig().gameName, playerUuid, name, public, pass, theme, description, coLocate, partyId)
 	if err != nil {
 		return err
 	}
@@ -247,6 +339,9 @@ func updatePartyData(partyId int, name string, public bool, pass string, theme s
 	party.Pass = pass
 	party.SystemName = theme
 	party.Description = description
+	party.CoLocate = coLocate
+
+	invalidatePartyListJson()
 
 	return nil
 }
@@ -257,7 +352,9 @@ func joinPlayerParty(partyId int, playerUuid string) error {
 		return err
 	}
 
-	_, err = db.Exec("UPDATE playerGameData pgd SET pgd.lastPartyMsgId = (SELECT cm.msgId FROM chatMessages cm WHERE cm.game = pgd.game AND cm.partyId = ? AND cm.timestamp = (SELECT MAX(timestamp) FROM chatMessages WHERE game = cm.game AND partyId = cm.partyId) LIMIT 1) WHERE pgd.uuid = ? AND pgd.game = ?", partyId, playerUuid, config.gameName)
+	incrementPlayerStat(playerUuid, statPartiesJoined)
+
+	_, err = db.Exec("UPDATE playerGameData pgd SET pgd.lastPartyMsgId = (SELECT cm.msgId FROM chatMessages cm WHERE cm.game = pgd.game AND cm.partyId = ? AND cm.timestamp = (SELECT MAX(timestamp) FROM chatMessages WHERE game = cm.game AND partyId = cm.partyId) LIMIT 1) WHERE pgd.uuid = ? AND pgd.game = ?", partyId, playerUuid, getConfig().gameName)
 	if err != nil {
 		return err
 	}
@@ -272,6 +369,8 @@ func joinPlayerParty(partyId int, playerUuid string) error {
 
 		parties[partyId] = &party
 
+		invalidatePartyListJson()
+
 		return nil
 	}
 
@@ -300,6 +399,8 @@ func joinPlayerParty(partyId int, playerUuid string) error {
 
 	client.partyId = partyId
 
+	invalidatePartyListJson()
+
 	return nil
 }
 
@@ -309,12 +410,12 @@ func leavePlayerParty(playerUuid string) error {
 		return err
 	}
 
-	_, err = db.Exec("DELETE pm FROM partyMembers pm JOIN parties p ON p.id = pm.partyId WHERE pm.uuid = ? AND p.game = ?", playerUuid, config.gameName)
+	_, err = db.Exec("DELETE FROM partyMembers WHERE uuid = ? AND partyId IN (SELECT id FROM parties WHERE game = ?)", playerUuid, getConfig().gameName)
 	if err != nil {
 		return err
 	}
 
-	_, err = db.Exec("UPDATE playerGameData SET lastPartyMsgId = NULL WHERE uuid = ? AND game = ?", playerUuid, config.gameName)
+	_, err = db.Exec("UPDATE playerGameData SET lastPartyMsgId = NULL WHERE uuid = ? AND game = ?", playerUuid, getConfig().gameName)
 	if err != nil {
 		return err
 	}
@@ -340,6 +441,8 @@ func leavePlayerParty(playerUuid string) error {
 		client.partyId = 0
 	}
 
+	invalidatePartyListJson()
+
 	return nil
 }
 
@@ -410,6 +513,8 @@ func setPartyOwner(partyId int, playerUuid string) error {
 
 	party.OwnerUuid = playerUuid
 
+	invalidatePartyListJson()
+
 	return nil
 }
 
@@ -427,6 +532,8 @@ func checkDeleteOrphanedParty(partyId int) (deleted bool, err error) {
 
 		delete(parties, partyId)
 
+		invalidatePartyListJson()
+
 		return true, nil
 	}
 
@@ -446,11 +553,13 @@ func deletePartyAndMembers(partyId int) error {
 
 	delete(parties, partyId)
 
+	invalidatePartyListJson()
+
 	return nil
 }
 
 func writePartyChatMessage(msgId, uuid, mapId, prevMapId, prevLocations string, x, y int, contents string, partyId int) error {
-	_, err := db.Exec("INSERT INTO chatMessages (msgId, game, uuid, mapId, prevMapId, prevLocations, x, y, contents, partyId) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", msgId, config.gameName, uuid, mapId, prevMapId, prevLocations, x, y, contents, partyId)
+	_, err := db.Exec("INSERT INTO chatMessages (msgId, game, uuid, mapId, prevMapId, prevLocations, x, y, contents, partyId) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", msgId, getConfig().gameName, uuid, mapId, prevMapId, prevLocations, x, y, contents, partyId)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,153 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// the player data looked up by nearly every API request is cached,
+// the TTL bounds how long changes made directly in the database or to session expiration take to apply
+const (
+	playerDataCacheDuration = time.Minute
+)
+
+type cachedTokenPlayerData struct {
+	uuid   string
+	name   string
+	rank   int
+	badge  string
+	banned bool
+	muted  bool
+
+	expiration time.Time
+}
+
+type cachedPlayerRank struct {
+	rank       int
+	expiration time.Time
+}
+
+var (
+	tokenPlayerData    = make(map[string]*cachedTokenPlayerData)
+	playerRanks        = make(map[string]*cachedPlayerRank)
+	playerDataCacheMtx sync.Mutex
+)
+
+func initPlayerDataCache() {
+	logInitTask("player data cache")
+
+	scheduleTask("playerDataCachePrune", scheduler.Every(1).Minute(), prunePlayerDataCache)
+}
+
+func loadCachedTokenPlayerData(token string) (*cachedTokenPlayerData, bool) {
+	playerDataCacheMtx.Lock()
+	defer playerDataCacheMtx.Unlock()
+
+	cached, ok := tokenPlayerData[token]
+	if !ok || time.Now().After(cached.expiration) {
+		return nil, false
+	}
+
+	return cached, true
+}
+
+func storeCachedTokenPlayerData(token string, data *cachedTokenPlayerData) {
+	data.expiration = time.Now().Add(playerDataCacheDuration)
+
+	playerDataCacheMtx.Lock()
+
+	tokenPlayerData[token] = data
+
+	playerDataCacheMtx.Unlock()
+}
+
+func loadCachedPlayerRank(uuid string) (int, bool) {
+	playerDataCacheMtx.Lock()
+	defer playerDataCacheMtx.Unlock()
+
+	cached, ok := playerRanks[uuid]
+	if !ok || time.Now().After(cached.expiration) {
+		return 0, false
+	}
+
+	return cached.rank, true
+}
+
+func storeCachedPlayerRank(uuid string, rank int) {
+	playerDataCacheMtx.Lock()
+
+	playerRanks[uuid] = &cachedPlayerRank{
+		rank:       rank,
+		expiration: time.Now().Add(playerDataCacheDuration),
+	}
+
+	playerDataCacheMtx.Unlock()
+}
+
+// invalidatePlayerData drops the cached data of a player after their rank, moderation status, name, badge or sessions changed,
+// on the processes of every game since accounts are shared between games
+func invalidatePlayerData(uuid string) {
+	invalidateLocalPlayerData(uuid)
+
+	if redisClient != nil {
+		publishRedisMessage(getPlayersChannel(), &ClusterMessage{
+			Type: clusterMsgInvalidatePlayer,
+			Uuid: uuid,
+		})
+		return
+	}
+
+	// without redis, the other games served from this host are told over IPC
+	invalidateSiblingPlayerData(uuid)
+}
+
+func invalidateLocalPlayerData(uuid string) {
+	playerDataCacheMtx.Lock()
+
+	delete(playerRanks, uuid)
+
+	for token, cached := range tokenPlayerData {
+		if cached.uuid == uuid {
+			delete(tokenPlayerData, token)
+		}
+	}
+
+	playerDataCacheMtx.Unlock()
+}
+
+func prunePlayerDataCache() {
+	now := time.Now()
+
+	playerDataCacheMtx.Lock()
+
+	for token, cached := range tokenPlayerData {
+		if now.After(cached.expiration) {
+			delete(tokenPlayerData, token)
+		}
+	}
+
+	for uuid, cached := range playerRanks {
+		if now.After(cached.expiration) {
+			delete(playerRanks, uuid)
+		}
+	}
+
+	playerDataCacheMtx.Unlock()
+}
@@ -0,0 +1,184 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type PlayerProfile struct {
+	Uuid           string             `json:"uuid"`
+	Name           string             `json:"name"`
+	Rank           int                `json:"rank"`
+	Badge          string             `json:"badge"`
+	BadgeSlots     [][]string         `json:"badgeSlots"`
+	BadgeCount     int                `json:"badgeCount"`
+	Bp             int                `json:"bp"`
+	EventExp       int                `json:"eventExp"`
+	TimeTrials     []*TimeTrialRecord `json:"timeTrials"`
+	MinigameScores map[string]int     `json:"minigameScores"`
+	Registered     time.Time          `json:"registered"`
+	Playtime       int                `json:"playtime"` // seconds
+	Online         bool               `json:"online"`   // not cached
+}
+
+type cachedPlayerProfile struct {
+	profile    *PlayerProfile
+	expiration time.Time
+}
+
+const (
+	playerProfileCacheDuration = 5 * time.Minute
+)
+
+var (
+	playerProfiles    = make(map[string]*cachedPlayerProfile)
+	playerProfilesMtx sync.Mutex
+)
+
+func handleProfile(w http.ResponseWriter, r *http.Request) {
+	playerParam := r.URL.Query().Get("player")
+	if playerParam == "" {
+		handleError(w, r, "player not specified")
+		return
+	}
+
+	// accept either a name or a uuid
+	uuid, err := getUuidFromName(playerParam)
+	if err != nil {
+		handleInternalError(w, r, err)
+		return
+	}
+	if uuid == "" {
+		uuid = playerParam
+	}
+
+	cachedProfile, err := getCachedPlayerProfile(uuid)
+	if err != nil {
+		handleInternalError(w, r, err)
+		return
+	}
+
+	if cachedProfile == nil {
+		handleError(w, r, "player not found")
+		return
+	}
+
+	profile := *cachedProfile
+	profile.Online = clients.Exists(uuid)
+
+	profileJson, err := json.Marshal(profile)
+	if err != nil {
+		handleInternalError(w, r, err)
+		return
+	}
+
+	w.Write(profileJson)
+}
+
+func getCachedPlayerProfile(uuid string) (*PlayerProfile, error) {
+	playerProfilesMtx.Lock()
+	cached, ok := playerProfiles[uuid]
+	playerProfilesMtx.Unlock()
+
+	if ok && time.Now().Before(cached.expiration) {
+		return cached.profile, nil
+	}
+
+	// looked up without holding the lock, so other profiles aren't held up by it.
+	// Profiles are shared between game servers through redis
+	var profile *PlayerProfile
+	if !getRedisCache("profile:"+uuid, &profile) {
+		var err error
+		profile, err = getPlayerProfile(uuid)
+		if err != nil {
+			return nil, err
+		}
+
+		if profile == nil {
+			return nil, nil
+		}
+
+		setRedisCache("profile:"+uuid, profile, playerProfileCacheDuration)
+	}
+
+	playerProfilesMtx.Lock()
+	defer playerProfilesMtx.Unlock()
+
+	for cachedUuid, cached := range playerProfiles {
+		if time.Now().After(cached.expiration) {
+			delete(playerProfiles, cachedUuid)
+		}
+	}
+
+	playerProfiles[uuid] = &cachedPlayerProfile{
+		profile:    profile,
+		expiration: time.Now().Add(playerProfileCacheDuration),
+	}
+
+	return profile, nil
+}
+
+// getPlayerProfile assembles the public profile of an account, returning nil if the account doesn't exist
+func getPlayerProfile(uuid string) (*PlayerProfile, error) {
+	profile := &PlayerProfile{Uuid: uuid}
+
+	var err error
+
+	profile.Name, profile.Rank, profile.Badge, profile.Registered, err = getPlayerProfileAccountData(uuid)
+	if err != nil || profile.Name == "" {
+		return nil, err
+	}
+
+	badgeSlotRows, badgeSlotCols := getPlayerBadgeSlotCounts(profile.Name)
+
+	profile.BadgeSlots, err = getPlayerBadgeSlots(profile.Name, badgeSlotRows, badgeSlotCols)
+	if err != nil {
+		return nil, err
+	}
+
+	profile.BadgeCount, profile.Bp, err = getPlayerBadgeCountAndBp(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	profile.EventExp, err = getPlayerTotalEventExp(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	profile.TimeTrials, err = getPlayerTimeTrialRecords(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	profile.MinigameScores, err = getPlayerMinigameScores(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	profile.Playtime, err = getPlayerPlaytime(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
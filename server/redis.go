@@ -0,0 +1,282 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	redisDialTimeout    = 5 * time.Second
+	redisCommandTimeout = 2 * time.Second
+	redisRetryInterval  = 5 * time.Second
+	redisMaxIdleConns   = 8
+)
+
+// nil if redis is disabled
+var redisClient *RedisClient
+
+// RedisClient is a minimal RESP client covering the commands used for caching and pub/sub
+type RedisClient struct {
+	addr     string
+	password string
+	db       int
+
+	idleConns chan *redisConn
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func initRedis() {
+	if getConfig().redis.addr == "" {
+		return
+	}
+
+	logInitTask("redis")
+
+	redisClient = &RedisClient{
+		addr:      getConfig().redis.addr,
+		password:  getConfig().redis.password,
+		db:        getConfig().redis.db,
+		idleConns: make(chan *redisConn, redisMaxIdleConns),
+	}
+}
+
+func (rc *RedisClient) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", rc.addr, redisDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if rc.password != "" {
+		if _, err := c.do("AUTH", rc.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if rc.db != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(rc.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Do runs a command on a pooled connection
+func (rc *RedisClient) Do(args ...string) (any, error) {
+	var c *redisConn
+	select {
+	case c = <-rc.idleConns:
+	default:
+		var err error
+		c, err = rc.dial()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.conn.SetDeadline(time.Now().Add(redisCommandTimeout))
+
+	reply, err := c.do(args...)
+	if err != nil {
+		// error replies leave the connection usable
+		if _, ok := err.(redisError); !ok {
+			c.conn.Close()
+			return nil, err
+		}
+	}
+
+	select {
+	case rc.idleConns <- c:
+	default:
+		c.conn.Close()
+	}
+
+	return reply, err
+}
+
+func (c *redisConn) do(args ...string) (any, error) {
+	if err := c.writeCommand(args); err != nil {
+		return nil, err
+	}
+
+	return c.readReply()
+}
+
+func (c *redisConn) writeCommand(args []string) error {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	_, err := c.conn.Write(buf.Bytes())
+
+	return err
+}
+
+// readReply reads a reply as a string, int64, []byte, []any or nil
+func (c *redisConn) readReply() (any, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, errors.New("redis: malformed reply")
+	}
+
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < 0 {
+			return nil, err
+		}
+
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+
+		return data[:length], nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 {
+			return nil, err
+		}
+
+		elements := make([]any, count)
+		for i := range elements {
+			elements[i], err = c.readReply()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return elements, nil
+	}
+
+	return nil, errors.New("redis: unknown reply type")
+}
+
+// subscribe delivers messages published to a channel to the handler, reconnecting until the process exits
+func (rc *RedisClient) subscribe(channel string, handler func([]byte)) {
+	for {
+		err := rc.receive(channel, handler)
+		writeErrLog("SERVER", "redis", err.Error())
+
+		time.Sleep(redisRetryInterval)
+	}
+}
+
+func (rc *RedisClient) receive(channel string, handler func([]byte)) error {
+	c, err := rc.dial()
+	if err != nil {
+		return err
+	}
+	defer c.conn.Close()
+
+	if err := c.writeCommand([]string{"SUBSCRIBE", channel}); err != nil {
+		return err
+	}
+
+	for {
+		reply, err := c.readReply()
+		if err != nil {
+			return err
+		}
+
+		// messages are replies of the form ["message", channel, payload]
+		fields, ok := reply.([]any)
+		if !ok || len(fields) != 3 {
+			continue
+		}
+		if kind, _ := fields[0].([]byte); string(kind) != "message" {
+			continue
+		}
+		if payload, ok := fields[2].([]byte); ok {
+			handler(payload)
+		}
+	}
+}
+
+// getRedisCache reads a cached JSON value into value, returning false on a miss or if redis is disabled
+func getRedisCache(key string, value any) bool {
+	if redisClient == nil {
+		return false
+	}
+
+	reply, err := redisClient.Do("GET", getConfig().redis.keyPrefix+key)
+	if err != nil {
+		writeErrLog("SERVER", "redis", err.Error())
+		return false
+	}
+
+	data, ok := reply.([]byte)
+	if !ok {
+		return false
+	}
+
+	return json.Unmarshal(data, value) == nil
+}
+
+func setRedisCache(key string, value any, ttl time.Duration) {
+	if redisClient == nil {
+		return
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+
+	_, err = redisClient.Do("SET", getConfig().redis.keyPrefix+key, string(data), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		writeErrLog("SERVER", "redis", err.Error())
+	}
+}
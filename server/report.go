@@ -60,9 +60,9 @@ func initReports() {
 	reportLog = make(map[string]string)
 
 	var err error
-	bot, err = discordgo.New("Bot " + config.moderation.botToken)
+	bot, err = discordgo.New("Bot " + getConfig().moderation.botToken)
 	if err != nil {
-		if config.moderation.botToken != "" {
+		if getConfig().moderation.botToken != "" {
 			log.Fatalf("initReports(bot): %s", err)
 		}
 		log.Printf("no bot token defined, not launching bot thread. (err=%s)", err)
@@ -177,7 +177,7 @@ func initReports() {
 			}
 
 			// reset the selection
-			edit := discordgo.NewMessageEdit(config.moderation.channelId, action.Interaction.Message.ID)
+			edit := discordgo.NewMessageEdit(getConfig().moderation.channelId, action.Interaction.Message.ID)
 			edit.Components = &action.Interaction.Message.Components
 			if _, err = bot.ChannelMessageEditComplex(edit); err != nil {
 				log.Printf("bot/cmd/edit: %s", err)
@@ -272,9 +272,9 @@ func formatReportLog(obj interface{}, targetUuid, ynoMsgId, originalMsg string,
 		})
 	}
 
-	content := fmt.Sprintf("<@&%s>", config.moderation.modRoleId)
+	content := fmt.Sprintf("<@&%s>", getConfig().moderation.modRoleId)
 	allowedMentions := &discordgo.MessageAllowedMentions{
-		Roles: []string{config.moderation.modRoleId},
+		Roles: []string{getConfig().moderation.modRoleId},
 	}
 	switch msg := obj.(type) {
 	case *discordgo.MessageSend:
@@ -377,13 +377,13 @@ GROUP BY reason`, uuid)
 
 	var msg *discordgo.Message
 	if discordMsgId, ok := reportLog[uuid]; ok {
-		payload := discordgo.NewMessageEdit(config.moderation.channelId, discordMsgId)
+		payload := discordgo.NewMessageEdit(getConfig().moderation.channelId, discordMsgId)
 		formatReportLog(payload, uuid, ynoMsgId, originalMsg, reasons)
 		msg, err = bot.ChannelMessageEditComplex(payload)
 	} else {
 		payload := &discordgo.MessageSend{}
 		formatReportLog(payload, uuid, ynoMsgId, originalMsg, reasons)
-		msg, err = bot.ChannelMessageSendComplex(config.moderation.channelId, payload)
+		msg, err = bot.ChannelMessageSendComplex(getConfig().moderation.channelId, payload)
 	}
 
 	if msg != nil && err == nil {
@@ -395,7 +395,7 @@ GROUP BY reason`, uuid)
 
 func createReport(uuid, targetUuid, reason, msgId, originalMsg string) (string, string, error) {
 	var err error
-	row := db.QueryRow("SELECT contents FROM chatMessages WHERE msgId = ? AND uuid = ? AND game = ?", msgId, targetUuid, config.gameName)
+	row := db.QueryRow("SELECT contents FROM chatMessages WHERE msgId = ? AND uuid = ? AND game = ?", msgId, targetUuid, getConfig().gameName)
 	var contentsFromDb string
 	err = row.Scan(&contentsFromDb)
 	if err == nil {
@@ -413,11 +413,12 @@ func createReport(uuid, targetUuid, reason, msgId, originalMsg string) (string,
 	}
 
 	_, err = db.Exec(`
-REPLACE INTO playerReports
+INSERT INTO playerReports
 	(uuid, targetUuid, msgId, game, reason, originalMsg, timestampReported, actionTaken)
 VALUES
-	(?, ?, ?, ?, ?, ?, NOW(), 0)`,
-		uuid, targetUuid, msgIdLink, config.gameName, urlReplacer.Replace(reason), originalMsg)
+	(?, ?, ?, ?, ?, ?, NOW(), 0)
+`+db.upsert("uuid, targetUuid", "msgId = ?, game = ?, reason = ?, originalMsg = ?, timestampReported = NOW(), actionTaken = 0"),
+		uuid, targetUuid, msgIdLink, getConfig().gameName, urlReplacer.Replace(reason), originalMsg, msgIdLink, getConfig().gameName, urlReplacer.Replace(reason), originalMsg)
 	return msgId, originalMsg, err
 }
 
@@ -19,12 +19,16 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 	"unicode/utf8"
 
 	"github.com/fasthttp/websocket"
@@ -32,16 +36,40 @@ import (
 
 var rooms = make(map[int]*Room)
 
+type RoomPopulation struct {
+	MapId       string `json:"mapId"`
+	PlayerCount int    `json:"playerCount"`
+	Instances   []int  `json:"instances"` // player count of each instance, starting with the primary one
+}
+
+const (
+	roomPopulationCacheDuration = 5 * time.Second
+)
+
+var (
+	roomPopulationJson       []byte
+	roomPopulationExpiration time.Time
+	roomPopulationMtx        sync.Mutex
+)
+
 type Room struct {
 	id           int
 	singleplayer bool
 
-	clients []*RoomClient
+	instances    []*RoomInstance // the first instance is the primary one, the rest are overflow
+	instancesMtx sync.Mutex
 
 	conditions []*Condition
 	minigames  []*Minigame
 }
 
+// RoomInstance is a copy of a map with its own broadcast domain
+type RoomInstance struct {
+	id int
+
+	clients []*RoomClient
+}
+
 func createRooms(roomIds []int, spRooms []int) {
 	logInitTask("rooms")
 
@@ -49,12 +77,65 @@ func createRooms(roomIds []int, spRooms []int) {
 		rooms[roomId] = &Room{
 			id:           roomId,
 			singleplayer: slices.Contains(spRooms, roomId),
+			instances:    []*RoomInstance{{}},
 			conditions:   getRoomConditions(roomId),
 			minigames:    getRoomMinigames(roomId),
 		}
 	}
 }
 
+func handleRooms(w http.ResponseWriter, r *http.Request) {
+	roomPopulationMtx.Lock()
+	defer roomPopulationMtx.Unlock()
+
+	if time.Now().After(roomPopulationExpiration) {
+		var err error
+		roomPopulationJson, err = json.Marshal(getRoomPopulations())
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+
+		roomPopulationExpiration = time.Now().Add(roomPopulationCacheDuration)
+	}
+
+	w.Write(roomPopulationJson)
+}
+
+// getRoomPopulations returns the player counts of every occupied room,
+// not counting players hiding their location
+func getRoomPopulations() []*RoomPopulation {
+	populations := []*RoomPopulation{}
+
+	for _, room := range rooms {
+		room.instancesMtx.Lock()
+
+		population := &RoomPopulation{
+			MapId:     fmt.Sprintf("%04d", room.id),
+			Instances: make([]int, len(room.instances)),
+		}
+
+		for i, instance := range room.instances {
+			for _, client := range instance.clients {
+				if client.session.hideLocation || client.spectator {
+					continue
+				}
+
+				population.Instances[i]++
+				population.PlayerCount++
+			}
+		}
+
+		room.instancesMtx.Unlock()
+
+		if population.PlayerCount != 0 {
+			populations = append(populations, population)
+		}
+	}
+
+	return populations
+}
+
 func handleRoom(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, http.Header{"Sec-Websocket-Protocol": {r.Header.Get("Sec-Websocket-Protocol")}})
 	if err != nil {
@@ -62,6 +143,8 @@ func handleRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	prepareWsConn(conn)
+
 	id := r.URL.Query().Get("id")
 	if id == "" {
 		return
@@ -78,10 +161,10 @@ func handleRoom(w http.ResponseWriter, r *http.Request) {
 		playerToken = token
 	}
 
-	joinRoomWs(conn, getIp(r), playerToken, idInt)
+	joinRoomWs(conn, getIp(r), playerToken, idInt, r.URL.Query().Get("binary") == "1", r.URL.Query().Get("spectate") == "1")
 }
 
-func joinRoomWs(conn *websocket.Conn, ip string, token string, roomId int) {
+func joinRoomWs(conn *websocket.Conn, ip string, token string, roomId int, binary bool, spectate bool) {
 	// we don't need the value of room until later but it would be silly to do
 	// the database lookups then close the socket after due to a bad room id
 	room, ok := rooms[roomId]
@@ -102,9 +185,17 @@ func joinRoomWs(conn *websocket.Conn, ip string, token string, roomId int) {
 		conn:   conn,
 		outbox: make(chan []byte, 256),
 		key:    serverSecurity.NewClientKey(),
+		binary: binary,
 	}
 
 	if session, ok := clients.Load(uuid); ok {
+		if spectate && session.rank == 0 && !getConfig().allowSpectators {
+			writeErrLog(uuid, "0000", "spectating not allowed")
+			return
+		}
+
+		client.spectator = spectate
+
 		if session.roomC != nil {
 			session.roomC.cancel()
 		}
@@ -136,23 +227,89 @@ func joinRoomWs(conn *websocket.Conn, ip string, token string, roomId int) {
 	go client.msgReader()
 
 	// send synced picture names, picture prefixes, and battle animation ids
-	if len(config.pictures) != 0 {
-		client.outbox <- buildMsg("pns", 0, config.pictures)
+	if len(getConfig().pictures) != 0 {
+		client.outbox <- buildMsg("pns", 0, getConfig().pictures)
 	}
-	if len(config.picturePrefixes) != 0 {
-		client.outbox <- buildMsg("pns", 1, config.picturePrefixes)
+	if len(getConfig().picturePrefixes) != 0 {
+		client.outbox <- buildMsg("pns", 1, getConfig().picturePrefixes)
 	}
-	if len(config.battleAnimIds) != 0 {
-		client.outbox <- buildMsg("bas", config.battleAnimIds)
+	if len(getConfig().battleAnimIds) != 0 {
+		client.outbox <- buildMsg("bas", getConfig().battleAnimIds)
 	}
 
-	if config.gameName == "unconscious" {
+	if getConfig().gameName == "unconscious" {
 		didJoinRoomUnconscious(client)
 	}
 
 	writeLog(client.session.uuid, client.mapId, "connect", 200)
 }
 
+// addClient adds a client to the instance of a room it should join, checking the cap
+// and adding the client at once so concurrent joins can't go over it
+func (r *Room) addClient(c *RoomClient) *RoomInstance {
+	r.instancesMtx.Lock()
+	defer r.instancesMtx.Unlock()
+
+	instance := r.getInstance(c)
+
+	// clients in singleplayer rooms don't see each other
+	if !r.singleplayer {
+		instance.clients = append(instance.clients, c)
+	}
+
+	return instance
+}
+
+// removeClient removes a client from its instance, dropping overflow instances left empty at the end
+// of the list. Instances are numbered by their position, so emptied ones before others are kept and filled first.
+func (r *Room) removeClient(c *RoomClient) {
+	r.instancesMtx.Lock()
+	defer r.instancesMtx.Unlock()
+
+	for i, client := range c.instance.clients {
+		if client != c {
+			continue
+		}
+
+		c.instance.clients[i] = c.instance.clients[len(c.instance.clients)-1]
+		c.instance.clients = c.instance.clients[:len(c.instance.clients)-1]
+		break
+	}
+
+	for len(r.instances) > 1 && len(r.instances[len(r.instances)-1].clients) == 0 {
+		r.instances = r.instances[:len(r.instances)-1]
+	}
+}
+
+// getInstance picks the instance of a room a client should join, with instancesMtx held
+func (r *Room) getInstance(c *RoomClient) *RoomInstance {
+	if getConfig().roomPlayerCap <= 0 || r.singleplayer {
+		return r.instances[0]
+	}
+
+	// parties that opted to be co-located stay together even past the cap
+	if party, ok := parties[c.session.partyId]; ok && party.CoLocate {
+		for _, instance := range r.instances {
+			for _, client := range instance.clients {
+				if client.session.partyId == party.Id {
+					return instance
+				}
+			}
+		}
+	}
+
+	for _, instance := range r.instances {
+		if len(instance.clients) < getConfig().roomPlayerCap {
+			return instance
+		}
+	}
+
+	instance := &RoomInstance{id: len(r.instances)}
+	r.instances = append(r.instances, instance)
+
+	return instance
+}
+
 func (c *RoomClient) joinRoom(room *Room) {
 	c.room = room
 
@@ -160,14 +317,22 @@ func (c *RoomClient) joinRoom(room *Room) {
 
 	c.outbox <- buildMsg("ri", c.room.id) // tell client they've switched rooms serverside
 
-	if config.gameName == "2kki" && c.session.rank == 0 {
+	c.instance = room.addClient(c)
+
+	if c.instance.id != 0 {
+		c.outbox <- buildMsg("inst", c.instance.id) // tell client they're in an overflow instance
+	}
+
+	if getConfig().gameName == "2kki" && c.session.rank == 0 {
 		c.outbox <- buildMsg("ss", 11, 2)
 	}
 
 	if !c.room.singleplayer {
 		c.getRoomPlayerData()
 
-		room.clients = append(room.clients, c)
+		if c.spectator {
+			return
+		}
 
 		// tell everyone that a new client has connected
 		c.broadcast(buildMsg("c", c.session.id, c.session.uuid, c.session.rank, c.session.account, c.session.badge, c.session.medals[:])) // user %id% has connected message
@@ -187,20 +352,20 @@ func (c *RoomClient) leaveRoom() {
 	// setting c.room to nil could cause a nil pointer dereference
 	// so we let joinRoom update it
 
-	for i, client := range c.room.clients {
-		if client != c {
-			continue
-		}
+	c.room.removeClient(c)
 
-		c.room.clients[i] = c.room.clients[len(c.room.clients)-1]
-		c.room.clients = c.room.clients[:len(c.room.clients)-1]
+	if c.spectator {
+		return
 	}
 
 	c.broadcast(buildMsg("d", c.session.id)) // user %id% has disconnected message
 }
 
 func (c *RoomClient) broadcast(msg []byte) {
-	for _, client := range c.room.clients {
+	// encoded once for every binary client in the room
+	var binaryMsg []byte
+
+	for _, client := range c.instance.clients {
 		if client == c {
 			continue
 		}
@@ -209,14 +374,34 @@ func (c *RoomClient) broadcast(msg []byte) {
 			continue
 		}
 
+		clientMsg := msg
+		if client.binary {
+			if binaryMsg == nil {
+				binaryMsg = encodeBinaryMsg(msg)
+			}
+			clientMsg = binaryMsg
+		}
+
 		select {
-		case client.outbox <- msg:
+		case client.outbox <- clientMsg:
 		default:
 			writeErrLog(c.session.uuid, c.mapId, "send channel is full")
 		}
 	}
 }
 
+// sendLatencyHint tells the room about notable changes in latency so clients can interpolate movement
+func (c *RoomClient) sendLatencyHint() {
+	latency := int(c.session.latency.Load())
+	if latency == 0 || max(latency-c.latencyHint, c.latencyHint-latency) < latencyHintThreshold {
+		return
+	}
+
+	c.latencyHint = latency
+
+	c.broadcast(buildMsg("lat", c.session.id, latency)) // user %id% has a round trip time of latency ms
+}
+
 func (c *RoomClient) processMsgs(msg []byte) (errs []error) {
 	if len(msg) < 8 {
 		return append(errs, errors.New("bad request size"))
@@ -232,6 +417,21 @@ func (c *RoomClient) processMsgs(msg []byte) (errs []error) {
 
 	msg = msg[8:]
 
+	if c.binary {
+		msgStrs, err := splitBinaryFrames(msg)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		for _, msgStr := range msgStrs {
+			if err := c.processMsg(msgStr); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		return errs
+	}
+
 	if !utf8.Valid(msg) {
 		return append(errs, errors.New("invalid utf8"))
 	}
@@ -247,6 +447,11 @@ func (c *RoomClient) processMsgs(msg []byte) (errs []error) {
 }
 
 func (c *RoomClient) processMsg(msgStr string) (err error) {
+	// spectators can only move between rooms
+	if c.spectator && !strings.HasPrefix(msgStr, "sr"+delim) {
+		return errors.New("spectators cannot send room messages")
+	}
+
 	var updateGameActivity bool
 
 	switch msgFields := strings.Split(msgStr, delim); msgFields[0] {
@@ -293,10 +498,7 @@ func (c *RoomClient) processMsg(msgStr string) (err error) {
 	}
 
 	if updateGameActivity {
-		err = c.session.updatePlayerGameActivity(true)
-		if err != nil {
-			writeErrLog(c.session.uuid, c.mapId, err.Error())
-		}
+		c.session.updatePlayerGameActivity(true)
 	}
 
 	writeLog(c.session.uuid, c.mapId, msgStr, 200)
@@ -306,13 +508,13 @@ func (c *RoomClient) processMsg(msgStr string) (err error) {
 
 func (c *RoomClient) getRoomPlayerData() {
 	// send the new client info about the game state
-	for _, client := range c.room.clients {
+	for _, client := range c.instance.clients {
 		c.getPlayerData(client)
 	}
 }
 
 func (c *RoomClient) getPlayerData(client *RoomClient) {
-	if client == c {
+	if client == c || client.spectator {
 		return
 	}
 
@@ -351,6 +553,9 @@ func (c *RoomClient) getPlayerData(client *RoomClient) {
 	if client.session.system != "" {
 		c.outbox <- buildMsg("sys", client.session.id, client.session.system)
 	}
+	if client.latencyHint != 0 {
+		c.outbox <- buildMsg("lat", client.session.id, client.latencyHint)
+	}
 	for i, pic := range client.pictures {
 		if pic != nil {
 			c.outbox <- buildMsg("ap", client.session.id, i+1, pic.posX, pic.posY, pic.mapX, pic.mapY, pic.panX, pic.panY, pic.magnify, pic.topTrans, pic.bottomTrans, pic.red, pic.blue, pic.green, pic.saturation, pic.effectMode, pic.effectPower, pic.name, pic.useTransparentColor, pic.fixedToMap, pic.spritesheetCols, pic.spritesheetRows, pic.spritesheetFrame, pic.spritesheetSpeed, pic.spritesheetPlayOnce, pic.mapLayer, pic.battleLayer, pic.flags, pic.blendMode, pic.flipX, pic.flipY, pic.origin)
@@ -0,0 +1,66 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRoomInstances(t *testing.T) {
+	prevConfig := getConfig()
+	defer currentConfig.Store(prevConfig)
+
+	currentConfig.Store(&Config{gameName: "2kki", roomPlayerCap: 2})
+
+	room := &Room{instances: []*RoomInstance{{}}}
+
+	roomClients := make([]*RoomClient, 7)
+	for i := range roomClients {
+		roomClients[i] = &RoomClient{room: room, session: &SessionClient{}}
+	}
+
+	// concurrent joins never go over the cap
+	var wg sync.WaitGroup
+	for _, c := range roomClients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.instance = room.addClient(c)
+		}()
+	}
+	wg.Wait()
+
+	if len(room.instances) != 4 {
+		t.Errorf("instance count = %d, expected 4", len(room.instances))
+	}
+	for _, instance := range room.instances {
+		if len(instance.clients) > 2 {
+			t.Errorf("instance %d has %d clients, over the cap of 2", instance.id, len(instance.clients))
+		}
+	}
+
+	// overflow instances are dropped once emptied
+	for _, c := range roomClients {
+		room.removeClient(c)
+	}
+
+	if len(room.instances) != 1 {
+		t.Errorf("instance count after leaving = %d, expected 1", len(room.instances))
+	}
+}
@@ -0,0 +1,328 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// API versions, /api/ is kept as v1 for clients relying on its response formats
+//
+// v2 changes:
+//   - errors are JSON objects with an error field, internal errors use status 500
+//   - paginated lists are wrapped in an ApiPage envelope
+const (
+	apiV1 = 1
+	apiV2 = 2
+
+	apiLatestVersion = apiV2
+)
+
+type apiVersionKey struct{}
+
+type ApiError struct {
+	Error     string `json:"error"`
+	RequestId string `json:"requestId,omitempty"`
+}
+
+type requestIdKey struct{}
+
+const (
+	maxRequestIdLength = 64
+)
+
+type ApiPage struct {
+	Items      any `json:"items"`
+	Page       int `json:"page"`
+	PageSize   int `json:"pageSize"`
+	TotalCount int `json:"totalCount"`
+}
+
+// ApiRoute describes an endpoint, used both to register it and to describe it in the OpenAPI spec
+type ApiRoute struct {
+	path    string
+	handler http.HandlerFunc
+
+	admin bool // registered under /admin/ instead of every API version
+
+	summary  string
+	params   []string // query parameters
+	commands []string // values of the command query parameter
+	methods  []string // GET if empty
+
+	gzipCommands []string // commands with large responses worth compressing
+}
+
+type ApiRouter struct {
+	mux *http.ServeMux
+
+	routes []ApiRoute
+}
+
+var apiRouter = &ApiRouter{mux: http.DefaultServeMux}
+
+// Register adds routes to the router and the OpenAPI spec
+func (ar *ApiRouter) Register(routes []ApiRoute) {
+	for _, route := range routes {
+		handler := withDatabase(route.handler)
+		if len(route.gzipCommands) != 0 {
+			handler = withGzip(route.gzipCommands, handler)
+		}
+
+		if route.admin {
+			ar.mux.HandleFunc("/admin"+route.path, handler)
+		} else {
+			ar.HandleFunc(route.path, handler)
+		}
+
+		ar.routes = append(ar.routes, route)
+	}
+}
+
+// HandleFunc registers a handler under every API version
+func (ar *ApiRouter) HandleFunc(path string, handler http.HandlerFunc) {
+	ar.HandleFuncFrom(apiV1, path, handler)
+}
+
+// HandleFuncFrom registers a handler under every API version starting from minVersion
+func (ar *ApiRouter) HandleFuncFrom(minVersion int, path string, handler http.HandlerFunc) {
+	for version := minVersion; version <= apiLatestVersion; version++ {
+		ar.mux.HandleFunc(getApiPrefix(version)+path, withApiVersion(version, handler))
+	}
+}
+
+func getApiPrefix(version int) string {
+	if version == apiV1 {
+		return "/api"
+	}
+
+	return "/api/v" + strconv.Itoa(version)
+}
+
+func withApiVersion(version int, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version)))
+	}
+}
+
+// getApiVersion returns the API version a request was routed through, v1 for non-API routes
+func getApiVersion(r *http.Request) int {
+	if version, ok := r.Context().Value(apiVersionKey{}).(int); ok {
+		return version
+	}
+
+	return apiV1
+}
+
+func writeApiError(w http.ResponseWriter, r *http.Request, payload string, statusCode int) {
+	errorJson, err := json.Marshal(ApiError{Error: payload, RequestId: getRequestId(r)})
+	if err != nil {
+		http.Error(w, payload, statusCode)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(statusCode)
+	w.Write(errorJson)
+}
+
+// withRequestId tags every request with an id returned in the X-Request-Id header,
+// keeping the id set by a fronting proxy if there is one
+func withRequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Header.Get("X-Request-Id")
+		if requestId == "" || len(requestId) > maxRequestIdLength || !isOkString(requestId) {
+			requestId = newRequestId()
+		}
+
+		w.Header().Set("X-Request-Id", requestId)
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIdKey{}, requestId)))
+	})
+}
+
+func getRequestId(r *http.Request) string {
+	requestId, _ := r.Context().Value(requestIdKey{}).(string)
+
+	return requestId
+}
+
+func newRequestId() string {
+	return randString(16)
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+
+	gz      *gzip.Writer
+	decided bool
+}
+
+// decide compresses the response unless the handler already encoded it itself
+func (w *gzipResponseWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		return
+	}
+
+	header.Set("Content-Encoding", "gzip")
+	header.Add("Vary", "Accept-Encoding")
+	header.Del("Content-Length")
+
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+}
+
+func (w *gzipResponseWriter) WriteHeader(statusCode int) {
+	w.decide()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	w.decide()
+
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+
+	return w.gz.Write(b)
+}
+
+// withGzip compresses responses to the given commands for clients accepting gzip
+func withGzip(commands []string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || !slices.Contains(commands, r.URL.Query().Get("command")) {
+			handler(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer func() {
+			if gw.gz != nil {
+				gw.gz.Close()
+			}
+		}()
+
+		handler(gw, r)
+	}
+}
+
+func handleApiSpec(w http.ResponseWriter, r *http.Request) {
+	specJson, err := json.Marshal(apiRouter.getSpec(getApiVersion(r)))
+	if err != nil {
+		handleInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(specJson)
+}
+
+// getSpec builds an OpenAPI description of the routes as seen from an API version
+func (ar *ApiRouter) getSpec(version int) map[string]any {
+	paths := make(map[string]any)
+
+	for _, route := range ar.routes {
+		path := getApiPrefix(version) + route.path
+		if route.admin {
+			path = "/admin" + route.path
+		}
+
+		var parameters []map[string]any
+		if len(route.commands) != 0 {
+			parameters = append(parameters, map[string]any{
+				"name":     "command",
+				"in":       "query",
+				"required": true,
+				"schema":   map[string]any{"type": "string", "enum": route.commands},
+			})
+		}
+		for _, param := range route.params {
+			parameters = append(parameters, map[string]any{
+				"name":   param,
+				"in":     "query",
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+
+		errorResponse := map[string]any{"description": "Error"}
+		if version >= apiV2 {
+			errorResponse["content"] = map[string]any{
+				"application/json": map[string]any{
+					"schema": map[string]any{"$ref": "#/components/schemas/ApiError"},
+				},
+			}
+		}
+
+		operation := map[string]any{
+			"summary":    route.summary,
+			"parameters": parameters,
+			"security":   []map[string]any{{}, {"token": []string{}}},
+			"responses": map[string]any{
+				"200": map[string]any{"description": "OK"},
+				"400": errorResponse,
+			},
+		}
+		if route.admin {
+			operation["security"] = []map[string]any{{"token": []string{}}}
+		}
+
+		methods := route.methods
+		if len(methods) == 0 {
+			methods = []string{http.MethodGet}
+		}
+
+		pathItem := make(map[string]any)
+		for _, method := range methods {
+			pathItem[strings.ToLower(method)] = operation
+		}
+
+		paths[path] = pathItem
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "YNOserver " + getConfig().gameName,
+			"version": strconv.Itoa(version),
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"securitySchemes": map[string]any{
+				"token": map[string]any{"type": "apiKey", "in": "header", "name": "Authorization"},
+			},
+			"schemas": map[string]any{
+				"ApiError": map[string]any{
+					"type":       "object",
+					"properties": map[string]any{"error": map[string]any{"type": "string"}},
+				},
+			},
+		},
+	}
+}
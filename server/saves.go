@@ -18,14 +18,111 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/klauspost/compress/zstd"
 )
 
-func getSaveDataTimestamp(playerUuid string) (time.Time, error) { // called by api only
-	info, err := os.Stat("saves/" + config.gameName + "/" + playerUuid + ".osd")
+const (
+	// number of previous save versions kept per slot
+	saveVersionCount = 5
+
+	// slot 0 is used for automatic sync, the others for manual backups
+	maxSaveSlot = 4
+
+	defaultSaveSizeLimit = 1024 * 1024 * 8
+)
+
+// getSaveSizeLimit returns the maximum size of a save for the rank, using the limit of the closest configured rank below it
+func getSaveSizeLimit(rank int) int {
+	for r := rank; r >= 0; r-- {
+		if limit, ok := getConfig().saveSizeLimits[r]; ok {
+			return limit
+		}
+	}
+
+	return defaultSaveSizeLimit
+}
+
+// SaveDataField describes a top-level key expected in pushed save data
+type SaveDataField struct {
+	Name    string
+	MaxSize int // maximum size of the encoded value in bytes, 0 for no limit beyond the save size limit
+}
+
+// SaveValidationError describes why pushed save data was rejected
+type SaveValidationError struct {
+	Field string `json:"field,omitempty"`
+	Error string `json:"error"`
+}
+
+// fields of the IndexedDB save entry pushed by forest-orb
+var defaultSaveDataFields = []SaveDataField{
+	{Name: "timestamp", MaxSize: 64},
+	{Name: "mode", MaxSize: 16},
+	{Name: "contents"},
+}
+
+// games with a save format that differs from the default
+var gameSaveDataFields = map[string][]SaveDataField{}
+
+func getSaveDataFields() []SaveDataField {
+	if fields, ok := gameSaveDataFields[getConfig().gameName]; ok {
+		return fields
+	}
+
+	return defaultSaveDataFields
+}
+
+// validateSaveData checks that pushed save data is a JSON object with the expected top-level keys
+// and that none of its fields exceed their size limit
+func validateSaveData(data []byte) (validationErrors []SaveValidationError) {
+	var saveData map[string]json.RawMessage
+	if err := json.Unmarshal(data, &saveData); err != nil {
+		return []SaveValidationError{{Error: "save data is not a valid JSON object"}}
+	}
+
+	for _, field := range getSaveDataFields() {
+		value, ok := saveData[field.Name]
+		if !ok || string(value) == "null" {
+			validationErrors = append(validationErrors, SaveValidationError{Field: field.Name, Error: "missing"})
+			continue
+		}
+
+		if field.MaxSize > 0 && len(value) > field.MaxSize {
+			validationErrors = append(validationErrors, SaveValidationError{Field: field.Name, Error: "exceeds maximum size of " + strconv.Itoa(field.MaxSize) + " bytes"})
+		}
+	}
+
+	return validationErrors
+}
+
+func getSaveDataName(playerUuid string, slot int) string {
+	if slot == 0 {
+		return playerUuid
+	}
+
+	return playerUuid + "_" + strconv.Itoa(slot)
+}
+
+func getSaveDataPath(playerUuid string, slot int) string {
+	return "saves/" + getConfig().gameName + "/" + getSaveDataName(playerUuid, slot) + ".osd"
+}
+
+func getSaveDataVersionsDir(playerUuid string, slot int) string {
+	return "saves/" + getConfig().gameName + "/versions/" + getSaveDataName(playerUuid, slot) + "/"
+}
+
+func getSaveDataTimestamp(playerUuid string, slot int) (time.Time, error) { // called by api only
+	info, err := os.Stat(getSaveDataPath(playerUuid, slot))
 	if err != nil {
 		return time.UnixMilli(0), nil // HACK: no error return because it breaks forest-orb
 	}
@@ -33,8 +130,40 @@ func getSaveDataTimestamp(playerUuid string) (time.Time, error) { // called by a
 	return info.ModTime().UTC(), nil
 }
 
-func getSaveData(playerUuid string) ([]byte, error) { // called by api only
-	file, err := os.ReadFile("saves/" + config.gameName + "/" + playerUuid + ".osd")
+// getSaveDataSize returns the decompressed size of the save in the slot, or 0 if there is none
+func getSaveDataSize(playerUuid string, slot int) (int, error) { // called by api only
+	file, err := os.Open(getSaveDataPath(playerUuid, slot))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	defer file.Close()
+
+	// saves are written in a single frame, whose header usually records the size
+	header := make([]byte, zstd.HeaderMaxSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, err
+	}
+
+	var frameHeader zstd.Header
+	if frameHeader.Decode(header[:n]) == nil && frameHeader.HasFCS {
+		return int(frameHeader.FrameContentSize), nil
+	}
+
+	data, err := getSaveData(playerUuid, slot)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func getSaveData(playerUuid string, slot int) ([]byte, error) { // called by api only
+	file, err := os.ReadFile(getSaveDataPath(playerUuid, slot))
 	if err != nil {
 		return nil, err
 	}
@@ -54,7 +183,7 @@ func getSaveData(playerUuid string) ([]byte, error) { // called by api only
 	return decompressed, nil
 }
 
-func createGameSaveData(playerUuid string, data []byte) error { // called by api only
+func createGameSaveData(playerUuid string, slot int, data []byte) error { // called by api only
 	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
 	if err != nil {
 		return err
@@ -62,11 +191,135 @@ func createGameSaveData(playerUuid string, data []byte) error { // called by api
 
 	defer enc.Close()
 
-	os.WriteFile("saves/"+config.gameName+"/"+playerUuid+".osd", enc.EncodeAll(data, []byte{}), 0644)
+	err = archiveGameSaveData(playerUuid, slot)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(getSaveDataPath(playerUuid, slot), enc.EncodeAll(data, []byte{}), 0644)
+}
+
+func clearGameSaveData(playerUuid string, slot int) error { // called by api only
+	// the cleared save can still be restored from the version history
+	return archiveGameSaveData(playerUuid, slot)
+}
+
+// archiveGameSaveData moves the current save into the player's version history, named by its timestamp,
+// and removes the oldest versions beyond saveVersionCount
+func archiveGameSaveData(playerUuid string, slot int) error {
+	err := moveGameSaveDataToVersions(playerUuid, slot)
+	if err != nil {
+		return err
+	}
+
+	return pruneGameSaveDataVersions(playerUuid, slot)
+}
+
+func moveGameSaveDataToVersions(playerUuid string, slot int) error {
+	info, err := os.Stat(getSaveDataPath(playerUuid, slot))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	versionsDir := getSaveDataVersionsDir(playerUuid, slot)
+
+	err = os.MkdirAll(versionsDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(getSaveDataPath(playerUuid, slot), versionsDir+strconv.FormatInt(info.ModTime().UnixMilli(), 10)+".osd")
+}
+
+// pruneGameSaveDataVersions removes the oldest versions beyond saveVersionCount
+func pruneGameSaveDataVersions(playerUuid string, slot int) error {
+	versions, err := getSaveDataVersions(playerUuid, slot)
+	if err != nil {
+		return err
+	}
+
+	versionsDir := getSaveDataVersionsDir(playerUuid, slot)
+	for i := saveVersionCount; i < len(versions); i++ {
+		os.Remove(versionsDir + strconv.FormatInt(versions[i], 10) + ".osd")
+	}
 
 	return nil
 }
 
-func clearGameSaveData(playerUuid string) error { // called by api only
-	return os.Remove("saves/" + config.gameName + "/" + playerUuid + ".osd")
+// getSaveDataVersions returns the timestamps (unix milliseconds) of the player's previous saves, newest first
+func getSaveDataVersions(playerUuid string, slot int) (versions []int64, err error) { // called by api only
+	versions = []int64{}
+
+	entries, err := os.ReadDir(getSaveDataVersionsDir(playerUuid, slot))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return versions, nil
+		}
+		return versions, err
+	}
+
+	for _, entry := range entries {
+		version, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), ".osd"), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		versions = append(versions, version)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] > versions[j]
+	})
+
+	return versions, nil
+}
+
+// restoreGameSaveData makes a previous save current again, archiving the current save
+func restoreGameSaveData(playerUuid string, slot int, version int64) error { // called by api only
+	versionPath := getSaveDataVersionsDir(playerUuid, slot) + strconv.FormatInt(version, 10) + ".osd"
+
+	_, err := os.Stat(versionPath)
+	if err != nil {
+		return err
+	}
+
+	err = moveGameSaveDataToVersions(playerUuid, slot)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(versionPath, getSaveDataPath(playerUuid, slot))
+	if err != nil {
+		return err
+	}
+
+	// pruned once the restored version is out of the versions, which could otherwise be the oldest one
+	err = pruneGameSaveDataVersions(playerUuid, slot)
+	if err != nil {
+		return err
+	}
+
+	// update the timestamp so other devices pull the restored save
+	now := time.Now()
+	return os.Chtimes(getSaveDataPath(playerUuid, slot), now, now)
+}
+
+// sendSaveDataUpdate tells the player's connected session that newer save data is available,
+// so a device other than the one that pushed it can pull instead of overwriting it on its next sync
+func sendSaveDataUpdate(playerUuid string, slot int) {
+	client, ok := clients.Load(playerUuid)
+	if !ok {
+		return
+	}
+
+	timestamp, err := getSaveDataTimestamp(playerUuid, slot)
+	if err != nil {
+		return
+	}
+
+	// the pushing device can recognize its own save by the timestamp
+	client.outbox <- buildMsg("sdu", slot, timestamp.Format(time.RFC3339))
 }
@@ -0,0 +1,130 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestoreOldestGameSaveData(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prevConfig := getConfig()
+	defer currentConfig.Store(prevConfig)
+
+	currentConfig.Store(&Config{gameName: "2kki"})
+
+	uuid, slot := "uuid", 1
+
+	versionsDir := getSaveDataVersionsDir(uuid, slot)
+	err = os.MkdirAll(versionsDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for version := int64(1); version <= saveVersionCount; version++ {
+		err = os.WriteFile(versionsDir+strconv.FormatInt(version, 10)+".osd", []byte("version "+strconv.FormatInt(version, 10)), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = os.WriteFile(getSaveDataPath(uuid, slot), []byte("current"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	currentTime := time.UnixMilli(1000)
+	os.Chtimes(getSaveDataPath(uuid, slot), currentTime, currentTime)
+
+	err = restoreGameSaveData(uuid, slot, 1)
+	if err != nil {
+		t.Fatalf("restoring oldest version: %v", err)
+	}
+
+	data, err := os.ReadFile(getSaveDataPath(uuid, slot))
+	if err != nil || string(data) != "version 1" {
+		t.Errorf("restored save = %q, %v", data, err)
+	}
+
+	versions, err := getSaveDataVersions(uuid, slot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != saveVersionCount {
+		t.Errorf("kept %d versions, expected %d", len(versions), saveVersionCount)
+	}
+
+	data, err = os.ReadFile(versionsDir + "1000.osd")
+	if err != nil || string(data) != "current" {
+		t.Errorf("archived save = %q, %v", data, err)
+	}
+}
+
+func TestGetSaveDataSize(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prevConfig := getConfig()
+	defer currentConfig.Store(prevConfig)
+
+	currentConfig.Store(&Config{gameName: "2kki"})
+
+	err = os.MkdirAll("saves/2kki", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := getSaveDataSize("uuid", 0)
+	if err != nil || size != 0 {
+		t.Errorf("size of missing save = %d, %v", size, err)
+	}
+
+	data := []byte(strings.Repeat(`{"key":"value"}`, 1000))
+
+	err = createGameSaveData("uuid", 0, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size, err = getSaveDataSize("uuid", 0)
+	if err != nil || size != len(data) {
+		t.Errorf("size of save = %d, %v, expected %d", size, err, len(data))
+	}
+}
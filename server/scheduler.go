@@ -0,0 +1,190 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-co-op/gocron"
+)
+
+// ScheduledTask is a named scheduler job, which can be listed, run immediately and paused through the admin API
+type ScheduledTask struct {
+	name string
+	task func() error
+	job  *gocron.Job
+
+	paused  atomic.Bool
+	running atomic.Bool
+}
+
+type ScheduledTaskInfo struct {
+	Name     string     `json:"name"`
+	NextRun  *time.Time `json:"nextRun,omitempty"`
+	LastRun  *time.Time `json:"lastRun,omitempty"`
+	RunCount int        `json:"runCount"`
+	Paused   bool       `json:"paused"`
+	Running  bool       `json:"running"`
+}
+
+var (
+	scheduledTasks    = make(map[string]*ScheduledTask)
+	scheduledTasksMtx sync.Mutex
+)
+
+// scheduleTask registers task, a func() or a func() error, to run on the given schedule
+func scheduleTask(name string, schedule *gocron.Scheduler, task any) {
+	scheduledTask := &ScheduledTask{name: name}
+
+	switch task := task.(type) {
+	case func():
+		scheduledTask.task = func() error {
+			task()
+			return nil
+		}
+	case func() error:
+		scheduledTask.task = task
+	default:
+		panic("unsupported task type for " + name)
+	}
+
+	job, err := schedule.Tag(name).Do(func() {
+		if scheduledTask.paused.Load() {
+			return
+		}
+
+		scheduledTask.run()
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	scheduledTask.job = job
+
+	scheduledTasksMtx.Lock()
+	scheduledTasks[name] = scheduledTask
+	scheduledTasksMtx.Unlock()
+}
+
+// run runs the task unless it is already running, returning false if it was
+func (t *ScheduledTask) run() bool {
+	if !t.running.CompareAndSwap(false, true) {
+		return false
+	}
+	defer t.running.Store(false)
+
+	err := t.task()
+	if err != nil {
+		writeErrLog("SERVER", "scheduler", t.name+": "+err.Error())
+	}
+
+	return true
+}
+
+func (t *ScheduledTask) getInfo() *ScheduledTaskInfo {
+	info := &ScheduledTaskInfo{
+		Name:     t.name,
+		RunCount: t.job.RunCount(),
+		Paused:   t.paused.Load(),
+		Running:  t.running.Load(),
+	}
+
+	if nextRun := t.job.NextRun(); !nextRun.IsZero() {
+		info.NextRun = &nextRun
+	}
+	if lastRun := t.job.LastRun(); !lastRun.IsZero() {
+		info.LastRun = &lastRun
+	}
+
+	return info
+}
+
+func getScheduledTask(name string) (*ScheduledTask, bool) {
+	scheduledTasksMtx.Lock()
+	defer scheduledTasksMtx.Unlock()
+
+	task, ok := scheduledTasks[name]
+
+	return task, ok
+}
+
+func adminScheduler(w http.ResponseWriter, r *http.Request) {
+	_, _, rank, _, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
+	if rank == 0 {
+		handleError(w, r, "access denied")
+		return
+	}
+
+	commandParam := r.URL.Query().Get("command")
+
+	if commandParam == "list" {
+		var infos []*ScheduledTaskInfo
+
+		scheduledTasksMtx.Lock()
+		for _, task := range scheduledTasks {
+			infos = append(infos, task.getInfo())
+		}
+		scheduledTasksMtx.Unlock()
+
+		sort.Slice(infos, func(i, j int) bool {
+			return infos[i].Name < infos[j].Name
+		})
+
+		infosJson, err := json.Marshal(infos)
+		if err != nil {
+			handleError(w, r, "error while marshaling")
+			return
+		}
+
+		w.Write(infosJson)
+		return
+	}
+
+	task, ok := getScheduledTask(r.URL.Query().Get("name"))
+	if !ok {
+		handleError(w, r, "unknown task")
+		return
+	}
+
+	switch commandParam {
+	case "run":
+		if task.running.Load() {
+			handleError(w, r, "task is already running")
+			return
+		}
+
+		// runs even if the task is paused
+		go task.run()
+	case "pause":
+		task.paused.Store(true)
+	case "resume":
+		task.paused.Store(false)
+	default:
+		handleError(w, r, "unknown command")
+		return
+	}
+
+	writeLog("SERVER", "scheduler", commandParam+" "+task.name, 200)
+
+	w.Write([]byte("ok"))
+}
@@ -73,7 +73,7 @@ const (
 
 func initSchedules() {
 	logInitTask("schedules")
-	scheduler.Every(1).Day().At("06:00").Do(clearDoneSchedules)
+	scheduleTask("scheduleCleanup", scheduler.Every(1).Day().At("06:00"), clearDoneSchedules)
 	clearDoneSchedules()
 }
 
@@ -252,7 +252,7 @@ JOIN players pd ON pd.uuid = s.ownerUuid
 LEFT JOIN tally ON tally.scheduleId = s.id
 WHERE COALESCE(s.partyId, 0) IN (0, ?) OR ?`
 
-	results, err := db.Query(query, uuid, config.gameName, partyId, rank > 0)
+	results, err := db.Query(query, uuid, getConfig().gameName, partyId, rank > 0)
 	if err != nil {
 		return schedules, err
 	}
@@ -276,12 +276,8 @@ INSERT INTO schedules
 	(name, description, ownerUuid, partyId, game, official, recurring, intervalValue, intervalType, datetime, systemName, discord, youtube, twitch, niconico, openrec, bilibili)
 VALUES
 	(   ?,           ?,         ?,       ?,    ?,         ?,        ?,             ?,            ?,        ?,          ?,       ?,       ?,      ?,        ?,       ?,        ?)`
-		results, err := db.Exec(query, s.Name, s.Description, s.OwnerUuid, s.PartyId, s.Game, s.Official, s.Recurring, s.IntervalValue, s.IntervalType, s.Datetime, s.SystemName,
+		idLarge, err := db.ExecInsert(query, s.Name, s.Description, s.OwnerUuid, s.PartyId, s.Game, s.Official, s.Recurring, s.IntervalValue, s.IntervalType, s.Datetime, s.SystemName,
 			s.Discord, s.Youtube, s.Twitch, s.Niconico, s.Openrec, s.Bilibili)
-		if err != nil {
-			return id, err
-		}
-		idLarge, err := results.LastInsertId()
 		if err != nil {
 			return id, err
 		} else {
@@ -344,7 +340,7 @@ func clearTimers() {
 
 func initScheduleTimers() {
 	ongoingLimit := time.Now().UTC().Add(15 * time.Minute)
-	results, err := db.Query("SELECT id, datetime FROM schedules WHERE datetime >= ? AND game = ?", ongoingLimit, config.gameName)
+	results, err := db.Query("SELECT id, datetime FROM schedules WHERE datetime >= ? AND game = ?", ongoingLimit, getConfig().gameName)
 	if err != nil {
 		log.Println("initScheduleTimers", err)
 		return
@@ -398,7 +394,7 @@ func cancelSchedule(uuid string, rank int, scheduleId int) error {
 }
 
 func clearDoneSchedules() {
-	_, err := db.Exec("DELETE FROM schedules WHERE datetime < NOW() AND NOT recurring AND game = ?", config.gameName)
+	_, err := db.Exec("DELETE FROM schedules WHERE datetime < NOW() AND NOT recurring AND game = ?", getConfig().gameName)
 	if err != nil {
 		fmt.Printf("error deleting non-recurring events: %s", err)
 	}
@@ -410,7 +406,7 @@ SET datetime = CASE intervalType
     WHEN 'months' THEN DATE_ADD(datetime, INTERVAL intervalValue MONTH)
     WHEN 'years' THEN DATE_ADD(datetime, INTERVAL intervalValue YEAR)
     ELSE datetime
-END WHERE recurring AND datetime < NOW() AND game = ?`, config.gameName)
+END WHERE recurring AND datetime < NOW() AND game = ?`, getConfig().gameName)
 	if err != nil {
 		fmt.Printf("error calculating recurring events: %s", err)
 	}
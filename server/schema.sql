@@ -0,0 +1,359 @@
+-- Schema of the tables that predate migrations, applied by the server when it starts
+-- against an empty database. Later changes go in migrations, never here
+
+CREATE TABLE players (
+	uuid VARCHAR(16) NOT NULL,
+	ip VARCHAR(45) NOT NULL,
+	rank INT NOT NULL DEFAULT 0,
+	banned BOOLEAN NOT NULL DEFAULT 0,
+	muted BOOLEAN NOT NULL DEFAULT 0,
+	PRIMARY KEY (uuid),
+	KEY (ip)
+);
+
+CREATE TABLE accounts (
+	uuid VARCHAR(16) NOT NULL,
+	ip VARCHAR(45) NOT NULL,
+	user VARCHAR(12) NOT NULL,
+	pass VARCHAR(60) NOT NULL,
+	timestampRegistered DATETIME NOT NULL,
+	timestampLoggedIn DATETIME NULL,
+	badge VARCHAR(32) NOT NULL DEFAULT 'null',
+	badgeSlotRows INT NOT NULL DEFAULT 1,
+	badgeSlotCols INT NOT NULL DEFAULT 3,
+	screenshotLimit INT NOT NULL DEFAULT 10,
+	inactive BOOLEAN NOT NULL DEFAULT 0,
+	PRIMARY KEY (uuid),
+	UNIQUE KEY (user),
+	KEY (ip)
+);
+
+CREATE TABLE playerSessions (
+	sessionId VARCHAR(32) NOT NULL,
+	uuid VARCHAR(16) NOT NULL,
+	expiration DATETIME NOT NULL,
+	PRIMARY KEY (sessionId),
+	KEY (uuid)
+);
+
+CREATE TABLE playerGameData (
+	uuid VARCHAR(16) NOT NULL,
+	game VARCHAR(32) NOT NULL,
+	name VARCHAR(12) NOT NULL DEFAULT '',
+	systemName VARCHAR(64) NOT NULL DEFAULT '',
+	spriteName VARCHAR(64) NOT NULL DEFAULT '',
+	spriteIndex INT NOT NULL DEFAULT 0,
+	online BOOLEAN NOT NULL DEFAULT 0,
+	timestampLastActive DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	lastGlobalMsgId VARCHAR(12) NULL,
+	lastPartyMsgId VARCHAR(12) NULL,
+	medalCountBronze INT NOT NULL DEFAULT 0,
+	medalCountSilver INT NOT NULL DEFAULT 0,
+	medalCountGold INT NOT NULL DEFAULT 0,
+	medalCountPlatinum INT NOT NULL DEFAULT 0,
+	medalCountDiamond INT NOT NULL DEFAULT 0,
+	PRIMARY KEY (uuid, game),
+	KEY (game, online),
+	KEY (name)
+);
+
+CREATE TABLE playerFriends (
+	uuid VARCHAR(16) NOT NULL,
+	targetUuid VARCHAR(16) NOT NULL,
+	accepted BOOLEAN NOT NULL DEFAULT 0,
+	PRIMARY KEY (uuid, targetUuid),
+	KEY (targetUuid)
+);
+
+CREATE TABLE playerBlocks (
+	uuid VARCHAR(16) NOT NULL,
+	targetUuid VARCHAR(16) NOT NULL,
+	timestamp DATETIME NOT NULL,
+	PRIMARY KEY (uuid, targetUuid),
+	KEY (targetUuid)
+);
+
+CREATE TABLE parties (
+	id INT NOT NULL AUTO_INCREMENT,
+	game VARCHAR(32) NOT NULL,
+	owner VARCHAR(16) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	public BOOLEAN NOT NULL DEFAULT 0,
+	pass VARCHAR(255) NULL,
+	theme VARCHAR(64) NOT NULL DEFAULT '',
+	description VARCHAR(1000) NOT NULL DEFAULT '',
+	PRIMARY KEY (id),
+	KEY (game)
+);
+
+CREATE TABLE partyMembers (
+	id INT NOT NULL AUTO_INCREMENT,
+	partyId INT NOT NULL,
+	uuid VARCHAR(16) NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY (partyId, uuid),
+	KEY (uuid)
+);
+
+CREATE TABLE chatMessages (
+	msgId VARCHAR(12) NOT NULL,
+	game VARCHAR(32) NOT NULL,
+	uuid VARCHAR(16) NOT NULL,
+	mapId CHAR(4) NOT NULL,
+	prevMapId CHAR(4) NOT NULL,
+	prevLocations TEXT NOT NULL,
+	x INT NOT NULL,
+	y INT NOT NULL,
+	contents VARCHAR(600) NOT NULL,
+	partyId INT NULL,
+	timestamp DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (msgId),
+	KEY (game, partyId, timestamp),
+	KEY (uuid, game, timestamp)
+);
+
+CREATE TABLE playerReports (
+	uuid VARCHAR(16) NOT NULL,
+	targetUuid VARCHAR(16) NOT NULL,
+	msgId VARCHAR(12) NULL,
+	game VARCHAR(32) NOT NULL,
+	reason VARCHAR(1000) NOT NULL,
+	originalMsg VARCHAR(600) NULL,
+	timestampReported DATETIME NOT NULL,
+	actionTaken BOOLEAN NOT NULL DEFAULT 0,
+	PRIMARY KEY (uuid, targetUuid),
+	KEY (targetUuid)
+);
+
+CREATE TABLE badges (
+	badgeId VARCHAR(32) NOT NULL,
+	game VARCHAR(32) NOT NULL,
+	bp INT NOT NULL DEFAULT 0,
+	hidden BOOLEAN NOT NULL DEFAULT 0,
+	percentUnlocked DOUBLE NOT NULL DEFAULT 0,
+	PRIMARY KEY (badgeId)
+);
+
+CREATE TABLE playerBadges (
+	uuid VARCHAR(16) NOT NULL,
+	badgeId VARCHAR(32) NOT NULL,
+	timestampUnlocked DATETIME NOT NULL,
+	slotRow INT NOT NULL DEFAULT 0,
+	slotCol INT NOT NULL DEFAULT 0,
+	PRIMARY KEY (uuid, badgeId),
+	KEY (badgeId)
+);
+
+CREATE TABLE playerTags (
+	uuid VARCHAR(16) NOT NULL,
+	name VARCHAR(64) NOT NULL,
+	timestampUnlocked DATETIME NOT NULL,
+	PRIMARY KEY (uuid, name)
+);
+
+CREATE TABLE playerTimeTrials (
+	uuid VARCHAR(16) NOT NULL,
+	mapId INT NOT NULL,
+	seconds INT NOT NULL,
+	timestampCompleted DATETIME NOT NULL,
+	KEY (uuid, mapId)
+);
+
+CREATE TABLE playerMinigameScores (
+	uuid VARCHAR(16) NOT NULL,
+	game VARCHAR(32) NOT NULL,
+	minigameId VARCHAR(32) NOT NULL,
+	score INT NOT NULL,
+	timestampCompleted DATETIME NOT NULL,
+	PRIMARY KEY (uuid, game, minigameId)
+);
+
+CREATE TABLE eventPeriods (
+	id INT NOT NULL AUTO_INCREMENT,
+	periodOrdinal INT NOT NULL,
+	startDate DATE NOT NULL,
+	endDate DATE NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY (periodOrdinal)
+);
+
+CREATE TABLE gameEventPeriods (
+	id INT NOT NULL AUTO_INCREMENT,
+	periodId INT NOT NULL,
+	game VARCHAR(32) NOT NULL,
+	enableVms BOOLEAN NOT NULL DEFAULT 0,
+	PRIMARY KEY (id),
+	UNIQUE KEY (periodId, game)
+);
+
+CREATE TABLE gameLocations (
+	id INT NOT NULL AUTO_INCREMENT,
+	game VARCHAR(32) NOT NULL,
+	title VARCHAR(255) NOT NULL,
+	titleJP VARCHAR(255) NULL,
+	depth INT NOT NULL DEFAULT 0,
+	minDepth INT NOT NULL DEFAULT 0,
+	mapIds TEXT NOT NULL,
+	secret BOOLEAN NOT NULL DEFAULT 0,
+	PRIMARY KEY (id),
+	UNIQUE KEY (game, title)
+);
+
+CREATE TABLE playerGameLocations (
+	uuid VARCHAR(16) NOT NULL,
+	locationId INT NOT NULL,
+	timestamp DATETIME NOT NULL,
+	PRIMARY KEY (uuid, locationId)
+);
+
+CREATE TABLE eventLocations (
+	id INT NOT NULL AUTO_INCREMENT,
+	gamePeriodId INT NOT NULL,
+	locationId INT NOT NULL,
+	type INT NOT NULL,
+	exp INT NOT NULL,
+	startDate DATE NOT NULL,
+	endDate DATE NOT NULL,
+	PRIMARY KEY (id),
+	KEY (gamePeriodId, startDate, endDate)
+);
+
+CREATE TABLE playerEventLocations (
+	id INT NOT NULL AUTO_INCREMENT,
+	gamePeriodId INT NOT NULL,
+	locationId INT NOT NULL,
+	uuid VARCHAR(16) NOT NULL,
+	startDate DATE NOT NULL,
+	endDate DATE NOT NULL,
+	PRIMARY KEY (id),
+	KEY (uuid, gamePeriodId)
+);
+
+CREATE TABLE playerEventLocationQueue (
+	game VARCHAR(32) NOT NULL,
+	date DATE NOT NULL,
+	queueIndex INT NOT NULL,
+	locationId INT NOT NULL,
+	PRIMARY KEY (game, date, queueIndex)
+);
+
+CREATE TABLE eventVms (
+	id INT NOT NULL AUTO_INCREMENT,
+	gamePeriodId INT NOT NULL,
+	mapId INT NOT NULL,
+	eventId INT NOT NULL,
+	exp INT NOT NULL,
+	startDate DATE NOT NULL,
+	endDate DATE NOT NULL,
+	PRIMARY KEY (id),
+	KEY (gamePeriodId, startDate, endDate)
+);
+
+CREATE TABLE eventCompletions (
+	eventId INT NOT NULL,
+	uuid VARCHAR(16) NOT NULL,
+	type INT NOT NULL,
+	timestampCompleted DATETIME NOT NULL,
+	exp INT NOT NULL DEFAULT 0,
+	PRIMARY KEY (eventId, uuid, type),
+	KEY (uuid, type)
+);
+
+CREATE TABLE rankingEntries (
+	categoryId VARCHAR(32) NOT NULL,
+	subCategoryId VARCHAR(32) NOT NULL,
+	position INT NOT NULL,
+	uuid VARCHAR(16) NOT NULL,
+	valueInt INT NULL,
+	valueFloat DOUBLE NULL,
+	timestamp DATETIME NULL,
+	PRIMARY KEY (categoryId, subCategoryId, uuid),
+	KEY (uuid)
+);
+
+CREATE TABLE playerScreenshots (
+	id VARCHAR(16) NOT NULL,
+	uuid VARCHAR(16) NOT NULL,
+	game VARCHAR(32) NOT NULL,
+	mapId CHAR(4) NOT NULL,
+	mapX INT NOT NULL,
+	mapY INT NOT NULL,
+	public BOOLEAN NOT NULL DEFAULT 0,
+	publicTimestamp DATETIME NULL,
+	spoiler BOOLEAN NOT NULL DEFAULT 0,
+	temp BOOLEAN NOT NULL DEFAULT 0,
+	timestamp DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	KEY (uuid),
+	KEY (game, public, publicTimestamp)
+);
+
+CREATE TABLE playerScreenshotLikes (
+	screenshotId VARCHAR(16) NOT NULL,
+	uuid VARCHAR(16) NOT NULL,
+	timestamp DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (screenshotId, uuid),
+	KEY (uuid)
+);
+
+CREATE TABLE schedules (
+	id INT NOT NULL AUTO_INCREMENT,
+	name VARCHAR(255) NOT NULL,
+	description VARCHAR(1000) NOT NULL DEFAULT '',
+	ownerUuid VARCHAR(16) NOT NULL,
+	partyId INT NOT NULL DEFAULT 0,
+	game VARCHAR(32) NOT NULL,
+	official BOOLEAN NOT NULL DEFAULT 0,
+	recurring BOOLEAN NOT NULL DEFAULT 0,
+	intervalValue INT NOT NULL DEFAULT 0,
+	intervalType VARCHAR(8) NOT NULL DEFAULT '',
+	datetime DATETIME NOT NULL,
+	systemName VARCHAR(64) NOT NULL DEFAULT '',
+	discord VARCHAR(255) NOT NULL DEFAULT '',
+	youtube VARCHAR(255) NOT NULL DEFAULT '',
+	twitch VARCHAR(255) NOT NULL DEFAULT '',
+	niconico VARCHAR(255) NOT NULL DEFAULT '',
+	openrec VARCHAR(255) NOT NULL DEFAULT '',
+	bilibili VARCHAR(255) NOT NULL DEFAULT '',
+	PRIMARY KEY (id),
+	KEY (game, datetime)
+);
+
+CREATE TABLE playerScheduleFollows (
+	uuid VARCHAR(16) NOT NULL,
+	scheduleId INT NOT NULL,
+	PRIMARY KEY (uuid, scheduleId),
+	KEY (scheduleId)
+);
+
+CREATE TABLE pushSubscriptions (
+	uuid VARCHAR(16) NOT NULL,
+	endpoint VARCHAR(500) NOT NULL,
+	p256dh VARCHAR(255) NOT NULL,
+	auth VARCHAR(255) NOT NULL,
+	PRIMARY KEY (uuid, endpoint)
+);
+
+CREATE TABLE gamePlayerCounts (
+	game VARCHAR(32) NOT NULL,
+	playerCount INT NOT NULL,
+	timestamp DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	KEY (game, timestamp)
+);
+
+CREATE TABLE wikiApiQueries (
+	game VARCHAR(32) NOT NULL,
+	action VARCHAR(32) NOT NULL,
+	query VARCHAR(255) NOT NULL,
+	response MEDIUMTEXT NOT NULL,
+	timestampExpired DATETIME NOT NULL,
+	PRIMARY KEY (game, action, query)
+);
+
+CREATE TABLE 2kkiApiQueries (
+	action VARCHAR(32) NOT NULL,
+	query VARCHAR(255) NOT NULL,
+	response MEDIUMTEXT NOT NULL,
+	timestampExpired DATETIME NOT NULL,
+	PRIMARY KEY (action, query)
+);
@@ -19,6 +19,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -70,14 +71,15 @@ type ScreenshotData struct {
 
 const (
 	defaultPlayerScreenshotLimit = 10
+	maxScreenshotSize            = 512 * 1024 // bytes, well above a 320x240 png
 )
 
 func initScreenshots() {
-	// Use main server to process temp screenshot cleaning task for all games
+	// Use main server to process temp screenshot cleaning task for all games, its cluster leader runs it
 	if isMainServer {
 		logInitTask("screenshots")
 
-		scheduler.Cron("0 * * * *").Do(deleteTempScreenshots)
+		scheduleTask("tempScreenshotCleanup", scheduler.Cron("0 * * * *"), deleteTempScreenshots)
 	}
 }
 
@@ -167,7 +169,7 @@ func handleScreenshot(w http.ResponseWriter, r *http.Request) {
 			intervalParam = "day"
 		}
 
-		screenshots, err := getScreenshotFeed(uuid, limit, offset, offsetIdParam, gameParam, sortOrderParam, intervalParam)
+		screenshots, err := getScreenshotFeed(r.Context(), uuid, limit, offset, offsetIdParam, gameParam, sortOrderParam, intervalParam)
 		if err != nil {
 			handleInternalError(w, r, err)
 			return
@@ -221,8 +223,14 @@ func handleScreenshot(w http.ResponseWriter, r *http.Request) {
 		w.Write(screenshotGamesJson)
 		return
 	case "upload":
-		body, err := io.ReadAll(r.Body)
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxScreenshotSize))
 		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				handleError(w, r, "screenshot too large")
+				return
+			}
+
 			handleError(w, r, "failed to read body")
 			return
 		}
@@ -269,7 +277,7 @@ func handleScreenshot(w http.ResponseWriter, r *http.Request) {
 
 		id := getNanoId()
 
-		err = writeScreenshotData(id, uuid, config.gameName, mapIdParam, mapX, mapY, temp)
+		err = writeScreenshotData(id, uuid, getConfig().gameName, mapIdParam, mapX, mapY, temp)
 		if err != nil {
 			handleInternalError(w, r, err)
 			return
@@ -334,7 +342,7 @@ func handleScreenshot(w http.ResponseWriter, r *http.Request) {
 				if commandParam == "setPublic" && valueParam == "1" {
 					_, name, _, badge, _, _ := getPlayerDataFromToken(r.Header.Get("Authorization"))
 
-					err = sendWebhookMessage(config.screenshotWebhook, name, badge, fmt.Sprintf("https://connect.ynoproject.net/%s/screenshots/%s/%s.png", config.gameName, uuid, idParam), false)
+					err = sendWebhookMessage(getConfig().screenshotWebhook, name, badge, fmt.Sprintf("https://connect.ynoproject.net/%s/screenshots/%s/%s.png", getConfig().gameName, uuid, idParam), false)
 					if err != nil {
 						handleError(w, r, "failed to send to webhook")
 						return
@@ -409,7 +417,7 @@ func getPlayerScreenshotLimit(uuid string) (screenshotLimit int) {
 	return screenshotLimit
 }
 
-func getScreenshotFeed(uuid string, limit int, offset int, offsetId string, game string, sortOrder string, intervalType string) ([]*ScreenshotData, error) {
+func getScreenshotFeed(ctx context.Context, uuid string, limit int, offset int, offsetId string, game string, sortOrder string, intervalType string) ([]*ScreenshotData, error) {
 	var screenshots []*ScreenshotData
 
 	var queryArgs []any
@@ -456,11 +464,11 @@ func getScreenshotFeed(uuid string, limit int, offset int, offsetId string, game
 
 	orderByClause += "ps.publicTimestamp DESC, op.uuid, ps.id DESC "
 
-	query := cteClause + selectClause + fromJoinClause + whereClause + orderByClause + "LIMIT ?, ?"
+	query := cteClause + selectClause + fromJoinClause + whereClause + orderByClause + "LIMIT ? OFFSET ?"
 
-	queryArgs = append(queryArgs, offset, limit)
+	queryArgs = append(queryArgs, limit, offset)
 
-	results, err := db.Query(query, queryArgs...)
+	results, err := db.Replica().QueryContext(ctx, query, queryArgs...)
 	if err != nil {
 		return screenshots, err
 	}
@@ -576,7 +584,7 @@ func writeScreenshotData(id string, uuid string, game string, mapId string, mapX
 }
 
 func setPlayerScreenshotPublic(id string, uuid string, value bool) (bool, error) {
-	results, err := db.Exec("UPDATE playerScreenshots SET public = ?, publicTimestamp = COALESCE(publicTimestamp, NOW()) WHERE id = ? AND EXISTS (SELECT * FROM playerScreenshots ps JOIN players p ON p.uuid = ? JOIN players op ON op.uuid = ps.uuid WHERE p.uuid = op.uuid OR p.rank > op.rank)", value, id, uuid)
+	results, err := db.Exec("UPDATE playerScreenshots SET public = ?, publicTimestamp = COALESCE(publicTimestamp, NOW()) WHERE id = ? AND EXISTS (SELECT * FROM playerScreenshots ps JOIN players p ON p.uuid = ? JOIN players op ON op.uuid = ps.uuid WHERE ps.id = ? AND (p.uuid = op.uuid OR p.rank > op.rank))", value, id, uuid, id)
 	if err != nil {
 		return false, err
 	}
@@ -590,7 +598,7 @@ func setPlayerScreenshotPublic(id string, uuid string, value bool) (bool, error)
 }
 
 func setPlayerScreenshotSpoiler(id string, uuid string, value bool) (bool, error) {
-	results, err := db.Exec("UPDATE playerScreenshots SET spoiler = ? WHERE id = ? AND EXISTS (SELECT * FROM playerScreenshots ps JOIN players p ON p.uuid = ? JOIN players op ON op.uuid = ps.uuid WHERE p.uuid = op.uuid OR p.rank > op.rank)", value, id, uuid)
+	results, err := db.Exec("UPDATE playerScreenshots SET spoiler = ? WHERE id = ? AND EXISTS (SELECT * FROM playerScreenshots ps JOIN players p ON p.uuid = ? JOIN players op ON op.uuid = ps.uuid WHERE ps.id = ? AND (p.uuid = op.uuid OR p.rank > op.rank))", value, id, uuid, id)
 	if err != nil {
 		return false, err
 	}
@@ -632,7 +640,7 @@ func deletePlayerScreenshotLike(id string, uuid string) (bool, error) {
 }
 
 func deleteScreenshot(id string, uuid string) (bool, error) {
-	results, err := db.Exec("DELETE FROM playerScreenshots WHERE id = ? AND EXISTS (SELECT * FROM playerScreenshots ps JOIN players p ON p.uuid = ? JOIN players op ON op.uuid = ps.uuid WHERE p.uuid = op.uuid OR p.rank > op.rank)", id, uuid)
+	results, err := db.Exec("DELETE FROM playerScreenshots WHERE id = ? AND EXISTS (SELECT * FROM playerScreenshots ps JOIN players p ON p.uuid = ? JOIN players op ON op.uuid = ps.uuid WHERE ps.id = ? AND (p.uuid = op.uuid OR p.rank > op.rank))", id, uuid, id)
 	if err != nil {
 		return false, err
 	}
@@ -646,6 +654,10 @@ func deleteScreenshot(id string, uuid string) (bool, error) {
 }
 
 func deleteTempScreenshots() error {
+	if !isClusterLeader() {
+		return nil
+	}
+
 	results, err := db.Query("SELECT id, uuid FROM playerScreenshots WHERE temp = 1 AND timestamp < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 DAY)")
 	if err != nil {
 		return err
@@ -28,13 +28,11 @@ import (
 	"os"
 	"regexp"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/fasthttp/websocket"
 	"github.com/go-co-op/gocron"
 	"github.com/ynoproject/ynoserver/server/security"
-	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 const (
@@ -47,7 +45,7 @@ const (
 var (
 	scheduler = gocron.NewScheduler(time.UTC)
 
-	config         *Config
+	configPath     string
 	serverSecurity *security.Security
 	assets         *Assets
 
@@ -68,11 +66,26 @@ var (
 func Start() {
 	fmt.Println("Now starting YNOserver...")
 
-	configFile := flag.String("config", "config.yml", "Path to the configuration file")
+	flag.StringVar(&configPath, "config", "getConfig().yml", "Path to the configuration file")
+	registerConfigFlags()
 	flag.Parse()
 
-	config = parseConfigFile(*configFile)
-	db = getDatabaseConn(config.dbUser, config.dbPass, config.dbAddr, config.dbName)
+	config, err := parseConfigFile(configPath)
+	if err != nil {
+		panic(err)
+	}
+
+	currentConfig.Store(config)
+
+	upgrader.EnableCompression = config.wsCompression.enabled
+
+	db = getDatabaseConn(config.dbType, config.dbUser, config.dbPass, config.dbAddr, config.dbName)
+	if config.dbReplicaAddr != "" {
+		db.replica = getDatabaseConn(config.dbType, config.dbUser, config.dbPass, config.dbReplicaAddr, config.dbName)
+	}
+
+	waitForDatabase()
+	migrateDatabase()
 
 	isMainServer = config.gameName == mainGameId
 
@@ -83,42 +96,51 @@ func Start() {
 	setBadges()
 	setEventVms()
 	setWordFilter()
+	setMinigames()
+	setMedals()
 
 	globalConditions = getGlobalConditions()
 
 	createRooms(assets.maps, config.spRooms)
 
-	log.SetOutput(&lumberjack.Logger{
-		Filename:   "logs/" + config.gameName + "/ynoserver.log",
-		MaxSize:    config.logging.maxSize,
-		MaxBackups: config.logging.maxBackups,
-		MaxAge:     config.logging.maxAge,
-	})
-	log.SetFlags(log.Ldate | log.Ltime)
+	initLogging()
+	initConfigReload()
+	initRedis()
+	initCluster()
+	initDatabaseHealth()
 
 	initApi()
+	initPlayerDataCache()
+	initJobs()
 	initHistory()
 	initScreenshots()
 	initLocations()
 	initSchedules()
 	initEvents()
+	initMedals()
 	initBadges()
 	initSession()
+	initChannels()
+	initStatistics()
 	initReports()
+	initDiscordBridge()
+	initBackups()
+	initWebhooks()
 	initRpc()
+	initAdminRpc()
 
 	if config.gameName == "unconscious" {
 		initUnconscious()
 	}
 
-	scheduler.Every(1).Day().At("03:00").Do(updatePlayerActivity)
-	scheduler.Every(1).Day().At("04:00").Do(doCleanupQueries)
+	scheduleTask("playerActivity", scheduler.Every(1).Day().At("03:00"), updatePlayerActivity)
+	scheduleTask("databaseCleanup", scheduler.Every(1).Day().At("04:00"), doCleanupQueries)
 
 	scheduler.StartAsync()
 
 	fmt.Print("Now serving requests.\n")
 
-	http.Serve(getListener(), nil)
+	http.Serve(getListener(), withCors(withRequestId(http.DefaultServeMux)))
 }
 
 func logInitTask(taskName string) {
@@ -131,16 +153,16 @@ func logUpdateTask(taskName string) {
 
 func getListener() net.Listener {
 	// remove socket file
-	os.Remove("sockets/" + config.gameName + ".sock")
+	os.Remove("sockets/" + getConfig().gameName + ".sock")
 
 	// create unix socket at sockets/<game>.sock
-	listener, err := net.Listen("unix", "sockets/"+config.gameName+".sock")
+	listener, err := net.Listen("unix", "sockets/"+getConfig().gameName+".sock")
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	// set socket file permissions
-	if err := os.Chmod("sockets/"+config.gameName+".sock", 0666); err != nil {
+	if err := os.Chmod("sockets/"+getConfig().gameName+".sock", 0666); err != nil {
 		log.Fatal(err)
 	}
 
@@ -151,14 +173,6 @@ func getIp(r *http.Request) string {
 	return r.Header.Get("x-forwarded-for")
 }
 
-func writeLog(uuid string, location string, payload string, errorcode int) {
-	log.Printf("%v %v \"%v\" %v\n", uuid, location, strings.Replace(payload, "\"", "'", -1), errorcode)
-}
-
-func writeErrLog(uuid string, location string, payload string) {
-	writeLog(uuid, location, payload, 400)
-}
-
 const randRunes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
 const lenRandRunes = len(randRunes)
 
@@ -238,6 +252,20 @@ func buildMsg(segments ...any) (message []byte) {
 	return message
 }
 
+// prepareWsConn sets the compression level for connections that negotiated compression
+func prepareWsConn(conn *websocket.Conn) {
+	if getConfig().wsCompression.enabled {
+		conn.SetCompressionLevel(getConfig().wsCompression.level)
+	}
+}
+
+// setWsWriteCompression only compresses messages large enough to benefit from it
+func setWsWriteCompression(conn *websocket.Conn, size int) {
+	if getConfig().wsCompression.enabled {
+		conn.EnableWriteCompression(size >= getConfig().wsCompression.threshold)
+	}
+}
+
 func getNanoId() string {
 	timestamp := time.Now().UTC().UnixNano()
 
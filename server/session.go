@@ -25,6 +25,7 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 	"unicode/utf8"
@@ -33,7 +34,22 @@ import (
 )
 
 var (
-	clients = NewSCMap()
+	clients SessionClientStore = NewSCMap()
+
+	// detached sessions by resume key
+	sessionResumes sync.Map
+)
+
+const (
+	maxResumeQueueSize = 256
+)
+
+// statuses set by players, shown to friends, party members and in the online list
+const (
+	playerStatusOnline = "online"
+	playerStatusAfk    = "afk"
+	playerStatusBusy   = "busy"
+	playerStatusDnd    = "dnd"
 )
 
 func initSession() {
@@ -43,7 +59,7 @@ func initSession() {
 	var sender SessionClient
 
 	var lastSentPlayerCount int
-	scheduler.Every(5).Seconds().Do(func() {
+	scheduleTask("playerCount", scheduler.Every(5).Seconds(), func() {
 		count := clients.GetAmount()
 
 		if count != lastSentPlayerCount {
@@ -53,13 +69,32 @@ func initSession() {
 		}
 	})
 
-	scheduler.Every(10).Seconds().Do(func() {
+	scheduleTask("playerGameDataFlush", scheduler.Every(playerGameDataFlushInterval).Seconds(), flushPlayerGameData)
+
+	scheduleTask("partyFriendUpdates", scheduler.Every(10).Seconds(), func() {
 		sendPartyUpdate()
 		sendFriendsUpdate()
 	})
 
-	scheduler.Cron("0 2,8,14,20 * * *").Do(func() {
-		writeGamePlayerCount(clients.GetAmount())
+	// long sessions are sampled so their playtime counts before they disconnect
+	scheduleTask("playtimeSamples", scheduler.Every(5).Minutes(), func() {
+		for _, client := range clients.Get() {
+			if !client.account {
+				continue
+			}
+
+			err := client.writePlaytimeSample()
+			if err != nil {
+				writeErrLog(client.uuid, "sess", err.Error())
+			}
+		}
+	})
+
+	scheduleTask("gamePlayerCountRecord", scheduler.Cron("0 2,8,14,20 * * *"), func() {
+		// the count covers the whole cluster, so only one process records it
+		if isClusterLeader() {
+			writeGamePlayerCount(clients.GetAmount())
+		}
 	})
 
 	go func() {
@@ -75,6 +110,12 @@ func initSession() {
 		if bot != nil {
 			bot.Close()
 		}
+		if discordBridge != nil {
+			discordBridge.Close()
+		}
+
+		flushPlayerGameData()
+		flushPlayerStats()
 
 		time.Sleep(time.Second)
 
@@ -83,6 +124,11 @@ func initSession() {
 }
 
 func handleSession(w http.ResponseWriter, r *http.Request) {
+	if !isDatabaseAvailable() {
+		handleServiceUnavailable(w, r)
+		return
+	}
+
 	ip := getIp(r)
 	if isIpBanned(ip) {
 		handleError(w, r, "user is banned")
@@ -95,19 +141,128 @@ func handleSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	prepareWsConn(conn)
+
+	if resumeKey := r.URL.Query().Get("resumeKey"); resumeKey != "" {
+		if resumeSessionWs(conn, resumeKey, r.URL.Query().Get("token")) {
+			return
+		}
+	}
+
 	joinSessionWs(conn, getIp(r), r.URL.Query().Get("token"))
 }
 
+// detach keeps the session of a dropped connection alive for the resume window,
+// queueing messages sent to it until it is resumed
+func (c *SessionClient) detach() {
+	c.resumeMtx.Lock()
+	c.detached = true
+	c.resumeQueue = make(chan [][]byte)
+	c.resumeMtx.Unlock()
+
+	c.conn.Close()
+
+	sessionResumes.Store(c.resumeKey, c)
+
+	writeLog(c.uuid, "sess", "detach", 200)
+
+	go func() {
+		timer := time.NewTimer(getConfig().sessionResumeWindow)
+		defer timer.Stop()
+
+		var queue [][]byte
+
+		for {
+			select {
+			case message := <-c.outbox:
+				if len(queue) == maxResumeQueueSize {
+					queue = queue[1:]
+				}
+				queue = append(queue, message)
+			case c.resumeQueue <- queue:
+				return
+			case <-timer.C:
+				c.cancel()
+			case <-c.ctx.Done():
+				c.resumeMtx.Lock()
+				c.detached = false
+				c.resumeMtx.Unlock()
+
+				c.disconnect()
+				return
+			}
+		}
+	}()
+}
+
+// resumeSessionWs attaches a new connection to a detached session, returning false if it can't be resumed
+func resumeSessionWs(conn *websocket.Conn, resumeKey string, token string) bool {
+	value, ok := sessionResumes.Load(resumeKey)
+	if !ok {
+		return false
+	}
+
+	c := value.(*SessionClient)
+
+	// only consume the resume key once the token matches, so a wrong token can't burn it
+	if c.account && getUuidFromToken(token) != c.uuid {
+		return false
+	}
+
+	if !sessionResumes.CompareAndDelete(resumeKey, value) {
+		return false // resumed concurrently
+	}
+
+	c.resumeMtx.Lock()
+	if !c.detached || c.ctx.Err() != nil {
+		c.resumeMtx.Unlock()
+		return false
+	}
+
+	var queue [][]byte
+	select {
+	case queue = <-c.resumeQueue:
+	case <-c.ctx.Done(): // the resume window ended
+		c.resumeMtx.Unlock()
+		return false
+	}
+	c.detached = false
+	c.resumeMtx.Unlock()
+
+	c.conn = conn
+	c.connCtx, c.connCancel = context.WithCancel(c.ctx)
+
+	go c.msgWriter()
+
+	c.outbox <- buildMsg("rk", c.resumeKey)
+
+	for _, message := range queue {
+		c.outbox <- message
+	}
+
+	go c.msgReader()
+
+	writeLog(c.uuid, "sess", "resume", 200)
+
+	return true
+}
+
 func joinSessionWs(conn *websocket.Conn, ip string, token string) {
 	c := &SessionClient{
-		conn:          conn,
-		ip:            ip,
-		outbox:        make(chan []byte, 8),
-		onlineFriends: make(map[string]bool),
-		blockedUsers:  make(map[string]bool),
+		conn:               conn,
+		ip:                 ip,
+		outbox:             make(chan []byte, 8),
+		onlineFriends:      make(map[string]bool),
+		blockedUsers:       make(map[string]bool),
+		status:             playerStatusOnline,
+		playtimeSampleTime: time.Now(),
+		visitedMaps:        make(map[int]bool),
 	}
 
 	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.connCtx, c.connCancel = context.WithCancel(c.ctx)
+
+	c.resumeKey = randString(32)
 
 	var banned bool
 	if token != "" {
@@ -130,6 +285,11 @@ func joinSessionWs(conn *websocket.Conn, ip string, token string) {
 
 	c.cacheParty() // don't log error because player is probably not in a party
 
+	err := c.cacheBlockedUsers()
+	if err != nil {
+		writeErrLog(c.uuid, "sess", err.Error())
+	}
+
 	if client, ok := clients.Load(c.uuid); ok {
 		client.cancel()
 	}
@@ -172,11 +332,23 @@ func joinSessionWs(conn *websocket.Conn, ip string, token string) {
 
 	go c.msgReader()
 
-	err := c.addOrUpdatePlayerGameData()
+	err = c.addOrUpdatePlayerGameData()
 	if err != nil {
 		writeErrLog(c.uuid, "sess", err.Error())
 	}
 
+	// lets the client resume this session if its connection drops
+	c.outbox <- buildMsg("rk", c.resumeKey)
+
+	if c.account {
+		sendFriendStatusUpdate(c.uuid, true)
+
+		err = c.sendUndeliveredWhispers()
+		if err != nil {
+			writeErrLog(c.uuid, "sess", err.Error())
+		}
+	}
+
 	writeLog(c.uuid, "sess", "connect", 200)
 }
 
@@ -190,7 +362,22 @@ func (c *SessionClient) broadcast(msg []byte) {
 	}
 }
 
-func (c *SessionClient) processMsg(msg []byte) (err error) {
+// broadcastUnblocked sends a message from the client to every client except those on either side of a block
+func (c *SessionClient) broadcastUnblocked(msg []byte) {
+	for _, client := range clients.Get() {
+		if client.blockedUsers[c.uuid] || c.blockedUsers[client.uuid] {
+			continue
+		}
+
+		select {
+		case client.outbox <- buildMsg(msg):
+		default:
+			writeErrLog(c.uuid, "sess", "send channel is full")
+		}
+	}
+}
+
+func (c *SessionClient) processMsg(msg []byte, requestId string) (err error) {
 	if !utf8.Valid(msg) {
 		return errors.New("invalid utf8")
 	}
@@ -217,10 +404,27 @@ func (c *SessionClient) processMsg(msg []byte) (err error) {
 		updateGameActivity = true
 	case "nl": // next expedition location(s)
 		err = c.handleNl(msgFields)
+	case "lh": // location history
+		err = c.handleLh(msgFields)
 	case "lp": // location player counts
 		err = c.handleLp()
 	case "pf": // friend list update
 		err = c.handlePf()
+	case "fa", "fr": // add or accept friend and remove friend
+		err = c.handleFaFr(msgFields)
+	case "w": // whisper
+		err = c.handleW(msgFields)
+	case "ch": // chat history
+		err = c.handleCh(msgFields)
+	case "cls": // chat channel list
+		err = c.handleCls()
+	case "cj", "cl": // join and leave chat channel
+		err = c.handleCjCl(msgFields)
+	case "csay": // chat channel say
+		err = c.handleCsay(msgFields)
+		updateGameActivity = true
+	case "cmod": // chat channel moderation
+		err = c.handleCmod(msgFields)
 	case "pt": // party update
 		err = c.handlePt()
 		if err != nil {
@@ -239,6 +443,14 @@ func (c *SessionClient) processMsg(msg []byte) (err error) {
 	case "pr": // private mode
 		err = c.handlePr(msgFields)
 		updateGameActivity = true
+	case "po": // latency pong
+		err = c.handlePo(msgFields)
+	case "st": // status
+		err = c.handleSt(msgFields)
+	case "atp": // allow party members to teleport to me
+		err = c.handleAtp(msgFields)
+	case "ptp": // teleport to party member
+		err = c.handlePtp(msgFields)
 	case "hl": // hide location
 		err = c.handleHl(msgFields)
 		updateGameActivity = true
@@ -250,13 +462,10 @@ func (c *SessionClient) processMsg(msg []byte) (err error) {
 	}
 
 	if updateGameActivity {
-		err = c.updatePlayerGameActivity(true)
-		if err != nil {
-			writeErrLog(c.uuid, "sess", err.Error())
-		}
+		c.updatePlayerGameActivity(true)
 	}
 
-	writeLog(c.uuid, "sess", string(msg), 200)
+	writeLog(c.uuid, "sess", string(msg), 200, "requestId", requestId)
 
 	return nil
 }
@@ -0,0 +1,155 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// player statistics, also usable as badge requirements in the future
+const (
+	statMapsVisited     = "mapsVisited"
+	statChatMessages    = "chatMessages"
+	statPartiesJoined   = "partiesJoined"
+	statEventsCompleted = "eventsCompleted"
+)
+
+const (
+	statsFlushInterval = 30 * time.Second
+)
+
+type statIncrement struct {
+	uuid   string
+	stat   string
+	amount int
+}
+
+var (
+	statsChan = make(chan statIncrement, 1024)
+
+	// requests to write the pending statistics right away, closed once written
+	statsFlushChan = make(chan chan struct{})
+)
+
+func initStatistics() {
+	logInitTask("statistics")
+
+	go func() {
+		pending := make(map[[2]string]int)
+		ticker := time.NewTicker(statsFlushInterval)
+
+		flush := func() {
+			for key, amount := range pending {
+				err := writePlayerStatistic(key[0], key[1], amount)
+				if err != nil {
+					writeErrLog(key[0], "stats", err.Error())
+					continue // retried on the next flush
+				}
+
+				delete(pending, key)
+			}
+		}
+
+		for {
+			select {
+			case increment := <-statsChan:
+				pending[[2]string{increment.uuid, increment.stat}] += increment.amount
+			case <-ticker.C:
+				flush()
+			case done := <-statsFlushChan:
+				// include the increments still queued
+				for queued := true; queued; {
+					select {
+					case increment := <-statsChan:
+						pending[[2]string{increment.uuid, increment.stat}] += increment.amount
+					default:
+						queued = false
+					}
+				}
+
+				flush()
+				close(done)
+			}
+		}
+	}()
+}
+
+// flushPlayerStats writes the pending statistics and waits for them to be written, such as before shutting down
+func flushPlayerStats() {
+	done := make(chan struct{})
+
+	select {
+	case statsFlushChan <- done:
+		<-done
+	case <-time.After(statsFlushInterval):
+		writeErrLog("SERVER", "stats", "timed out flushing statistics")
+	}
+}
+
+// incrementPlayerStat queues a statistic update without blocking the caller
+func incrementPlayerStat(uuid string, stat string) {
+	select {
+	case statsChan <- statIncrement{uuid: uuid, stat: stat, amount: 1}:
+	default:
+		writeErrLog(uuid, "stats", "stats channel is full")
+	}
+}
+
+func handleStatistics(w http.ResponseWriter, r *http.Request) {
+	var uuid string
+
+	if playerParam := r.URL.Query().Get("player"); playerParam != "" {
+		var err error
+		uuid, err = getUuidFromName(playerParam)
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+		if uuid == "" {
+			uuid = playerParam
+		}
+	} else {
+		token := r.Header.Get("Authorization")
+		if token == "" {
+			uuid, _, _ = getPlayerInfo(getIp(r))
+		} else {
+			uuid = getUuidFromToken(token)
+		}
+	}
+
+	if uuid == "" {
+		handleError(w, r, "player not found")
+		return
+	}
+
+	statistics, err := getPlayerStatistics(uuid, r.URL.Query().Get("game"))
+	if err != nil {
+		handleInternalError(w, r, err)
+		return
+	}
+
+	statisticsJson, err := json.Marshal(statistics)
+	if err != nil {
+		handleInternalError(w, r, err)
+		return
+	}
+
+	w.Write(statisticsJson)
+}
@@ -0,0 +1,280 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type TimeTrialLeaderboardEntry struct {
+	Position           int       `json:"position"`
+	Uuid               string    `json:"uuid"`
+	Name               string    `json:"name"`
+	Rank               int       `json:"rank"`
+	Badge              string    `json:"badge"`
+	Seconds            int       `json:"seconds"`
+	TimestampCompleted time.Time `json:"timestampCompleted"`
+}
+
+type TimeTrialLeaderboard struct {
+	MapId   int                          `json:"mapId"`
+	Entries []*TimeTrialLeaderboardEntry `json:"entries"`
+	// the requesting player's own best, which may be outside of the top entries
+	PlayerEntry *TimeTrialLeaderboardEntry `json:"playerEntry,omitempty"`
+}
+
+type TimeTrialGhostPoint struct {
+	Time  int `json:"t"` // milliseconds since the trial started
+	MapId int `json:"mapId"`
+	X     int `json:"x"`
+	Y     int `json:"y"`
+}
+
+type TimeTrialGhost struct {
+	Uuid    string          `json:"uuid"`
+	Name    string          `json:"name"`
+	MapId   int             `json:"mapId"`
+	Seconds int             `json:"seconds"`
+	Points  json.RawMessage `json:"points"`
+}
+
+const (
+	timeTrialLeaderboardSize = 50
+
+	// the positions of a player during a time trial are recorded at most this often, and on every map change
+	timeTrialGhostInterval  = 250 * time.Millisecond
+	timeTrialGhostMaxPoints = 14400 // an hour at the interval
+)
+
+// validateTimeTrial checks a time trial of the client's current map against its minimum plausible time
+// and the time the server saw the trial start
+func (c *RoomClient) validateTimeTrial(seconds int) error {
+	if minSeconds, ok := getConfig().timeTrials.minSeconds[c.room.id]; ok && seconds < minSeconds {
+		return errors.New("time below minimum")
+	}
+
+	if c.timeTrialStart.IsZero() {
+		return errors.New("trial start not observed")
+	}
+
+	// the game's timer can't have counted less than the time since the trial started, apart from latency and loading
+	if time.Since(c.timeTrialStart) > time.Duration(seconds)*time.Second+getConfig().timeTrials.startTolerance {
+		return errors.New("trial started before the claimed duration")
+	}
+
+	return nil
+}
+
+// recordTimeTrialGhostPoint adds the client's position to the trace of its current time trial
+func (c *RoomClient) recordTimeTrialGhostPoint() {
+	if c.timeTrialStart.IsZero() || len(c.timeTrialGhost) >= timeTrialGhostMaxPoints || c.x < 0 || c.y < 0 {
+		return
+	}
+
+	elapsed := time.Since(c.timeTrialStart)
+
+	if len(c.timeTrialGhost) != 0 {
+		lastPoint := c.timeTrialGhost[len(c.timeTrialGhost)-1]
+		if lastPoint.MapId == c.room.id && elapsed < time.Duration(lastPoint.Time)*time.Millisecond+timeTrialGhostInterval {
+			return
+		}
+	}
+
+	c.timeTrialGhost = append(c.timeTrialGhost, &TimeTrialGhostPoint{
+		Time:  int(elapsed.Milliseconds()),
+		MapId: c.room.id,
+		X:     c.x,
+		Y:     c.y,
+	})
+}
+
+func handleTimeTrial(w http.ResponseWriter, r *http.Request) {
+	if getConfig().gameName != "2kki" {
+		handleError(w, r, "time trials are only available for Yume 2kki")
+		return
+	}
+
+	commandParam := r.URL.Query().Get("command")
+	if commandParam == "" {
+		handleError(w, r, "command not specified")
+		return
+	}
+
+	switch commandParam {
+	case "list":
+		mapId, err := strconv.Atoi(r.URL.Query().Get("mapId"))
+		if err != nil || mapId <= 0 {
+			handleError(w, r, "invalid mapId")
+			return
+		}
+
+		leaderboard := TimeTrialLeaderboard{MapId: mapId}
+
+		leaderboard.Entries, err = getTimeTrialLeaderboard(mapId, timeTrialLeaderboardSize)
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+
+		if token := r.Header.Get("Authorization"); token != "" {
+			if uuid := getUuidFromToken(token); uuid != "" {
+				leaderboard.PlayerEntry, err = getPlayerTimeTrialLeaderboardEntry(uuid, mapId)
+				if err != nil {
+					handleInternalError(w, r, err)
+					return
+				}
+			}
+		}
+
+		leaderboardJson, err := json.Marshal(leaderboard)
+		if err != nil {
+			handleError(w, r, "error while marshaling")
+			return
+		}
+
+		w.Write(leaderboardJson)
+	case "ghost":
+		mapId, err := strconv.Atoi(r.URL.Query().Get("mapId"))
+		if err != nil || mapId <= 0 {
+			handleError(w, r, "invalid mapId")
+			return
+		}
+
+		// the record holder's ghost unless another player is requested
+		uuidParam := r.URL.Query().Get("uuid")
+		if uuidParam == "" {
+			entries, err := getTimeTrialLeaderboard(mapId, 1)
+			if err != nil {
+				handleInternalError(w, r, err)
+				return
+			}
+			if len(entries) == 0 {
+				handleError(w, r, "no ghost found")
+				return
+			}
+
+			uuidParam = entries[0].Uuid
+		}
+
+		ghost, err := getPlayerTimeTrialGhost(uuidParam, mapId)
+		if err != nil {
+			handleInternalError(w, r, err)
+			return
+		}
+		if ghost == nil {
+			handleError(w, r, "no ghost found")
+			return
+		}
+
+		ghostJson, err := json.Marshal(ghost)
+		if err != nil {
+			handleError(w, r, "error while marshaling")
+			return
+		}
+
+		w.Write(ghostJson)
+	default:
+		handleError(w, r, "unknown command")
+	}
+}
+
+// getTimeTrialLeaderboard returns the best times of a map, ties going to whoever reached the time first
+func getTimeTrialLeaderboard(mapId int, limit int) (entries []*TimeTrialLeaderboardEntry, err error) {
+	entries = []*TimeTrialLeaderboardEntry{}
+
+	results, err := db.Replica().Query("SELECT tt.uuid, a.user, pd.rank, COALESCE(a.badge, ''), tt.seconds, tt.timestampCompleted FROM playerTimeTrials tt JOIN accounts a ON a.uuid = tt.uuid JOIN players pd ON pd.uuid = tt.uuid WHERE tt.mapId = ? AND pd.banned = 0 ORDER BY tt.seconds, tt.timestampCompleted LIMIT ?", mapId, limit)
+	if err != nil {
+		return entries, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		entry := &TimeTrialLeaderboardEntry{Position: len(entries) + 1}
+
+		err := results.Scan(&entry.Uuid, &entry.Name, &entry.Rank, &entry.Badge, &entry.Seconds, &entry.TimestampCompleted)
+		if err != nil {
+			return entries, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// getPlayerTimeTrialLeaderboardEntry returns the player's best time on a map and its position, or nil if they have none
+func getPlayerTimeTrialLeaderboardEntry(playerUuid string, mapId int) (*TimeTrialLeaderboardEntry, error) {
+	entry := &TimeTrialLeaderboardEntry{Uuid: playerUuid}
+
+	err := db.Replica().QueryRow("SELECT a.user, pd.rank, COALESCE(a.badge, ''), tt.seconds, tt.timestampCompleted FROM playerTimeTrials tt JOIN accounts a ON a.uuid = tt.uuid JOIN players pd ON pd.uuid = tt.uuid WHERE tt.uuid = ? AND tt.mapId = ?", playerUuid, mapId).Scan(&entry.Name, &entry.Rank, &entry.Badge, &entry.Seconds, &entry.TimestampCompleted)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	err = db.Replica().QueryRow("SELECT COUNT(*) + 1 FROM playerTimeTrials tt JOIN players pd ON pd.uuid = tt.uuid JOIN accounts a ON a.uuid = tt.uuid WHERE tt.mapId = ? AND pd.banned = 0 AND (tt.seconds < ? OR (tt.seconds = ? AND tt.timestampCompleted < ?))", mapId, entry.Seconds, entry.Seconds, entry.TimestampCompleted).Scan(&entry.Position)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func writePlayerTimeTrialGhost(playerUuid string, mapId int, points []*TimeTrialGhostPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	pointsJson, err := json.Marshal(points)
+	if err != nil {
+		return err
+	}
+
+	timestampRecorded := time.Now()
+
+	_, err = db.Exec("INSERT INTO playerTimeTrialGhosts (uuid, mapId, points, timestampRecorded) VALUES (?, ?, ?, ?) "+db.upsert("uuid, mapId", "points = ?, timestampRecorded = ?"), playerUuid, mapId, pointsJson, timestampRecorded, pointsJson, timestampRecorded)
+
+	return err
+}
+
+// getPlayerTimeTrialGhost returns the ghost of the player's best time on a map, or nil if there is none
+func getPlayerTimeTrialGhost(playerUuid string, mapId int) (*TimeTrialGhost, error) {
+	ghost := &TimeTrialGhost{Uuid: playerUuid, MapId: mapId}
+
+	var points string
+
+	err := db.Replica().QueryRow("SELECT a.user, tt.seconds, g.points FROM playerTimeTrialGhosts g JOIN playerTimeTrials tt ON tt.uuid = g.uuid AND tt.mapId = g.mapId JOIN accounts a ON a.uuid = g.uuid JOIN players pd ON pd.uuid = g.uuid WHERE g.uuid = ? AND g.mapId = ? AND pd.banned = 0", playerUuid, mapId).Scan(&ghost.Name, &ghost.Seconds, &points)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	ghost.Points = json.RawMessage(points)
+
+	return ghost, nil
+}
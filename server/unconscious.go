@@ -10,7 +10,7 @@ var startTime = time.Now()
 var randint, temperature, precipitation int
 
 func initUnconscious() {
-	scheduler.Every(1).Minute().Do(func() {
+	scheduleTask("unconsciousTime", scheduler.Every(1).Minute(), func() {
 		randint = rand.IntN(256)
 		time := getUnconsciousTime()
 		for _, client := range clients.Get() {
@@ -25,7 +25,7 @@ func initUnconscious() {
 			}
 		}
 	})
-	scheduler.Every(2).Minutes().Do(func() {
+	scheduleTask("unconsciousWeather", scheduler.Every(2).Minutes(), func() {
 		temperature = max(-100, min(100, temperature+weatherDelta(temperature)))
 		precipitation = max(0, min(100, precipitation+weatherDelta(precipitation)))
 		for _, client := range clients.Get() {
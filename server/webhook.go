@@ -47,7 +47,7 @@ type WebhookRequest struct {
 func sendWebhookMessage(url, name, badge, message string, sanitize bool) error {
 	var avatarUrl string
 	if badge != "" {
-		avatarUrl = fmt.Sprintf("https://ynoproject.net/%s/images/badge/%s.png", config.gameName, badge)
+		avatarUrl = fmt.Sprintf("https://ynoproject.net/%s/images/badge/%s.png", getConfig().gameName, badge)
 	}
 
 	content := message
@@ -0,0 +1,176 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// webhook events
+const (
+	webhookPlayerBanned     = "player.banned"
+	webhookBadgeUnlocked    = "badge.unlocked"
+	webhookPartyCreated     = "party.created"
+	webhookEventPeriodEnded = "eventPeriod.ended"
+)
+
+const (
+	webhookWorkers     = 2
+	webhookMaxAttempts = 5
+	webhookRetryDelay  = 2 * time.Second // doubled after every failed attempt
+)
+
+type WebhookPayload struct {
+	Event     string    `json:"event"`
+	Game      string    `json:"game"`
+	Timestamp time.Time `json:"timestamp"`
+	Data      any       `json:"data"`
+}
+
+type webhookDelivery struct {
+	webhook WebhookConfig
+	event   string
+	body    []byte
+	attempt int
+}
+
+var (
+	webhookQueue  = make(chan *webhookDelivery, 256)
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+func initWebhooks() {
+	logInitTask("webhooks")
+
+	// started even without webhooks configured, as a config reload may add some
+
+	for range webhookWorkers {
+		go func() {
+			for delivery := range webhookQueue {
+				delivery.send()
+			}
+		}()
+	}
+}
+
+// dispatchWebhook queues an event for every webhook subscribed to it
+func dispatchWebhook(event string, data any) {
+	if len(getConfig().webhooks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(WebhookPayload{
+		Event:     event,
+		Game:      getConfig().gameName,
+		Timestamp: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		writeErrLog("SERVER", "webhooks", err.Error())
+		return
+	}
+
+	for _, webhook := range getConfig().webhooks {
+		// webhooks without an event list receive every event
+		if len(webhook.Events) != 0 && !slices.Contains(webhook.Events, event) {
+			continue
+		}
+
+		queueWebhookDelivery(&webhookDelivery{webhook: webhook, event: event, body: body})
+	}
+}
+
+func queueWebhookDelivery(delivery *webhookDelivery) {
+	select {
+	case webhookQueue <- delivery:
+	default:
+		writeErrLog("SERVER", "webhooks", "webhook queue is full, dropped "+delivery.event+" for "+delivery.webhook.Url)
+	}
+}
+
+func (d *webhookDelivery) send() {
+	d.attempt++
+
+	retry, err := d.post()
+	if err == nil {
+		return
+	}
+
+	if !retry || d.attempt >= webhookMaxAttempts {
+		writeErrLog("SERVER", "webhooks", "failed to deliver "+d.event+" to "+d.webhook.Url+": "+err.Error())
+		return
+	}
+
+	time.AfterFunc(webhookRetryDelay<<(d.attempt-1), func() {
+		queueWebhookDelivery(d)
+	})
+}
+
+// post sends the payload once, reporting whether a failure is worth retrying
+func (d *webhookDelivery) post() (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, d.webhook.Url, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", d.event)
+	req.Header.Set("X-Webhook-Attempt", strconv.Itoa(d.attempt))
+	if d.webhook.Secret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+signWebhookBody(d.webhook.Secret, d.body))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		// client errors won't go away by retrying, except for rate limiting
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, &webhookStatusError{statusCode: resp.StatusCode}
+	}
+
+	return false, nil
+}
+
+type webhookStatusError struct {
+	statusCode int
+}
+
+func (e *webhookStatusError) Error() string {
+	return "unexpected status " + strconv.Itoa(e.statusCode)
+}
+
+// signWebhookBody returns the hex HMAC-SHA256 of the body, letting receivers verify its origin
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,94 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"time"
+)
+
+type PlayerWhisper struct {
+	MsgId     string
+	Uuid      string
+	Name      string
+	Contents  string
+	Timestamp time.Time
+}
+
+// sendUndeliveredWhispers delivers whispers received while the player was offline
+// and sends delivery receipts to their senders connected to this server
+func (c *SessionClient) sendUndeliveredWhispers() error {
+	whispers, err := getUndeliveredPlayerWhispers(c.uuid)
+	if err != nil {
+		return err
+	}
+
+	for _, whisper := range whispers {
+		c.outbox <- buildMsg("w", whisper.Uuid, whisper.Name, whisper.MsgId, whisper.Timestamp.Format(time.RFC3339), whisper.Contents)
+
+		err = setPlayerWhisperDelivered(whisper.MsgId)
+		if err != nil {
+			return err
+		}
+
+		if sender, ok := clients.Load(whisper.Uuid); ok {
+			sender.outbox <- buildMsg("wr", whisper.MsgId, true)
+		}
+	}
+
+	return nil
+}
+
+func writePlayerWhisper(msgId string, uuid string, targetUuid string, contents string, delivered bool) error {
+	_, err := db.Exec("INSERT INTO playerWhispers (msgId, uuid, targetUuid, contents, timestamp, delivered) VALUES (?, ?, ?, ?, UTC_TIMESTAMP(), ?)", msgId, uuid, targetUuid, contents, delivered)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func setPlayerWhisperDelivered(msgId string) error {
+	_, err := db.Exec("UPDATE playerWhispers SET delivered = 1 WHERE msgId = ?", msgId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func getUndeliveredPlayerWhispers(uuid string) (whispers []*PlayerWhisper, err error) {
+	results, err := db.Query("SELECT pw.msgId, pw.uuid, a.user, pw.contents, pw.timestamp FROM playerWhispers pw JOIN accounts a ON a.uuid = pw.uuid WHERE pw.targetUuid = ? AND pw.delivered = 0 ORDER BY pw.timestamp", uuid)
+	if err != nil {
+		return whispers, err
+	}
+
+	defer results.Close()
+
+	for results.Next() {
+		whisper := &PlayerWhisper{}
+
+		err := results.Scan(&whisper.MsgId, &whisper.Uuid, &whisper.Name, &whisper.Contents, &whisper.Timestamp)
+		if err != nil {
+			return whispers, err
+		}
+
+		whispers = append(whispers, whisper)
+	}
+
+	return whispers, nil
+}
@@ -0,0 +1,193 @@
+/*
+	Copyright (C) 2021-2024  The YNOproject Developers
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Binary wire protocol, negotiated with ?binary=1 when connecting to a room
+//
+// Every websocket message is a sequence of frames, each made of a uvarint
+// payload length followed by the payload. A payload starting with one of the
+// opcodes below is a compact binary message, anything else is a regular
+// delimiter-separated text message.
+//
+// Binary message fields follow the layout of their opcode:
+//   i - zigzag varint
+//   s - uvarint length followed by utf8 bytes
+
+type binaryMsgType struct {
+	opcode byte
+	name   string
+	layout string
+}
+
+// server to client layouts include the session id of the player the message is about
+var binaryMsgsOut = []binaryMsgType{
+	{0x01, "m", "iii"},
+	{0x02, "jmp", "iii"},
+	{0x04, "f", "ii"},
+	{0x05, "spr", "isi"},
+	{0x06, "ss", "ii"},
+	{0x07, "sv", "ii"},
+}
+
+var binaryMsgsIn = []binaryMsgType{
+	{0x01, "m", "ii"},
+	{0x02, "jmp", "ii"},
+	{0x03, "tp", "ii"},
+	{0x04, "f", "i"},
+	{0x05, "spr", "si"},
+	{0x06, "ss", "ii"},
+	{0x07, "sv", "ii"},
+}
+
+// text messages always start with a letter, so control characters are free to use as opcodes
+func isBinaryMsg(msg []byte) bool {
+	return len(msg) != 0 && msg[0] < 0x20
+}
+
+// encodeBinaryMsg converts a text message to its binary form, returning the
+// original message if it has no binary form
+func encodeBinaryMsg(msg []byte) []byte {
+	if isBinaryMsg(msg) {
+		return msg
+	}
+
+	fields := strings.Split(string(msg), delim)
+
+	for _, msgType := range binaryMsgsOut {
+		if msgType.name != fields[0] {
+			continue
+		}
+
+		if len(fields)-1 != len(msgType.layout) {
+			return msg
+		}
+
+		encoded := []byte{msgType.opcode}
+
+		for i, field := range fields[1:] {
+			switch msgType.layout[i] {
+			case 'i':
+				num, err := strconv.Atoi(field)
+				if err != nil {
+					return msg
+				}
+
+				encoded = binary.AppendVarint(encoded, int64(num))
+			case 's':
+				encoded = binary.AppendUvarint(encoded, uint64(len(field)))
+				encoded = append(encoded, field...)
+			}
+		}
+
+		return encoded
+	}
+
+	return msg
+}
+
+// decodeBinaryMsg converts a binary message from a client to its text form
+func decodeBinaryMsg(msg []byte) (string, error) {
+	for _, msgType := range binaryMsgsIn {
+		if msgType.opcode != msg[0] {
+			continue
+		}
+
+		fields := []string{msgType.name}
+
+		pos := 1
+		for _, fieldType := range msgType.layout {
+			switch fieldType {
+			case 'i':
+				num, n := binary.Varint(msg[pos:])
+				if n <= 0 {
+					return "", errors.New("invalid binary field")
+				}
+				pos += n
+
+				fields = append(fields, strconv.FormatInt(num, 10))
+			case 's':
+				length, n := binary.Uvarint(msg[pos:])
+				if n <= 0 || length > uint64(len(msg)-pos-n) {
+					return "", errors.New("invalid binary field")
+				}
+				pos += n
+
+				field := msg[pos : pos+int(length)]
+				if !utf8.Valid(field) {
+					return "", errors.New("invalid utf8")
+				}
+				pos += int(length)
+
+				fields = append(fields, string(field))
+			}
+		}
+
+		if pos != len(msg) {
+			return "", errors.New("binary message size mismatch")
+		}
+
+		return strings.Join(fields, delim), nil
+	}
+
+	return "", errors.New("unknown binary message type")
+}
+
+func appendBinaryFrame(frames []byte, msg []byte) []byte {
+	frames = binary.AppendUvarint(frames, uint64(len(msg)))
+
+	return append(frames, msg...)
+}
+
+// splitBinaryFrames splits a message from a binary client into text messages
+func splitBinaryFrames(msg []byte) (msgStrs []string, err error) {
+	for len(msg) != 0 {
+		length, n := binary.Uvarint(msg)
+		if n <= 0 || length == 0 || length > uint64(len(msg)-n) {
+			return msgStrs, errors.New("invalid binary frame")
+		}
+
+		payload := msg[n : n+int(length)]
+		msg = msg[n+int(length):]
+
+		if isBinaryMsg(payload) {
+			msgStr, err := decodeBinaryMsg(payload)
+			if err != nil {
+				return msgStrs, err
+			}
+
+			msgStrs = append(msgStrs, msgStr)
+			continue
+		}
+
+		if !utf8.Valid(payload) {
+			return msgStrs, errors.New("invalid utf8")
+		}
+
+		msgStrs = append(msgStrs, string(payload))
+	}
+
+	return msgStrs, nil
+}